@@ -0,0 +1,324 @@
+package fileupload
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	hasura "github.com/wubshet-kebede/go-app/Hasura"
+)
+
+// Direct uploads go from the client straight to object storage, landing
+// under incomingPrefix until confirmUpload checks them and turns them into
+// the usual resized variants.
+const (
+	incomingPrefix       = "incoming/"
+	maxDirectUploadBytes = 20 << 20
+	directUploadExpiry   = 15 * time.Minute
+)
+
+type CreateUploadUrlInput struct {
+	Bucket      string `json:"bucket"`
+	ContentType string `json:"contentType"`
+	Size        int64  `json:"size"`
+}
+
+type CreateUploadUrlActionPayload struct {
+	Input struct {
+		Input CreateUploadUrlInput `json:"input"`
+	} `json:"input"`
+	SessionVariables map[string]string `json:"session_variables"`
+}
+
+// CreateUploadUrlOutput tells the client where to PUT the file. The request
+// must send exactly ContentType and the size it asked for.
+type CreateUploadUrlOutput struct {
+	UploadURL   string    `json:"uploadUrl"`
+	Key         string    `json:"key"`
+	Method      string    `json:"method"`
+	ContentType string    `json:"contentType"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
+
+// ConfirmUploadInput names an uploaded object. RecipeID is required for
+// recipe and step images, which are registered in recipe_images; ImageOrder
+// defaults to after the recipe's last image.
+type ConfirmUploadInput struct {
+	Key        string  `json:"key"`
+	RecipeID   *string `json:"recipeId"`
+	ImageOrder *int    `json:"imageOrder"`
+}
+
+type ConfirmUploadActionPayload struct {
+	Input struct {
+		Input ConfirmUploadInput `json:"input"`
+	} `json:"input"`
+	SessionVariables map[string]string `json:"session_variables"`
+}
+
+// ConfirmUploadOutput is the recipe_images or profile_images row the upload
+// was registered as.
+type ConfirmUploadOutput struct {
+	ID    string        `json:"id"`
+	Image UploadedImage `json:"image"`
+}
+
+// uuidScalar sends a string as a Hasura uuid variable.
+type uuidScalar string
+
+func (uuidScalar) GetGraphQLType() string { return "uuid" }
+
+type recipe_images_insert_input struct {
+	RecipeID     string `json:"recipe_id"`
+	ImageURL     string `json:"image_url"`
+	ThumbnailURL string `json:"thumbnail_url"`
+	CardURL      string `json:"card_url"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+	ImageOrder   int    `json:"image_order"`
+}
+
+type recipeImageTargetQuery struct {
+	RecipesByPk *struct {
+		UserID                string `graphql:"user_id"`
+		RecipeImagesAggregate struct {
+			Aggregate *struct {
+				Max *struct {
+					ImageOrder *int `graphql:"image_order"`
+				} `graphql:"max"`
+			} `graphql:"aggregate"`
+		} `graphql:"recipe_images_aggregate"`
+	} `graphql:"recipes_by_pk(id: $id)"`
+}
+
+type insertRecipeImageMutation struct {
+	InsertRecipeImagesOne *struct {
+		ID string `graphql:"id"`
+	} `graphql:"insert_recipe_images_one(object: $object)"`
+}
+
+// directUploader returns the store when it accepts direct uploads.
+func directUploader() (DirectUploader, bool) {
+	uploader, ok := Store.(DirectUploader)
+	return uploader, ok
+}
+
+// parseIncomingKey checks that key is an upload the user was given by
+// createUploadUrl and returns the bucket it was meant for.
+func parseIncomingKey(key string, userID string) (Bucket, bool) {
+	parts := strings.Split(strings.TrimPrefix(key, incomingPrefix), "/")
+	if !strings.HasPrefix(key, incomingPrefix) || len(parts) != 3 || !validKey(key) {
+		return "", false
+	}
+	bucket := Bucket(parts[0])
+	if !buckets[bucket] || parts[1] != userID {
+		return "", false
+	}
+	return bucket, true
+}
+
+// HandleCreateUploadUrl returns a short-lived signed URL the caller can PUT
+// one image to, for STORAGE_BACKEND=s3 only.
+func HandleCreateUploadUrl(w http.ResponseWriter, r *http.Request) {
+	var payload CreateUploadUrlActionPayload
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&payload); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	userID := payload.SessionVariables["x-hasura-user-id"]
+	if _, err := uuid.Parse(userID); err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Missing user ID")
+		return
+	}
+	uploader, ok := directUploader()
+	if !ok {
+		respondWithError(w, http.StatusNotImplemented, "Direct uploads are not available; use uploadFiles")
+		return
+	}
+	input := payload.Input.Input
+	bucket, err := ParseBucket(input.Bucket)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	format, ok := formatForContentType(input.ContentType)
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "Unsupported content type; upload a JPEG, PNG, WebP or GIF image")
+		return
+	}
+	if input.Size <= 0 || input.Size > maxDirectUploadBytes {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Size must be between 1 byte and %d MB", maxDirectUploadBytes>>20))
+		return
+	}
+
+	key := incomingPrefix + string(bucket) + "/" + userID + "/" + uuid.New().String() + format.ext
+	uploadURL, err := uploader.PresignPut(key, format.mime, input.Size, directUploadExpiry)
+	if err != nil {
+		log.Printf("Could not sign upload URL for %s: %v\n", key, err)
+		respondWithError(w, http.StatusInternalServerError, "Could not create upload URL")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, CreateUploadUrlOutput{
+		UploadURL:   uploadURL,
+		Key:         key,
+		Method:      http.MethodPut,
+		ContentType: format.mime,
+		ExpiresAt:   time.Now().Add(directUploadExpiry).UTC(),
+	})
+}
+
+// HandleConfirmUpload checks an object uploaded with createUploadUrl,
+// replaces it with resized, metadata-free variants and registers them in
+// recipe_images, or as the caller's picture in profile_images.
+func HandleConfirmUpload(w http.ResponseWriter, r *http.Request) {
+	var payload ConfirmUploadActionPayload
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&payload); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	userID := payload.SessionVariables["x-hasura-user-id"]
+	if _, err := uuid.Parse(userID); err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Missing user ID")
+		return
+	}
+	uploader, ok := directUploader()
+	if !ok {
+		respondWithError(w, http.StatusNotImplemented, "Direct uploads are not available; use uploadFiles")
+		return
+	}
+	input := payload.Input.Input
+	bucket, ok := parseIncomingKey(input.Key, userID)
+	if !ok {
+		respondWithError(w, http.StatusForbidden, "Unknown upload key")
+		return
+	}
+	// The original keeps its metadata, so it never stays past this point,
+	// however the confirmation ends
+	defer func() {
+		if err := Store.Delete(context.Background(), input.Key); err != nil {
+			log.Printf("Could not remove %s: %v\n", input.Key, err)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Minute)
+	defer cancel()
+
+	// Recipe images need a recipe of the caller's to belong to
+	imageOrder := 0
+	if bucket != BucketAvatars {
+		if input.RecipeID == nil || *input.RecipeID == "" {
+			respondWithError(w, http.StatusBadRequest, "recipeId is required for recipe and step images")
+			return
+		}
+		if _, err := uuid.Parse(*input.RecipeID); err != nil {
+			respondWithError(w, http.StatusBadRequest, "recipeId is not a valid ID")
+			return
+		}
+		var target recipeImageTargetQuery
+		if err := hasura.Client.Query(ctx, &target, map[string]interface{}{"id": uuidScalar(*input.RecipeID)}); err != nil {
+			log.Printf("Error loading recipe %s: %v\n", *input.RecipeID, err)
+			respondWithError(w, http.StatusInternalServerError, "Could not load recipe")
+			return
+		}
+		if target.RecipesByPk == nil || target.RecipesByPk.UserID != userID {
+			respondWithError(w, http.StatusNotFound, "Recipe not found")
+			return
+		}
+		if input.ImageOrder != nil {
+			imageOrder = *input.ImageOrder
+		} else if aggregate := target.RecipesByPk.RecipeImagesAggregate.Aggregate; aggregate != nil && aggregate.Max != nil && aggregate.Max.ImageOrder != nil {
+			imageOrder = *aggregate.Max.ImageOrder + 1
+		}
+	}
+
+	info, err := uploader.Stat(ctx, input.Key)
+	if errors.Is(err, fs.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "Upload not found; PUT the file to its upload URL first")
+		return
+	}
+	if err != nil {
+		log.Printf("Could not stat %s: %v\n", input.Key, err)
+		respondWithError(w, http.StatusInternalServerError, "Could not read upload")
+		return
+	}
+	if info.Size > maxDirectUploadBytes {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("file is larger than %d MB", maxDirectUploadBytes>>20))
+		return
+	}
+	declared, ok := formatForContentType(info.ContentType)
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "Unsupported content type; upload a JPEG, PNG, WebP or GIF image")
+		return
+	}
+	data, err := uploader.Get(ctx, input.Key)
+	if err != nil {
+		log.Printf("Could not read %s: %v\n", input.Key, err)
+		respondWithError(w, http.StatusInternalServerError, "Could not read upload")
+		return
+	}
+	decoded, err := validateImageSize(data, maxDirectUploadBytes)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if decoded.format != declared {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("file is a %s image, not %s", decoded.format.name, declared.mime))
+		return
+	}
+
+	if bucket == BucketAvatars {
+		variants, err := makeVariants(decoded, data, avatarVariants)
+		if err != nil {
+			log.Printf("Image processing error: %v\n", err)
+			respondWithError(w, http.StatusInternalServerError, "Could not process image")
+			return
+		}
+		id, url, err := setProfilePicture(ctx, userID, variants[0])
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		respondWithJSON(w, http.StatusOK, ConfirmUploadOutput{
+			ID:    id,
+			Image: UploadedImage{URL: url, Width: variants[0].width, Height: variants[0].height},
+		})
+		return
+	}
+
+	variants, err := makeVariants(decoded, data, imageVariants)
+	if err != nil {
+		log.Printf("Image processing error: %v\n", err)
+		respondWithError(w, http.StatusInternalServerError, "Could not process image")
+		return
+	}
+	uploaded, keys, err := storeVariants(ctx, bucket, userID, variants)
+	if err != nil {
+		log.Printf("File write error: %v\n", err)
+		respondWithError(w, http.StatusInternalServerError, "Could not save file")
+		return
+	}
+	var inserted insertRecipeImageMutation
+	object := recipe_images_insert_input{
+		RecipeID:     *input.RecipeID,
+		ImageURL:     uploaded.URL,
+		ThumbnailURL: uploaded.ThumbnailURL,
+		CardURL:      uploaded.CardURL,
+		Width:        uploaded.Width,
+		Height:       uploaded.Height,
+		ImageOrder:   imageOrder,
+	}
+	if err := hasura.Client.Mutate(ctx, &inserted, map[string]interface{}{"object": object}); err != nil || inserted.InsertRecipeImagesOne == nil {
+		log.Printf("Error registering image for recipe %s: %v\n", *input.RecipeID, err)
+		releaseFiles(ctx, keys)
+		respondWithError(w, http.StatusInternalServerError, "Could not save recipe image")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, ConfirmUploadOutput{ID: inserted.InsertRecipeImagesOne.ID, Image: uploaded})
+}
@@ -0,0 +1,185 @@
+package fileupload
+
+import (
+	"context"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeDirectUploader keeps objects on disk and hands out fake signed URLs
+// that record what they were signed for.
+type fakeDirectUploader struct {
+	*LocalStorage
+	infos map[string]ObjectInfo
+}
+
+func (f *fakeDirectUploader) PresignPut(key string, contentType string, size int64, expires time.Duration) (string, error) {
+	query := url.Values{"type": {contentType}, "size": {strconv.FormatInt(size, 10)}, "expires": {expires.String()}}
+	return "https://uploads.example.com/" + key + "?" + query.Encode(), nil
+}
+
+func (f *fakeDirectUploader) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	info, ok := f.infos[key]
+	if !ok {
+		return ObjectInfo{}, fs.ErrNotExist
+	}
+	return info, nil
+}
+
+func useDirectUploader(t *testing.T) *fakeDirectUploader {
+	t.Helper()
+	saved := Store
+	t.Cleanup(func() { Store = saved })
+	f := &fakeDirectUploader{
+		LocalStorage: NewLocalStorage(t.TempDir(), "https://cdn.example.com/files"),
+		infos:        map[string]ObjectInfo{},
+	}
+	Store = f
+	return f
+}
+
+func actionRequest(t *testing.T, userID string, input interface{}) *http.Request {
+	t.Helper()
+	body, err := json.Marshal(map[string]interface{}{
+		"input":             map[string]interface{}{"input": input},
+		"session_variables": map[string]string{"x-hasura-user-id": userID},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return httptest.NewRequest(http.MethodPost, "/", strings.NewReader(string(body)))
+}
+
+func TestParseIncomingKey(t *testing.T) {
+	const otherUserID = "9c0a3f2b-8c1e-4d4a-9a1c-2e5f7d8b6d4a"
+	tests := []struct {
+		key    string
+		want   Bucket
+		wantOK bool
+	}{
+		{key: "incoming/avatars/" + testUserID + "/abc.png", want: BucketAvatars, wantOK: true},
+		{key: "incoming/step_images/" + testUserID + "/abc.webp", want: BucketStepImages, wantOK: true},
+		{key: "incoming/avatars/" + otherUserID + "/abc.png"},
+		{key: "avatars/" + testUserID + "/abc.png"},
+		{key: "incoming/profile_pics/" + testUserID + "/abc.png"},
+		{key: "incoming/avatars/" + testUserID + "/sub/abc.png"},
+		{key: "incoming/avatars/" + testUserID + "/../abc.png"},
+		{key: "incoming/avatars/" + testUserID + "/.hidden"},
+		{key: "incoming/avatars/" + testUserID + "/"},
+		{key: "incoming/avatars/" + testUserID},
+	}
+	for _, tt := range tests {
+		got, ok := parseIncomingKey(tt.key, testUserID)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("parseIncomingKey(%q) = %q, %v; want %q, %v", tt.key, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestCreateUploadUrl(t *testing.T) {
+	useDirectUploader(t)
+	rec := httptest.NewRecorder()
+	HandleCreateUploadUrl(rec, actionRequest(t, testUserID, CreateUploadUrlInput{Bucket: "step_images", ContentType: "image/webp", Size: 1234}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	var out CreateUploadUrlOutput
+	if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if bucket, ok := parseIncomingKey(out.Key, testUserID); !ok || bucket != BucketStepImages || !strings.HasSuffix(out.Key, ".webp") {
+		t.Errorf("key = %q", out.Key)
+	}
+	signed, err := url.Parse(out.UploadURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signed.Path != "/"+out.Key || signed.Query().Get("type") != "image/webp" || signed.Query().Get("size") != "1234" || signed.Query().Get("expires") != "15m0s" {
+		t.Errorf("upload URL = %s", out.UploadURL)
+	}
+	if out.Method != http.MethodPut || out.ContentType != "image/webp" || time.Until(out.ExpiresAt) > directUploadExpiry {
+		t.Errorf("response = %+v", out)
+	}
+}
+
+func TestCreateUploadUrlRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		userID string
+		input  CreateUploadUrlInput
+		status int
+		msg    string
+	}{
+		{"no user", "", CreateUploadUrlInput{ContentType: "image/png", Size: 1}, http.StatusUnauthorized, "Missing user ID"},
+		{"unknown bucket", testUserID, CreateUploadUrlInput{Bucket: "profile_pics", ContentType: "image/png", Size: 1}, http.StatusBadRequest, `unknown bucket "profile_pics"; use recipe_images, step_images or avatars`},
+		{"svg", testUserID, CreateUploadUrlInput{ContentType: "image/svg+xml", Size: 1}, http.StatusBadRequest, "Unsupported content type; upload a JPEG, PNG, WebP or GIF image"},
+		{"empty", testUserID, CreateUploadUrlInput{ContentType: "image/png"}, http.StatusBadRequest, "Size must be between 1 byte and 20 MB"},
+		{"too large", testUserID, CreateUploadUrlInput{ContentType: "image/png", Size: maxDirectUploadBytes + 1}, http.StatusBadRequest, "Size must be between 1 byte and 20 MB"},
+	}
+	useDirectUploader(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			HandleCreateUploadUrl(rec, actionRequest(t, tt.userID, tt.input))
+			var out struct{ Error string }
+			json.Unmarshal(rec.Body.Bytes(), &out)
+			if rec.Code != tt.status || out.Error != tt.msg {
+				t.Errorf("got %d %q, want %d %q", rec.Code, out.Error, tt.status, tt.msg)
+			}
+		})
+	}
+
+	// Local storage cannot sign uploads, so clients must use uploadFiles.
+	Store = NewLocalStorage(t.TempDir(), "https://cdn.example.com/files")
+	rec := httptest.NewRecorder()
+	HandleCreateUploadUrl(rec, actionRequest(t, testUserID, CreateUploadUrlInput{ContentType: "image/png", Size: 1}))
+	if rec.Code != http.StatusNotImplemented {
+		t.Errorf("local storage: status = %d, want 501", rec.Code)
+	}
+}
+
+// A confirmation that fails removes the incoming object however early it
+// fails, once the key is known to be the caller's.
+func TestConfirmUploadRejects(t *testing.T) {
+	const otherUserID = "9c0a3f2b-8c1e-4d4a-9a1c-2e5f7d8b6d4a"
+	notAnID := "recipe-1"
+	tests := []struct {
+		name   string
+		key    string
+		input  ConfirmUploadInput
+		status int
+		msg    string
+		kept   bool
+	}{
+		{"foreign key", "incoming/step_images/" + otherUserID + "/abc.png", ConfirmUploadInput{}, http.StatusForbidden, "Unknown upload key", true},
+		{"no recipe", "incoming/step_images/" + testUserID + "/abc.png", ConfirmUploadInput{}, http.StatusBadRequest, "recipeId is required for recipe and step images", false},
+		{"invalid recipe", "incoming/recipe_images/" + testUserID + "/abc.png", ConfirmUploadInput{RecipeID: &notAnID}, http.StatusBadRequest, "recipeId is not a valid ID", false},
+	}
+	f := useDirectUploader(t)
+	ctx := context.Background()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := f.Put(ctx, tt.key, []byte("data"), "image/png"); err != nil {
+				t.Fatal(err)
+			}
+			tt.input.Key = tt.key
+			rec := httptest.NewRecorder()
+			HandleConfirmUpload(rec, actionRequest(t, testUserID, tt.input))
+			var out struct{ Error string }
+			json.Unmarshal(rec.Body.Bytes(), &out)
+			if rec.Code != tt.status || out.Error != tt.msg {
+				t.Errorf("got %d %q, want %d %q", rec.Code, out.Error, tt.status, tt.msg)
+			}
+			if _, err := f.Get(ctx, tt.key); (err == nil) != tt.kept {
+				t.Errorf("incoming object kept = %v, want %v", err == nil, tt.kept)
+			}
+		})
+	}
+}
@@ -0,0 +1,269 @@
+package fileupload
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	hasura "github.com/wubshet-kebede/go-app/Hasura"
+)
+
+// Files are stored before the image rows that use them exist, so a recipe
+// form that is never saved leaves its uploads behind. The collector deletes
+// files nothing refers to once they are older than the grace period.
+
+const (
+	defaultGCGraceHours = 24
+	maxReportedOrphans  = 500
+)
+
+// gcGraceHours reads UPLOAD_GC_GRACE_HOURS, how old an unreferenced file must
+// be before it is collected.
+func gcGraceHours() int {
+	raw := os.Getenv("UPLOAD_GC_GRACE_HOURS")
+	if raw == "" {
+		return defaultGCGraceHours
+	}
+	hours, err := strconv.Atoi(raw)
+	if err != nil || hours < 1 {
+		log.Printf("Invalid UPLOAD_GC_GRACE_HOURS %q, using %d\n", raw, defaultGCGraceHours)
+		return defaultGCGraceHours
+	}
+	return hours
+}
+
+// gcDryRun reads UPLOAD_GC_DRY_RUN; scheduled runs then only report.
+func gcDryRun() bool {
+	dryRun, _ := strconv.ParseBool(os.Getenv("UPLOAD_GC_DRY_RUN"))
+	return dryRun
+}
+
+// UploadGCReport summarises one collector run. In a dry run nothing is
+// deleted and Orphans lists what would have been.
+type UploadGCReport struct {
+	DryRun         bool             `json:"dryRun"`
+	GraceHours     int              `json:"graceHours"`
+	ScannedFiles   int              `json:"scannedFiles"`
+	ScannedBytes   int64            `json:"scannedBytes"`
+	OrphanedFiles  int              `json:"orphanedFiles"`
+	OrphanedBytes  int64            `json:"orphanedBytes"`
+	DeletedFiles   int              `json:"deletedFiles"`
+	ReclaimedBytes int64            `json:"reclaimedBytes"`
+	FailedFiles    int              `json:"failedFiles"`
+	Orphans        []OrphanedUpload `json:"orphans"`
+}
+
+type OrphanedUpload struct {
+	Key        string    `json:"key"`
+	Size       int64     `json:"size"`
+	ModifiedAt time.Time `json:"modifiedAt"`
+}
+
+// uploadReferencesQuery loads every URL an image row points at, plus the
+// stored files that are referenced or were handed out since the cutoff.
+// Order items keep the image URL of what was bought for purchase history.
+type uploadReferencesQuery struct {
+	RecipeImages []struct {
+		ImageURL     string  `graphql:"image_url"`
+		ThumbnailURL *string `graphql:"thumbnail_url"`
+		CardURL      *string `graphql:"card_url"`
+	} `graphql:"recipe_images"`
+	ProfileImages []struct {
+		ImageURL string `graphql:"image_url"`
+	} `graphql:"profile_images"`
+	OrderItems []struct {
+		RecipeImageURL *string `graphql:"recipe_image_url"`
+	} `graphql:"order_items(where: {recipe_image_url: {_is_null: false}})"`
+	StoredFiles []struct {
+		Key string `graphql:"key"`
+	} `graphql:"stored_files(where: {_or: [{ref_count: {_gt: 0}}, {updated_at: {_gte: $cutoff}}]})"`
+}
+
+type upload_gc_runs_insert_input struct {
+	StartedAt      time.Time `json:"started_at"`
+	DryRun         bool      `json:"dry_run"`
+	GraceHours     int       `json:"grace_hours"`
+	ScannedFiles   int       `json:"scanned_files"`
+	ScannedBytes   int64     `json:"scanned_bytes"`
+	OrphanedFiles  int       `json:"orphaned_files"`
+	OrphanedBytes  int64     `json:"orphaned_bytes"`
+	DeletedFiles   int       `json:"deleted_files"`
+	ReclaimedBytes int64     `json:"reclaimed_bytes"`
+	FailedFiles    int       `json:"failed_files"`
+}
+
+type insertUploadGCRunMutation struct {
+	InsertUploadGcRunsOne *struct {
+		ID string `graphql:"id"`
+	} `graphql:"insert_upload_gc_runs_one(object: $object)"`
+}
+
+// keyForURL finds the storage key a stored image URL points at. URLs from
+// before the current backend, including the BASE_URL//app/uploads/ ones the
+// first upload handlers wrote, are matched by what follows /uploads/.
+func keyForURL(url string) (string, bool) {
+	if key, ok := strings.CutPrefix(url, Store.URL("")); ok && key != "" {
+		return key, true
+	}
+	if _, key, ok := strings.Cut(url, "/uploads/"); ok {
+		key = strings.TrimLeft(key, "/")
+		return key, key != ""
+	}
+	return "", false
+}
+
+// referencedKeys returns the keys of every file that must be kept.
+func referencedKeys(ctx context.Context, cutoff time.Time) (map[string]bool, error) {
+	var refs uploadReferencesQuery
+	vars := map[string]interface{}{"cutoff": timestamptz(cutoff.UTC().Format(time.RFC3339Nano))}
+	if err := hasura.Client.Query(ctx, &refs, vars); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]bool{}
+	add := func(url *string) {
+		if url == nil {
+			return
+		}
+		if key, ok := keyForURL(*url); ok {
+			keys[key] = true
+		}
+	}
+	for _, image := range refs.RecipeImages {
+		add(&image.ImageURL)
+		add(image.ThumbnailURL)
+		add(image.CardURL)
+	}
+	for _, image := range refs.ProfileImages {
+		add(&image.ImageURL)
+	}
+	for _, item := range refs.OrderItems {
+		add(item.RecipeImageURL)
+	}
+	for _, file := range refs.StoredFiles {
+		keys[file.Key] = true
+	}
+	return keys, nil
+}
+
+// collectOrphanedUploads scans storage and deletes, unless dryRun is set,
+// every file that is not referenced and was last written before the grace
+// period. If the references cannot be loaded nothing is deleted.
+func collectOrphanedUploads(ctx context.Context, now time.Time, graceHours int, dryRun bool) (UploadGCReport, error) {
+	report := UploadGCReport{DryRun: dryRun, GraceHours: graceHours, Orphans: []OrphanedUpload{}}
+	cutoff := now.Add(-time.Duration(graceHours) * time.Hour)
+
+	objects, err := Store.List(ctx, "")
+	if err != nil {
+		return report, err
+	}
+	keep, err := referencedKeys(ctx, cutoff)
+	if err != nil {
+		return report, err
+	}
+
+	for _, object := range objects {
+		report.ScannedFiles++
+		report.ScannedBytes += object.Size
+		if keep[object.Key] || object.ModTime.IsZero() || object.ModTime.After(cutoff) {
+			continue
+		}
+		report.OrphanedFiles++
+		report.OrphanedBytes += object.Size
+		if len(report.Orphans) < maxReportedOrphans {
+			report.Orphans = append(report.Orphans, OrphanedUpload{Key: object.Key, Size: object.Size, ModifiedAt: object.ModTime})
+		}
+		if dryRun {
+			continue
+		}
+		// Checked again against stored_files, in case it was reused meanwhile
+		deleted, err := deleteUnreferencedFile(ctx, object.Key, cutoff)
+		if err != nil {
+			log.Printf("Could not collect %s: %v\n", object.Key, err)
+			report.FailedFiles++
+			continue
+		}
+		if deleted {
+			report.DeletedFiles++
+			report.ReclaimedBytes += object.Size
+		}
+	}
+	return report, nil
+}
+
+// recordGCRun stores the run's totals in upload_gc_runs.
+func recordGCRun(ctx context.Context, startedAt time.Time, report UploadGCReport) error {
+	var resp insertUploadGCRunMutation
+	object := upload_gc_runs_insert_input{
+		StartedAt:      startedAt,
+		DryRun:         report.DryRun,
+		GraceHours:     report.GraceHours,
+		ScannedFiles:   report.ScannedFiles,
+		ScannedBytes:   report.ScannedBytes,
+		OrphanedFiles:  report.OrphanedFiles,
+		OrphanedBytes:  report.OrphanedBytes,
+		DeletedFiles:   report.DeletedFiles,
+		ReclaimedBytes: report.ReclaimedBytes,
+		FailedFiles:    report.FailedFiles,
+	}
+	return hasura.Client.Mutate(ctx, &resp, map[string]interface{}{"object": object})
+}
+
+// validCronRequest checks the shared secret Hasura sends with scheduled
+// triggers in the X-Cron-Secret header.
+func validCronRequest(r *http.Request) bool {
+	secret := os.Getenv("CRON_SECRET")
+	if secret == "" {
+		log.Println("❌ CRON_SECRET is not configured; rejecting scheduled trigger")
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Cron-Secret")), []byte(secret)) == 1
+}
+
+// HandleCollectOrphanedUploads handles the Hasura cron trigger that deletes
+// orphaned uploads. A payload of {"dryRun": true} only reports them, as does
+// UPLOAD_GC_DRY_RUN=true for scheduled runs.
+func HandleCollectOrphanedUploads(w http.ResponseWriter, r *http.Request) {
+	if !validCronRequest(r) {
+		respondWithError(w, http.StatusUnauthorized, "Invalid cron secret")
+		return
+	}
+	defer r.Body.Close()
+	var trigger struct {
+		Payload struct {
+			DryRun *bool `json:"dryRun"`
+		} `json:"payload"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 64<<10)).Decode(&trigger); err != nil && !errors.Is(err, io.EOF) {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	dryRun := gcDryRun()
+	if trigger.Payload.DryRun != nil {
+		dryRun = *trigger.Payload.DryRun
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	startedAt := time.Now()
+	report, err := collectOrphanedUploads(ctx, startedAt, gcGraceHours(), dryRun)
+	if err != nil {
+		log.Printf("Failed to collect orphaned uploads: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to collect orphaned uploads")
+		return
+	}
+	log.Printf("Upload GC (dry run: %t): %d of %d files orphaned, %d deleted, %d bytes reclaimed, %d failed\n",
+		report.DryRun, report.OrphanedFiles, report.ScannedFiles, report.DeletedFiles, report.ReclaimedBytes, report.FailedFiles)
+	if err := recordGCRun(ctx, startedAt, report); err != nil {
+		log.Printf("Failed to record upload GC run: %v", err)
+	}
+	respondWithJSON(w, http.StatusOK, report)
+}
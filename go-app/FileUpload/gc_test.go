@@ -0,0 +1,201 @@
+package fileupload
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeyForURL(t *testing.T) {
+	useTestStorage(t)
+	tests := []struct {
+		url  string
+		want string
+		ok   bool
+	}{
+		{url: "https://cdn.example.com/files/avatars/" + testUserID + "/abc.png", want: "avatars/" + testUserID + "/abc.png", ok: true},
+		{url: "http://localhost:8082/uploads/profile_pics/profile_1.png", want: "profile_pics/profile_1.png", ok: true},
+		{url: "http://localhost:8082//app/uploads/20240101_x.png", want: "20240101_x.png", ok: true},
+		{url: "https://cdn.example.com/files/", ok: false},
+		{url: "http://localhost:8082/uploads/", ok: false},
+		{url: "https://elsewhere.example.com/image.png", ok: false},
+	}
+	for _, tt := range tests {
+		got, ok := keyForURL(tt.url)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("keyForURL(%q) = %q, %v; want %q, %v", tt.url, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+// gcFixture stores files of the given ages and answers the references
+// query with the URLs and stored_files rows the test sets up.
+type gcFixture struct {
+	storage *LocalStorage
+	files   *fakeStoredFiles
+	now     time.Time
+	refs    map[string][]string
+}
+
+func newGCFixture(t *testing.T) *gcFixture {
+	f := &gcFixture{storage: useTestStorage(t), now: time.Now(), refs: map[string][]string{}}
+	var h *fakeHasura
+	h, f.files = useFakeStoredFiles(t)
+	for _, field := range []struct{ name, column string }{
+		{"recipe_images", "image_url"},
+		{"profile_images", "image_url"},
+		{"order_items", "recipe_image_url"},
+	} {
+		field := field
+		h.on(field.name, func(map[string]json.RawMessage) string {
+			rows := []map[string]string{}
+			for _, url := range f.refs[field.name] {
+				rows = append(rows, map[string]string{field.column: url})
+			}
+			out, _ := json.Marshal(rows)
+			return string(out)
+		})
+	}
+	h.on("stored_files", func(vars map[string]json.RawMessage) string {
+		var cutoff time.Time
+		json.Unmarshal(vars["cutoff"], &cutoff)
+		f.files.mu.Lock()
+		defer f.files.mu.Unlock()
+		rows := []map[string]string{}
+		for key, row := range f.files.rows {
+			if row.RefCount > 0 || !row.UpdatedAt.Before(cutoff) {
+				rows = append(rows, map[string]string{"key": key})
+			}
+		}
+		out, _ := json.Marshal(rows)
+		return string(out)
+	})
+	return f
+}
+
+// put stores a file last written age ago and returns its key.
+func (f *gcFixture) put(t *testing.T, key string, age time.Duration) string {
+	t.Helper()
+	if _, err := f.storage.Put(context.Background(), key, []byte(key), "image/png"); err != nil {
+		t.Fatal(err)
+	}
+	modTime := f.now.Add(-age)
+	if err := os.Chtimes(filepath.Join(f.storage.root, filepath.FromSlash(key)), modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// record adds a stored_files row for key, last used age ago.
+func (f *gcFixture) record(key string, refCount int, age time.Duration) {
+	f.files.mu.Lock()
+	defer f.files.mu.Unlock()
+	f.files.rows[key] = &storedFileRow{
+		stored_files_insert_input: stored_files_insert_input{Key: key, URL: f.storage.URL(key), UpdatedAt: f.now.Add(-age)},
+		RefCount:                  refCount,
+	}
+}
+
+func (f *gcFixture) exists(key string) bool {
+	_, err := f.storage.Get(context.Background(), key)
+	return !errors.Is(err, fs.ErrNotExist)
+}
+
+func TestCollectOrphanedUploads(t *testing.T) {
+	f := newGCFixture(t)
+	old := 48 * time.Hour
+	prefix := "recipe_images/" + testUserID + "/"
+
+	recipeImage := f.put(t, prefix+"recipe.png", old)
+	thumbnail := f.put(t, prefix+"thumb.png", old)
+	legacyAvatar := f.put(t, "profile_pics/profile_1.png", old)
+	bought := f.put(t, prefix+"bought.png", old)
+	counted := f.put(t, prefix+"counted.png", old)
+	reused := f.put(t, prefix+"reused.png", old)
+	recent := f.put(t, prefix+"recent.png", time.Hour)
+	orphan := f.put(t, prefix+"orphan.png", old)
+	orphanRow := f.put(t, prefix+"orphan-row.png", old)
+
+	f.refs["recipe_images"] = []string{f.storage.URL(recipeImage), f.storage.URL(thumbnail)}
+	f.refs["profile_images"] = []string{"http://localhost:8082//app/uploads/" + legacyAvatar}
+	f.refs["order_items"] = []string{f.storage.URL(bought)}
+	f.record(counted, 1, old)
+	f.record(reused, 0, time.Hour)
+	f.record(orphanRow, 0, old)
+
+	report, err := collectOrphanedUploads(context.Background(), f.now, 24, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{recipeImage, thumbnail, legacyAvatar, bought, counted, reused, recent} {
+		if !f.exists(key) {
+			t.Errorf("%s was collected", key)
+		}
+	}
+	for _, key := range []string{orphan, orphanRow} {
+		if f.exists(key) {
+			t.Errorf("%s was kept", key)
+		}
+	}
+	if f.files.has(orphanRow) {
+		t.Errorf("the stored_files row of %s was kept", orphanRow)
+	}
+	if report.ScannedFiles != 9 || report.OrphanedFiles != 2 || report.DeletedFiles != 2 || report.FailedFiles != 0 ||
+		report.ReclaimedBytes != int64(len(orphan)+len(orphanRow)) || len(report.Orphans) != 2 {
+		t.Errorf("report = %+v", report)
+	}
+}
+
+func TestCollectOrphanedUploadsDryRun(t *testing.T) {
+	f := newGCFixture(t)
+	orphan := f.put(t, "step_images/"+testUserID+"/orphan.png", 25*time.Hour)
+	young := f.put(t, "step_images/"+testUserID+"/young.png", 23*time.Hour)
+
+	report, err := collectOrphanedUploads(context.Background(), f.now, 24, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.exists(orphan) || !f.exists(young) {
+		t.Error("a dry run deleted files")
+	}
+	if !report.DryRun || report.OrphanedFiles != 1 || report.DeletedFiles != 0 || len(report.Orphans) != 1 || report.Orphans[0].Key != orphan {
+		t.Errorf("report = %+v", report)
+	}
+}
+
+func TestCollectOrphanedUploadsRequiresSecret(t *testing.T) {
+	t.Setenv("CRON_SECRET", "s3cret")
+	f := newGCFixture(t)
+	orphan := f.put(t, "avatars/"+testUserID+"/orphan.png", 48*time.Hour)
+
+	for _, secret := range []string{"", "wrong"} {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"payload":{}}`))
+		req.Header.Set("X-Cron-Secret", secret)
+		rec := httptest.NewRecorder()
+		HandleCollectOrphanedUploads(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("secret %q: status = %d, want 401", secret, rec.Code)
+		}
+	}
+	if !f.exists(orphan) {
+		t.Fatal("an unauthenticated request collected files")
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"payload":{"dryRun":true}}`))
+	req.Header.Set("X-Cron-Secret", "s3cret")
+	rec := httptest.NewRecorder()
+	HandleCollectOrphanedUploads(rec, req)
+	var report UploadGCReport
+	json.Unmarshal(rec.Body.Bytes(), &report)
+	if rec.Code != http.StatusOK || !report.DryRun || report.OrphanedFiles != 1 || !f.exists(orphan) {
+		t.Errorf("dry run: status %d, report %+v", rec.Code, report)
+	}
+}
@@ -0,0 +1,155 @@
+package fileupload
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hasura/go-graphql-client"
+	hasura "github.com/wubshet-kebede/go-app/Hasura"
+)
+
+// fakeHasura stands in for hasura.Client in handler tests. It answers each
+// root field a test registers with JSON built from the operation's
+// variables, and records the operations it receives.
+type fakeHasura struct {
+	mu        sync.Mutex
+	responses map[string]func(vars map[string]json.RawMessage) string
+	requests  []graphqlRequest
+}
+
+type graphqlRequest struct {
+	Query     string                     `json:"query"`
+	Variables map[string]json.RawMessage `json:"variables"`
+}
+
+// useFakeHasura points hasura.Client at a new fakeHasura for the test.
+func useFakeHasura(t *testing.T) *fakeHasura {
+	h := &fakeHasura{responses: make(map[string]func(map[string]json.RawMessage) string)}
+	server := httptest.NewServer(http.HandlerFunc(h.serve))
+	saved := hasura.Client
+	t.Cleanup(func() {
+		hasura.Client = saved
+		server.Close()
+	})
+	hasura.Client = graphql.NewClient(server.URL, server.Client())
+	return h
+}
+
+// on answers the root field with a value built from the operation's variables.
+func (h *fakeHasura) on(field string, value func(vars map[string]json.RawMessage) string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.responses[field] = value
+}
+
+// calls returns the operations that selected the root field, oldest first.
+func (h *fakeHasura) calls(field string) []graphqlRequest {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var matched []graphqlRequest
+	for _, req := range h.requests {
+		if selects(req.Query, field) {
+			matched = append(matched, req)
+		}
+	}
+	return matched
+}
+
+func (h *fakeHasura) serve(w http.ResponseWriter, r *http.Request) {
+	var req graphqlRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.mu.Lock()
+	h.requests = append(h.requests, req)
+	var fields []string
+	for field, value := range h.responses {
+		if selects(req.Query, field) {
+			fields = append(fields, `"`+field+`":`+value(req.Variables))
+		}
+	}
+	h.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"data":{` + strings.Join(fields, ",") + `}}`))
+}
+
+// selects reports whether a GraphQL document selects the root field.
+func selects(query string, field string) bool {
+	return regexp.MustCompile(`(^|[^\w])` + regexp.QuoteMeta(field) + `(: \w+)?[({]`).MatchString(query)
+}
+
+// fakeStoredFiles keeps stored_files rows in memory behind a fakeHasura.
+// Reference counts are set by the test, as the database triggers would.
+type fakeStoredFiles struct {
+	mu   sync.Mutex
+	rows map[string]*storedFileRow
+}
+
+type storedFileRow struct {
+	stored_files_insert_input
+	RefCount int
+}
+
+func useFakeStoredFiles(t *testing.T) (*fakeHasura, *fakeStoredFiles) {
+	h := useFakeHasura(t)
+	files := &fakeStoredFiles{rows: map[string]*storedFileRow{}}
+	h.on("stored_files_by_pk", func(vars map[string]json.RawMessage) string {
+		var key string
+		json.Unmarshal(vars["key"], &key)
+		files.mu.Lock()
+		defer files.mu.Unlock()
+		row, ok := files.rows[key]
+		if !ok {
+			return "null"
+		}
+		out, _ := json.Marshal(map[string]interface{}{"url": row.URL, "ref_count": row.RefCount})
+		return string(out)
+	})
+	h.on("insert_stored_files_one", func(vars map[string]json.RawMessage) string {
+		var object stored_files_insert_input
+		json.Unmarshal(vars["object"], &object)
+		files.mu.Lock()
+		defer files.mu.Unlock()
+		if row, ok := files.rows[object.Key]; ok {
+			row.UpdatedAt = object.UpdatedAt
+		} else {
+			files.rows[object.Key] = &storedFileRow{stored_files_insert_input: object}
+		}
+		out, _ := json.Marshal(map[string]string{"url": files.rows[object.Key].URL})
+		return string(out)
+	})
+	h.on("delete_stored_files", func(vars map[string]json.RawMessage) string {
+		var key string
+		var cutoff time.Time
+		json.Unmarshal(vars["key"], &key)
+		json.Unmarshal(vars["cutoff"], &cutoff)
+		files.mu.Lock()
+		defer files.mu.Unlock()
+		if row, ok := files.rows[key]; ok && row.RefCount == 0 && row.UpdatedAt.Before(cutoff) {
+			delete(files.rows, key)
+			return `{"affected_rows":1}`
+		}
+		return `{"affected_rows":0}`
+	})
+	return h, files
+}
+
+func (f *fakeStoredFiles) setRefCount(key string, count int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rows[key].RefCount = count
+}
+
+func (f *fakeStoredFiles) has(key string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.rows[key]
+	return ok
+}
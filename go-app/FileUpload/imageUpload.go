@@ -1,17 +1,18 @@
 package fileupload
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
+	hasura "github.com/wubshet-kebede/go-app/Hasura"
 )
 
 // Request structs
@@ -21,7 +22,7 @@ type ImageInput struct {
 
 type UploadProfilePictureInput struct {
 	File   ImageInput `json:"file"`
-	Folder string    `json:"folder"`
+	Folder string     `json:"folder"`
 }
 
 type ProfileInput struct {
@@ -41,26 +42,109 @@ type UploadProfilePictureOutput struct {
 	ProfilePictureUrl string `json:"profilePictureUrl"`
 }
 
-func UploadProfilePicHandler(w http.ResponseWriter, r *http.Request) {
-	log.Println("UploadProfilePicHandler called")
-	w.Header().Set("Content-Type", "application/json")
+type profile_images_insert_input struct {
+	UserID    string    `json:"user_id"`
+	ImageURL  string    `json:"image_url"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// upsertProfileImageMutation replaces the user's current picture; there is
+// at most one profile_images row per user.
+type upsertProfileImageMutation struct {
+	InsertProfileImagesOne *struct {
+		ID string `graphql:"id"`
+	} `graphql:"insert_profile_images_one(object: $object, on_conflict: {constraint: profile_images_user_id_key, update_columns: [image_url, updated_at]})"`
+}
+
+// decodeBase64Image decodes a base64 file, with or without a
+// "data:...;base64," prefix. The media type the client declared is ignored;
+// validateImage goes by the file's magic bytes.
+func decodeBase64Image(encoded string) ([]byte, error) {
+	rawBase64 := encoded
+	if header, rest, ok := strings.Cut(encoded, ","); ok {
+		if !strings.HasPrefix(header, "data:") || !strings.HasSuffix(header, ";base64") {
+			return nil, uploadError{"invalid base64 data URL"}
+		}
+		rawBase64 = rest
+	}
+	if base64.StdEncoding.DecodedLen(len(rawBase64)) > maxImageBytes+2 {
+		return nil, uploadError{fmt.Sprintf("file is larger than %d MB", maxImageBytes>>20)}
+	}
+	data, err := base64.StdEncoding.DecodeString(rawBase64)
+	if err != nil {
+		return nil, uploadError{"base64 decode failed"}
+	}
+	return data, nil
+}
+
+// decodeImage decodes a base64 image and validates its content.
+func decodeImage(encoded string) (imageInfo, []byte, error) {
+	data, err := decodeBase64Image(encoded)
+	if err != nil {
+		return imageInfo{}, nil, err
+	}
+	info, err := validateImage(data)
+	if err != nil {
+		return imageInfo{}, nil, err
+	}
+	return info, data, nil
+}
 
-	baseURL := os.Getenv("BASE_URL")
-	if baseURL == "" {
-		port := os.Getenv("PORT")
-		if port == "" {
-			port = "8082"
+// removeOldProfilePictures deletes every stored picture of the user except
+// the current one, including those older versions left in profile_pics.
+func removeOldProfilePictures(ctx context.Context, userID string, current string) {
+	prefix, err := namespaceKey(BucketAvatars, userID)
+	if err != nil {
+		return
+	}
+	for _, p := range []string{prefix, "profile_pics/profile_" + userID} {
+		objects, err := Store.List(ctx, p)
+		if err != nil {
+			log.Printf("Error listing old profile pictures of %s: %v\n", userID, err)
+			continue
 		}
-		baseURL = fmt.Sprintf("http://localhost:%s", port)
+		for _, object := range objects {
+			if object.Key != current {
+				releaseFile(ctx, object.Key)
+			}
+		}
+	}
+}
+
+// setProfilePicture stores an avatar variant and records it as the user's
+// current picture, removing the previous ones. It returns the profile_images
+// row ID and the picture's URL.
+func setProfilePicture(ctx context.Context, userID string, avatar imageVariant) (string, string, error) {
+	// Files are named by content, so browsers and CDNs never serve a stale picture
+	key, fullURL, err := storeFile(ctx, BucketAvatars, userID, avatar.data, avatar.ext)
+	if err != nil {
+		log.Printf("File write error: %v\n", err)
+		return "", "", uploadError{"Could not save file"}
 	}
 
-	// Read request body
-	body, err := io.ReadAll(r.Body)
+	var mutationResp upsertProfileImageMutation
+	vars := map[string]interface{}{
+		"object": profile_images_insert_input{UserID: userID, ImageURL: fullURL, UpdatedAt: time.Now()},
+	}
+	if err := hasura.Client.Mutate(ctx, &mutationResp, vars); err != nil || mutationResp.InsertProfileImagesOne == nil {
+		log.Printf("Error saving profile picture of %s: %v\n", userID, err)
+		releaseFile(ctx, key)
+		return "", "", uploadError{"Could not save profile picture"}
+	}
+	removeOldProfilePictures(ctx, userID, key)
+	return mutationResp.InsertProfileImagesOne.ID, fullURL, nil
+}
+
+func UploadProfilePicHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("UploadProfilePicHandler called")
+	w.Header().Set("Content-Type", "application/json")
+
+	// Read request body; base64 is a third larger than the image it holds
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxImageBytes/3*4+64<<10))
 	defer r.Body.Close()
 	if err != nil {
 		log.Printf("Error reading body: %v\n", err)
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Could not read request body"})
+		respondWithError(w, http.StatusBadRequest, "Could not read request body")
 		return
 	}
 
@@ -68,70 +152,42 @@ func UploadProfilePicHandler(w http.ResponseWriter, r *http.Request) {
 	err = json.Unmarshal(body, &hasuraReq)
 	if err != nil {
 		log.Printf("Hasura Action parse error: %v\n", err)
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid Hasura Action request"})
+		respondWithError(w, http.StatusBadRequest, "Invalid Hasura Action request")
 		return
 	}
 
+	// The user ID names the file, so it must be a real UUID
+	userID := hasuraReq.SessionVariables["x-hasura-user-id"]
+	if _, err := uuid.Parse(userID); err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Missing user ID")
+		return
+	}
 	req := hasuraReq.Input.Input
-	file := req.File
-
-	// Folder for profile pictures
-	folder := req.Folder
-	if folder == "" {
-		folder = "/app/uploads/profile_pics"
+	if req.Folder != "" {
+		log.Printf("Ignoring requested folder %q for profile picture of %s\n", req.Folder, userID)
 	}
-	err = os.MkdirAll(folder, os.ModePerm)
+
+	info, data, err := decodeImage(req.File.Base64)
 	if err != nil {
-		log.Printf("Folder creation error: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Could not create folder"})
+		respondWithError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	// Decode base64
-	commaIndex := strings.Index(file.Base64, ",")
-	if commaIndex == -1 {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid base64 string"})
-		return
-	}
-	rawBase64 := file.Base64[commaIndex+1:]
-	data, err := base64.StdEncoding.DecodeString(rawBase64)
+	variants, err := makeVariants(info, data, avatarVariants)
 	if err != nil {
-		log.Printf("Base64 decode error: %v\n", err)
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Base64 decode failed"})
+		log.Printf("Image processing error: %v\n", err)
+		respondWithError(w, http.StatusInternalServerError, "Could not process image")
 		return
 	}
 
-	// Determine extension
-	ext := ".png"
-	if strings.Contains(file.Base64, "image/jpeg") || strings.Contains(file.Base64, "image/jpg") {
-		ext = ".jpg"
-	} else if strings.Contains(file.Base64, "image/gif") {
-		ext = ".gif"
-	}
-
-	// Filename based on user ID (overwrite existing)
-	userID := hasuraReq.SessionVariables["x-hasura-user-id"]
-	if userID == "" {
-		userID = uuid.New().String() // fallback
-	}
-	filename := fmt.Sprintf("profile_%s%s", userID, ext)
-	filePath := filepath.Join(folder, filename)
-
-	// Save file
-	err = os.WriteFile(filePath, data, 0644)
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	_, fullURL, err := setProfilePicture(ctx, userID, variants[0])
 	if err != nil {
-		log.Printf("File write error: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Could not save file"})
+		respondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	fullURL := fmt.Sprintf("%s/%s", baseURL, filepath.ToSlash(filePath))
-
 	// Return response
 	response := UploadProfilePictureOutput{
 		Success:           true,
@@ -0,0 +1,100 @@
+package fileupload
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+)
+
+// maxMultipartBytes caps a whole multipart request: every file at its limit
+// plus room for part headers.
+const maxMultipartBytes = maxUploadFiles*maxImageBytes + 1<<20
+
+// MultipartUploadHandler accepts images as multipart/form-data, one per part
+// with a file name, from a caller main has already authenticated. The
+// bucket is picked with ?bucket=. Parts are read one at a time straight from
+// the request, never buffering more than a single file, and the response
+// has the same shape as the uploadFiles action.
+func MultipartUploadHandler(w http.ResponseWriter, r *http.Request, userID string) {
+	w.Header().Set("Content-Type", "application/json")
+	bucket, err := ParseBucket(r.URL.Query().Get("bucket"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if _, err := namespaceKey(bucket, userID); err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Missing user ID")
+		return
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "multipart/form-data" {
+		respondWithError(w, http.StatusUnsupportedMediaType, "Send files as multipart/form-data")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxMultipartBytes)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid multipart request")
+		return
+	}
+
+	results := newUploadResults()
+	count := 0
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Printf("Multipart read error from %s: %v\n", userID, err)
+			respondWithError(w, http.StatusBadRequest, "Could not read request body")
+			return
+		}
+		if part.FileName() == "" {
+			part.Close()
+			continue
+		}
+		if count == maxUploadFiles {
+			part.Close()
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("At most %d files can be uploaded at once", maxUploadFiles))
+			return
+		}
+
+		data, err := readPart(part)
+		part.Close()
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				respondWithError(w, http.StatusRequestEntityTooLarge, "Request is too large")
+				return
+			}
+			var rejected uploadError
+			if !errors.As(err, &rejected) {
+				log.Printf("Read error for file %d from %s: %v\n", count, userID, err)
+				err = uploadError{"Could not read file"}
+			}
+			results.add(count, UploadedImage{}, err)
+		} else {
+			uploaded, err := saveImage(r.Context(), bucket, userID, data)
+			results.add(count, uploaded, err)
+		}
+		count++
+	}
+	writeUploadResults(w, results, count)
+}
+
+// readPart reads one file part, refusing it as soon as it passes
+// maxImageBytes rather than reading the rest.
+func readPart(part io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(part, maxImageBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxImageBytes {
+		return nil, uploadError{fmt.Sprintf("file is larger than %d MB", maxImageBytes>>20)}
+	}
+	return data, nil
+}
@@ -0,0 +1,185 @@
+package fileupload
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type testPart struct {
+	fileName string
+	data     []byte
+}
+
+func multipartRequest(t *testing.T, bucket string, parts ...testPart) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, part := range parts {
+		var err error
+		if part.fileName == "" {
+			err = mw.WriteField("note", string(part.data))
+		} else {
+			var fw io.Writer
+			fw, err = mw.CreateFormFile("file", part.fileName)
+			if err == nil {
+				_, err = fw.Write(part.data)
+			}
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/upload?bucket="+bucket, &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+func useTestStorage(t *testing.T) *LocalStorage {
+	t.Helper()
+	saved := Store
+	t.Cleanup(func() { Store = saved })
+	s := NewLocalStorage(t.TempDir(), "https://cdn.example.com/files")
+	Store = s
+	return s
+}
+
+func TestMultipartUpload(t *testing.T) {
+	s := useTestStorage(t)
+	useFakeStoredFiles(t)
+	req := multipartRequest(t, "step_images",
+		testPart{fileName: "a.png", data: encodeTestImage(t, "png", 40, 30)},
+		testPart{data: []byte("fields without a file name are skipped")},
+		testPart{fileName: "b.txt", data: []byte("not an image")},
+		testPart{fileName: "big.png", data: make([]byte, maxImageBytes+1)},
+	)
+	rec := httptest.NewRecorder()
+	MultipartUploadHandler(rec, req, testUserID)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	var out UploadFilesOutput
+	if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Success || len(out.Files) != 1 || out.Message != "2 of 3 files were not uploaded" {
+		t.Fatalf("response = %+v", out)
+	}
+	if file := out.Files[0]; file.Width != 40 || file.Height != 30 ||
+		!strings.HasPrefix(file.URL, "https://cdn.example.com/files/step_images/"+testUserID+"/") {
+		t.Errorf("file = %+v", file)
+	}
+	wantErrors := []UploadFileError{
+		{Index: 1, Error: "unsupported file type; upload a JPEG, PNG, WebP or GIF image"},
+		{Index: 2, Error: "file is larger than 5 MB"},
+	}
+	if fmt.Sprint(out.Errors) != fmt.Sprint(wantErrors) {
+		t.Errorf("errors = %+v, want %+v", out.Errors, wantErrors)
+	}
+	// The image is smaller than every variant, so they are all the same file.
+	if keys, _ := s.List(context.Background(), "step_images/"+testUserID+"/"); len(keys) != 1 || out.Files[0].ThumbnailURL != out.Files[0].URL {
+		t.Errorf("stored %v for %+v, want a single file", keys, out.Files[0])
+	}
+}
+
+func TestMultipartUploadPartLimit(t *testing.T) {
+	s := useTestStorage(t)
+	useFakeStoredFiles(t)
+	parts := make([]testPart, maxUploadFiles+1)
+	for i := range parts {
+		parts[i] = testPart{fileName: fmt.Sprintf("%d.png", i), data: encodeTestImage(t, "png", 4+i, 4)}
+	}
+	rec := httptest.NewRecorder()
+	MultipartUploadHandler(rec, multipartRequest(t, "", parts...), testUserID)
+
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "At most 10 files can be uploaded at once") {
+		t.Errorf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	// The files before the extra part were read one by one and stored. Every
+	// variant of an image this small has the same content, so one key each.
+	if keys, _ := s.List(context.Background(), "recipe_images/"+testUserID+"/"); len(keys) != maxUploadFiles {
+		t.Errorf("stored %d keys, want %d", len(keys), maxUploadFiles)
+	}
+}
+
+func TestMultipartUploadRejects(t *testing.T) {
+	useTestStorage(t)
+	image := testPart{fileName: "a.png", data: encodeTestImage(t, "png", 4, 4)}
+	tests := []struct {
+		name   string
+		req    func() *http.Request
+		userID string
+		status int
+	}{
+		{
+			name:   "unknown bucket",
+			req:    func() *http.Request { return multipartRequest(t, "secrets", image) },
+			userID: testUserID,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "no user",
+			req:    func() *http.Request { return multipartRequest(t, "", image) },
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "not multipart",
+			req: func() *http.Request {
+				req := httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader(`{"files":[]}`))
+				req.Header.Set("Content-Type", "application/json")
+				return req
+			},
+			userID: testUserID,
+			status: http.StatusUnsupportedMediaType,
+		},
+		{
+			name: "malformed body",
+			req: func() *http.Request {
+				req := httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader("no boundary here"))
+				req.Header.Set("Content-Type", "multipart/form-data; boundary=x")
+				return req
+			},
+			userID: testUserID,
+			status: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			MultipartUploadHandler(rec, tt.req(), tt.userID)
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d; body %s", rec.Code, tt.status, rec.Body)
+			}
+		})
+	}
+}
+
+// A body cut off inside a file is reported without the reader's error.
+func TestMultipartUploadTruncated(t *testing.T) {
+	s := useTestStorage(t)
+	useFakeStoredFiles(t)
+	full := multipartRequest(t, "step_images", testPart{fileName: "a.png", data: encodeTestImage(t, "png", 40, 30)})
+	body, _ := io.ReadAll(full.Body)
+	req := httptest.NewRequest(http.MethodPost, "/upload?bucket=step_images", bytes.NewReader(body[:len(body)/2]))
+	req.Header.Set("Content-Type", full.Header.Get("Content-Type"))
+	rec := httptest.NewRecorder()
+	MultipartUploadHandler(rec, req, testUserID)
+
+	if rec.Code != http.StatusBadRequest || strings.Contains(rec.Body.String(), "EOF") {
+		t.Errorf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	if keys, _ := s.List(context.Background(), "step_images/"+testUserID+"/"); len(keys) != 0 {
+		t.Errorf("stored %v from a truncated file", keys)
+	}
+}
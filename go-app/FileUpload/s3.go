@@ -0,0 +1,362 @@
+package fileupload
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3Storage keeps files in a bucket of an S3-compatible service such as
+// AWS S3 or MinIO. Requests are signed with AWS Signature Version 4.
+type S3Storage struct {
+	endpoint        *url.URL
+	region          string
+	bucket          string
+	accessKeyID     string
+	secretAccessKey string
+	pathStyle       bool
+	publicURL       string
+	presignEndpoint *url.URL
+	client          *http.Client
+	now             func() time.Time
+}
+
+// NewS3StorageFromEnv configures S3 storage from S3_ENDPOINT (e.g.
+// http://minio:9000), S3_REGION, S3_BUCKET, S3_ACCESS_KEY_ID and
+// S3_SECRET_ACCESS_KEY. S3_PUBLIC_URL is where objects are served from, such
+// as a CDN; it defaults to the bucket's own URL, which must then allow public
+// reads. S3_PRESIGN_ENDPOINT is the endpoint as clients reach it, for signed
+// upload URLs, when that differs from S3_ENDPOINT. S3_FORCE_PATH_STYLE=false
+// switches to virtual-hosted bucket URLs.
+func NewS3StorageFromEnv() (*S3Storage, error) {
+	endpoint, err := url.Parse(os.Getenv("S3_ENDPOINT"))
+	if err != nil || endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("S3_ENDPOINT must be a URL such as http://minio:9000")
+	}
+	bucket := os.Getenv("S3_BUCKET")
+	accessKeyID := os.Getenv("S3_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("S3_SECRET_ACCESS_KEY")
+	if bucket == "" || accessKeyID == "" || secretAccessKey == "" {
+		return nil, fmt.Errorf("S3_BUCKET, S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY are required")
+	}
+	region := os.Getenv("S3_REGION")
+	if region == "" {
+		region = "us-east-1"
+	}
+	pathStyle := true
+	if raw := os.Getenv("S3_FORCE_PATH_STYLE"); raw != "" {
+		pathStyle, err = strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid S3_FORCE_PATH_STYLE %q", raw)
+		}
+	}
+
+	s := &S3Storage{
+		endpoint:        endpoint,
+		region:          region,
+		bucket:          bucket,
+		accessKeyID:     accessKeyID,
+		secretAccessKey: secretAccessKey,
+		pathStyle:       pathStyle,
+		client:          &http.Client{Timeout: 30 * time.Second},
+		now:             time.Now,
+	}
+	s.presignEndpoint = endpoint
+	if raw := os.Getenv("S3_PRESIGN_ENDPOINT"); raw != "" {
+		s.presignEndpoint, err = url.Parse(raw)
+		if err != nil || s.presignEndpoint.Scheme == "" || s.presignEndpoint.Host == "" {
+			return nil, fmt.Errorf("invalid S3_PRESIGN_ENDPOINT %q", raw)
+		}
+	}
+	s.publicURL = strings.TrimRight(os.Getenv("S3_PUBLIC_URL"), "/")
+	if s.publicURL == "" {
+		s.publicURL = strings.TrimRight(s.objectURL(s.endpoint, "").String(), "/")
+	}
+	return s, nil
+}
+
+// objectURL is the URL of key at endpoint, or of the bucket when key is empty.
+func (s *S3Storage) objectURL(endpoint *url.URL, key string) *url.URL {
+	u := *endpoint
+	u.Path = strings.TrimRight(u.Path, "/")
+	if s.pathStyle {
+		u.Path += "/" + s.bucket
+	} else {
+		u.Host = s.bucket + "." + u.Host
+	}
+	u.Path += "/" + key
+	u.RawPath = ""
+	return &u
+}
+
+func (s *S3Storage) Put(ctx context.Context, key string, data []byte, contentType string) (string, error) {
+	if !validKey(key) {
+		return "", fmt.Errorf("invalid storage key %q", key)
+	}
+	headers := map[string]string{"Content-Type": contentType}
+	if _, _, err := s.do(ctx, http.MethodPut, key, nil, headers, data); err != nil {
+		return "", err
+	}
+	return s.URL(key), nil
+}
+
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	if !validKey(key) {
+		return fmt.Errorf("invalid storage key %q", key)
+	}
+	_, _, err := s.do(ctx, http.MethodDelete, key, nil, nil, nil)
+	return err
+}
+
+func (s *S3Storage) Get(ctx context.Context, key string) ([]byte, error) {
+	if !validKey(key) {
+		return nil, fmt.Errorf("invalid storage key %q", key)
+	}
+	_, body, err := s.do(ctx, http.MethodGet, key, nil, nil, nil)
+	return body, err
+}
+
+func (s *S3Storage) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	if !validKey(key) {
+		return ObjectInfo{}, fmt.Errorf("invalid storage key %q", key)
+	}
+	header, _, err := s.do(ctx, http.MethodHead, key, nil, nil, nil)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	size, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("s3 HEAD %s: invalid Content-Length", key)
+	}
+	modTime, _ := http.ParseTime(header.Get("Last-Modified"))
+	return ObjectInfo{Key: key, Size: size, ContentType: header.Get("Content-Type"), ModTime: modTime}, nil
+}
+
+// PresignPut signs a PUT URL with the Content-Type and Content-Length headers
+// included in the signature, so the upload is refused unless the client sends
+// exactly the type and size it asked for.
+func (s *S3Storage) PresignPut(key string, contentType string, size int64, expires time.Duration) (string, error) {
+	if !validKey(key) {
+		return "", fmt.Errorf("invalid storage key %q", key)
+	}
+	headers := map[string]string{
+		"content-length": strconv.FormatInt(size, 10),
+		"content-type":   contentType,
+	}
+	return s.presign(http.MethodPut, key, headers, expires), nil
+}
+
+// presign returns a URL carrying its signature in the query string.
+func (s *S3Storage) presign(method string, key string, headers map[string]string, expires time.Duration) string {
+	now := s.now().UTC()
+	u := s.objectURL(s.presignEndpoint, key)
+	values := map[string]string{"host": u.Host}
+	for name, value := range headers {
+		values[name] = value
+	}
+	canonicalHeaders, signedHeaders := canonicalHeaderList(values)
+
+	query := url.Values{
+		"X-Amz-Algorithm":     {"AWS4-HMAC-SHA256"},
+		"X-Amz-Credential":    {s.accessKeyID + "/" + s.scope(now)},
+		"X-Amz-Date":          {now.Format("20060102T150405Z")},
+		"X-Amz-Expires":       {strconv.Itoa(int(expires / time.Second))},
+		"X-Amz-SignedHeaders": {signedHeaders},
+	}
+	canonicalRequest := strings.Join([]string{
+		method,
+		uriEncode(u.Path, false),
+		canonicalQuery(query),
+		canonicalHeaders,
+		signedHeaders,
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	query.Set("X-Amz-Signature", s.signature(now, canonicalRequest))
+	u.RawQuery = canonicalQuery(query)
+	return u.String()
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (s *S3Storage) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	for {
+		_, body, err := s.do(ctx, http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		var result listBucketResult
+		if err := xml.Unmarshal(body, &result); err != nil {
+			return nil, fmt.Errorf("invalid list response: %w", err)
+		}
+		for _, object := range result.Contents {
+			objects = append(objects, ObjectInfo{Key: object.Key, Size: object.Size, ModTime: object.LastModified})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+func (s *S3Storage) URL(key string) string {
+	return s.publicURL + "/" + key
+}
+
+// do sends a signed request and returns the response headers and body. Any
+// status other than 2xx is an error; a missing object is fs.ErrNotExist,
+// except for deletes, which succeed.
+func (s *S3Storage) do(ctx context.Context, method string, key string, query url.Values, headers map[string]string, body []byte) (http.Header, []byte, error) {
+	u := s.objectURL(s.endpoint, key)
+	u.RawQuery = canonicalQuery(query)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	s.sign(req, body)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("s3 %s %s: %w", method, key, err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		if method == http.MethodDelete {
+			return resp.Header, respBody, nil
+		}
+		return nil, nil, fmt.Errorf("s3 %s %s: %w", method, key, fs.ErrNotExist)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, nil, fmt.Errorf("s3 %s %s: %s: %s", method, key, resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	return resp.Header, respBody, nil
+}
+
+// sign adds AWS Signature Version 4 headers to req.
+func (s *S3Storage) sign(req *http.Request, body []byte) {
+	now := s.now().UTC()
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	values := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		values[strings.ToLower(name)] = strings.TrimSpace(req.Header.Get(name))
+	}
+	canonicalHeaders, signedHeaders := canonicalHeaderList(values)
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriEncode(req.URL.Path, false),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKeyID, s.scope(now), signedHeaders, s.signature(now, canonicalRequest)))
+}
+
+func (s *S3Storage) scope(now time.Time) string {
+	return now.Format("20060102") + "/" + s.region + "/s3/aws4_request"
+}
+
+// signature signs a canonical request made at now.
+func (s *S3Storage) signature(now time.Time, canonicalRequest string) string {
+	stringToSign := "AWS4-HMAC-SHA256\n" + now.Format("20060102T150405Z") + "\n" + s.scope(now) + "\n" + sha256Hex([]byte(canonicalRequest))
+	key := hmacSHA256([]byte("AWS4"+s.secretAccessKey), now.Format("20060102"))
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// canonicalHeaderList formats lower-case headers sorted by name, returning
+// the canonical header block and the signed header list.
+func canonicalHeaderList(values map[string]string) (string, string) {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name + ":" + values[name] + "\n")
+	}
+	return b.String(), strings.Join(names, ";")
+}
+
+// canonicalQuery encodes query parameters sorted by name, as both the
+// request and its signature need them.
+func canonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var parts []string
+	for _, name := range names {
+		for _, value := range query[name] {
+			parts = append(parts, uriEncode(name, true)+"="+uriEncode(value, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode percent-encodes everything but unreserved characters, and
+// slashes too when encodeSlash is set.
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
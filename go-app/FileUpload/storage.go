@@ -0,0 +1,328 @@
+package fileupload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Bucket is a logical place uploads go. Clients pick a bucket by name; only
+// the server decides where it is stored.
+type Bucket string
+
+const (
+	BucketRecipeImages Bucket = "recipe_images"
+	BucketStepImages   Bucket = "step_images"
+	BucketAvatars      Bucket = "avatars"
+)
+
+var buckets = map[Bucket]bool{
+	BucketRecipeImages: true,
+	BucketStepImages:   true,
+	BucketAvatars:      true,
+}
+
+// ParseBucket validates a bucket name from a request, defaulting to recipe
+// images when none is given.
+func ParseBucket(name string) (Bucket, error) {
+	if name == "" {
+		return BucketRecipeImages, nil
+	}
+	bucket := Bucket(strings.ToLower(strings.TrimSpace(name)))
+	if !buckets[bucket] {
+		return "", fmt.Errorf("unknown bucket %q; use recipe_images, step_images or avatars", name)
+	}
+	return bucket, nil
+}
+
+// Storage keeps uploaded files under slash-separated keys such as
+// "avatars/<user id>/profile_20250101120000.jpg".
+type Storage interface {
+	// Put stores data under key, replacing any existing object, and returns
+	// its public URL.
+	Put(ctx context.Context, key string, data []byte, contentType string) (string, error)
+	// Get reads an object; a missing key is an fs.ErrNotExist error.
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Stat describes an object; a missing key is an fs.ErrNotExist error.
+	Stat(ctx context.Context, key string) (ObjectInfo, error)
+	// Delete removes the object; deleting a missing key is not an error.
+	Delete(ctx context.Context, key string) error
+	// List describes every object whose key starts with prefix.
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	// URL is the public URL of key.
+	URL(key string) string
+}
+
+// ObjectInfo describes a stored object. ContentType is only known to Stat.
+type ObjectInfo struct {
+	Key         string
+	Size        int64
+	ContentType string
+	ModTime     time.Time
+}
+
+// DirectUploader is a Storage that clients can upload to without the file
+// passing through this server.
+type DirectUploader interface {
+	Storage
+	// PresignPut returns a URL accepting one PUT of exactly size bytes of
+	// contentType to key, valid for expires.
+	PresignPut(key string, contentType string, size int64, expires time.Duration) (string, error)
+}
+
+// Store is the storage backend uploads go to, set by InitStorage.
+var Store Storage = NewLocalStorage(UploadsRoot(), baseURL()+"/uploads")
+
+// InitStorage picks the backend from STORAGE_BACKEND: "local" (the default)
+// writes to UPLOADS_DIR and serves files from /uploads/, "s3" writes to an
+// S3-compatible bucket so several replicas can share uploads.
+func InitStorage() error {
+	switch backend := os.Getenv("STORAGE_BACKEND"); backend {
+	case "", "local":
+		Store = NewLocalStorage(UploadsRoot(), baseURL()+"/uploads")
+	case "s3":
+		s3, err := NewS3StorageFromEnv()
+		if err != nil {
+			return err
+		}
+		Store = s3
+	default:
+		return fmt.Errorf("unknown STORAGE_BACKEND %q; use local or s3", backend)
+	}
+	log.Printf("Uploads are stored with %T\n", Store)
+	return nil
+}
+
+// IsLocalStorage reports whether uploads are on this server's disk and so
+// must be served by it.
+func IsLocalStorage() bool {
+	_, ok := Store.(*LocalStorage)
+	return ok
+}
+
+// UploadsRoot reads UPLOADS_DIR, the directory served under /uploads/.
+func UploadsRoot() string {
+	if dir := os.Getenv("UPLOADS_DIR"); dir != "" {
+		return dir
+	}
+	return "/app/uploads"
+}
+
+// baseURL is where this server is reachable, from BASE_URL or localhost on PORT.
+func baseURL() string {
+	if base := os.Getenv("BASE_URL"); base != "" {
+		return strings.TrimRight(base, "/")
+	}
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8082"
+	}
+	return fmt.Sprintf("http://localhost:%s", port)
+}
+
+// validKey accepts relative slash-separated keys without empty, dot or
+// dot-dot segments, so a key can never leave the storage root.
+func validKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return false
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || strings.HasPrefix(segment, ".") {
+			return false
+		}
+	}
+	return true
+}
+
+// contentTypeFor guesses a content type from the key's extension.
+func contentTypeFor(key string) string {
+	if contentType := mime.TypeByExtension(path.Ext(key)); contentType != "" {
+		return contentType
+	}
+	return "application/octet-stream"
+}
+
+// LocalStorage keeps files in a directory on this server.
+type LocalStorage struct {
+	root    string
+	baseURL string
+}
+
+// NewLocalStorage stores files under root and serves them from baseURL.
+func NewLocalStorage(root string, baseURL string) *LocalStorage {
+	return &LocalStorage{root: root, baseURL: strings.TrimRight(baseURL, "/")}
+}
+
+func (s *LocalStorage) Put(ctx context.Context, key string, data []byte, contentType string) (string, error) {
+	if !validKey(key) {
+		return "", fmt.Errorf("invalid storage key %q", key)
+	}
+	filePath := filepath.Join(s.root, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return "", fmt.Errorf("could not create folder: %w", err)
+	}
+	if err := os.WriteFile(filePath, data, 0644); err != nil {
+		return "", fmt.Errorf("could not save file: %w", err)
+	}
+	return s.URL(key), nil
+}
+
+func (s *LocalStorage) Get(ctx context.Context, key string) ([]byte, error) {
+	if !validKey(key) {
+		return nil, fmt.Errorf("invalid storage key %q", key)
+	}
+	return os.ReadFile(filepath.Join(s.root, filepath.FromSlash(key)))
+}
+
+func (s *LocalStorage) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	if !validKey(key) {
+		return ObjectInfo{}, fmt.Errorf("invalid storage key %q", key)
+	}
+	info, err := os.Stat(filepath.Join(s.root, filepath.FromSlash(key)))
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{Key: key, Size: info.Size(), ContentType: contentTypeFor(key), ModTime: info.ModTime()}, nil
+}
+
+func (s *LocalStorage) Delete(ctx context.Context, key string) error {
+	if !validKey(key) {
+		return fmt.Errorf("invalid storage key %q", key)
+	}
+	err := os.Remove(filepath.Join(s.root, filepath.FromSlash(key)))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *LocalStorage) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	// Walk the deepest directory the prefix names, then match the rest.
+	dir := path.Dir(prefix)
+	if strings.HasSuffix(prefix, "/") {
+		dir = strings.TrimSuffix(prefix, "/")
+	}
+	if dir == "." {
+		dir = ""
+	}
+	var objects []ObjectInfo
+	err := filepath.WalkDir(filepath.Join(s.root, filepath.FromSlash(dir)), func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(s.root, filePath)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, ObjectInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	return objects, err
+}
+
+func (s *LocalStorage) URL(key string) string {
+	return s.baseURL + "/" + key
+}
+
+// namespaceKey is the key prefix holding one user's files in a bucket. The
+// user ID must be a canonical UUID so it can never climb out of the bucket.
+func namespaceKey(bucket Bucket, userID string) (string, error) {
+	if !buckets[bucket] {
+		return "", fmt.Errorf("unknown bucket %q", bucket)
+	}
+	if id, err := uuid.Parse(userID); err != nil || id.String() != userID {
+		return "", fmt.Errorf("invalid user ID %q", userID)
+	}
+	return string(bucket) + "/" + userID + "/", nil
+}
+
+// storeFile saves data under the user's namespace in bucket, named by the
+// SHA-256 of its content plus ext, and returns its storage key and public
+// URL. When the same content is already stored, the existing file is reused;
+// if its row outlived the object, the object is stored again.
+func storeFile(ctx context.Context, bucket Bucket, userID string, data []byte, ext string) (string, string, error) {
+	if !validExt(ext) {
+		return "", "", fmt.Errorf("invalid file extension %q", ext)
+	}
+	prefix, err := namespaceKey(bucket, userID)
+	if err != nil {
+		return "", "", err
+	}
+	sum := sha256Hex(data)
+	key := prefix + sum + ext
+
+	existing, err := lookupStoredFile(ctx, key)
+	if err != nil {
+		return "", "", err
+	}
+	stored := existing != nil
+	if stored {
+		if _, err := Store.Stat(ctx, key); errors.Is(err, fs.ErrNotExist) {
+			log.Printf("Stored file %s is missing, storing it again\n", key)
+			stored = false
+		} else if err != nil {
+			return "", "", err
+		}
+	}
+	if !stored {
+		if _, err := Store.Put(ctx, key, data, contentTypeFor(key)); err != nil {
+			return "", "", err
+		}
+	} else {
+		log.Printf("Reusing stored file %s\n", key)
+	}
+	url, err := recordStoredFile(ctx, stored_files_insert_input{
+		Key:         key,
+		Sha256:      sum,
+		URL:         Store.URL(key),
+		Size:        int64(len(data)),
+		ContentType: contentTypeFor(key),
+		UpdatedAt:   time.Now(),
+	})
+	if err != nil {
+		if existing == nil {
+			if err := Store.Delete(ctx, key); err != nil {
+				log.Printf("Could not remove %s: %v\n", key, err)
+			}
+		}
+		return "", "", err
+	}
+	return key, url, nil
+}
+
+// validExt accepts a dot followed by letters and digits only.
+func validExt(ext string) bool {
+	if len(ext) < 2 || ext[0] != '.' {
+		return false
+	}
+	for _, c := range ext[1:] {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,218 @@
+package fileupload
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testUserID = "3f2b8c1e-6d4a-4b7e-9a1c-2e5f7d8b9c0a"
+
+func TestParseBucket(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Bucket
+		wantErr bool
+	}{
+		{in: "avatars", want: BucketAvatars},
+		{in: " Recipe_Images ", want: BucketRecipeImages},
+		{in: "step_images", want: BucketStepImages},
+		{in: "", want: BucketRecipeImages},
+		{in: "../etc", wantErr: true},
+		{in: "/app/uploads", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseBucket(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseBucket(%q) = %q, %v; want %q, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// Only the canonical form of a user ID names a namespace, so one user can
+// never be given two namespaces or a key outside the bucket.
+func TestNamespaceKey(t *testing.T) {
+	key, err := namespaceKey(BucketAvatars, testUserID)
+	if err != nil || key != "avatars/"+testUserID+"/" {
+		t.Errorf("namespaceKey = %q, %v", key, err)
+	}
+
+	for _, userID := range []string{"", "../" + testUserID, "3F2B8C1E-6D4A-4B7E-9A1C-2E5F7D8B9C0A", "{" + testUserID + "}", "urn:uuid:" + testUserID} {
+		if key, err := namespaceKey(BucketAvatars, userID); err == nil {
+			t.Errorf("namespaceKey for user %q = %q", userID, key)
+		}
+	}
+	if key, err := namespaceKey("profile_pics", testUserID); err == nil {
+		t.Errorf("namespaceKey for an unknown bucket = %q", key)
+	}
+}
+
+func TestValidKey(t *testing.T) {
+	tests := map[string]bool{
+		"avatars/" + testUserID + "/abc.png": true,
+		"profile_pics/profile_1.png":         true,
+		"file.png":                           true,
+		"":                                   false,
+		"/etc/passwd":                        false,
+		"avatars/../../etc/passwd":           false,
+		"avatars/./abc.png":                  false,
+		"avatars//abc.png":                   false,
+		"avatars/":                           false,
+		"avatars/.hidden":                    false,
+		`avatars\..\abc.png`:                 false,
+	}
+	for key, want := range tests {
+		if got := validKey(key); got != want {
+			t.Errorf("validKey(%q) = %v, want %v", key, got, want)
+		}
+	}
+}
+
+func TestLocalStorage(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	s := NewLocalStorage(root, "http://localhost:8082/uploads/")
+	key := "avatars/" + testUserID + "/abc.png"
+
+	url, err := s.Put(ctx, key, []byte("data"), "image/png")
+	if err != nil || url != "http://localhost:8082/uploads/"+key {
+		t.Fatalf("Put = %q, %v", url, err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(root, "avatars", testUserID, "abc.png")); string(data) != "data" {
+		t.Errorf("stored %q", data)
+	}
+	if data, err := s.Get(ctx, key); err != nil || string(data) != "data" {
+		t.Errorf("Get = %q, %v", data, err)
+	}
+	if info, err := s.Stat(ctx, key); err != nil || info.Key != key || info.Size != 4 || info.ContentType != "image/png" {
+		t.Errorf("Stat = %+v, %v", info, err)
+	}
+	s.Put(ctx, "avatars/"+testUserID+"x/other.png", []byte("x"), "image/png")
+	objects, err := s.List(ctx, "avatars/"+testUserID+"/")
+	if err != nil || len(objects) != 1 || objects[0].Key != key || objects[0].Size != 4 || objects[0].ModTime.IsZero() {
+		t.Fatalf("List = %+v, %v", objects, err)
+	}
+	if err := s.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(ctx, key); err != nil {
+		t.Errorf("Delete of a missing key = %v, want nil", err)
+	}
+	if objects, _ := s.List(ctx, "avatars/"+testUserID+"/"); len(objects) != 0 {
+		t.Errorf("List after Delete = %+v", objects)
+	}
+	if _, err := s.Get(ctx, key); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Get after Delete = %v, want fs.ErrNotExist", err)
+	}
+	if _, err := s.Stat(ctx, key); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat after Delete = %v, want fs.ErrNotExist", err)
+	}
+	for _, bad := range []string{"../escape.png", "/abs.png"} {
+		if _, err := s.Put(ctx, bad, []byte("x"), "image/png"); err == nil {
+			t.Errorf("Put(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestValidExt(t *testing.T) {
+	tests := map[string]bool{
+		".png":  true,
+		".webp": true,
+		".JPG":  true,
+		"":      false,
+		".":     false,
+		"png":   false,
+		"./png": false,
+		".p/ng": false,
+		".png ": false,
+	}
+	for ext, want := range tests {
+		if got := validExt(ext); got != want {
+			t.Errorf("validExt(%q) = %v, want %v", ext, got, want)
+		}
+	}
+}
+
+// Files are named by their content, so uploading the same bytes twice
+// stores them once and records the second upload on the same row.
+func TestStoreFile(t *testing.T) {
+	s := useTestStorage(t)
+	h, files := useFakeStoredFiles(t)
+	ctx := context.Background()
+
+	key, url, err := storeFile(ctx, BucketStepImages, testUserID, []byte("data"), ".png")
+	wantKey := "step_images/" + testUserID + "/" + sha256Hex([]byte("data")) + ".png"
+	if err != nil || key != wantKey || url != "https://cdn.example.com/files/"+wantKey {
+		t.Fatalf("storeFile = %q, %q, %v", key, url, err)
+	}
+	if !files.has(key) {
+		t.Errorf("no stored_files row for %s", key)
+	}
+	path := filepath.Join(s.root, filepath.FromSlash(key))
+	written := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(path, written, written); err != nil {
+		t.Fatal(err)
+	}
+	again, _, err := storeFile(ctx, BucketStepImages, testUserID, []byte("data"), ".png")
+	if err != nil || again != key {
+		t.Fatalf("second storeFile = %q, %v", again, err)
+	}
+	if info, err := s.Stat(ctx, key); err != nil || !info.ModTime.Equal(written) {
+		t.Errorf("the second upload was written again: %+v, %v", info, err)
+	}
+	if n := len(h.calls("insert_stored_files_one")); n != 2 {
+		t.Errorf("stored_files upserted %d times, want 2", n)
+	}
+
+	// A row whose object went missing is stored again rather than left
+	// pointing at nothing.
+	if err := s.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := storeFile(ctx, BucketStepImages, testUserID, []byte("data"), ".png"); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := s.Get(ctx, key); err != nil || string(data) != "data" {
+		t.Errorf("missing object was not stored again: %q, %v", data, err)
+	}
+
+	for _, ext := range []string{"", "png", "./png", ".p/ng"} {
+		if _, _, err := storeFile(ctx, BucketStepImages, testUserID, []byte("x"), ext); err == nil {
+			t.Errorf("storeFile accepted the extension %q", ext)
+		}
+	}
+}
+
+func TestReleaseFile(t *testing.T) {
+	s := useTestStorage(t)
+	_, files := useFakeStoredFiles(t)
+	ctx := context.Background()
+
+	key, _, err := storeFile(ctx, BucketAvatars, testUserID, []byte("data"), ".png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files.setRefCount(key, 1)
+	releaseFile(ctx, key)
+	if _, err := s.Get(ctx, key); err != nil || !files.has(key) {
+		t.Fatalf("a referenced file was released: %v", err)
+	}
+
+	files.setRefCount(key, 0)
+	releaseFile(ctx, key)
+	if _, err := s.Get(ctx, key); !errors.Is(err, fs.ErrNotExist) || files.has(key) {
+		t.Errorf("an unreferenced file was kept: %v", err)
+	}
+
+	// Files from before stored_files have no row and are deleted outright.
+	legacy := "avatars/" + testUserID + "/profile_20240101120000.png"
+	s.Put(ctx, legacy, []byte("old"), "image/png")
+	releaseFile(ctx, legacy)
+	if _, err := s.Get(ctx, legacy); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("a legacy file was kept: %v", err)
+	}
+}
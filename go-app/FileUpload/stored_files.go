@@ -0,0 +1,109 @@
+package fileupload
+
+import (
+	"context"
+	"log"
+	"time"
+
+	hasura "github.com/wubshet-kebede/go-app/Hasura"
+)
+
+// Stored files are tracked in stored_files, whose ref_count database
+// triggers keep equal to the number of recipe_images and profile_images
+// rows pointing at the file. A file is only deleted once that reaches zero.
+
+type stored_files_insert_input struct {
+	Key         string    `json:"key"`
+	Sha256      string    `json:"sha256"`
+	URL         string    `json:"url"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"content_type"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+type storedFile struct {
+	URL      string `graphql:"url"`
+	RefCount int    `graphql:"ref_count"`
+}
+
+type storedFileQuery struct {
+	StoredFilesByPk *storedFile `graphql:"stored_files_by_pk(key: $key)"`
+}
+
+// upsertStoredFileMutation records a new file, or touches an existing one so
+// it counts as recently uploaded.
+type upsertStoredFileMutation struct {
+	InsertStoredFilesOne *struct {
+		URL string `graphql:"url"`
+	} `graphql:"insert_stored_files_one(object: $object, on_conflict: {constraint: stored_files_pkey, update_columns: [updated_at]})"`
+}
+
+// deleteUnreferencedFileMutation removes the row of a file no image refers
+// to and that was neither stored nor reused since the cutoff.
+type deleteUnreferencedFileMutation struct {
+	DeleteStoredFiles *struct {
+		AffectedRows int `graphql:"affected_rows"`
+	} `graphql:"delete_stored_files(where: {key: {_eq: $key}, ref_count: {_eq: 0}, updated_at: {_lt: $cutoff}})"`
+}
+
+// timestamptz sends a time as a Hasura timestamptz variable.
+type timestamptz string
+
+func (timestamptz) GetGraphQLType() string { return "timestamptz" }
+
+// lookupStoredFile returns the stored_files row for key, or nil when there
+// is none.
+func lookupStoredFile(ctx context.Context, key string) (*storedFile, error) {
+	var resp storedFileQuery
+	if err := hasura.Client.Query(ctx, &resp, map[string]interface{}{"key": key}); err != nil {
+		return nil, err
+	}
+	return resp.StoredFilesByPk, nil
+}
+
+// recordStoredFile upserts a stored_files row and returns the file's URL.
+func recordStoredFile(ctx context.Context, file stored_files_insert_input) (string, error) {
+	var resp upsertStoredFileMutation
+	if err := hasura.Client.Mutate(ctx, &resp, map[string]interface{}{"object": file}); err != nil {
+		return "", err
+	}
+	if resp.InsertStoredFilesOne == nil {
+		return file.URL, nil
+	}
+	return resp.InsertStoredFilesOne.URL, nil
+}
+
+// releaseFile deletes a stored file unless an image still references it.
+func releaseFile(ctx context.Context, key string) {
+	if _, err := deleteUnreferencedFile(ctx, key, time.Now()); err != nil {
+		log.Printf("Could not release %s: %v\n", key, err)
+	}
+}
+
+// deleteUnreferencedFile deletes a stored file unless an image references it
+// or it was stored or reused since idleSince, and reports whether it did.
+// Files with no row, which no image pointed at when stored_files was
+// backfilled, are deleted outright.
+func deleteUnreferencedFile(ctx context.Context, key string, idleSince time.Time) (bool, error) {
+	var deleted deleteUnreferencedFileMutation
+	vars := map[string]interface{}{
+		"key":    key,
+		"cutoff": timestamptz(idleSince.UTC().Format(time.RFC3339Nano)),
+	}
+	if err := hasura.Client.Mutate(ctx, &deleted, vars); err != nil {
+		return false, err
+	}
+	if deleted.DeleteStoredFiles == nil || deleted.DeleteStoredFiles.AffectedRows == 0 {
+		existing, err := lookupStoredFile(ctx, key)
+		if err != nil {
+			return false, err
+		}
+		if existing != nil {
+			return false, nil // still referenced, or in use again
+		}
+	}
+	if err := Store.Delete(ctx, key); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -1,138 +1,208 @@
 package fileupload
 
 import (
-	"encoding/base64"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
-	"os"
-	"path/filepath"
-	"strings"
-	"time"
 
 	"github.com/google/uuid"
 )
+
+// maxUploadFiles caps how many files one request may carry.
+const maxUploadFiles = 10
+
 type Files struct {
 	Base64 string `json:"base64"`
 }
+
+// UploadFilesRequest names a bucket for the files; Folder is only accepted
+// for older clients and is ignored.
 type UploadFilesRequest struct {
-	Files []Files `json:"files"`
-	Folder string `json:"folder"`
+	Files  []Files `json:"files"`
+	Bucket string  `json:"bucket"`
+	Folder string  `json:"folder"`
 }
 type OuterInput struct {
 	Input UploadFilesRequest `json:"input"`
 }
 type HasuraActionRequest struct {
-	Action map[string]interface{} `json:"action"`
-	Input OuterInput    `json:"input"`
-	RequestQuery string `json:"request_query"`
-	SessionVariables map[string]string `json:"session_variables"`
+	Action           map[string]interface{} `json:"action"`
+	Input            OuterInput             `json:"input"`
+	RequestQuery     string                 `json:"request_query"`
+	SessionVariables map[string]string      `json:"session_variables"`
 }
+
+// UploadFilesOutput lists the full-size URL of each saved file in Urls, and
+// every variant of it in Files.
 type UploadFilesOutput struct {
-	Success bool `json:"success"`
-	Urls []string `json:"urls"`
+	Success bool              `json:"success"`
+	Message string            `json:"message,omitempty"`
+	Urls    []string          `json:"urls"`
+	Files   []UploadedImage   `json:"files"`
+	Errors  []UploadFileError `json:"errors"`
+}
+
+// UploadedImage holds the URLs of an uploaded image's variants, ready to be
+// stored on a recipe_images row.
+type UploadedImage struct {
+	URL          string `json:"url"`
+	ThumbnailURL string `json:"thumbnailUrl"`
+	CardURL      string `json:"cardUrl"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+}
+
+// UploadFileError explains why one of the files, by its position in the
+// request, was not saved.
+type UploadFileError struct {
+	Index int    `json:"index"`
+	Error string `json:"error"`
 }
-func UploadFilesHandler(w http.ResponseWriter, r*http.Request){
+
+// UploadFilesHandler serves the uploadFiles Hasura action, which carries
+// files as base64 strings. New clients should prefer MultipartUploadHandler;
+// this path is kept for compatibility.
+func UploadFilesHandler(w http.ResponseWriter, r *http.Request) {
 	log.Println("UploadFilesHandler called")
 	w.Header().Set("Content-Type", "application/json")
-	baseURL := os.Getenv("BASE_URL")
-	if baseURL == ""{
-		port:= os.Getenv("PORT")
-		if port == ""{
-			port = "8082"
-		}
-		baseURL = fmt.Sprintf("http://localhost:%s", port)
-	}
-	body, err := io.ReadAll(r.Body)
 	defer r.Body.Close()
-	if err != nil {
-		log.Printf("Error reading body: %v\n", err)
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Could not read request body"})
-		return
-	}
-	log.Printf("Raw request body: %s\n", string(body))
-   var hasuraReq HasuraActionRequest
-	err = json.Unmarshal(body, &hasuraReq)
+	var hasuraReq HasuraActionRequest
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxUploadFiles*(maxImageBytes/3*4)+64<<10)).Decode(&hasuraReq)
 	if err != nil {
 		log.Printf("Hasura Action parse error: %v\n", err)
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid Hasura Action request"})
+		respondWithError(w, http.StatusBadRequest, "Invalid Hasura Action request")
 		return
 	}
 	req := hasuraReq.Input.Input
 
-	log.Printf("Received files: %d, Folder: %s\n", len(req.Files), req.Folder)
- folder := req.Folder
- if folder == ""{
-	folder = "/app/uploads"
- }
- log.Println("Attempting to create folder:", folder)
-	err = os.MkdirAll(folder, os.ModePerm)
+	// Files go under the caller's own namespace in the bucket
+	userID := hasuraReq.SessionVariables["x-hasura-user-id"]
+	if _, err := uuid.Parse(userID); err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Missing user ID")
+		return
+	}
+	bucket, err := ParseBucket(req.Bucket)
 	if err != nil {
-		log.Printf("Folder creation error: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Could not create folder"})
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.Folder != "" {
+		log.Printf("Ignoring requested folder %q from %s\n", req.Folder, userID)
+	}
+	if len(req.Files) > maxUploadFiles {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("At most %d files can be uploaded at once", maxUploadFiles))
 		return
 	}
-	log.Println("Folder creation successful.")
-	var savedURLs []string
-	var success bool = true
-	for i, file := range req.Files {
-		log.Printf("Processing file %d, base64 length: %d\n", i, len(file.Base64))
-  commaIndex := strings.Index(file.Base64, ",")
-		if commaIndex == -1 {
-			log.Printf("Base64 string for file %d missing comma separator: %s\n", i, file.Base64)
-			success = false
-			continue
-		}
-	rawBase64 := file.Base64[commaIndex+1:]
 
-		log.Println("Attempting base64 decode for file:", i)
-		data, err := base64.StdEncoding.DecodeString(rawBase64)
+	log.Printf("Received files: %d, Bucket: %s\n", len(req.Files), bucket)
+	results := newUploadResults()
+	for i, file := range req.Files {
+		data, err := decodeBase64Image(file.Base64)
 		if err != nil {
-			log.Printf("Base64 decode error for file %d: %v\n", i, err)
-			success = false
+			results.add(i, UploadedImage{}, err)
 			continue
 		}
-	log.Println("Base64 decode successful for file:", i, "decoded size:", len(data), "bytes")
-
+		uploaded, err := saveImage(r.Context(), bucket, userID, data)
+		results.add(i, uploaded, err)
+	}
+	writeUploadResults(w, results, len(req.Files))
+}
 
-		ext := ".png"
-		if strings.Contains(file.Base64, "image/jpeg") {
-			ext = ".jpeg"
-		} else if strings.Contains(file.Base64, "image/jpg") {
-			ext = ".jpg"
-		} else if strings.Contains(file.Base64, "image/gif") {
-			ext = ".gif"
-		}
-		
-		filename := fmt.Sprintf("%s_%s%s", time.Now().Format("20060102150405"), uuid.New().String(), ext)
-		filePath := filepath.Join(folder, filename)
+// uploadError is a reason a file was refused that is safe to show the
+// uploader; any other error is logged and reported generically.
+type uploadError struct{ msg string }
 
-		log.Println("Attempting to write file:", filePath)
-		err = os.WriteFile(filePath, data, 0644)
-		if err != nil {
-			log.Printf("File write error for file %d: %v\n", i, err)
-			success = false
-			continue
-		}
-		log.Println("File write successful for file:", filePath)
+func (e uploadError) Error() string { return e.msg }
 
-		urlPath := filepath.ToSlash(filePath)
-		fullURL := fmt.Sprintf("%s/%s", baseURL, urlPath)
-		savedURLs = append(savedURLs, fullURL)
-	}	
-	response := UploadFilesOutput{
-		Success: success,
-		Urls:    savedURLs,
+// saveImage validates one uploaded image, renders its variants and stores
+// them under the user's namespace in bucket.
+func saveImage(ctx context.Context, bucket Bucket, userID string, data []byte) (UploadedImage, error) {
+	info, err := validateImage(data)
+	if err != nil {
+		return UploadedImage{}, uploadError{err.Error()}
+	}
+	variants, err := makeVariants(info, data, imageVariants)
+	if err != nil {
+		log.Printf("Image processing error: %v\n", err)
+		return UploadedImage{}, uploadError{"Could not process image"}
 	}
+	uploaded, _, err := storeVariants(ctx, bucket, userID, variants)
+	if err != nil {
+		log.Printf("File write error: %v\n", err)
+		return UploadedImage{}, uploadError{"Could not save file"}
+	}
+	log.Println("File write successful for file:", uploaded.URL)
+	return uploaded, nil
+}
 
-	log.Printf("Returning response: %+v\n", response)
+// uploadResults collects the outcome of each file in a request.
+type uploadResults struct {
+	urls   []string
+	files  []UploadedImage
+	errors []UploadFileError
+}
+
+func newUploadResults() *uploadResults {
+	return &uploadResults{urls: []string{}, files: []UploadedImage{}, errors: []UploadFileError{}}
+}
+
+func (u *uploadResults) add(index int, uploaded UploadedImage, err error) {
+	if err != nil {
+		log.Printf("Rejected file %d: %v\n", index, err)
+		u.errors = append(u.errors, UploadFileError{Index: index, Error: err.Error()})
+		return
+	}
+	u.urls = append(u.urls, uploaded.URL)
+	u.files = append(u.files, uploaded)
+}
 
+func writeUploadResults(w http.ResponseWriter, results *uploadResults, total int) {
+	response := UploadFilesOutput{
+		Success: len(results.errors) == 0,
+		Urls:    results.urls,
+		Files:   results.files,
+		Errors:  results.errors,
+	}
+	if len(results.errors) > 0 {
+		response.Message = fmt.Sprintf("%d of %d files were not uploaded", len(results.errors), total)
+	}
+	log.Printf("Uploaded %d of %d files\n", len(results.urls), total)
 	if err := json.NewEncoder(w).Encode(response); err != nil {
 		log.Printf("Error encoding response: %v\n", err)
-	}	
-}
\ No newline at end of file
+	}
+}
+
+// storeVariants stores every variant and returns their keys. If one cannot
+// be saved, those already stored are released again.
+func storeVariants(ctx context.Context, bucket Bucket, userID string, variants []imageVariant) (UploadedImage, []string, error) {
+	var uploaded UploadedImage
+	var written []string
+	for _, variant := range variants {
+		key, url, err := storeFile(ctx, bucket, userID, variant.data, variant.ext)
+		if err != nil {
+			releaseFiles(ctx, written)
+			return UploadedImage{}, nil, err
+		}
+		written = append(written, key)
+		switch variant.name {
+		case "thumbnail":
+			uploaded.ThumbnailURL = url
+		case "card":
+			uploaded.CardURL = url
+		default:
+			uploaded.URL = url
+			uploaded.Width = variant.width
+			uploaded.Height = variant.height
+		}
+	}
+	return uploaded, written, nil
+}
+
+// releaseFiles releases stored files whose upload could not be completed.
+func releaseFiles(ctx context.Context, keys []string) {
+	for _, key := range keys {
+		releaseFile(ctx, key)
+	}
+}
@@ -0,0 +1,27 @@
+package fileupload
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// respondWithError sends an error response as {"error": message}, the shape
+// every upload endpoint returns.
+func respondWithError(w http.ResponseWriter, code int, message string) {
+	respondWithJSON(w, code, map[string]string{"error": message})
+}
+
+// respondWithJSON sends a success response with a JSON payload.
+func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
+	response, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Error marshaling JSON response: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Internal server error: Failed to marshal response"}`))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(response)
+}
@@ -0,0 +1,108 @@
+package fileupload
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	_ "golang.org/x/image/webp"
+)
+
+// Limits on uploaded images. Dimensions are checked from the header before
+// the image is decoded, so a small file cannot expand into a huge bitmap.
+const (
+	maxImageBytes     = 5 << 20
+	maxImageDimension = 8000
+	maxImagePixels    = 40_000_000
+)
+
+// imageFormat is one of the allowed upload formats, as named by image.Decode.
+type imageFormat struct {
+	name string
+	ext  string
+	mime string
+}
+
+var (
+	formatJPEG = imageFormat{"jpeg", ".jpg", "image/jpeg"}
+	formatPNG  = imageFormat{"png", ".png", "image/png"}
+	formatGIF  = imageFormat{"gif", ".gif", "image/gif"}
+	formatWebP = imageFormat{"webp", ".webp", "image/webp"}
+)
+
+var imageFormats = []imageFormat{formatJPEG, formatPNG, formatGIF, formatWebP}
+
+// formatForContentType finds the allowed format with the given MIME type.
+func formatForContentType(contentType string) (imageFormat, bool) {
+	for _, format := range imageFormats {
+		if format.mime == contentType {
+			return format, true
+		}
+	}
+	return imageFormat{}, false
+}
+
+// sniffImage identifies an allowed format from the file's magic bytes,
+// whatever type the client claimed.
+func sniffImage(data []byte) (imageFormat, bool) {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8, 0xFF}):
+		return formatJPEG, true
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return formatPNG, true
+	case bytes.HasPrefix(data, []byte("GIF87a")), bytes.HasPrefix(data, []byte("GIF89a")):
+		return formatGIF, true
+	case len(data) >= 12 && bytes.Equal(data[0:4], []byte("RIFF")) && bytes.Equal(data[8:12], []byte("WEBP")):
+		return formatWebP, true
+	}
+	return imageFormat{}, false
+}
+
+// imageInfo describes an image that passed validation.
+type imageInfo struct {
+	format imageFormat
+	width  int
+	height int
+	img    image.Image
+}
+
+// validateImage accepts only complete JPEG, PNG, GIF and WebP images within
+// the size and dimension limits. The error is suitable to show the uploader.
+func validateImage(data []byte) (imageInfo, error) {
+	return validateImageSize(data, maxImageBytes)
+}
+
+// validateImageSize is validateImage with a different limit on file size.
+func validateImageSize(data []byte, maxBytes int) (imageInfo, error) {
+	if len(data) == 0 {
+		return imageInfo{}, fmt.Errorf("file is empty")
+	}
+	if len(data) > maxBytes {
+		return imageInfo{}, fmt.Errorf("file is larger than %d MB", maxBytes>>20)
+	}
+	format, ok := sniffImage(data)
+	if !ok {
+		return imageInfo{}, fmt.Errorf("unsupported file type; upload a JPEG, PNG, WebP or GIF image")
+	}
+
+	config, name, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || name != format.name {
+		return imageInfo{}, fmt.Errorf("file is not a valid %s image", format.name)
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width > maxImageDimension || config.Height > maxImageDimension {
+		return imageInfo{}, fmt.Errorf("image is %dx%d; each side must be at most %d pixels", config.Width, config.Height, maxImageDimension)
+	}
+	if config.Width*config.Height > maxImagePixels {
+		return imageInfo{}, fmt.Errorf("image is %dx%d; it must have at most %d megapixels", config.Width, config.Height, maxImagePixels/1_000_000)
+	}
+
+	// Decode the whole image so truncated or corrupt files are refused.
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return imageInfo{}, fmt.Errorf("file is not a valid %s image: %v", format.name, err)
+	}
+	return imageInfo{format: format, width: config.Width, height: config.Height, img: img}, nil
+}
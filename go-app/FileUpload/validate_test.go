@@ -0,0 +1,162 @@
+package fileupload
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func testImage(width, height int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		img.Set(x, 0, color.RGBA{R: uint8(x), A: 255})
+	}
+	return img
+}
+
+func encodeTestImage(t *testing.T, format string, width, height int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "png":
+		err = png.Encode(&buf, testImage(width, height))
+	case "jpeg":
+		err = jpeg.Encode(&buf, testImage(width, height), nil)
+	case "gif":
+		err = gif.Encode(&buf, testImage(width, height), nil)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// pngHeader is the start of a PNG claiming the given size, enough for
+// image.DecodeConfig without spending memory on the pixels.
+func pngHeader(width, height uint32) []byte {
+	ihdr := make([]byte, 17)
+	copy(ihdr, "IHDR")
+	binary.BigEndian.PutUint32(ihdr[4:], width)
+	binary.BigEndian.PutUint32(ihdr[8:], height)
+	ihdr[12], ihdr[13] = 8, 2 // 8-bit RGB
+	var b bytes.Buffer
+	b.WriteString("\x89PNG\r\n\x1a\n")
+	binary.Write(&b, binary.BigEndian, uint32(13))
+	b.Write(ihdr)
+	binary.Write(&b, binary.BigEndian, crc32.ChecksumIEEE(ihdr))
+	return b.Bytes()
+}
+
+func TestValidateImage(t *testing.T) {
+	validPNG := encodeTestImage(t, "png", 40, 30)
+	tests := []struct {
+		name       string
+		data       []byte
+		wantFormat imageFormat
+		wantErr    string
+	}{
+		{name: "png", data: validPNG, wantFormat: formatPNG},
+		{name: "jpeg", data: encodeTestImage(t, "jpeg", 40, 30), wantFormat: formatJPEG},
+		{name: "gif", data: encodeTestImage(t, "gif", 40, 30), wantFormat: formatGIF},
+		{name: "empty", data: nil, wantErr: "file is empty"},
+		{name: "too large", data: make([]byte, maxImageBytes+1), wantErr: "file is larger than 5 MB"},
+		{name: "not an image", data: []byte("<svg xmlns='http://www.w3.org/2000/svg'/>"), wantErr: "unsupported file type; upload a JPEG, PNG, WebP or GIF image"},
+		{name: "png magic on other content", data: append([]byte("\x89PNG\r\n\x1a\n"), "not a png"...), wantErr: "file is not a valid png image"},
+		{name: "webp magic on other content", data: []byte("RIFF\x00\x00\x00\x00WEBPjunk"), wantErr: "file is not a valid webp image"},
+		{name: "truncated", data: validPNG[:len(validPNG)/2], wantErr: "file is not a valid png image: "},
+		{name: "too wide", data: pngHeader(maxImageDimension+1, 1), wantErr: "image is 8001x1; each side must be at most 8000 pixels"},
+		{name: "too many pixels", data: pngHeader(8000, 5001), wantErr: "image is 8000x5001; it must have at most 40 megapixels"},
+		{name: "zero height", data: pngHeader(10, 0), wantErr: "file is not a valid png image"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := validateImage(tt.data)
+			if tt.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.format != tt.wantFormat || info.width != 40 || info.height != 30 || info.img == nil {
+				t.Errorf("info = %s %dx%d, want %s 40x30", info.format.name, info.width, info.height, tt.wantFormat.name)
+			}
+		})
+	}
+}
+
+func TestValidateImageSize(t *testing.T) {
+	data := encodeTestImage(t, "png", 10, 10)
+	if _, err := validateImageSize(data, len(data)); err != nil {
+		t.Errorf("an image at the limit was refused: %v", err)
+	}
+	if _, err := validateImageSize(data, len(data)-1); err == nil {
+		t.Error("an image over the limit was accepted")
+	}
+}
+
+func TestDecodeBase64Image(t *testing.T) {
+	data := encodeTestImage(t, "png", 4, 4)
+	encoded := base64.StdEncoding.EncodeToString(data)
+	tests := []struct {
+		name    string
+		in      string
+		wantErr string
+	}{
+		{name: "data URL", in: "data:image/png;base64," + encoded},
+		{name: "declared type is ignored", in: "data:image/jpeg;base64," + encoded},
+		{name: "bare base64", in: encoded},
+		{name: "not a data URL", in: "image/png," + encoded, wantErr: "invalid base64 data URL"},
+		{name: "not base64 encoded", in: "data:image/png," + encoded, wantErr: "invalid base64 data URL"},
+		{name: "bad base64", in: "data:image/png;base64,***", wantErr: "base64 decode failed"},
+		{name: "too large", in: strings.Repeat("A", (maxImageBytes+3)/3*4+4), wantErr: "file is larger than 5 MB"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeBase64Image(tt.in)
+			if tt.wantErr != "" {
+				var uErr uploadError
+				if !errors.As(err, &uErr) || uErr.msg != tt.wantErr {
+					t.Fatalf("error = %v, want uploadError %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || !bytes.Equal(got, data) {
+				t.Fatalf("decodeBase64Image = %d bytes, %v; want the %d bytes encoded", len(got), err, len(data))
+			}
+		})
+	}
+}
+
+func TestFormatForContentType(t *testing.T) {
+	tests := []struct {
+		contentType string
+		want        imageFormat
+		ok          bool
+	}{
+		{"image/jpeg", formatJPEG, true},
+		{"image/png", formatPNG, true},
+		{"image/gif", formatGIF, true},
+		{"image/webp", formatWebP, true},
+		{"image/svg+xml", imageFormat{}, false},
+		{"IMAGE/PNG", imageFormat{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := formatForContentType(tt.contentType)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("formatForContentType(%q) = %v, %v; want %v, %v", tt.contentType, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package fileupload
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"os"
+	"strconv"
+
+	"golang.org/x/image/draw"
+)
+
+// variantSpec is one size an uploaded image is re-encoded to: it is scaled
+// down, never up, so that neither side exceeds maxSide.
+type variantSpec struct {
+	name    string
+	maxSide int
+}
+
+// Sizes generated for recipe and step images, smallest first, so the
+// frontend can build a srcset. The last one stands in for the original.
+var imageVariants = []variantSpec{
+	{name: "thumbnail", maxSide: 320},
+	{name: "card", maxSide: 800},
+	{name: "full", maxSide: 1600},
+}
+
+var avatarVariants = []variantSpec{
+	{name: "avatar", maxSide: 512},
+}
+
+const defaultImageQuality = 82
+
+// imageQuality reads IMAGE_QUALITY, the JPEG quality (1-100) variants are
+// encoded at.
+func imageQuality() int {
+	raw := os.Getenv("IMAGE_QUALITY")
+	if raw == "" {
+		return defaultImageQuality
+	}
+	quality, err := strconv.Atoi(raw)
+	if err != nil || quality < 1 || quality > 100 {
+		log.Printf("Invalid IMAGE_QUALITY %q, using %d\n", raw, defaultImageQuality)
+		return defaultImageQuality
+	}
+	return quality
+}
+
+// imageVariant is an encoded variant ready to store.
+type imageVariant struct {
+	name   string
+	ext    string
+	data   []byte
+	width  int
+	height int
+}
+
+// fitWithin scales width and height down to fit a square of maxSide.
+func fitWithin(width, height, maxSide int) (int, int) {
+	if width <= maxSide && height <= maxSide {
+		return width, height
+	}
+	if width >= height {
+		return maxSide, max(1, height*maxSide/width)
+	}
+	return max(1, width*maxSide/height), maxSide
+}
+
+// makeVariants renders each spec from the uploaded image. Only pixels are
+// kept: re-encoding drops EXIF and any other metadata, including GPS
+// positions, after the EXIF orientation has been applied to the pixels.
+// Go has no WebP encoder, so variants are JPEG, or PNG when the image has
+// transparency.
+func makeVariants(info imageInfo, original []byte, specs []variantSpec) ([]imageVariant, error) {
+	orientation := 1
+	if info.format == formatJPEG {
+		orientation = jpegOrientation(original)
+	}
+	src := info.img
+	quality := imageQuality()
+
+	variants := make([]imageVariant, 0, len(specs))
+	for _, spec := range specs {
+		width, height := fitWithin(src.Bounds().Dx(), src.Bounds().Dy(), spec.maxSide)
+		scaled := image.NewRGBA(image.Rect(0, 0, width, height))
+		draw.CatmullRom.Scale(scaled, scaled.Bounds(), src, src.Bounds(), draw.Src, nil)
+		oriented := applyOrientation(scaled, orientation)
+
+		var buf bytes.Buffer
+		ext := formatJPEG.ext
+		var err error
+		if oriented.Opaque() {
+			err = jpeg.Encode(&buf, oriented, &jpeg.Options{Quality: quality})
+		} else {
+			ext = formatPNG.ext
+			err = png.Encode(&buf, oriented)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not encode %s variant: %w", spec.name, err)
+		}
+		variants = append(variants, imageVariant{
+			name:   spec.name,
+			ext:    ext,
+			data:   buf.Bytes(),
+			width:  oriented.Bounds().Dx(),
+			height: oriented.Bounds().Dy(),
+		})
+	}
+	return variants, nil
+}
+
+// applyOrientation turns pixels stored in EXIF orientation 2-8 the right way
+// up; orientation 1 and unknown values are returned unchanged.
+func applyOrientation(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2:
+				sx, sy = w-1-x, y
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sx, sy = x, h-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			}
+			dst.SetRGBA(x, y, src.RGBAAt(sx, sy))
+		}
+	}
+	return dst
+}
+
+// jpegOrientation reads the EXIF orientation tag of a JPEG, returning 1 when
+// there is none.
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	pos := 2
+	for pos+4 <= len(data) && data[pos] == 0xFF {
+		marker := data[pos+1]
+		if marker == 0xDA || marker == 0xD9 {
+			break // image data follows; EXIF always comes before it
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2 : pos+4]))
+		if length < 2 || pos+2+length > len(data) {
+			break
+		}
+		segment := data[pos+4 : pos+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+		pos += 2 + length
+	}
+	return 1
+}
+
+// exifOrientation finds tag 0x0112 in the first IFD of a TIFF structure.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[0:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:8]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[ifd : ifd+2]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[entry:entry+2]) == 0x0112 {
+			return int(order.Uint16(tiff[entry+8 : entry+10]))
+		}
+	}
+	return 1
+}
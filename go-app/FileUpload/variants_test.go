@@ -0,0 +1,120 @@
+package fileupload
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestFitWithin(t *testing.T) {
+	tests := []struct{ w, h, max, wantW, wantH int }{
+		{w: 300, h: 200, max: 320, wantW: 300, wantH: 200},
+		{w: 4000, h: 3000, max: 800, wantW: 800, wantH: 600},
+		{w: 3000, h: 4000, max: 800, wantW: 600, wantH: 800},
+		{w: 5000, h: 2, max: 320, wantW: 320, wantH: 1},
+	}
+	for _, tt := range tests {
+		if w, h := fitWithin(tt.w, tt.h, tt.max); w != tt.wantW || h != tt.wantH {
+			t.Errorf("fitWithin(%d, %d, %d) = %dx%d, want %dx%d", tt.w, tt.h, tt.max, w, h, tt.wantW, tt.wantH)
+		}
+	}
+}
+
+// withEXIF inserts an APP1 segment after the JPEG's SOI marker holding the
+// given orientation and some GPS text standing in for a location.
+func withEXIF(jpegData []byte, orientation uint16) []byte {
+	var tiff bytes.Buffer
+	tiff.WriteString("MM\x00\x2a")
+	binary.Write(&tiff, binary.BigEndian, uint32(8))
+	binary.Write(&tiff, binary.BigEndian, uint16(1))
+	binary.Write(&tiff, binary.BigEndian, []uint16{0x0112, 3, 0, 1, orientation, 0})
+	binary.Write(&tiff, binary.BigEndian, uint32(0))
+	tiff.WriteString("GPSLatitude 9.0301N")
+
+	segment := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	var out bytes.Buffer
+	out.Write(jpegData[:2])
+	out.Write([]byte{0xFF, 0xE1})
+	binary.Write(&out, binary.BigEndian, uint16(len(segment)+2))
+	out.Write(segment)
+	out.Write(jpegData[2:])
+	return out.Bytes()
+}
+
+func TestMakeVariantsStripsEXIF(t *testing.T) {
+	original := withEXIF(encodeTestImage(t, "jpeg", 1200, 900), 6)
+	if got := jpegOrientation(original); got != 6 {
+		t.Fatalf("jpegOrientation = %d, want 6", got)
+	}
+	info, err := validateImage(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	variants, err := makeVariants(info, original, imageVariants)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Orientation 6 is a quarter turn, so the landscape upload comes out
+	// portrait, scaled down but never up.
+	want := map[string][2]int{"thumbnail": {240, 320}, "card": {600, 800}, "full": {900, 1200}}
+	for _, v := range variants {
+		if size := [2]int{v.width, v.height}; size != want[v.name] || v.ext != ".jpg" {
+			t.Errorf("%s variant is a %dx%d %s, want %v .jpg", v.name, v.width, v.height, v.ext, want[v.name])
+		}
+		if bytes.Contains(v.data, []byte("Exif")) || bytes.Contains(v.data, []byte("GPSLatitude")) {
+			t.Errorf("%s variant kept the EXIF metadata", v.name)
+		}
+		if jpegOrientation(v.data) != 1 {
+			t.Errorf("%s variant still has an orientation tag", v.name)
+		}
+	}
+}
+
+func TestMakeVariantsKeepsTransparency(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	img.Set(1, 1, color.NRGBA{R: 255, A: 128})
+	variants, err := makeVariants(imageInfo{format: formatPNG, width: 64, height: 64, img: img}, nil, avatarVariants)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(variants) != 1 || variants[0].ext != ".png" || variants[0].width != 64 {
+		t.Errorf("variants = %+v, want one 64px png", variants)
+	}
+}
+
+func TestApplyOrientation(t *testing.T) {
+	// A 2x1 image: red on the left, blue on the right.
+	src := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	red, blue := color.RGBA{R: 255, A: 255}, color.RGBA{B: 255, A: 255}
+	src.SetRGBA(0, 0, red)
+	src.SetRGBA(1, 0, blue)
+
+	tests := []struct {
+		orientation int
+		want        [][]color.RGBA // rows of the result
+	}{
+		{orientation: 1, want: [][]color.RGBA{{red, blue}}},
+		{orientation: 2, want: [][]color.RGBA{{blue, red}}},
+		{orientation: 3, want: [][]color.RGBA{{blue, red}}},
+		{orientation: 6, want: [][]color.RGBA{{red}, {blue}}},
+		{orientation: 8, want: [][]color.RGBA{{blue}, {red}}},
+		{orientation: 9, want: [][]color.RGBA{{red, blue}}},
+	}
+	for _, tt := range tests {
+		got := applyOrientation(src, tt.orientation)
+		if got.Bounds().Dy() != len(tt.want) || got.Bounds().Dx() != len(tt.want[0]) {
+			t.Errorf("orientation %d gives %v, want %dx%d", tt.orientation, got.Bounds(), len(tt.want[0]), len(tt.want))
+			continue
+		}
+		for y, row := range tt.want {
+			for x, want := range row {
+				if got.RGBAAt(x, y) != want {
+					t.Errorf("orientation %d: pixel %d,%d = %v, want %v", tt.orientation, x, y, got.RGBAAt(x, y), want)
+				}
+			}
+		}
+	}
+}
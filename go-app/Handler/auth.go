@@ -0,0 +1,39 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// AuthenticatedUserID returns the user ID from the Bearer token on a request
+// that reaches the backend directly rather than through a Hasura Action.
+// The token is the one issued by LoginHandler.
+func AuthenticatedUserID(r *http.Request) (string, error) {
+	header := r.Header.Get("Authorization")
+	tokenString, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok || tokenString == "" {
+		return "", errors.New("missing bearer token")
+	}
+	if len(jwtSecret) == 0 {
+		return "", errors.New("JWT secret is not configured")
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		return jwtSecret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil {
+		return "", fmt.Errorf("invalid token: %w", err)
+	}
+
+	hasuraClaims, _ := claims["https://hasura.io/jwt/claims"].(map[string]interface{})
+	userID, _ := hasuraClaims["x-hasura-user-id"].(string)
+	if userID == "" {
+		return "", errors.New("token has no user ID")
+	}
+	return userID, nil
+}
@@ -42,7 +42,7 @@ import (
 
 	type getUserByEmailQuery struct {
 		Users []struct {
-		Id uuid.UUID `json:"id" graphql: "id"`
+		Id uuid.UUID `json:"id" graphql:"id"`
 		Username    string    `json:"username" graphql:"username"`
         Email       string    `json:"email" graphql:"email"`
         PasswordHash string   `json:"password_hash" graphql:"password_hash"`
@@ -0,0 +1,213 @@
+// Command migrate-uploads copies the files in the local uploads directory to
+// the storage backend picked by STORAGE_BACKEND and points recipe_images and
+// profile_images at their new URLs.
+//
+// Run it once after switching to S3, with the same environment as the server:
+//
+//	STORAGE_BACKEND=s3 migrate-uploads -dry-run
+//	STORAGE_BACKEND=s3 migrate-uploads -delete-local
+//
+// Files keep their path below the uploads directory as their key, so it can be
+// run again safely after an interruption.
+package main
+
+import (
+	"context"
+	"flag"
+	"io/fs"
+	"log"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	fileupload "github.com/wubshet-kebede/go-app/FileUpload"
+	hasura "github.com/wubshet-kebede/go-app/Hasura"
+)
+
+type uuid string
+
+type recipe_images_set_input struct {
+	ImageURL     *string `json:"image_url,omitempty"`
+	ThumbnailURL *string `json:"thumbnail_url,omitempty"`
+	CardURL      *string `json:"card_url,omitempty"`
+}
+
+type profile_images_set_input struct {
+	ImageURL *string `json:"image_url,omitempty"`
+}
+
+type storedImagesQuery struct {
+	RecipeImages []struct {
+		ID           string  `graphql:"id"`
+		ImageURL     string  `graphql:"image_url"`
+		ThumbnailURL *string `graphql:"thumbnail_url"`
+		CardURL      *string `graphql:"card_url"`
+	} `graphql:"recipe_images"`
+	ProfileImages []struct {
+		ID       string `graphql:"id"`
+		ImageURL string `graphql:"image_url"`
+	} `graphql:"profile_images"`
+}
+
+type updateRecipeImageMutation struct {
+	UpdateRecipeImagesByPk *struct {
+		ID string `graphql:"id"`
+	} `graphql:"update_recipe_images_by_pk(pk_columns: {id: $id}, _set: $set)"`
+}
+
+type updateProfileImageMutation struct {
+	UpdateProfileImagesByPk *struct {
+		ID string `graphql:"id"`
+	} `graphql:"update_profile_images_by_pk(pk_columns: {id: $id}, _set: $set)"`
+}
+
+func main() {
+	dir := flag.String("dir", fileupload.UploadsRoot(), "local uploads directory to move files out of")
+	dryRun := flag.Bool("dry-run", false, "only report what would be moved and rewritten")
+	deleteLocal := flag.Bool("delete-local", false, "remove each local file once it has been copied")
+	flag.Parse()
+
+	hasura.InitClient()
+	if err := fileupload.InitStorage(); err != nil {
+		log.Fatalf("Storage setup failed: %v", err)
+	}
+	if fileupload.IsLocalStorage() {
+		log.Fatal("STORAGE_BACKEND is local; set it to the backend files should move to")
+	}
+	ctx := context.Background()
+
+	moved, err := copyFiles(ctx, *dir, *dryRun)
+	if err != nil {
+		log.Fatalf("Copying files failed: %v", err)
+	}
+	log.Printf("%d files copied from %s\n", len(moved), *dir)
+
+	if err := rewriteURLs(ctx, moved, *dryRun); err != nil {
+		log.Fatalf("Rewriting image URLs failed: %v", err)
+	}
+
+	// Only remove local copies once every row points at the new ones
+	if *deleteLocal && !*dryRun {
+		for key := range moved {
+			if err := os.Remove(filepath.Join(*dir, filepath.FromSlash(key))); err != nil {
+				log.Printf("Could not remove %s: %v\n", key, err)
+			}
+		}
+	}
+}
+
+// copyFiles puts every file under dir into the store, keyed by its path
+// relative to dir, and returns the keys it copied.
+func copyFiles(ctx context.Context, dir string, dryRun bool) (map[string]bool, error) {
+	moved := map[string]bool{}
+	err := filepath.WalkDir(dir, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if dryRun {
+			log.Printf("Would copy %s\n", key)
+			moved[key] = true
+			return nil
+		}
+		data, err := os.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+		contentType := mime.TypeByExtension(filepath.Ext(key))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		putCtx, cancel := context.WithTimeout(ctx, time.Minute)
+		defer cancel()
+		if _, err := fileupload.Store.Put(putCtx, key, data, contentType); err != nil {
+			return err
+		}
+		moved[key] = true
+		return nil
+	})
+	return moved, err
+}
+
+// movedURL returns the new URL of an image stored under /uploads/, or false
+// when the image was not one of the moved files. Both current URLs
+// (BASE_URL/uploads/<key>) and those the first upload handlers wrote
+// (BASE_URL//app/uploads/<key>) are recognised.
+func movedURL(oldURL string, moved map[string]bool) (string, bool) {
+	_, key, ok := strings.Cut(oldURL, "/uploads/")
+	if !ok {
+		return "", false
+	}
+	key = strings.TrimLeft(key, "/")
+	if !moved[key] {
+		return "", false
+	}
+	newURL := fileupload.Store.URL(key)
+	return newURL, newURL != oldURL
+}
+
+// rewriteURLs points recipe and profile images at the moved files.
+func rewriteURLs(ctx context.Context, moved map[string]bool, dryRun bool) error {
+	var images storedImagesQuery
+	if err := hasura.Client.Query(ctx, &images, nil); err != nil {
+		return err
+	}
+
+	rewrite := func(field **string, old string) bool {
+		newURL, ok := movedURL(old, moved)
+		if ok {
+			*field = &newURL
+		}
+		return ok
+	}
+
+	updated := 0
+	for _, image := range images.RecipeImages {
+		var set recipe_images_set_input
+		changed := rewrite(&set.ImageURL, image.ImageURL)
+		if image.ThumbnailURL != nil && rewrite(&set.ThumbnailURL, *image.ThumbnailURL) {
+			changed = true
+		}
+		if image.CardURL != nil && rewrite(&set.CardURL, *image.CardURL) {
+			changed = true
+		}
+		if !changed {
+			continue
+		}
+		updated++
+		if dryRun {
+			log.Printf("Would rewrite recipe image %s\n", image.ID)
+			continue
+		}
+		var resp updateRecipeImageMutation
+		if err := hasura.Client.Mutate(ctx, &resp, map[string]interface{}{"id": uuid(image.ID), "set": set}); err != nil {
+			return err
+		}
+	}
+	for _, image := range images.ProfileImages {
+		var set profile_images_set_input
+		if !rewrite(&set.ImageURL, image.ImageURL) {
+			continue
+		}
+		updated++
+		if dryRun {
+			log.Printf("Would rewrite profile image %s\n", image.ID)
+			continue
+		}
+		var resp updateProfileImageMutation
+		if err := hasura.Client.Mutate(ctx, &resp, map[string]interface{}{"id": uuid(image.ID), "set": set}); err != nil {
+			return err
+		}
+	}
+	log.Printf("%d image rows rewritten\n", updated)
+	return nil
+}
@@ -2,13 +2,17 @@ module github.com/wubshet-kebede/go-app
 
 go 1.24.6
 
+require (
+	github.com/golang-jwt/jwt/v5 v5.3.0
+	github.com/google/uuid v1.6.0
+	github.com/gorilla/mux v1.8.1
+	github.com/hasura/go-graphql-client v0.14.4
+	github.com/joho/godotenv v1.5.1
+	golang.org/x/crypto v0.41.0
+	golang.org/x/image v0.25.0
+)
+
 require (
 	github.com/coder/websocket v1.8.13 // indirect
-	github.com/golang-jwt/jwt/v5 v5.3.0 // indirect
-	github.com/google/uuid v1.6.0 // indirect
-	github.com/gorilla/mux v1.8.1 // indirect
 	github.com/graphql-go/graphql v0.8.1 // indirect
-	github.com/hasura/go-graphql-client v0.14.4 // indirect
-	github.com/joho/godotenv v1.5.1 // indirect
-	golang.org/x/crypto v0.41.0 // indirect
 )
@@ -21,25 +21,141 @@ func main() {
 	log.Printf("Starting server on port %s...", port)
 
 	hasura.InitClient()
+	if err := fileupload.InitStorage(); err != nil {
+		log.Fatalf("Storage setup failed: %v", err)
+	}
 hService := payment.NewHasuraService()
-cService := payment.NewChapaService()
+providers := payment.NewProviderRegistry()
 
 	r := mux.NewRouter()
-	r.PathPrefix("/uploads/").Handler(http.StripPrefix("/uploads/", http.FileServer(http.Dir("/app/uploads"))))
+	if fileupload.IsLocalStorage() {
+		r.PathPrefix("/uploads/").Handler(http.StripPrefix("/uploads/", http.FileServer(http.Dir(fileupload.UploadsRoot()))))
+	}
 	r.HandleFunc("/signUp", Handler.SignupHandler).Methods("POST")
 	r.HandleFunc("/login", Handler.LoginHandler).Methods("POST")
 	r.HandleFunc("/uploadFiles", fileupload.UploadFilesHandler).Methods("POST")
+	r.HandleFunc("/uploadProfilePicture", fileupload.UploadProfilePicHandler).Methods("POST")
+	r.HandleFunc("/createUploadUrl", fileupload.HandleCreateUploadUrl).Methods("POST")
+	r.HandleFunc("/confirmUpload", fileupload.HandleConfirmUpload).Methods("POST")
+	r.HandleFunc("/collectOrphanedUploads", fileupload.HandleCollectOrphanedUploads).Methods("POST")
+	r.HandleFunc("/upload", func(w http.ResponseWriter, r *http.Request) {
+		userID, err := Handler.AuthenticatedUserID(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		fileupload.MultipartUploadHandler(w, r, userID)
+	}).Methods("POST")
 r.HandleFunc("/initiate_chapa_payment", func(w http.ResponseWriter, r *http.Request) {
-    payment.HandleInitiateChapaPayment(w, r, hService, cService)
+    payment.HandleInitiatePayment(w, r, hService, providers)
 }).Methods("POST")
+	r.HandleFunc("/initiatePayment", func(w http.ResponseWriter, r *http.Request) {
+		payment.HandleInitiatePayment(w, r, hService, providers)
+	}).Methods("POST")
    
 
 
 r.HandleFunc("/chapa/callback", func(w http.ResponseWriter, r *http.Request) {
     log.Println("🔥 Chapa callback received!")
     log.Printf("Method: %s, URL: %s\n", r.Method, r.URL.String())
-    payment.HandleChapaCallback(w, r, hService, cService)
+    payment.HandlePaymentCallback(w, r, hService, providers, "chapa")
 }).Methods("GET", "POST")
+	r.HandleFunc("/payments/{provider}/callback", func(w http.ResponseWriter, r *http.Request) {
+		payment.HandlePaymentCallback(w, r, hService, providers, mux.Vars(r)["provider"])
+	}).Methods("GET", "POST")
+	r.HandleFunc("/refundOrder", func(w http.ResponseWriter, r *http.Request) {
+		payment.HandleRefundOrder(w, r, hService, providers)
+	}).Methods("POST")
+	r.HandleFunc("/myOrders", func(w http.ResponseWriter, r *http.Request) {
+		payment.HandleMyOrders(w, r, hService)
+	}).Methods("POST")
+	r.HandleFunc("/orderDetail", func(w http.ResponseWriter, r *http.Request) {
+		payment.HandleOrderDetail(w, r, hService)
+	}).Methods("POST")
+	r.HandleFunc("/orders/{orderId}/receipt.pdf", func(w http.ResponseWriter, r *http.Request) {
+		userID, err := Handler.AuthenticatedUserID(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		payment.HandleOrderReceipt(w, r, hService, userID, mux.Vars(r)["orderId"])
+	}).Methods("GET")
+	r.HandleFunc("/expireOrders", func(w http.ResponseWriter, r *http.Request) {
+		payment.HandleExpireOrders(w, r, hService)
+	}).Methods("POST")
+	r.HandleFunc("/issueMissingInvoices", func(w http.ResponseWriter, r *http.Request) {
+		payment.HandleIssueMissingInvoices(w, r, hService)
+	}).Methods("POST")
+	r.HandleFunc("/postMissingSales", func(w http.ResponseWriter, r *http.Request) {
+		payment.HandlePostMissingSales(w, r, hService)
+	}).Methods("POST")
+	r.HandleFunc("/reconcileRefunds", func(w http.ResponseWriter, r *http.Request) {
+		payment.HandleReconcileRefunds(w, r, hService, providers)
+	}).Methods("POST")
+	r.HandleFunc("/subscribe", func(w http.ResponseWriter, r *http.Request) {
+		payment.HandleSubscribe(w, r, hService, providers)
+	}).Methods("POST")
+	r.HandleFunc("/cancelSubscription", func(w http.ResponseWriter, r *http.Request) {
+		payment.HandleCancelSubscription(w, r, hService)
+	}).Methods("POST")
+	r.HandleFunc("/renewSubscriptions", func(w http.ResponseWriter, r *http.Request) {
+		payment.HandleRenewSubscriptions(w, r, hService, providers)
+	}).Methods("POST")
+	r.HandleFunc("/tipAuthor", func(w http.ResponseWriter, r *http.Request) {
+		payment.HandleTipAuthor(w, r, hService, providers)
+	}).Methods("POST")
+	r.HandleFunc("/orders/{orderId}/invoice.{format:html|pdf}", func(w http.ResponseWriter, r *http.Request) {
+		userID, err := Handler.AuthenticatedUserID(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		vars := mux.Vars(r)
+		payment.HandleOrderInvoice(w, r, hService, userID, vars["orderId"], vars["format"])
+	}).Methods("GET")
+	r.HandleFunc("/addToCart", func(w http.ResponseWriter, r *http.Request) {
+		payment.HandleAddToCart(w, r, hService)
+	}).Methods("POST")
+	r.HandleFunc("/updateCartItem", func(w http.ResponseWriter, r *http.Request) {
+		payment.HandleUpdateCartItem(w, r, hService)
+	}).Methods("POST")
+	r.HandleFunc("/removeFromCart", func(w http.ResponseWriter, r *http.Request) {
+		payment.HandleRemoveFromCart(w, r, hService)
+	}).Methods("POST")
+	r.HandleFunc("/getCartSummary", func(w http.ResponseWriter, r *http.Request) {
+		payment.HandleGetCartSummary(w, r, hService)
+	}).Methods("POST")
+	r.HandleFunc("/checkoutCart", func(w http.ResponseWriter, r *http.Request) {
+		payment.HandleCheckoutCart(w, r, hService, providers)
+	}).Methods("POST")
+	r.HandleFunc("/myEarnings", func(w http.ResponseWriter, r *http.Request) {
+		payment.HandleMyEarnings(w, r, hService)
+	}).Methods("POST")
+	r.HandleFunc("/creatorSalesReport", func(w http.ResponseWriter, r *http.Request) {
+		payment.HandleCreatorSalesReport(w, r, hService)
+	}).Methods("POST")
+	r.HandleFunc("/myPayoutHistory", func(w http.ResponseWriter, r *http.Request) {
+		payment.HandleMyPayoutHistory(w, r, hService)
+	}).Methods("POST")
+	r.HandleFunc("/createPayoutBatch", func(w http.ResponseWriter, r *http.Request) {
+		payment.HandleCreatePayoutBatch(w, r, hService)
+	}).Methods("POST")
+	r.HandleFunc("/exportPayoutBatch", func(w http.ResponseWriter, r *http.Request) {
+		payment.HandleExportPayoutBatch(w, r, hService)
+	}).Methods("POST")
+	r.HandleFunc("/settlePayoutBatch", func(w http.ResponseWriter, r *http.Request) {
+		payment.HandleSettlePayoutBatch(w, r, hService)
+	}).Methods("POST")
+	r.HandleFunc("/setExchangeRate", func(w http.ResponseWriter, r *http.Request) {
+		payment.HandleSetExchangeRate(w, r, hService)
+	}).Methods("POST")
+	r.HandleFunc("/importExchangeRates", func(w http.ResponseWriter, r *http.Request) {
+		payment.HandleImportExchangeRates(w, r, hService)
+	}).Methods("POST")
+	if fake, ok := providers.Fake(); ok {
+		r.HandleFunc("/fake-pay/checkout", fake.CheckoutPageHandler).Methods("GET")
+		r.HandleFunc("/fake-pay/complete", fake.CompleteHandler).Methods("POST")
+	}
 	r.HandleFunc("/submitContactForm", contact.HandleSubmitContactForm).Methods("POST")
 	// THIS MUST BLOCK
 	err := http.ListenAndServe("0.0.0.0:"+port, r)
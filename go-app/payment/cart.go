@@ -0,0 +1,524 @@
+package payment
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxCartItemQuantity caps how many copies of one recipe a single order may hold.
+const maxCartItemQuantity = 100
+
+// CartItemError explains why one cart line was rejected.
+type CartItemError struct {
+	RecipeID string `json:"recipeId"`
+	Message  string `json:"message"`
+}
+
+// cartError is returned when one or more cart lines are invalid. Every bad
+// line is reported, not just the first, so the client can fix them together.
+type cartError struct {
+	items []CartItemError
+}
+
+func (e cartError) Error() string {
+	messages := make([]string, 0, len(e.items))
+	for _, item := range e.items {
+		messages = append(messages, item.Message)
+	}
+	return "Some cart items are invalid: " + strings.Join(messages, "; ")
+}
+
+// cartLine is a validated cart entry priced from the recipe as stored. The
+// unit price is in ETB until priceCartLines converts it for checkout.
+type cartLine struct {
+	recipe    recipeDetails
+	quantity  int
+	unitPrice Money
+}
+
+func (l cartLine) total() Money {
+	return l.unitPrice.Mul(l.quantity)
+}
+
+// normalizeCartItems merges repeated recipe IDs by adding up their
+// quantities, keeping the order in which recipes first appear. Lines with a
+// missing ID or a quantity outside 1..maxCartItemQuantity are rejected.
+func normalizeCartItems(items []RecipeItemInput) ([]RecipeItemInput, []CartItemError) {
+	var normalized []RecipeItemInput
+	var errs []CartItemError
+	index := make(map[string]int, len(items))
+	for _, item := range items {
+		recipeID := strings.TrimSpace(item.RecipeID)
+		if recipeID == "" {
+			errs = append(errs, CartItemError{Message: "recipeId is required"})
+			continue
+		}
+		if item.Quantity <= 0 {
+			errs = append(errs, CartItemError{RecipeID: recipeID, Message: fmt.Sprintf("quantity must be at least 1, got %d", item.Quantity)})
+			continue
+		}
+		if i, ok := index[recipeID]; ok {
+			normalized[i].Quantity += item.Quantity
+			continue
+		}
+		index[recipeID] = len(normalized)
+		normalized = append(normalized, RecipeItemInput{RecipeID: recipeID, Quantity: item.Quantity})
+	}
+	for _, item := range normalized {
+		if item.Quantity > maxCartItemQuantity {
+			errs = append(errs, CartItemError{RecipeID: item.RecipeID, Message: fmt.Sprintf("quantity cannot exceed %d", maxCartItemQuantity)})
+		}
+	}
+	return normalized, errs
+}
+
+// validateCartLines matches normalized items to their recipes and rejects
+// recipes that do not exist, are not published, belong to the buyer or are
+// already owned by them.
+func validateCartLines(items []RecipeItemInput, recipes []recipeDetails, owned map[string]bool, buyerID string) ([]cartLine, []CartItemError) {
+	byID := make(map[string]recipeDetails, len(recipes))
+	for _, recipe := range recipes {
+		byID[recipe.ID] = recipe
+	}
+
+	var lines []cartLine
+	var errs []CartItemError
+	for _, item := range items {
+		recipe, ok := byID[item.RecipeID]
+		switch {
+		case !ok:
+			errs = append(errs, CartItemError{RecipeID: item.RecipeID, Message: "recipe not found"})
+		case !recipe.IsPublished:
+			errs = append(errs, CartItemError{RecipeID: item.RecipeID, Message: fmt.Sprintf("%s is not available for purchase", recipe.Title)})
+		case recipe.UserID == buyerID:
+			errs = append(errs, CartItemError{RecipeID: item.RecipeID, Message: fmt.Sprintf("%s is your own recipe", recipe.Title)})
+		case owned[item.RecipeID]:
+			errs = append(errs, CartItemError{RecipeID: item.RecipeID, Message: fmt.Sprintf("you already own %s", recipe.Title)})
+		default:
+			lines = append(lines, cartLine{recipe: recipe, quantity: item.Quantity, unitPrice: recipe.PriceETB})
+		}
+	}
+	return lines, errs
+}
+
+// loadCartLines normalizes and validates a buyer's cart against the current
+// recipes. Invalid items are reported together as a cartError.
+func loadCartLines(ctx context.Context, hasuraService *HasuraService, buyerID string, items []RecipeItemInput) ([]cartLine, error) {
+	normalized, errs := normalizeCartItems(items)
+	if len(normalized) == 0 {
+		if len(errs) == 0 {
+			errs = append(errs, CartItemError{Message: "the cart is empty"})
+		}
+		return nil, cartError{items: errs}
+	}
+
+	recipeIDs := make([]string, len(normalized))
+	for i, item := range normalized {
+		recipeIDs[i] = item.RecipeID
+	}
+	recipesResp, err := hasuraService.QueryRecipeDetails(ctx, recipeIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve recipe details: %w", err)
+	}
+	ownedResp, err := hasuraService.QueryOwnedRecipes(ctx, buyerID, recipeIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check owned recipes: %w", err)
+	}
+	owned := make(map[string]bool)
+	for _, purchase := range ownedResp.Purchases {
+		owned[purchase.RecipeID] = true
+	}
+	for _, item := range ownedResp.OrderItems {
+		owned[item.RecipeID] = true
+	}
+
+	lines, lineErrs := validateCartLines(normalized, recipesResp.Recipes, owned, buyerID)
+	errs = append(errs, lineErrs...)
+	if len(errs) > 0 {
+		return nil, cartError{items: errs}
+	}
+	return lines, nil
+}
+
+// respondWithCartError reports every rejected cart line under the error's
+// extensions so the client can point at each one.
+func respondWithCartError(w http.ResponseWriter, err cartError) {
+	respondWithErrorExtensions(w, http.StatusBadRequest, err.Error(), map[string]interface{}{
+		"code":  "invalid_cart",
+		"items": err.items,
+	})
+}
+
+// buildCartSummary prices the user's cart from the live recipe prices and
+// flags items whose price changed since they were added or that can no
+// longer be bought.
+func buildCartSummary(ctx context.Context, hasuraService *HasuraService, userID string) (CartSummaryOutput, error) {
+	summary := CartSummaryOutput{Items: []CartItemSummary{}, Currency: baseCurrency, Issues: []CartItemError{}}
+	cartResp, err := hasuraService.QueryCart(ctx, userID)
+	if err != nil {
+		return summary, fmt.Errorf("failed to retrieve cart: %w", err)
+	}
+	if len(cartResp.Carts) == 0 {
+		return summary, nil
+	}
+	cart := cartResp.Carts[0]
+	summary.CartID = &cart.ID
+	if len(cart.CartItems) == 0 {
+		return summary, nil
+	}
+
+	items := make([]RecipeItemInput, 0, len(cart.CartItems))
+	recipes := make([]recipeDetails, 0, len(cart.CartItems))
+	recipeIDs := make([]string, 0, len(cart.CartItems))
+	for _, item := range cart.CartItems {
+		items = append(items, RecipeItemInput{RecipeID: item.RecipeID, Quantity: item.Quantity})
+		recipes = append(recipes, item.Recipe)
+		recipeIDs = append(recipeIDs, item.RecipeID)
+	}
+	ownedResp, err := hasuraService.QueryOwnedRecipes(ctx, userID, recipeIDs)
+	if err != nil {
+		return summary, fmt.Errorf("failed to check owned recipes: %w", err)
+	}
+	owned := make(map[string]bool)
+	for _, purchase := range ownedResp.Purchases {
+		owned[purchase.RecipeID] = true
+	}
+	for _, item := range ownedResp.OrderItems {
+		owned[item.RecipeID] = true
+	}
+	if _, errs := validateCartLines(items, recipes, owned, userID); len(errs) > 0 {
+		summary.Issues = errs
+	}
+
+	for _, item := range cart.CartItems {
+		lineTotal := item.Recipe.PriceETB.Mul(item.Quantity)
+		priceChanged := item.Recipe.PriceETB != item.PriceAtAdd
+		summary.Items = append(summary.Items, CartItemSummary{
+			RecipeID:     item.RecipeID,
+			Title:        item.Recipe.Title,
+			ImageURL:     featuredImageURL(item.Recipe),
+			Quantity:     item.Quantity,
+			UnitPrice:    item.Recipe.PriceETB,
+			PriceAtAdd:   item.PriceAtAdd,
+			PriceChanged: priceChanged,
+			LineTotal:    lineTotal,
+		})
+		summary.ItemCount += item.Quantity
+		summary.Subtotal += lineTotal
+		summary.HasPriceChanges = summary.HasPriceChanges || priceChanged
+	}
+	return summary, nil
+}
+
+// saveCartItem validates a recipe at the given quantity and stores it in the
+// user's cart. A recipe new to the cart is stored at today's price; one
+// already there keeps the price it was added at, so a price change stays
+// flagged until the buyer accepts it at checkout.
+func saveCartItem(ctx context.Context, hasuraService *HasuraService, userID string, recipeID string, quantity int) error {
+	lines, err := loadCartLines(ctx, hasuraService, userID, []RecipeItemInput{{RecipeID: recipeID, Quantity: quantity}})
+	if err != nil {
+		return err
+	}
+	cartResp, err := hasuraService.UpsertCart(ctx, userID)
+	if err != nil || cartResp.InsertCartsOne == nil {
+		return fmt.Errorf("failed to create cart: %v", err)
+	}
+	_, err = hasuraService.UpsertCartItem(ctx, cart_items_insert_input{
+		CartID:     uuid(cartResp.InsertCartsOne.ID),
+		RecipeID:   uuid(lines[0].recipe.ID),
+		Quantity:   lines[0].quantity,
+		PriceAtAdd: lines[0].recipe.PriceETB,
+		UpdatedAt:  DateTime(time.Now()),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save cart item: %w", err)
+	}
+	return nil
+}
+
+// cartQuantity returns how many of a recipe the user's cart already holds.
+func cartQuantity(cartResp cartQuery, recipeID string) (int, bool) {
+	if len(cartResp.Carts) == 0 {
+		return 0, false
+	}
+	for _, item := range cartResp.Carts[0].CartItems {
+		if item.RecipeID == recipeID {
+			return item.Quantity, true
+		}
+	}
+	return 0, false
+}
+
+// respondWithCartSummary answers a cart action with the updated cart.
+func respondWithCartSummary(ctx context.Context, w http.ResponseWriter, hasuraService *HasuraService, userID string) {
+	summary, err := buildCartSummary(ctx, hasuraService, userID)
+	if err != nil {
+		log.Printf("Failed to build cart summary for %s: %v", userID, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve cart")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, summary)
+}
+
+// respondWithCartSaveError reports why a cart item could not be saved.
+func respondWithCartSaveError(w http.ResponseWriter, userID string, err error) {
+	var cErr cartError
+	if errors.As(err, &cErr) {
+		respondWithCartError(w, cErr)
+		return
+	}
+	log.Printf("Failed to update cart for %s: %v", userID, err)
+	respondWithError(w, http.StatusInternalServerError, "Failed to update cart")
+}
+
+// HandleAddToCart handles the Hasura Action webhook that adds a recipe to the
+// caller's cart, adding to the quantity if it is already there.
+func HandleAddToCart(w http.ResponseWriter, r *http.Request, hasuraService *HasuraService) {
+	var payload CartItemActionPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Failed to parse Hasura Action payload")
+		return
+	}
+	defer r.Body.Close()
+
+	input := payload.Input.Input
+	userID := payload.SessionVariables["x-hasura-user-id"]
+	if userID == "" || input.RecipeID == "" {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload or missing user ID")
+		return
+	}
+	quantity := 1
+	if input.Quantity != nil {
+		quantity = *input.Quantity
+	}
+	if quantity <= 0 {
+		respondWithError(w, http.StatusBadRequest, "quantity must be at least 1")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cartResp, err := hasuraService.QueryCart(ctx, userID)
+	if err != nil {
+		log.Printf("Failed to query cart for %s: %v", userID, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve cart")
+		return
+	}
+	existing, _ := cartQuantity(cartResp, input.RecipeID)
+	if err := saveCartItem(ctx, hasuraService, userID, input.RecipeID, existing+quantity); err != nil {
+		respondWithCartSaveError(w, userID, err)
+		return
+	}
+	respondWithCartSummary(ctx, w, hasuraService, userID)
+}
+
+// HandleUpdateCartItem handles the Hasura Action webhook that sets the
+// quantity of a recipe already in the caller's cart. A quantity of zero
+// removes it.
+func HandleUpdateCartItem(w http.ResponseWriter, r *http.Request, hasuraService *HasuraService) {
+	var payload CartItemActionPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Failed to parse Hasura Action payload")
+		return
+	}
+	defer r.Body.Close()
+
+	input := payload.Input.Input
+	userID := payload.SessionVariables["x-hasura-user-id"]
+	if userID == "" || input.RecipeID == "" || input.Quantity == nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload or missing user ID")
+		return
+	}
+	if *input.Quantity < 0 {
+		respondWithError(w, http.StatusBadRequest, "quantity cannot be negative")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cartResp, err := hasuraService.QueryCart(ctx, userID)
+	if err != nil {
+		log.Printf("Failed to query cart for %s: %v", userID, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve cart")
+		return
+	}
+	if _, ok := cartQuantity(cartResp, input.RecipeID); !ok {
+		respondWithError(w, http.StatusNotFound, "This recipe is not in your cart")
+		return
+	}
+
+	if *input.Quantity == 0 {
+		_, err = hasuraService.DeleteCartItems(ctx, userID, []string{input.RecipeID})
+	} else {
+		err = saveCartItem(ctx, hasuraService, userID, input.RecipeID, *input.Quantity)
+	}
+	if err != nil {
+		respondWithCartSaveError(w, userID, err)
+		return
+	}
+	respondWithCartSummary(ctx, w, hasuraService, userID)
+}
+
+// HandleRemoveFromCart handles the Hasura Action webhook that removes a recipe from the caller's cart.
+func HandleRemoveFromCart(w http.ResponseWriter, r *http.Request, hasuraService *HasuraService) {
+	var payload CartItemActionPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Failed to parse Hasura Action payload")
+		return
+	}
+	defer r.Body.Close()
+
+	input := payload.Input.Input
+	userID := payload.SessionVariables["x-hasura-user-id"]
+	if userID == "" || input.RecipeID == "" {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload or missing user ID")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := hasuraService.DeleteCartItems(ctx, userID, []string{input.RecipeID}); err != nil {
+		log.Printf("Failed to remove %s from cart for %s: %v", input.RecipeID, userID, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to update cart")
+		return
+	}
+	respondWithCartSummary(ctx, w, hasuraService, userID)
+}
+
+// HandleGetCartSummary handles the Hasura Action webhook returning the caller's cart priced at today's prices.
+func HandleGetCartSummary(w http.ResponseWriter, r *http.Request, hasuraService *HasuraService) {
+	var payload CartSummaryActionPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Failed to parse Hasura Action payload")
+		return
+	}
+	defer r.Body.Close()
+
+	userID := payload.SessionVariables["x-hasura-user-id"]
+	if userID == "" {
+		respondWithError(w, http.StatusBadRequest, "Missing user ID")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	respondWithCartSummary(ctx, w, hasuraService, userID)
+}
+
+// HandleCheckoutCart handles the Hasura Action webhook that turns the
+// caller's cart into an order. Totals come only from the stored recipe
+// prices; if any price changed since it was added, the buyer must confirm
+// with acceptPriceChanges before the order is created.
+func HandleCheckoutCart(w http.ResponseWriter, r *http.Request, hasuraService *HasuraService, providers *ProviderRegistry) {
+	log.Println("🚀 Received /checkoutCart request")
+	var payload CheckoutCartActionPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Failed to parse Hasura Action payload")
+		return
+	}
+	defer r.Body.Close()
+
+	input := payload.Input.Input
+	userID := payload.SessionVariables["x-hasura-user-id"]
+	if userID == "" || input.ReturnURL == "" {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload or missing user ID")
+		return
+	}
+	provider, ok := providers.Get(input.Provider)
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Unsupported payment provider: %s", input.Provider))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	cartResp, err := hasuraService.QueryCart(ctx, userID)
+	if err != nil {
+		log.Printf("Failed to query cart for %s: %v", userID, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve cart")
+		return
+	}
+	if len(cartResp.Carts) == 0 || len(cartResp.Carts[0].CartItems) == 0 {
+		respondWithError(w, http.StatusBadRequest, "Your cart is empty")
+		return
+	}
+
+	cartItems := cartResp.Carts[0].CartItems
+	items := make([]RecipeItemInput, 0, len(cartItems))
+	priceAtAdd := make(map[string]Money, len(cartItems))
+	for _, item := range cartItems {
+		items = append(items, RecipeItemInput{RecipeID: item.RecipeID, Quantity: item.Quantity})
+		priceAtAdd[item.RecipeID] = item.PriceAtAdd
+	}
+	lines, err := loadCartLines(ctx, hasuraService, userID, items)
+	if err != nil {
+		respondWithCartSaveError(w, userID, err)
+		return
+	}
+
+	var changed []CartItemError
+	newPrices := make(map[string]Money)
+	for _, line := range lines {
+		if was := priceAtAdd[line.recipe.ID]; was != line.recipe.PriceETB {
+			newPrices[line.recipe.ID] = line.recipe.PriceETB
+			changed = append(changed, CartItemError{
+				RecipeID: line.recipe.ID,
+				Message:  fmt.Sprintf("the price of %s changed from %s to %s", line.recipe.Title, was, line.recipe.PriceETB),
+			})
+		}
+	}
+	if len(changed) > 0 {
+		if !input.AcceptPriceChanges {
+			respondWithErrorExtensions(w, http.StatusConflict, "Some prices in your cart have changed", map[string]interface{}{
+				"code":  "price_changed",
+				"items": changed,
+			})
+			return
+		}
+		// Accepted prices stop being flagged, even if this payment is abandoned
+		if _, err := hasuraService.AcceptCartPrices(ctx, cartResp.Carts[0].ID, newPrices); err != nil {
+			log.Printf("Failed to accept new cart prices for %s: %v", userID, err)
+		}
+	}
+
+	currency, rate, err := priceCartLines(ctx, hasuraService, provider, lines, input.Currency)
+	if err != nil {
+		respondWithCheckoutError(w, err)
+		return
+	}
+	output, err := startCheckout(ctx, hasuraService, provider, checkoutRequest{
+		buyerID:      userID,
+		lines:        lines,
+		currency:     currency,
+		exchangeRate: rate,
+		returnURL:    input.ReturnURL,
+		couponCode:   input.CouponCode,
+		checkoutType: checkoutTypeCart,
+	})
+	if err != nil {
+		respondWithCheckoutError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, output)
+}
+
+// clearPurchasedCartItems empties the buyer's cart of the recipes in a paid
+// order. The cart is kept until then so an abandoned payment loses nothing.
+func clearPurchasedCartItems(ctx context.Context, hasuraService *HasuraService, order callbackOrder) {
+	if len(order.OrderItems) == 0 {
+		return
+	}
+	recipeIDs := make([]string, 0, len(order.OrderItems))
+	for _, item := range order.OrderItems {
+		recipeIDs = append(recipeIDs, item.RecipeID)
+	}
+	if _, err := hasuraService.DeleteCartItems(ctx, order.UserID, recipeIDs); err != nil {
+		log.Printf("Failed to clear purchased items from the cart of %s: %v", order.UserID, err)
+	}
+}
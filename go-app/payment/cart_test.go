@@ -0,0 +1,253 @@
+package payment
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeCartItems(t *testing.T) {
+	items := []RecipeItemInput{
+		{RecipeID: "r1", Quantity: 1},
+		{RecipeID: " r2 ", Quantity: 2},
+		{RecipeID: "r1", Quantity: 3},
+		{RecipeID: "", Quantity: 1},
+		{RecipeID: "r3", Quantity: 0},
+		{RecipeID: "r4", Quantity: -2},
+	}
+	normalized, errs := normalizeCartItems(items)
+
+	want := []RecipeItemInput{{RecipeID: "r1", Quantity: 4}, {RecipeID: "r2", Quantity: 2}}
+	if !reflect.DeepEqual(normalized, want) {
+		t.Errorf("normalized = %+v, want %+v", normalized, want)
+	}
+	wantErrs := []CartItemError{
+		{Message: "recipeId is required"},
+		{RecipeID: "r3", Message: "quantity must be at least 1, got 0"},
+		{RecipeID: "r4", Message: "quantity must be at least 1, got -2"},
+	}
+	if !reflect.DeepEqual(errs, wantErrs) {
+		t.Errorf("errors = %+v, want %+v", errs, wantErrs)
+	}
+}
+
+// The quantity limit applies to the merged line, so splitting a recipe over
+// several entries cannot get around it.
+func TestNormalizeCartItemsLimitsMergedQuantity(t *testing.T) {
+	_, errs := normalizeCartItems([]RecipeItemInput{
+		{RecipeID: "r1", Quantity: maxCartItemQuantity},
+		{RecipeID: "r1", Quantity: 1},
+	})
+	if len(errs) != 1 || errs[0].RecipeID != "r1" {
+		t.Errorf("errors = %+v, want one for r1", errs)
+	}
+}
+
+func TestValidateCartLines(t *testing.T) {
+	recipes := []recipeDetails{
+		{ID: "r1", UserID: "author", Title: "Doro wat", PriceETB: 7525, IsPublished: true},
+		{ID: "r2", UserID: "author", Title: "Draft", IsPublished: false},
+		{ID: "r3", UserID: "buyer", Title: "My shiro", IsPublished: true},
+		{ID: "r4", UserID: "author", Title: "Kitfo", IsPublished: true},
+	}
+	items := []RecipeItemInput{
+		{RecipeID: "r1", Quantity: 2},
+		{RecipeID: "r2", Quantity: 1},
+		{RecipeID: "r3", Quantity: 1},
+		{RecipeID: "r4", Quantity: 1},
+		{RecipeID: "r5", Quantity: 1},
+	}
+	lines, errs := validateCartLines(items, recipes, map[string]bool{"r4": true}, "buyer")
+
+	if len(lines) != 1 || lines[0].recipe.ID != "r1" || lines[0].total() != 15050 {
+		t.Errorf("lines = %+v, want r1 for 150.50", lines)
+	}
+	wantErrs := []CartItemError{
+		{RecipeID: "r2", Message: "Draft is not available for purchase"},
+		{RecipeID: "r3", Message: "My shiro is your own recipe"},
+		{RecipeID: "r4", Message: "you already own Kitfo"},
+		{RecipeID: "r5", Message: "recipe not found"},
+	}
+	if !reflect.DeepEqual(errs, wantErrs) {
+		t.Errorf("errors = %+v, want %+v", errs, wantErrs)
+	}
+}
+
+func TestLoadCartLinesOwnership(t *testing.T) {
+	hasura, hasuraService := newFakeHasura(t)
+	hasura.on("recipes", `[
+		{"id":"r1","user_id":"a1","price_etb":10,"title":"Bought","is_published":true},
+		{"id":"r2","user_id":"a1","price_etb":10,"title":"Paid","is_published":true},
+		{"id":"r3","user_id":"a1","price_etb":10,"title":"New","is_published":true}]`)
+	hasura.on("purchases", `[{"recipe_id":"r1"}]`)
+	hasura.on("order_items", `[{"recipe_id":"r2"}]`)
+
+	_, err := loadCartLines(context.Background(), hasuraService, "buyer", []RecipeItemInput{
+		{RecipeID: "r1", Quantity: 1}, {RecipeID: "r2", Quantity: 1}, {RecipeID: "r3", Quantity: 1},
+	})
+	var cErr cartError
+	if !errors.As(err, &cErr) {
+		t.Fatalf("loadCartLines error = %v, want a cartError", err)
+	}
+	if len(cErr.items) != 2 || cErr.items[0].RecipeID != "r1" || cErr.items[1].RecipeID != "r2" {
+		t.Errorf("rejected %+v, want r1 and r2", cErr.items)
+	}
+
+	var buyerID string
+	hasura.calls("purchases")[0].variable(t, "buyerId", &buyerID)
+	if buyerID != "buyer" {
+		t.Errorf("ownership checked for %q, want the buyer", buyerID)
+	}
+}
+
+func TestInitiatePaymentReportsEveryBadItem(t *testing.T) {
+	hasura, hasuraService := newFakeHasura(t)
+	hasura.on("recipes", `[{"id":"r1","user_id":"u1","price_etb":10,"title":"Mine","is_published":true}]`)
+	t.Setenv("PAYMENT_PROVIDER", "fake")
+	t.Setenv("PAYMENT_PROVIDERS", "")
+
+	action := `{"input":{"input":{"recipeItems":[{"recipeId":"r1","quantity":1},{"recipeId":"r9","quantity":0}],"amount":10,"currency":"ETB"}},"session_variables":{"x-hasura-user-id":"u1"}}`
+	rec := httptest.NewRecorder()
+	HandleInitiatePayment(rec, httptest.NewRequest(http.MethodPost, "/initiatePayment", bytes.NewBufferString(action)), hasuraService, NewProviderRegistry())
+
+	var resp struct {
+		Extensions struct {
+			Code  string          `json:"code"`
+			Items []CartItemError `json:"items"`
+		} `json:"extensions"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if rec.Code != http.StatusBadRequest || resp.Extensions.Code != "invalid_cart" || len(resp.Extensions.Items) != 2 {
+		t.Errorf("initiate = %d %s", rec.Code, rec.Body)
+	}
+	if len(hasura.calls("insert_orders_one")) != 0 {
+		t.Error("an order was created for an invalid cart")
+	}
+}
+
+func TestCartSummary(t *testing.T) {
+	hasura, hasuraService := newFakeHasura(t)
+	hasura.on("carts", `[{"id":"cart1","cart_items":[
+		{"id":"ci1","recipe_id":"r1","quantity":2,"price_at_add":70.00,
+			"recipe":{"id":"r1","user_id":"a1","price_etb":75.25,"title":"Doro wat","is_published":true}},
+		{"id":"ci2","recipe_id":"r2","quantity":1,"price_at_add":40.00,
+			"recipe":{"id":"r2","user_id":"a1","price_etb":40.00,"title":"Shiro","is_published":false}}]}]`)
+
+	summary, err := buildCartSummary(context.Background(), hasuraService, "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.CartID == nil || *summary.CartID != "cart1" || summary.ItemCount != 3 || summary.Subtotal != 19050 {
+		t.Errorf("summary = %+v", summary)
+	}
+	if !summary.HasPriceChanges || !summary.Items[0].PriceChanged || summary.Items[1].PriceChanged {
+		t.Errorf("price changes flagged as %+v", summary.Items)
+	}
+	// Items that can no longer be bought stay in the cart but are reported.
+	if len(summary.Issues) != 1 || summary.Issues[0].RecipeID != "r2" {
+		t.Errorf("issues = %+v, want r2", summary.Issues)
+	}
+}
+
+func TestAddToCartIncreasesQuantity(t *testing.T) {
+	hasura, hasuraService := newFakeHasura(t)
+	hasura.on("carts", `[{"id":"cart1","cart_items":[{"id":"ci1","recipe_id":"r1","quantity":2,"price_at_add":70.00,"recipe":{"id":"r1"}}]}]`)
+	hasura.on("recipes", `[{"id":"r1","user_id":"a1","price_etb":75.25,"title":"Doro wat","is_published":true}]`)
+	hasura.on("insert_carts_one", `{"id":"cart1"}`)
+	hasura.on("insert_cart_items_one", `{"id":"ci1"}`)
+
+	action := `{"input":{"input":{"recipeId":"r1"}},"session_variables":{"x-hasura-user-id":"u1"}}`
+	rec := httptest.NewRecorder()
+	HandleAddToCart(rec, httptest.NewRequest(http.MethodPost, "/addToCart", bytes.NewBufferString(action)), hasuraService)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("addToCart = %d %s", rec.Code, rec.Body)
+	}
+
+	var item cart_items_insert_input
+	upsert := hasura.calls("insert_cart_items_one")[0]
+	upsert.variable(t, "object", &item)
+	if item.Quantity != 3 {
+		t.Errorf("saved quantity %d, want 3", item.Quantity)
+	}
+	// The existing item keeps the price it was added at, so the change is
+	// still flagged at checkout.
+	if !strings.Contains(upsert.Query, "update_columns: [quantity, updated_at]") {
+		t.Errorf("adding again overwrites price_at_add: %s", upsert.Query)
+	}
+}
+
+func TestCheckoutCartAsksToAcceptPriceChanges(t *testing.T) {
+	hasura, hasuraService := newFakeHasura(t)
+	hasura.on("carts", `[{"id":"cart1","cart_items":[{"id":"ci1","recipe_id":"r1","quantity":1,"price_at_add":70.00,"recipe":{"id":"r1"}}]}]`)
+	hasura.on("recipes", `[{"id":"r1","user_id":"a1","price_etb":75.25,"title":"Doro wat","is_published":true}]`)
+	hasura.on("users_by_pk", `{"first_name":"Abebe","last_name":"Kebede","email":"abebe@example.com"}`)
+	hasura.on("exchange_rates_by_pk", `{"currency":"ETB","etb_per_unit":1}`)
+	hasura.on("insert_orders_one", `{"id":"o1","chapa_tx_ref":"","return_url":""}`)
+	t.Setenv("PAYMENT_PROVIDER", "fake")
+	t.Setenv("PAYMENT_PROVIDERS", "")
+	providers := NewProviderRegistry()
+
+	checkout := func(accept bool) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]interface{}{
+			"input": map[string]interface{}{"input": map[string]interface{}{
+				"returnUrl": "https://shop.example.com", "provider": "fake", "acceptPriceChanges": accept,
+			}},
+			"session_variables": map[string]string{"x-hasura-user-id": "u1"},
+		})
+		rec := httptest.NewRecorder()
+		HandleCheckoutCart(rec, httptest.NewRequest(http.MethodPost, "/checkoutCart", bytes.NewReader(body)), hasuraService, providers)
+		return rec
+	}
+
+	if rec := checkout(false); rec.Code != http.StatusConflict || !bytes.Contains(rec.Body.Bytes(), []byte(`"price_changed"`)) {
+		t.Fatalf("checkout with a changed price = %d %s", rec.Code, rec.Body)
+	}
+	if n := len(hasura.calls("insert_orders_one")); n != 0 {
+		t.Fatalf("%d orders created before the buyer accepted the new price", n)
+	}
+
+	if n := len(hasura.calls("update_cart_items_many")); n != 0 {
+		t.Fatalf("the new price was accepted %d times without the buyer", n)
+	}
+
+	if rec := checkout(true); rec.Code != http.StatusOK {
+		t.Fatalf("checkout accepting the new price = %d %s", rec.Code, rec.Body)
+	}
+	var order orders_insert_input
+	hasura.calls("insert_orders_one")[0].variable(t, "object", &order)
+	if order.TotalAmount != 7525 {
+		t.Errorf("order total = %s, want the current price 75.25", order.TotalAmount)
+	}
+	if order.CheckoutType == nil || *order.CheckoutType != checkoutTypeCart {
+		t.Errorf("order checkout type = %v, want cart", order.CheckoutType)
+	}
+	var updates []cart_items_updates
+	if calls := hasura.calls("update_cart_items_many"); len(calls) != 1 {
+		t.Fatalf("new price accepted %d times, want once", len(calls))
+	} else {
+		calls[0].variable(t, "updates", &updates)
+	}
+	if len(updates) != 1 || updates[0].Set.PriceAtAdd != 7525 {
+		t.Errorf("accepted prices = %+v, want r1 at 75.25", updates)
+	}
+}
+
+func TestCheckoutEmptyCart(t *testing.T) {
+	hasura, hasuraService := newFakeHasura(t)
+	hasura.on("carts", `[]`)
+	t.Setenv("PAYMENT_PROVIDER", "fake")
+	t.Setenv("PAYMENT_PROVIDERS", "")
+
+	action := `{"input":{"input":{"returnUrl":"https://shop.example.com"}},"session_variables":{"x-hasura-user-id":"u1"}}`
+	rec := httptest.NewRecorder()
+	HandleCheckoutCart(rec, httptest.NewRequest(http.MethodPost, "/checkoutCart", bytes.NewBufferString(action)), hasuraService, NewProviderRegistry())
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("checkout of an empty cart = %d, want 400", rec.Code)
+	}
+}
@@ -10,87 +10,204 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"strings"
 	"time"
 )
 
-const chapaAPIURL = "https://api.chapa.co/v1/transaction/initialize"
-const chapaVerifyAPIURL = "https://api.chapa.co/v1/transaction/verify"
+const defaultChapaBaseURL = "https://api.chapa.co/v1"
 
-// ChapaService encapsulates all Chapa API logic.
+// ChapaService encapsulates all Chapa API logic and implements PaymentProvider.
 type ChapaService struct {
 	secretKey string
+	baseURL   string
 	client    *http.Client
 }
 
 // NewChapaService creates a new instance of ChapaService.
+// CHAPA_API_BASE_URL overrides the API host, e.g. to point at a stub server.
 func NewChapaService() *ChapaService {
 	secret := os.Getenv("CHAPA_SECRET_KEY")
 	if secret == "" {
 		log.Fatal("CHAPA_SECRET_KEY environment variable not set.")
 	}
+	baseURL := os.Getenv("CHAPA_API_BASE_URL")
+	if baseURL == "" {
+		baseURL = defaultChapaBaseURL
+	}
 	return &ChapaService{
 		secretKey: secret,
+		baseURL:   strings.TrimSuffix(baseURL, "/"),
 		client:    &http.Client{Timeout: 10 * time.Second},
 	}
 }
 
+// Name identifies Chapa as the provider of an order.
+func (s *ChapaService) Name() string {
+	return "chapa"
+}
+
+// SupportsCurrency reports whether Chapa settles payments in currency.
+// Chapa accepts birr and US dollars.
+func (s *ChapaService) SupportsCurrency(currency string) bool {
+	return currency == "ETB" || currency == "USD"
+}
+
 // InitiatePayment calls the Chapa API to start a new transaction.
-func (s *ChapaService) InitiatePayment(ctx context.Context, reqData ChapaInitiateRequest) (ChapaInitiateResponse, error) {
-	reqBytes, err := json.Marshal(reqData)
+func (s *ChapaService) InitiatePayment(ctx context.Context, reqData InitiatePaymentRequest) (InitiatePaymentResult, error) {
+	chapaReq := ChapaInitiateRequest{
+		Amount:      reqData.Amount.String(),
+		Currency:    reqData.Currency,
+		TxRef:       reqData.TxRef,
+		Email:       reqData.Email,
+		FirstName:   reqData.FirstName,
+		LastName:    reqData.LastName,
+		PhoneNumber: reqData.PhoneNumber,
+		CallbackURL: reqData.CallbackURL,
+		ReturnURL:   reqData.ReturnURL,
+		Title:       reqData.Title,
+		Description: reqData.Description,
+	}
+	reqBytes, err := json.Marshal(chapaReq)
 	if err != nil {
-		return ChapaInitiateResponse{}, fmt.Errorf("failed to marshal Chapa request: %w", err)
+		return InitiatePaymentResult{}, fmt.Errorf("failed to marshal Chapa request: %w", err)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", chapaAPIURL, bytes.NewBuffer(reqBytes))
+	req, err := http.NewRequestWithContext(ctx, "POST", s.baseURL+"/transaction/initialize", bytes.NewBuffer(reqBytes))
 	if err != nil {
-		return ChapaInitiateResponse{}, fmt.Errorf("failed to create Chapa API request: %w", err)
+		return InitiatePaymentResult{}, fmt.Errorf("failed to create Chapa API request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer "+s.secretKey)
 
 	resp, err := s.client.Do(req)
 	if err != nil {
-		return ChapaInitiateResponse{}, fmt.Errorf("failed to call Chapa API: %w", err)
+		return InitiatePaymentResult{}, fmt.Errorf("failed to call Chapa API: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		bodyBytes, _ := io.ReadAll(resp.Body)
-		return ChapaInitiateResponse{}, fmt.Errorf("chapa API returned non-OK status: %d, response: %s", resp.StatusCode, string(bodyBytes))
+		return InitiatePaymentResult{}, fmt.Errorf("chapa API returned non-OK status: %d, response: %s", resp.StatusCode, string(bodyBytes))
 	}
 
 	var chapaResp ChapaInitiateResponse
 	if err := json.NewDecoder(resp.Body).Decode(&chapaResp); err != nil {
-		return ChapaInitiateResponse{}, fmt.Errorf("failed to decode Chapa API response: %w", err)
+		return InitiatePaymentResult{}, fmt.Errorf("failed to decode Chapa API response: %w", err)
 	}
-	return chapaResp, nil
+	return InitiatePaymentResult{CheckoutURL: chapaResp.Data.CheckoutURL}, nil
 }
 
 // VerifyPayment calls the Chapa API to verify a transaction's status.
-func (s *ChapaService) VerifyPayment(ctx context.Context, txRef string) (ChapaVerifyResponse, error) {
-	verifyURL := fmt.Sprintf("%s/%s", chapaVerifyAPIURL, txRef)
+func (s *ChapaService) VerifyPayment(ctx context.Context, txRef string) (PaymentVerification, error) {
+	verifyURL := fmt.Sprintf("%s/transaction/verify/%s", s.baseURL, txRef)
 	req, err := http.NewRequestWithContext(ctx, "GET", verifyURL, nil)
 	if err != nil {
-		return ChapaVerifyResponse{}, fmt.Errorf("error creating Chapa verify request: %w", err)
+		return PaymentVerification{}, fmt.Errorf("error creating Chapa verify request: %w", err)
 	}
 	req.Header.Set("Authorization", "Bearer "+s.secretKey)
 
 	resp, err := s.client.Do(req)
 	if err != nil {
-		return ChapaVerifyResponse{}, fmt.Errorf("error calling Chapa verify API: %w", err)
+		return PaymentVerification{}, fmt.Errorf("error calling Chapa verify API: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		bodyBytes, _ := io.ReadAll(resp.Body)
-		return ChapaVerifyResponse{}, fmt.Errorf("chapa verify API returned non-OK status: %d, response: %s", resp.StatusCode, string(bodyBytes))
+		return PaymentVerification{}, fmt.Errorf("chapa verify API returned non-OK status: %d, response: %s", resp.StatusCode, string(bodyBytes))
 	}
 
 	var chapaVerifyResp ChapaVerifyResponse
 	if err := json.NewDecoder(resp.Body).Decode(&chapaVerifyResp); err != nil {
-		return ChapaVerifyResponse{}, fmt.Errorf("failed to decode Chapa verify response: %w", err)
+		return PaymentVerification{}, fmt.Errorf("failed to decode Chapa verify response: %w", err)
+	}
+	log.Printf("Chapa verification response: %+v", chapaVerifyResp)
+
+	status := PaymentStatusPending
+	switch chapaVerifyResp.Data.Status {
+	case "success":
+		status = PaymentStatusSuccess
+	case "failed":
+		status = PaymentStatusFailed
+	}
+	return PaymentVerification{
+		TransactionID: chapaVerifyResp.Data.ID,
+		TxRef:         chapaVerifyResp.Data.TxRef,
+		Amount:        chapaVerifyResp.Data.Amount,
+		Currency:      chapaVerifyResp.Data.Currency,
+		Status:        status,
+	}, nil
+}
+
+// Refund calls the Chapa API to return all or part of a completed transaction.
+func (s *ChapaService) Refund(ctx context.Context, txRef string, reqData RefundRequest) (RefundResult, error) {
+	reqBytes, err := json.Marshal(ChapaRefundRequest{
+		Reason:    reqData.Reason,
+		Amount:    reqData.Amount.String(),
+		Reference: reqData.Reference,
+	})
+	if err != nil {
+		return RefundResult{}, fmt.Errorf("failed to marshal Chapa refund request: %w", err)
+	}
+
+	refundURL := fmt.Sprintf("%s/refund/%s", s.baseURL, txRef)
+	req, err := http.NewRequestWithContext(ctx, "POST", refundURL, bytes.NewBuffer(reqBytes))
+	if err != nil {
+		return RefundResult{}, fmt.Errorf("error creating Chapa refund request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+s.secretKey)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return RefundResult{}, fmt.Errorf("error calling Chapa refund API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Chapa answers a refund it will not make with a 4xx; a 5xx, a timeout
+	// or a garbled reply says nothing about whether the money moved.
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		if definitiveChapaRejection(resp.StatusCode) {
+			var rejection ChapaRefundResponse
+			if json.Unmarshal(bodyBytes, &rejection) != nil || rejection.Message == "" {
+				rejection.Message = string(bodyBytes)
+			}
+			return RefundResult{}, RefundRejectedError{Provider: s.Name(), Reason: rejection.Message}
+		}
+		return RefundResult{}, fmt.Errorf("chapa refund API returned non-OK status: %d, response: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	var chapaRefundResp ChapaRefundResponse
+	if err := json.NewDecoder(resp.Body).Decode(&chapaRefundResp); err != nil {
+		return RefundResult{}, fmt.Errorf("failed to decode Chapa refund response: %w", err)
+	}
+	if chapaRefundResp.Status != "success" {
+		return RefundResult{}, RefundRejectedError{Provider: s.Name(), Reason: chapaRefundResp.Message}
+	}
+	return RefundResult{ProviderReference: chapaRefundResp.Data.ChapaReference}, nil
+}
+
+// definitiveChapaRejection reports whether a refund answered with status
+// was turned down rather than not processed yet.
+func definitiveChapaRejection(status int) bool {
+	return status >= 400 && status < 500 && status != http.StatusRequestTimeout && status != http.StatusTooManyRequests
+}
+
+// ParseWebhook reads the transaction reference from a Chapa callback.
+// Chapa sends trx_ref on the query string for GET callbacks and tx_ref in
+// the JSON body for POST webhooks.
+func (s *ChapaService) ParseWebhook(r *http.Request) (WebhookEvent, error) {
+	if txRef := r.URL.Query().Get("trx_ref"); txRef != "" {
+		return WebhookEvent{TxRef: txRef}, nil
+	}
+	if r.Method == http.MethodPost && r.Body != nil {
+		var payload ChapaCallbackPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err == nil && payload.TxRef != "" {
+			return WebhookEvent{TxRef: payload.TxRef}, nil
+		}
 	}
-	return chapaVerifyResp, nil
+	return WebhookEvent{}, fmt.Errorf("missing tx_ref in Chapa callback")
 }
 
 // getFrontendRedirectURL builds the final URL for user redirection.
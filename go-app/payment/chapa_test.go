@@ -0,0 +1,60 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Only an answer that turns the refund down counts as a rejection; anything
+// else leaves the refund to be retried.
+func TestChapaRefundOutcome(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		ref      string
+		rejected bool
+		reason   string
+	}{
+		{name: "refunded", status: http.StatusOK, body: `{"status":"success","data":{"chapa_reference":"ch-1"}}`, ref: "ch-1"},
+		{name: "declined", status: http.StatusOK, body: `{"status":"failed","message":"Insufficient balance"}`, rejected: true, reason: "Insufficient balance"},
+		{name: "bad request", status: http.StatusBadRequest, body: `{"status":"failed","message":"Transaction already refunded"}`, rejected: true, reason: "Transaction already refunded"},
+		{name: "rate limited", status: http.StatusTooManyRequests, body: `{}`},
+		{name: "server error", status: http.StatusBadGateway, body: `upstream down`},
+		{name: "garbled", status: http.StatusOK, body: `<html>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/refund/c-1" {
+					http.NotFound(w, r)
+					return
+				}
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+			s := &ChapaService{baseURL: server.URL, client: server.Client()}
+
+			result, err := s.Refund(context.Background(), "c-1", RefundRequest{Amount: 1000, Currency: "ETB", Reference: "r-1"})
+			var rejected RefundRejectedError
+			switch {
+			case tt.ref != "":
+				if err != nil || result.ProviderReference != tt.ref {
+					t.Errorf("Refund = %+v, %v", result, err)
+				}
+			case tt.rejected:
+				if !errors.As(err, &rejected) || rejected.Reason != tt.reason {
+					t.Errorf("Refund error = %v, want a rejection for %q", err, tt.reason)
+				}
+			default:
+				if err == nil || errors.As(err, &rejected) {
+					t.Errorf("Refund error = %v, want an unknown outcome", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	google_uuid "github.com/google/uuid"
+)
+
+// checkoutError is a checkout failure with the HTTP status to report it with.
+type checkoutError struct {
+	status  int
+	message string
+}
+
+func (e checkoutError) Error() string {
+	return e.message
+}
+
+// checkoutRequest describes an order to create from already validated cart
+// lines, priced in currency at exchangeRate birr per unit.
+type checkoutRequest struct {
+	buyerID      string
+	lines        []cartLine
+	currency     string
+	exchangeRate ExchangeRate
+	returnURL    string
+	couponCode   string
+	checkoutType string
+}
+
+// startCheckout applies any coupon, records the pending order with its items
+// and starts the payment with the provider. It is shared by every action that
+// creates an order, so totals always come from the stored recipe prices. A
+// retry of a checkout that is still open returns the existing order instead.
+// The coupon is validated before that, so a code that is not valid (any
+// more) never matches an open order.
+func startCheckout(ctx context.Context, hasuraService *HasuraService, provider PaymentProvider, req checkoutRequest) (InitiatePaymentOutput, error) {
+	var backendCalculatedAmount Money
+	var orderItemsForInsertion []order_items_insert_input
+	var pricedLines []pricedLine
+	for _, line := range req.lines {
+		dbRecipe := line.recipe
+		backendCalculatedAmount += line.total()
+		pricedLines = append(pricedLines, pricedLine{
+			recipeID: dbRecipe.ID,
+			authorID: dbRecipe.UserID,
+			total:    line.total(),
+		})
+
+		orderItemsForInsertion = append(orderItemsForInsertion, order_items_insert_input{
+			ID:              uuid(google_uuid.New().String()),
+			RecipeID:        uuid(dbRecipe.ID),
+			Quantity:        line.quantity,
+			PriceAtPurchase: line.unitPrice,
+			RecipeName:      dbRecipe.Title,
+			RecipeImageURL:  featuredImageURL(dbRecipe),
+			CreatedAt:       DateTime(time.Now()),
+			UpdatedAt:       DateTime(time.Now()),
+		})
+	}
+
+	var couponID *uuid
+	var couponCode *string
+	var discountAmount Money
+	if code := normalizeCouponCode(req.couponCode); code != "" {
+		couponResp, err := hasuraService.QueryCoupon(ctx, code, req.buyerID)
+		if err != nil {
+			log.Printf("Failed to query coupon %s: %v", code, err)
+			return InitiatePaymentOutput{}, checkoutError{http.StatusInternalServerError, "Failed to validate coupon"}
+		}
+		if len(couponResp.Coupons) == 0 {
+			return InitiatePaymentOutput{}, checkoutError{http.StatusBadRequest, "Invalid coupon code"}
+		}
+		coupon := couponResp.Coupons[0]
+		if coupon.DiscountType == couponTypeFixed {
+			// Fixed discounts are set in ETB like recipe prices.
+			coupon.DiscountValue = req.exchangeRate.FromETB(coupon.DiscountValue)
+		}
+		discountAmount, err = applyCoupon(coupon, couponResp.CouponRedemptionsAggregate.Aggregate.Count, pricedLines, time.Now())
+		var couponErr couponError
+		if errors.As(err, &couponErr) {
+			return InitiatePaymentOutput{}, checkoutError{http.StatusBadRequest, couponErr.Error()}
+		}
+		if err != nil {
+			log.Printf("Failed to apply coupon %s: %v", code, err)
+			return InitiatePaymentOutput{}, checkoutError{http.StatusInternalServerError, "Failed to validate coupon"}
+		}
+		id := uuid(coupon.ID)
+		couponID = &id
+		couponCode = &coupon.Code
+		for i := range orderItemsForInsertion {
+			orderItemsForInsertion[i].DiscountAmount = pricedLines[i].discount
+		}
+		backendCalculatedAmount -= discountAmount
+		if backendCalculatedAmount <= 0 {
+			return InitiatePaymentOutput{}, checkoutError{http.StatusBadRequest, "This coupon cannot be used to make an order free"}
+		}
+	}
+
+	fingerprint := cartFingerprint(req, provider.Name())
+	if output, ok := reuseOpenOrder(ctx, hasuraService, req.buyerID, fingerprint); ok {
+		return output, nil
+	}
+
+	orderObject := orders_insert_input{
+		ID:                        uuid(google_uuid.New().String()),
+		UserID:                    uuid(req.buyerID),
+		TotalAmount:               backendCalculatedAmount,
+		Currency:                  req.currency,
+		ReturnURL:                 req.returnURL,
+		Status:                    "pending",
+		ChapaTxRef:                newOrderTxRef(),
+		PaymentProvider:           provider.Name(),
+		CouponID:                  couponID,
+		CouponCode:                couponCode,
+		DiscountAmount:            discountAmount,
+		PlatformCommissionPercent: platformCommissionPercent(),
+		ExchangeRate:              req.exchangeRate,
+		CartFingerprint:           fingerprint,
+		ExpiresAt:                 DateTime(time.Now().Add(orderExpiry())),
+		OrderType:                 orderTypePurchase,
+		CheckoutType:              &req.checkoutType,
+		CreatedAt:                 DateTime(time.Now()),
+		UpdatedAt:                 DateTime(time.Now()),
+		OrderItems:                &order_items_arr_rel_insert_input{Data: orderItemsForInsertion},
+	}
+	return placeOrder(ctx, hasuraService, provider, orderObject, "Food Recipes Order")
+}
+
+// newOrderTxRef generates the provider reference for an order.
+func newOrderTxRef() string {
+	return fmt.Sprintf("c-%s-%d", google_uuid.New().String()[:8], time.Now().Unix())
+}
+
+// placeOrder records a pending order and starts its payment with the
+// provider. The provider is only called once the order is safely stored.
+func placeOrder(ctx context.Context, hasuraService *HasuraService, provider PaymentProvider, order orders_insert_input, title string) (InitiatePaymentOutput, error) {
+	userResp, err := hasuraService.QueryUserDetails(ctx, string(order.UserID))
+	if err != nil || userResp.UsersByPk == nil {
+		return InitiatePaymentOutput{}, checkoutError{http.StatusNotFound, "Buyer details not found"}
+	}
+	user := userResp.UsersByPk
+
+	orderResp, err := hasuraService.InsertOrder(ctx, order)
+	if err != nil || orderResp.InsertOrdersOne == nil {
+		log.Printf("Failed to insert order for %s: %v", order.UserID, err)
+		return InitiatePaymentOutput{}, checkoutError{http.StatusInternalServerError, "Failed to record pending order"}
+	}
+	orderID := orderResp.InsertOrdersOne.OrderID
+	txRef := order.ChapaTxRef
+
+	paymentRequest := InitiatePaymentRequest{
+		Amount:      order.TotalAmount,
+		Currency:    order.Currency,
+		TxRef:       txRef,
+		Email:       user.Email,
+		FirstName:   user.FirstName,
+		LastName:    user.LastName,
+		PhoneNumber: user.PhoneNumber,
+		CallbackURL: callbackURLFor(provider.Name()),
+		ReturnURL:   getFrontendRedirectURL(order.ReturnURL, "pending", orderID, txRef, ""),
+		Title:       title,
+		Description: fmt.Sprintf("Order ID: %s", orderID),
+	}
+	log.Printf("%s request payload: %+v", provider.Name(), paymentRequest)
+
+	paymentResp, err := provider.InitiatePayment(ctx, paymentRequest)
+	if err != nil {
+		log.Printf("%s API error: %v", provider.Name(), err)
+		return InitiatePaymentOutput{}, checkoutError{http.StatusInternalServerError, "Payment service failed to initiate"}
+	}
+	log.Printf("✅ %s response: %+v", provider.Name(), paymentResp)
+	if _, err := hasuraService.UpdateOrder(ctx, orderID, orders_set_input{CheckoutURL: &paymentResp.CheckoutURL}); err != nil {
+		log.Printf("Failed to store checkout URL for order %s: %v", orderID, err)
+	}
+	return InitiatePaymentOutput{
+		CheckoutURL:    paymentResp.CheckoutURL,
+		Message:        "Payment initiated successfully",
+		OrderID:        orderID,
+		TxRef:          txRef,
+		Provider:       provider.Name(),
+		Amount:         order.TotalAmount,
+		DiscountAmount: order.DiscountAmount,
+	}, nil
+}
+
+// featuredImageURL picks the recipe's featured image, falling back to its first image if available.
+func featuredImageURL(recipe recipeDetails) *string {
+	for _, img := range recipe.Images {
+		if img.IsFeatured != nil && *img.IsFeatured {
+			return &img.ImageURL
+		}
+	}
+	if len(recipe.Images) > 0 {
+		return &recipe.Images[0].ImageURL
+	}
+	return nil
+}
+
+// respondWithCheckoutError reports a failed checkout with the status it carries.
+func respondWithCheckoutError(w http.ResponseWriter, err error) {
+	var cErr checkoutError
+	if errors.As(err, &cErr) {
+		respondWithError(w, cErr.status, cErr.message)
+		return
+	}
+	log.Printf("Checkout failed: %v", err)
+	respondWithError(w, http.StatusInternalServerError, "Failed to start checkout")
+}
@@ -0,0 +1,31 @@
+package payment
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// onOrderCompleted runs the bookkeeping that must happen exactly once, when
+// an order's payment has just been confirmed. Failures are logged rather than
+// surfaced: the buyer has already paid and the order stays completed. A
+// missing invoice is issued later by the issue_missing_invoices cron trigger,
+// and a missing ledger posting by post_missing_sales.
+func onOrderCompleted(ctx context.Context, hasuraService *HasuraService, order callbackOrder) {
+	postSale(ctx, hasuraService, order)
+	issueInvoice(ctx, hasuraService, order)
+	clearPurchasedCartItems(ctx, hasuraService, order)
+
+	if order.CouponID != nil {
+		redemption := coupon_redemptions_insert_input{
+			CouponID:       uuid(*order.CouponID),
+			OrderID:        uuid(order.OrderID),
+			UserID:         uuid(order.UserID),
+			DiscountAmount: order.DiscountAmount,
+			CreatedAt:      DateTime(time.Now()),
+		}
+		if _, err := hasuraService.InsertCouponRedemption(ctx, redemption); err != nil {
+			log.Printf("❌ Failed to record coupon redemption for order %s: %v", order.OrderID, err)
+		}
+	}
+}
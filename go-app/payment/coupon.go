@@ -0,0 +1,100 @@
+package payment
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	couponTypePercentage = "percentage"
+	couponTypeFixed      = "fixed"
+)
+
+// couponError is a coupon rejection that can be shown to the buyer as-is.
+type couponError struct {
+	message string
+}
+
+func (e couponError) Error() string {
+	return e.message
+}
+
+// pricedLine is an order line priced from the database, with the share of
+// the coupon discount it received.
+type pricedLine struct {
+	recipeID string
+	authorID string
+	total    Money
+	discount Money
+}
+
+// normalizeCouponCode matches how codes are stored in the coupons table.
+func normalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// applyCoupon checks that a coupon may be used by this buyer right now and
+// spreads its discount over the lines it is scoped to. It returns the total
+// discount; the per-line shares are written to lines.
+func applyCoupon(coupon couponRecord, userRedemptions int, lines []pricedLine, now time.Time) (Money, error) {
+	if !coupon.IsActive {
+		return 0, couponError{"This coupon is no longer active"}
+	}
+	if coupon.StartsAt != nil && now.Before(time.Time(*coupon.StartsAt)) {
+		return 0, couponError{"This coupon is not active yet"}
+	}
+	if coupon.ExpiresAt != nil && !now.Before(time.Time(*coupon.ExpiresAt)) {
+		return 0, couponError{"This coupon has expired"}
+	}
+	if coupon.MaxRedemptions != nil && coupon.RedemptionCount >= *coupon.MaxRedemptions {
+		return 0, couponError{"This coupon has reached its usage limit"}
+	}
+	if coupon.PerUserLimit != nil && userRedemptions >= *coupon.PerUserLimit {
+		return 0, couponError{"You have already used this coupon the maximum number of times"}
+	}
+
+	var eligible []int
+	var eligibleTotal Money
+	for i, line := range lines {
+		if coupon.RecipeID != nil && *coupon.RecipeID != line.recipeID {
+			continue
+		}
+		if coupon.AuthorID != nil && *coupon.AuthorID != line.authorID {
+			continue
+		}
+		eligible = append(eligible, i)
+		eligibleTotal += line.total
+	}
+	if len(eligible) == 0 || eligibleTotal <= 0 {
+		return 0, couponError{"This coupon does not apply to the recipes in your order"}
+	}
+
+	var discount Money
+	switch coupon.DiscountType {
+	case couponTypePercentage:
+		for _, i := range eligible {
+			share := lines[i].total.Percentage(coupon.DiscountValue)
+			lines[i].discount = share
+			discount += share
+		}
+	case couponTypeFixed:
+		discount = coupon.DiscountValue
+		if discount > eligibleTotal {
+			discount = eligibleTotal
+		}
+		// Split proportionally; the last eligible line takes the rounding remainder.
+		remaining := discount
+		for n, i := range eligible {
+			share := remaining
+			if n < len(eligible)-1 {
+				share = Money(int64(discount) * int64(lines[i].total) / int64(eligibleTotal))
+			}
+			lines[i].discount = share
+			remaining -= share
+		}
+	default:
+		return 0, fmt.Errorf("coupon %s has unknown discount type %q", coupon.Code, coupon.DiscountType)
+	}
+	return discount, nil
+}
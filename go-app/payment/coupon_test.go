@@ -0,0 +1,153 @@
+package payment
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestApplyCoupon(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	past, future := DateTime(now.Add(-time.Hour)), DateTime(now.Add(time.Hour))
+	limit := func(n int) *int { return &n }
+	id := func(s string) *string { return &s }
+	coupon := func(change func(*couponRecord)) couponRecord {
+		c := couponRecord{Code: "SAVE", DiscountType: couponTypePercentage, DiscountValue: 1000, IsActive: true}
+		if change != nil {
+			change(&c)
+		}
+		return c
+	}
+	lines := func() []pricedLine {
+		return []pricedLine{
+			{recipeID: "r1", authorID: "a1", total: 1000},
+			{recipeID: "r2", authorID: "a1", total: 2000},
+			{recipeID: "r3", authorID: "a2", total: 333},
+		}
+	}
+
+	tests := []struct {
+		name            string
+		coupon          couponRecord
+		userRedemptions int
+		wantDiscount    Money
+		wantShares      []Money
+		wantErr         string
+	}{
+		{
+			name:         "percentage of every line",
+			coupon:       coupon(nil),
+			wantDiscount: 333,
+			wantShares:   []Money{100, 200, 33},
+		},
+		{
+			name:         "percentage scoped to an author",
+			coupon:       coupon(func(c *couponRecord) { c.AuthorID = id("a1") }),
+			wantDiscount: 300,
+			wantShares:   []Money{100, 200, 0},
+		},
+		{
+			name:         "percentage scoped to a recipe",
+			coupon:       coupon(func(c *couponRecord) { c.RecipeID = id("r3"); c.DiscountValue = 5000 }),
+			wantDiscount: 167,
+			wantShares:   []Money{0, 0, 167},
+		},
+		{
+			name:         "fixed split by line total with the remainder on the last line",
+			coupon:       coupon(func(c *couponRecord) { c.DiscountType = couponTypeFixed; c.DiscountValue = 100 }),
+			wantDiscount: 100,
+			wantShares:   []Money{30, 60, 10},
+		},
+		{
+			name:         "fixed capped at the eligible total",
+			coupon:       coupon(func(c *couponRecord) { c.DiscountType = couponTypeFixed; c.DiscountValue = 5000; c.AuthorID = id("a1") }),
+			wantDiscount: 3000,
+			wantShares:   []Money{1000, 2000, 0},
+		},
+		{
+			name:    "inactive",
+			coupon:  coupon(func(c *couponRecord) { c.IsActive = false }),
+			wantErr: "This coupon is no longer active",
+		},
+		{
+			name:    "not started",
+			coupon:  coupon(func(c *couponRecord) { c.StartsAt = &future }),
+			wantErr: "This coupon is not active yet",
+		},
+		{
+			name:    "expired",
+			coupon:  coupon(func(c *couponRecord) { c.ExpiresAt = &past }),
+			wantErr: "This coupon has expired",
+		},
+		{
+			name:    "used up",
+			coupon:  coupon(func(c *couponRecord) { c.MaxRedemptions = limit(5); c.RedemptionCount = 5 }),
+			wantErr: "This coupon has reached its usage limit",
+		},
+		{
+			name:            "per user limit reached",
+			coupon:          coupon(func(c *couponRecord) { c.PerUserLimit = limit(1) }),
+			userRedemptions: 1,
+			wantErr:         "You have already used this coupon the maximum number of times",
+		},
+		{
+			name:    "no eligible lines",
+			coupon:  coupon(func(c *couponRecord) { c.RecipeID = id("other") }),
+			wantErr: "This coupon does not apply to the recipes in your order",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := lines()
+			discount, err := applyCoupon(tt.coupon, tt.userRedemptions, lines, now)
+			if tt.wantErr != "" {
+				var cErr couponError
+				if !errors.As(err, &cErr) || cErr.message != tt.wantErr {
+					t.Fatalf("applyCoupon error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if discount != tt.wantDiscount {
+				t.Errorf("discount = %s, want %s", discount, tt.wantDiscount)
+			}
+			var shares []Money
+			var sum Money
+			for _, line := range lines {
+				shares = append(shares, line.discount)
+				sum += line.discount
+			}
+			if !reflect.DeepEqual(shares, tt.wantShares) {
+				t.Errorf("shares = %v, want %v", shares, tt.wantShares)
+			}
+			if sum != discount {
+				t.Errorf("shares add up to %s, not the discount %s", sum, discount)
+			}
+		})
+	}
+}
+
+func TestApplyCouponUnknownType(t *testing.T) {
+	lines := []pricedLine{{recipeID: "r1", total: 1000}}
+	_, err := applyCoupon(couponRecord{DiscountType: "bogus", IsActive: true}, 0, lines, time.Now())
+	var cErr couponError
+	if err == nil || errors.As(err, &cErr) {
+		t.Errorf("applyCoupon error = %v, want an internal error", err)
+	}
+}
+
+func TestNormalizeCouponCode(t *testing.T) {
+	tests := map[string]string{
+		"save10":    "SAVE10",
+		"  Save10 ": "SAVE10",
+		"":          "",
+	}
+	for in, want := range tests {
+		if got := normalizeCouponCode(in); got != want {
+			t.Errorf("normalizeCouponCode(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
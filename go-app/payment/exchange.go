@@ -0,0 +1,296 @@
+package payment
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// baseCurrency is the currency recipe prices, cart prices and fixed coupon
+// discounts are set in.
+const baseCurrency = "ETB"
+
+// exchangeRateScale is the number of exchange rate units per birr; rates
+// are stored with six decimals.
+const exchangeRateScale = 1000000
+
+// ExchangeRate is how many birr one unit of a currency is worth, held
+// exactly in millionths to match the numeric(18,6) column.
+type ExchangeRate int64
+
+// ParseExchangeRate parses a positive decimal with up to six fraction digits.
+func ParseExchangeRate(s string) (ExchangeRate, error) {
+	rat, ok := new(big.Rat).SetString(strings.TrimSpace(s))
+	if !ok {
+		return 0, fmt.Errorf("invalid exchange rate: %q", s)
+	}
+	rat.Mul(rat, big.NewRat(exchangeRateScale, 1))
+	if !rat.IsInt() || !rat.Num().IsInt64() {
+		return 0, fmt.Errorf("invalid exchange rate %q: more than six decimal places", s)
+	}
+	if rat.Sign() <= 0 {
+		return 0, fmt.Errorf("invalid exchange rate %q: must be greater than zero", s)
+	}
+	return ExchangeRate(rat.Num().Int64()), nil
+}
+
+// String formats the rate without trailing zeros, e.g. "57.5".
+func (r ExchangeRate) String() string {
+	frac := strings.TrimRight(fmt.Sprintf("%06d", int64(r)%exchangeRateScale), "0")
+	if frac == "" {
+		return strconv.FormatInt(int64(r)/exchangeRateScale, 10)
+	}
+	return fmt.Sprintf("%d.%s", int64(r)/exchangeRateScale, frac)
+}
+
+// FromETB converts a birr amount into the rate's currency, rounding half up
+// to the nearest minor unit.
+func (r ExchangeRate) FromETB(amount Money) Money {
+	return Money((2*int64(amount)*exchangeRateScale + int64(r)) / (2 * int64(r)))
+}
+
+// ToETB converts an amount in the rate's currency into birr, rounding half
+// up to the nearest minor unit.
+func (r ExchangeRate) ToETB(amount Money) Money {
+	return Money((2*int64(amount)*int64(r) + exchangeRateScale) / (2 * exchangeRateScale))
+}
+
+// GetGraphQLType lets ExchangeRate be passed directly as a numeric query variable.
+func (r ExchangeRate) GetGraphQLType() string {
+	return "numeric"
+}
+
+func (r ExchangeRate) MarshalJSON() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// UnmarshalJSON accepts a JSON number or a quoted decimal string.
+func (r *ExchangeRate) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	parsed, err := ParseExchangeRate(string(bytes.Trim(data, `"`)))
+	if err != nil {
+		return err
+	}
+	*r = parsed
+	return nil
+}
+
+var currencyCodePattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// normalizeCurrency upper-cases a currency code, defaulting to ETB.
+func normalizeCurrency(currency string) string {
+	currency = strings.ToUpper(strings.TrimSpace(currency))
+	if currency == "" {
+		return baseCurrency
+	}
+	return currency
+}
+
+// priceCartLines prices each line in the requested currency: the recipe's
+// own price in that currency if its author set one, otherwise price_etb
+// converted at the stored rate. Only currencies the provider accepts and
+// that have an exchange rate can be used. It returns the normalized
+// currency and the rate used.
+func priceCartLines(ctx context.Context, hasuraService *HasuraService, provider PaymentProvider, lines []cartLine, currency string) (string, ExchangeRate, error) {
+	currency = normalizeCurrency(currency)
+	if !currencyCodePattern.MatchString(currency) {
+		return "", 0, checkoutError{http.StatusBadRequest, fmt.Sprintf("Unsupported currency: %s", currency)}
+	}
+	if !provider.SupportsCurrency(currency) {
+		return "", 0, checkoutError{http.StatusBadRequest, fmt.Sprintf("%s does not accept payments in %s", provider.Name(), currency)}
+	}
+	resp, err := hasuraService.QueryExchangeRate(ctx, currency)
+	if err != nil {
+		log.Printf("Failed to query exchange rate for %s: %v", currency, err)
+		return "", 0, checkoutError{http.StatusInternalServerError, "Failed to retrieve exchange rate"}
+	}
+	if resp.ExchangeRatesByPk == nil {
+		return "", 0, checkoutError{http.StatusBadRequest, fmt.Sprintf("Unsupported currency: %s", currency)}
+	}
+	rate := resp.ExchangeRatesByPk.EtbPerUnit
+
+	for i := range lines {
+		lines[i].unitPrice = rate.FromETB(lines[i].recipe.PriceETB)
+		for _, price := range lines[i].recipe.Prices {
+			if price.Currency == currency {
+				lines[i].unitPrice = price.Price
+			}
+		}
+		if lines[i].unitPrice <= 0 {
+			return "", 0, checkoutError{http.StatusBadRequest, fmt.Sprintf("%s cannot be bought in %s", lines[i].recipe.Title, currency)}
+		}
+	}
+	return currency, rate, nil
+}
+
+// exchangeRateInput validates one rate an admin wants to store.
+func exchangeRateInput(currency string, rate ExchangeRate, source string, now time.Time) (exchange_rates_insert_input, error) {
+	currency = strings.ToUpper(strings.TrimSpace(currency))
+	if !currencyCodePattern.MatchString(currency) {
+		return exchange_rates_insert_input{}, fmt.Errorf("%q is not a three-letter currency code", currency)
+	}
+	if rate <= 0 {
+		return exchange_rates_insert_input{}, fmt.Errorf("the rate for %s must be greater than zero", currency)
+	}
+	if currency == baseCurrency && rate != exchangeRateScale {
+		return exchange_rates_insert_input{}, fmt.Errorf("the rate for %s is always 1", baseCurrency)
+	}
+	return exchange_rates_insert_input{
+		Currency:   currency,
+		EtbPerUnit: rate,
+		Source:     source,
+		UpdatedAt:  DateTime(now),
+	}, nil
+}
+
+// saveExchangeRates upserts rates and reports them back to the admin.
+func saveExchangeRates(ctx context.Context, hasuraService *HasuraService, rates []exchange_rates_insert_input) ([]ExchangeRateOutput, error) {
+	resp, err := hasuraService.UpsertExchangeRates(ctx, rates)
+	if err != nil || resp.InsertExchangeRates == nil {
+		return nil, fmt.Errorf("failed to save exchange rates: %v", err)
+	}
+	output := make([]ExchangeRateOutput, 0, len(resp.InsertExchangeRates.Returning))
+	for _, rate := range resp.InsertExchangeRates.Returning {
+		output = append(output, ExchangeRateOutput{
+			Currency:   rate.Currency,
+			EtbPerUnit: rate.EtbPerUnit,
+			Source:     rate.Source,
+			UpdatedAt:  rate.UpdatedAt,
+		})
+	}
+	return output, nil
+}
+
+// HandleSetExchangeRate handles the admin-only Hasura Action that sets the
+// rate for one currency, adding it to the supported set if it is new.
+func HandleSetExchangeRate(w http.ResponseWriter, r *http.Request, hasuraService *HasuraService) {
+	var payload SetExchangeRateActionPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Failed to parse Hasura Action payload")
+		return
+	}
+	defer r.Body.Close()
+
+	if !isAdminSession(r, payload.SessionVariables) {
+		respondWithError(w, http.StatusForbidden, "Only admins can set exchange rates")
+		return
+	}
+	input := payload.Input.Input
+	rate, err := exchangeRateInput(input.Currency, input.EtbPerUnit, "manual", time.Now())
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	saved, err := saveExchangeRates(ctx, hasuraService, []exchange_rates_insert_input{rate})
+	if err != nil || len(saved) == 0 {
+		log.Printf("Failed to set exchange rate for %s: %v", rate.Currency, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to save exchange rate")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, saved[0])
+}
+
+// parseExchangeRatesCSV reads "currency,etb_per_unit" rows. A header row is
+// skipped, blank lines are ignored and every bad row is reported with its
+// line number.
+func parseExchangeRatesCSV(data string, now time.Time) ([]exchange_rates_insert_input, []string) {
+	reader := csv.NewReader(strings.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	var rates []exchange_rates_insert_input
+	var errs []string
+	seen := make(map[string]int)
+	for first := true; ; first = false {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			errs = append(errs, err.Error())
+			break
+		}
+		line, _ := reader.FieldPos(0)
+		if len(record) != 2 {
+			errs = append(errs, fmt.Sprintf("line %d: expected currency,etb_per_unit", line))
+			continue
+		}
+		if first && strings.EqualFold(strings.TrimSpace(record[0]), "currency") {
+			continue
+		}
+		value, err := ParseExchangeRate(record[1])
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("line %d: %v", line, err))
+			continue
+		}
+		rate, err := exchangeRateInput(record[0], value, "csv", now)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("line %d: %v", line, err))
+			continue
+		}
+		if earlier, ok := seen[rate.Currency]; ok {
+			errs = append(errs, fmt.Sprintf("line %d: %s is already listed on line %d", line, rate.Currency, earlier))
+			continue
+		}
+		seen[rate.Currency] = line
+		rates = append(rates, rate)
+	}
+	return rates, errs
+}
+
+// HandleImportExchangeRates handles the admin-only Hasura Action that loads
+// rates from the contents of a CSV file. Nothing is saved unless every row
+// is valid.
+func HandleImportExchangeRates(w http.ResponseWriter, r *http.Request, hasuraService *HasuraService) {
+	var payload ImportExchangeRatesActionPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Failed to parse Hasura Action payload")
+		return
+	}
+	defer r.Body.Close()
+
+	if !isAdminSession(r, payload.SessionVariables) {
+		respondWithError(w, http.StatusForbidden, "Only admins can import exchange rates")
+		return
+	}
+	rates, errs := parseExchangeRatesCSV(payload.Input.Input.CSV, time.Now())
+	if len(errs) > 0 {
+		respondWithErrorExtensions(w, http.StatusBadRequest, "The exchange rate file has errors", map[string]interface{}{
+			"code":   "invalid_csv",
+			"errors": errs,
+		})
+		return
+	}
+	if len(rates) == 0 {
+		respondWithError(w, http.StatusBadRequest, "The exchange rate file has no rates")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	saved, err := saveExchangeRates(ctx, hasuraService, rates)
+	if err != nil {
+		log.Printf("Failed to import exchange rates: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to save exchange rates")
+		return
+	}
+	log.Printf("✅ Imported %d exchange rates", len(saved))
+	respondWithJSON(w, http.StatusOK, ImportExchangeRatesOutput{Imported: len(saved), Rates: saved})
+}
@@ -0,0 +1,168 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseExchangeRatesCSV(t *testing.T) {
+	now := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		csv      string
+		wantKeys map[string]ExchangeRate
+		wantErrs []string
+	}{
+		{
+			name:     "header and rows",
+			csv:      "currency,etb_per_unit\nUSD,57.5\neur, 62.25\n",
+			wantKeys: map[string]ExchangeRate{"USD": 57500000, "EUR": 62250000},
+		},
+		{
+			name:     "no header, blank lines and the base currency",
+			csv:      "\nUSD,57.123456\n\nETB,1\n",
+			wantKeys: map[string]ExchangeRate{"USD": 57123456, "ETB": 1000000},
+		},
+		{
+			name: "every bad row is reported",
+			csv:  "USD\nEUR,abc\nGBP,0\nUSDX,1\nETB,2\nUSD,58\nUSD,59\nJPY,0.0000001\n",
+			wantErrs: []string{
+				"line 1: expected currency,etb_per_unit",
+				`line 2: invalid exchange rate: "abc"`,
+				`line 3: invalid exchange rate "0": must be greater than zero`,
+				`line 4: "USDX" is not a three-letter currency code`,
+				"line 5: the rate for ETB is always 1",
+				"line 7: USD is already listed on line 6",
+				`line 8: invalid exchange rate "0.0000001": more than six decimal places`,
+			},
+			wantKeys: map[string]ExchangeRate{"USD": 58000000},
+		},
+		{
+			name:     "header only counts on the first line",
+			csv:      "USD,57\ncurrency,etb_per_unit\n",
+			wantErrs: []string{`line 2: invalid exchange rate: "etb_per_unit"`},
+			wantKeys: map[string]ExchangeRate{"USD": 57000000},
+		},
+		{
+			name:     "empty",
+			csv:      "",
+			wantKeys: map[string]ExchangeRate{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rates, errs := parseExchangeRatesCSV(tt.csv, now)
+			if !reflect.DeepEqual(errs, tt.wantErrs) {
+				t.Errorf("errors = %q, want %q", errs, tt.wantErrs)
+			}
+			got := map[string]ExchangeRate{}
+			for _, rate := range rates {
+				got[rate.Currency] = rate.EtbPerUnit
+				if rate.Source != "csv" || time.Time(rate.UpdatedAt) != now {
+					t.Errorf("%s has source %q, updated %v", rate.Currency, rate.Source, rate.UpdatedAt)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.wantKeys) {
+				t.Errorf("rates = %v, want %v", got, tt.wantKeys)
+			}
+		})
+	}
+}
+
+func TestParseExchangeRatesCSVMalformed(t *testing.T) {
+	rates, errs := parseExchangeRatesCSV("USD,57\nEUR,\"60\n", time.Now())
+	if len(errs) != 1 {
+		t.Errorf("errors = %q, want one CSV error", errs)
+	}
+	if len(rates) != 1 {
+		t.Errorf("rates = %v, want the row before the error", rates)
+	}
+}
+
+func TestExchangeRateConversion(t *testing.T) {
+	tests := []struct {
+		rate    string
+		etb     Money
+		foreign Money
+	}{
+		{rate: "57.5", etb: 57500, foreign: 1000},
+		{rate: "57.5", etb: 100, foreign: 2},
+		{rate: "1", etb: 1234, foreign: 1234},
+		{rate: "0.5", etb: 1001, foreign: 2002},
+		{rate: "3", etb: 5, foreign: 2},
+	}
+	for _, tt := range tests {
+		rate, err := ParseExchangeRate(tt.rate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := rate.FromETB(tt.etb); got != tt.foreign {
+			t.Errorf("%s FromETB(%s) = %s, want %s", tt.rate, tt.etb, got, tt.foreign)
+		}
+		if got := rate.String(); got != tt.rate {
+			t.Errorf("String() = %q, want %q", got, tt.rate)
+		}
+	}
+}
+
+func TestPriceCartLines(t *testing.T) {
+	hasura, hasuraService := newFakeHasura(t)
+	hasura.on("exchange_rates_by_pk", `{"currency":"USD","etb_per_unit":"57.5"}`)
+	converted := recipeDetails{ID: "r1", Title: "Doro wat", PriceETB: 57500}
+	ownPrice := recipeDetails{ID: "r2", Title: "Kitfo", PriceETB: 57500}
+	ownPrice.Prices = append(ownPrice.Prices, struct {
+		Currency string `json:"currency" graphql:"currency"`
+		Price    Money  `json:"price" graphql:"price"`
+	}{Currency: "USD", Price: 899})
+	lines := []cartLine{{recipe: converted, quantity: 1}, {recipe: ownPrice, quantity: 2}}
+	chapa := &ChapaService{}
+
+	currency, rate, err := priceCartLines(context.Background(), hasuraService, chapa, lines, " usd ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if currency != "USD" || rate.String() != "57.5" {
+		t.Errorf("priced in %s at %s", currency, rate)
+	}
+	if lines[0].unitPrice != 1000 || lines[1].unitPrice != 899 || lines[1].total() != 1798 {
+		t.Errorf("unit prices %s and %s, want 10.00 converted and the author's 8.99", lines[0].unitPrice, lines[1].unitPrice)
+	}
+
+	hasura.on("exchange_rates_by_pk", `null`)
+	var cErr checkoutError
+	if _, _, err := priceCartLines(context.Background(), hasuraService, NewFakeProvider(), lines, "EUR"); !errors.As(err, &cErr) || cErr.status != http.StatusBadRequest {
+		t.Errorf("currency without a rate: %v", err)
+	}
+	if _, _, err := priceCartLines(context.Background(), hasuraService, chapa, lines, "US$"); err == nil {
+		t.Error("malformed currency code accepted")
+	}
+	if _, _, err := priceCartLines(context.Background(), hasuraService, &TelebirrService{}, lines, "USD"); !errors.As(err, &cErr) || cErr.status != http.StatusBadRequest {
+		t.Errorf("currency the provider does not accept: %v", err)
+	}
+	if len(hasura.calls("exchange_rates_by_pk")) != 2 {
+		t.Error("malformed currency code was looked up")
+	}
+}
+
+func TestProviderCurrencies(t *testing.T) {
+	providers := []PaymentProvider{&ChapaService{}, &TelebirrService{}, NewFakeProvider()}
+	tests := []struct {
+		currency string
+		want     []bool
+	}{
+		{currency: "ETB", want: []bool{true, true, true}},
+		{currency: "USD", want: []bool{true, false, true}},
+		{currency: "EUR", want: []bool{false, false, true}},
+	}
+	for _, tt := range tests {
+		for i, provider := range providers {
+			if got := provider.SupportsCurrency(tt.currency); got != tt.want[i] {
+				t.Errorf("%s SupportsCurrency(%s) = %v, want %v", provider.Name(), tt.currency, got, tt.want[i])
+			}
+		}
+	}
+}
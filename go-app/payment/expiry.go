@@ -0,0 +1,199 @@
+package payment
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultOrderExpiryMinutes = 60
+
+// maxOrderExpiry caps how many orders one cleanup run expires; the rest are
+// left for the next run.
+const maxOrderExpiry = 500
+
+// How a purchase was checked out, recorded on the order.
+const (
+	checkoutTypeDirect = "direct"
+	checkoutTypeCart   = "cart"
+)
+
+// orderExpiry reads ORDER_EXPIRY_MINUTES, how long a pending order's checkout
+// stays open before the cleanup job expires it.
+func orderExpiry() time.Duration {
+	minutes := defaultOrderExpiryMinutes
+	if raw := os.Getenv("ORDER_EXPIRY_MINUTES"); raw != "" {
+		if parsed, err := strconv.Atoi(raw); err == nil && parsed > 0 {
+			minutes = parsed
+		} else {
+			log.Printf("Invalid ORDER_EXPIRY_MINUTES %q, using %d", raw, defaultOrderExpiryMinutes)
+		}
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// cartFingerprint identifies a checkout by everything that shapes its order:
+// the recipes, quantities and prices in the order currency, the coupon,
+// provider, currency and return URL. A retry with the same fingerprint can reuse the open order;
+// any change, including a price change, starts a new one.
+func cartFingerprint(req checkoutRequest, provider string) string {
+	parts := make([]string, 0, len(req.lines))
+	for _, line := range req.lines {
+		parts = append(parts, fmt.Sprintf("%s:%d:%s", line.recipe.ID, line.quantity, line.unitPrice))
+	}
+	sort.Strings(parts)
+	parts = append(parts, normalizeCouponCode(req.couponCode), provider, req.currency, req.returnURL)
+	sum := sha256.Sum256([]byte(strings.Join(parts, "|")))
+	return hex.EncodeToString(sum[:])
+}
+
+// reuseOpenOrder returns the checkout of an open pending order for the same
+// cart, so retrying a checkout does not leave another dead order behind. The
+// order must stay open for a little while longer to be worth handing out.
+func reuseOpenOrder(ctx context.Context, hasuraService *HasuraService, buyerID string, fingerprint string) (InitiatePaymentOutput, bool) {
+	resp, err := hasuraService.QueryOpenOrder(ctx, buyerID, fingerprint, time.Now().Add(time.Minute))
+	if err != nil {
+		log.Printf("Failed to look up open orders for %s: %v", buyerID, err)
+		return InitiatePaymentOutput{}, false
+	}
+	if len(resp.Orders) == 0 {
+		return InitiatePaymentOutput{}, false
+	}
+	order := resp.Orders[0]
+	log.Printf("♻️ Reusing pending order %s for %s", order.ID, buyerID)
+	return InitiatePaymentOutput{
+		CheckoutURL:    *order.CheckoutURL,
+		Message:        "Payment already initiated for this cart",
+		OrderID:        order.ID,
+		TxRef:          order.ChapaTxRef,
+		Provider:       order.PaymentProvider,
+		Amount:         order.TotalAmount,
+		DiscountAmount: order.DiscountAmount,
+	}, true
+}
+
+// resumeCheckoutURL is where a reminder email sends the buyer to finish
+// their purchase: CHECKOUT_RESUME_URL (e.g. the frontend cart page) if set,
+// otherwise the order's return URL, which learns the order expired.
+func resumeCheckoutURL(order expiredOrder) string {
+	base := os.Getenv("CHECKOUT_RESUME_URL")
+	if base == "" {
+		return getFrontendRedirectURL(order.ReturnURL, "expired", order.ID, order.ChapaTxRef, "")
+	}
+	u, err := url.Parse(base)
+	if err != nil {
+		log.Printf("Invalid CHECKOUT_RESUME_URL %q: %v", base, err)
+		return getFrontendRedirectURL(order.ReturnURL, "expired", order.ID, order.ChapaTxRef, "")
+	}
+	q := u.Query()
+	q.Set("order_id", order.ID)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// sendCheckoutReminder emails the buyer of an expired order a link to
+// resume checkout. Orders checked out from the cart point back to it, since
+// the recipes stay there until they are bought.
+func sendCheckoutReminder(order expiredOrder) error {
+	name := order.User.FirstName
+	if name == "" {
+		name = "there"
+	}
+	next := "You can start a new checkout for the same recipes whenever you are ready:"
+	if order.CheckoutType != nil && *order.CheckoutType == checkoutTypeCart {
+		next = "The recipes are still in your cart, so you can pick up where you left off:"
+	}
+	body := fmt.Sprintf("Hi %s,\n\nYour order of %s %s was not paid and has expired. %s\n\n%s\n\nFood Recipes\n",
+		name, order.TotalAmount, order.Currency, next, resumeCheckoutURL(order))
+	return sendMail(order.User.Email, "Your recipes are still waiting", body)
+}
+
+// remindersEnabled reads ABANDONED_CHECKOUT_REMINDERS; reminders also need
+// outgoing email to be configured.
+func remindersEnabled() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv("ABANDONED_CHECKOUT_REMINDERS"))
+	return enabled && mailConfigured()
+}
+
+// validCronRequest checks the shared secret Hasura sends with scheduled
+// triggers in the X-Cron-Secret header.
+func validCronRequest(r *http.Request) bool {
+	secret := os.Getenv("CRON_SECRET")
+	if secret == "" {
+		log.Println("❌ CRON_SECRET is not configured; rejecting scheduled trigger")
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Cron-Secret")), []byte(secret)) == 1
+}
+
+// HandleExpireOrders handles the Hasura cron trigger that expires abandoned
+// checkouts. An expired order can still complete if its payment arrives
+// late, since the callback only refuses orders that are already paid.
+func HandleExpireOrders(w http.ResponseWriter, r *http.Request, hasuraService *HasuraService) {
+	if !validCronRequest(r) {
+		respondWithError(w, http.StatusUnauthorized, "Invalid cron secret")
+		return
+	}
+	defer r.Body.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	now := time.Now()
+	resp, err := hasuraService.ExpirePendingOrders(ctx, now, now.Add(-orderExpiry()), maxOrderExpiry)
+	if err != nil || resp.UpdateOrders == nil {
+		log.Printf("Failed to expire pending orders: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to expire pending orders")
+		return
+	}
+
+	output := ExpireOrdersOutput{Expired: resp.UpdateOrders.AffectedRows}
+	if remindersEnabled() {
+		// Only orders that had a checkout window are reminded, and each
+		// buyer about their latest one
+		latest := make(map[string]expiredOrder)
+		for _, order := range resp.UpdateOrders.Returning {
+			if order.User.Email == "" || order.OrderType == orderTypeTip || order.ExpiresAt == nil {
+				continue
+			}
+			if seen, ok := latest[order.UserID]; !ok || time.Time(order.CreatedAt).After(time.Time(seen.CreatedAt)) {
+				latest[order.UserID] = order
+			}
+		}
+		var due []string
+		for _, order := range latest {
+			due = append(due, order.ID)
+		}
+		sort.Strings(due)
+		// Claimed before sending, so a failed send is not retried rather
+		// than risking a second email
+		var claimed []expiredOrder
+		if len(due) > 0 {
+			claimed, err = hasuraService.ClaimCheckoutReminders(ctx, due, now)
+			if err != nil {
+				log.Printf("Failed to claim checkout reminders: %v", err)
+			}
+		}
+		for _, order := range claimed {
+			if err := sendCheckoutReminder(order); err != nil {
+				log.Printf("Failed to send checkout reminder for order %s: %v", order.ID, err)
+				continue
+			}
+			output.RemindersSent++
+		}
+	}
+	if output.Expired == maxOrderExpiry {
+		log.Printf("⏰ Expired a full batch of %d orders; the rest are left for the next run", maxOrderExpiry)
+	}
+	log.Printf("⏰ Expired %d pending orders, sent %d reminders", output.Expired, output.RemindersSent)
+	respondWithJSON(w, http.StatusOK, output)
+}
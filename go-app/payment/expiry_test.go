@@ -0,0 +1,283 @@
+package payment
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCartFingerprint(t *testing.T) {
+	line := func(id string, quantity int, price Money) cartLine {
+		return cartLine{recipe: recipeDetails{ID: id, PriceETB: price}, quantity: quantity, unitPrice: price}
+	}
+	base := checkoutRequest{
+		lines:      []cartLine{line("r1", 1, 1000), line("r2", 2, 500)},
+		currency:   "ETB",
+		returnURL:  "https://shop.example.com",
+		couponCode: "save10",
+	}
+	fingerprint := cartFingerprint(base, "chapa")
+
+	same := base
+	same.lines = []cartLine{line("r2", 2, 500), line("r1", 1, 1000)}
+	same.couponCode = " SAVE10 "
+	if cartFingerprint(same, "chapa") != fingerprint {
+		t.Error("line order or coupon spelling changed the fingerprint")
+	}
+
+	changes := map[string]func(*checkoutRequest){
+		"price":      func(r *checkoutRequest) { r.lines = []cartLine{line("r1", 1, 1100), line("r2", 2, 500)} },
+		"quantity":   func(r *checkoutRequest) { r.lines = []cartLine{line("r1", 2, 1000), line("r2", 2, 500)} },
+		"coupon":     func(r *checkoutRequest) { r.couponCode = "" },
+		"return URL": func(r *checkoutRequest) { r.returnURL = "https://shop.example.com/other" },
+	}
+	for name, change := range changes {
+		changed := base
+		change(&changed)
+		if cartFingerprint(changed, "chapa") == fingerprint {
+			t.Errorf("changing the %s kept the fingerprint", name)
+		}
+	}
+	if cartFingerprint(base, "telebirr") == fingerprint {
+		t.Error("changing the provider kept the fingerprint")
+	}
+}
+
+func TestReuseOpenOrder(t *testing.T) {
+	hasura, hasuraService := newFakeHasura(t)
+	hasura.on("orders", `[{"id":"o1","chapa_tx_ref":"c-1","checkout_url":"https://pay.example.com/c-1","payment_provider":"chapa","total_amount":100}]`)
+
+	before := time.Now()
+	output, ok := reuseOpenOrder(context.Background(), hasuraService, "u1", "fp")
+	if !ok || output.OrderID != "o1" || output.CheckoutURL != "https://pay.example.com/c-1" || output.Amount != 10000 {
+		t.Fatalf("reuseOpenOrder = %+v, %v", output, ok)
+	}
+
+	// Only a pending order of the same buyer and cart that already has a
+	// checkout page and stays open for at least another minute qualifies.
+	var where struct {
+		UserID          map[string]string `json:"user_id"`
+		CartFingerprint map[string]string `json:"cart_fingerprint"`
+		Status          map[string]string `json:"status"`
+		ExpiresAt       map[string]string `json:"expires_at"`
+		CheckoutURL     map[string]bool   `json:"checkout_url"`
+	}
+	hasura.calls("orders")[0].variable(t, "where", &where)
+	if where.UserID["_eq"] != "u1" || where.CartFingerprint["_eq"] != "fp" || where.Status["_eq"] != "pending" || where.CheckoutURL["_is_null"] {
+		t.Errorf("open orders queried with %+v", where)
+	}
+	openUntil, err := time.Parse(time.RFC3339Nano, where.ExpiresAt["_gt"])
+	if err != nil || openUntil.Before(before.Add(time.Minute)) {
+		t.Errorf("orders must stay open until %s, want at least a minute from now", where.ExpiresAt["_gt"])
+	}
+
+	hasura.on("orders", `[]`)
+	if _, ok := reuseOpenOrder(context.Background(), hasuraService, "u1", "fp"); ok {
+		t.Error("reused an order when none is open")
+	}
+}
+
+func TestExpireOrdersCutoff(t *testing.T) {
+	hasura, hasuraService := newFakeHasura(t)
+	hasura.on("orders", `[{"id":"o1"},{"id":"o2"}]`)
+	hasura.on("update_orders", `{"affected_rows":2,"returning":[{"id":"o1"},{"id":"o2"}]}`)
+	t.Setenv("CRON_SECRET", "s3cret")
+	t.Setenv("ORDER_EXPIRY_MINUTES", "30")
+	t.Setenv("ABANDONED_CHECKOUT_REMINDERS", "false")
+
+	expire := func(secret string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/cron/expireOrders", bytes.NewBufferString(`{}`))
+		req.Header.Set("X-Cron-Secret", secret)
+		rec := httptest.NewRecorder()
+		HandleExpireOrders(rec, req, hasuraService)
+		return rec
+	}
+	if rec := expire("wrong"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong cron secret = %d, want 401", rec.Code)
+	}
+
+	before := time.Now()
+	if rec := expire("s3cret"); rec.Code != http.StatusOK || rec.Body.String() != `{"expired":2,"remindersSent":0}` {
+		t.Fatalf("expire = %d %s", rec.Code, rec.Body)
+	}
+	var where struct {
+		Status map[string]string `json:"status"`
+		Or     []struct {
+			ExpiresAt map[string]interface{} `json:"expires_at"`
+			CreatedAt map[string]string      `json:"created_at"`
+		} `json:"_or"`
+	}
+	var limit int
+	hasura.calls("orders")[0].variable(t, "where", &where)
+	hasura.calls("orders")[0].variable(t, "limit", &limit)
+	if where.Status["_eq"] != "pending" || len(where.Or) != 2 || limit != maxOrderExpiry {
+		t.Fatalf("expired up to %d orders matching %+v", limit, where)
+	}
+	var expired struct {
+		ID     map[string][]string `json:"id"`
+		Status map[string]string   `json:"status"`
+	}
+	hasura.calls("update_orders")[0].variable(t, "where", &expired)
+	if strings.Join(expired.ID["_in"], ",") != "o1,o2" || expired.Status["_eq"] != "pending" {
+		t.Errorf("expired orders matching %+v, want the batch found if still pending", expired)
+	}
+	expiresBefore, _ := time.Parse(time.RFC3339Nano, where.Or[0].ExpiresAt["_lt"].(string))
+	createdBefore, _ := time.Parse(time.RFC3339Nano, where.Or[1].CreatedAt["_lt"])
+	if expiresBefore.Before(before) {
+		t.Errorf("orders expiring before %s are expired, want now", expiresBefore)
+	}
+	if age := expiresBefore.Sub(createdBefore); age != 30*time.Minute {
+		t.Errorf("orders without an expiry are expired after %s, want ORDER_EXPIRY_MINUTES", age)
+	}
+
+	hasura.on("orders", `[]`)
+	if rec := expire("s3cret"); rec.Code != http.StatusOK || rec.Body.String() != `{"expired":0,"remindersSent":0}` {
+		t.Fatalf("expire with nothing due = %d %s", rec.Code, rec.Body)
+	}
+	if n := len(hasura.calls("update_orders")); n != 1 {
+		t.Errorf("update_orders called %d times, want no update when nothing is due", n)
+	}
+}
+
+// fakeSMTP accepts mail on a local port and keeps each message it receives.
+type fakeSMTP struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+// useFakeSMTP points SMTP_HOST and SMTP_PORT at a new fakeSMTP for the test.
+func useFakeSMTP(t *testing.T) *fakeSMTP {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	t.Setenv("SMTP_HOST", host)
+	t.Setenv("SMTP_PORT", port)
+	t.Setenv("SMTP_USERNAME", "")
+
+	s := &fakeSMTP{}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSMTP) serve(conn net.Conn) {
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+	text.PrintfLine("220 fake ESMTP")
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		switch verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0]); verb {
+		case "DATA":
+			text.PrintfLine("354 go ahead")
+			message, err := text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, string(message))
+			s.mu.Unlock()
+			text.PrintfLine("250 queued")
+		case "QUIT":
+			text.PrintfLine("221 bye")
+			return
+		default:
+			text.PrintfLine("250 ok")
+		}
+	}
+}
+
+func (s *fakeSMTP) sent() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.messages...)
+}
+
+// Each buyer is reminded once per run, about their latest order, and
+// orders expired without a checkout window are not reminded at all.
+func TestExpireOrdersRemindsOnce(t *testing.T) {
+	hasura, hasuraService := newFakeHasura(t)
+	mail := useFakeSMTP(t)
+	t.Setenv("CRON_SECRET", "s3cret")
+	t.Setenv("ABANDONED_CHECKOUT_REMINDERS", "true")
+
+	abebe := `"user_id":"u1","user":{"email":"abebe@example.com","first_name":"Abebe"}`
+	sara := `"user_id":"u2","user":{"email":"sara@example.com","first_name":"Sara"}`
+	expired := map[string]string{
+		"o1": `{"id":"o1","order_type":"purchase","checkout_type":"cart","currency":"ETB","total_amount":150,"expires_at":"2026-10-19T09:00:00Z","created_at":"2026-10-19T08:00:00Z",` + abebe + `}`,
+		"o2": `{"id":"o2","order_type":"purchase","checkout_type":"direct","currency":"ETB","total_amount":75,"expires_at":"2026-10-19T08:00:00Z","created_at":"2026-10-19T07:00:00Z",` + abebe + `}`,
+		"o3": `{"id":"o3","order_type":"tip","expires_at":"2026-10-19T09:00:00Z","created_at":"2026-10-19T08:00:00Z",` + sara + `}`,
+		"o4": `{"id":"o4","order_type":"purchase","created_at":"2025-01-01T00:00:00Z",` + sara + `}`,
+		"o5": `{"id":"o5","order_type":"purchase","checkout_type":"direct","currency":"ETB","total_amount":40,"expires_at":"2026-10-19T09:00:00Z","created_at":"2026-10-19T08:00:00Z","user_id":"u3","user":{"email":"","first_name":"Kebede"}}`,
+		"o6": `{"id":"o6","order_type":"purchase","checkout_type":"direct","currency":"ETB","total_amount":20,"expires_at":"2026-10-19T09:00:00Z","created_at":"2026-10-19T08:00:00Z",` + sara + `}`,
+	}
+	hasura.on("orders", `[{"id":"o1"},{"id":"o2"},{"id":"o3"},{"id":"o4"},{"id":"o5"},{"id":"o6"}]`)
+	hasura.onFunc("update_orders", func(vars map[string]json.RawMessage) string {
+		ids := []string{"o1", "o2", "o3", "o4", "o5", "o6"}
+		raw, claiming := vars["ids"]
+		if claiming {
+			json.Unmarshal(raw, &ids)
+		}
+		var returning []string
+		for _, id := range ids {
+			returning = append(returning, expired[id])
+		}
+		if claiming {
+			return `{"returning":[` + strings.Join(returning, ",") + `]}`
+		}
+		return `{"affected_rows":` + strconv.Itoa(len(returning)) + `,"returning":[` + strings.Join(returning, ",") + `]}`
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/cron/expireOrders", bytes.NewBufferString(`{}`))
+	req.Header.Set("X-Cron-Secret", "s3cret")
+	rec := httptest.NewRecorder()
+	HandleExpireOrders(rec, req, hasuraService)
+	if rec.Code != http.StatusOK || rec.Body.String() != `{"expired":6,"remindersSent":2}` {
+		t.Fatalf("expire = %d %s", rec.Code, rec.Body)
+	}
+
+	calls := hasura.calls("update_orders")
+	if len(calls) != 2 {
+		t.Fatalf("update_orders called %d times, want expire then claim", len(calls))
+	}
+	var ids []string
+	calls[1].variable(t, "ids", &ids)
+	if strings.Join(ids, ",") != "o1,o6" {
+		t.Errorf("claimed reminders for %v, want each buyer's latest purchase with a checkout window", ids)
+	}
+	if !strings.Contains(calls[1].Query, "expires_at: {_is_null: false}") {
+		t.Errorf("claim mutation:\n%s", calls[1].Query)
+	}
+
+	sent := mail.sent()
+	if len(sent) != 2 {
+		t.Fatalf("sent %d reminders, want one per buyer", len(sent))
+	}
+	if !strings.Contains(sent[0], "still in your cart") {
+		t.Errorf("cart checkout reminder:\n%s", sent[0])
+	}
+	if !strings.Contains(sent[1], "start a new checkout") {
+		t.Errorf("direct checkout reminder:\n%s", sent[1])
+	}
+}
@@ -0,0 +1,228 @@
+package payment
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+
+	google_uuid "github.com/google/uuid"
+)
+
+// FakeProvider is an in-process PaymentProvider for local development and
+// integration tests. Its checkout page lets the tester choose whether the
+// payment succeeds, fails or times out during verification.
+type FakeProvider struct {
+	baseURL string
+
+	mu       sync.Mutex
+	payments map[string]*fakePayment
+}
+
+type fakePayment struct {
+	request       InitiatePaymentRequest
+	status        string
+	transactionID string
+	refunded      Money
+	refunds       map[string]bool // references already refunded
+}
+
+// fakeStatusTimeout makes VerifyPayment block until the caller gives up.
+const fakeStatusTimeout = "timeout"
+
+// NewFakeProvider creates a FakeProvider whose checkout pages are served
+// under FAKE_PAYMENT_BASE_URL, falling back to BASE_URL.
+func NewFakeProvider() *FakeProvider {
+	baseURL := os.Getenv("FAKE_PAYMENT_BASE_URL")
+	if baseURL == "" {
+		baseURL = os.Getenv("BASE_URL")
+	}
+	if baseURL == "" {
+		port := os.Getenv("PORT")
+		if port == "" {
+			port = "8082"
+		}
+		baseURL = fmt.Sprintf("http://localhost:%s", port)
+	}
+	return &FakeProvider{
+		baseURL:  baseURL,
+		payments: make(map[string]*fakePayment),
+	}
+}
+
+// Name identifies the fake provider on orders.
+func (p *FakeProvider) Name() string {
+	return "fake"
+}
+
+// SupportsCurrency accepts any currency so every checkout can be tried locally.
+func (p *FakeProvider) SupportsCurrency(currency string) bool {
+	return true
+}
+
+// InitiatePayment records the payment and points the buyer at the fake checkout page.
+func (p *FakeProvider) InitiatePayment(ctx context.Context, req InitiatePaymentRequest) (InitiatePaymentResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, exists := p.payments[req.TxRef]; exists {
+		return InitiatePaymentResult{}, fmt.Errorf("fake provider: duplicate tx_ref %s", req.TxRef)
+	}
+	p.payments[req.TxRef] = &fakePayment{request: req, status: PaymentStatusPending}
+
+	checkoutURL := fmt.Sprintf("%s/fake-pay/checkout?tx_ref=%s", p.baseURL, url.QueryEscape(req.TxRef))
+	return InitiatePaymentResult{CheckoutURL: checkoutURL}, nil
+}
+
+// VerifyPayment reports the outcome chosen on the checkout page.
+func (p *FakeProvider) VerifyPayment(ctx context.Context, txRef string) (PaymentVerification, error) {
+	p.mu.Lock()
+	payment, ok := p.payments[txRef]
+	var verification PaymentVerification
+	status := ""
+	if ok {
+		status = payment.status
+		verification = PaymentVerification{
+			TransactionID: payment.transactionID,
+			TxRef:         txRef,
+			Amount:        payment.request.Amount,
+			Currency:      payment.request.Currency,
+			Status:        payment.status,
+		}
+	}
+	p.mu.Unlock()
+
+	if !ok {
+		return PaymentVerification{}, fmt.Errorf("fake provider: unknown tx_ref %s", txRef)
+	}
+	if status == fakeStatusTimeout {
+		<-ctx.Done()
+		return PaymentVerification{}, fmt.Errorf("fake provider: verification timed out: %w", ctx.Err())
+	}
+	return verification, nil
+}
+
+// Refund succeeds for completed payments as long as the total refunded stays
+// within the amount paid. A repeated reference returns the first result.
+func (p *FakeProvider) Refund(ctx context.Context, txRef string, req RefundRequest) (RefundResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	payment, ok := p.payments[txRef]
+	if !ok {
+		return RefundResult{}, RefundRejectedError{Provider: p.Name(), Reason: "unknown tx_ref " + txRef}
+	}
+	result := RefundResult{ProviderReference: "fake-refund-" + req.Reference}
+	if payment.refunds[req.Reference] {
+		return result, nil
+	}
+	if payment.status != PaymentStatusSuccess {
+		return RefundResult{}, RefundRejectedError{Provider: p.Name(), Reason: fmt.Sprintf("transaction %s was not completed", txRef)}
+	}
+	if req.Currency != payment.request.Currency {
+		return RefundResult{}, RefundRejectedError{Provider: p.Name(), Reason: fmt.Sprintf("refund in %s for a payment in %s", req.Currency, payment.request.Currency)}
+	}
+	if payment.refunded+req.Amount > payment.request.Amount {
+		return RefundResult{}, RefundRejectedError{Provider: p.Name(), Reason: "refund exceeds amount paid"}
+	}
+	payment.refunded += req.Amount
+	if payment.refunds == nil {
+		payment.refunds = make(map[string]bool)
+	}
+	payment.refunds[req.Reference] = true
+	return result, nil
+}
+
+// ParseWebhook reads the transaction reference the checkout page sends back.
+func (p *FakeProvider) ParseWebhook(r *http.Request) (WebhookEvent, error) {
+	txRef := r.URL.Query().Get("trx_ref")
+	if txRef == "" {
+		return WebhookEvent{}, fmt.Errorf("missing trx_ref in fake callback")
+	}
+	return WebhookEvent{TxRef: txRef}, nil
+}
+
+var fakeCheckoutTemplate = template.Must(template.New("checkout").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Fake checkout</title></head>
+<body>
+  <h1>Fake checkout</h1>
+  <p>{{.Title}}: {{.Description}}</p>
+  <p>Amount: {{.Amount}} {{.Currency}}</p>
+  <p>Reference: {{.TxRef}}</p>
+  <form method="POST" action="/fake-pay/complete">
+    <input type="hidden" name="tx_ref" value="{{.TxRef}}">
+    <button name="outcome" value="success">Pay</button>
+    <button name="outcome" value="failed">Fail</button>
+    <button name="outcome" value="timeout">Time out</button>
+  </form>
+</body>
+</html>`))
+
+// CheckoutPageHandler renders the fake checkout page for a transaction.
+func (p *FakeProvider) CheckoutPageHandler(w http.ResponseWriter, r *http.Request) {
+	txRef := r.URL.Query().Get("tx_ref")
+	p.mu.Lock()
+	payment, ok := p.payments[txRef]
+	var req InitiatePaymentRequest
+	if ok {
+		req = payment.request
+	}
+	p.mu.Unlock()
+
+	if !ok {
+		http.Error(w, "Unknown transaction", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := fakeCheckoutTemplate.Execute(w, req); err != nil {
+		log.Printf("Error rendering fake checkout page: %v", err)
+	}
+}
+
+// CompleteHandler applies the outcome picked on the checkout page and
+// sends the buyer through the callback URL, as a real provider would.
+func (p *FakeProvider) CompleteHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return
+	}
+	txRef := r.FormValue("tx_ref")
+	outcome := r.FormValue("outcome")
+	if outcome != PaymentStatusSuccess && outcome != PaymentStatusFailed && outcome != fakeStatusTimeout {
+		http.Error(w, "Unknown outcome", http.StatusBadRequest)
+		return
+	}
+
+	p.mu.Lock()
+	payment, ok := p.payments[txRef]
+	var callbackURL string
+	if ok {
+		payment.status = outcome
+		payment.transactionID = "fake-" + google_uuid.New().String()[:8]
+		callbackURL = payment.request.CallbackURL
+		if callbackURL == "" {
+			callbackURL = payment.request.ReturnURL
+		}
+	}
+	p.mu.Unlock()
+
+	if !ok {
+		http.Error(w, "Unknown transaction", http.StatusNotFound)
+		return
+	}
+	log.Printf("Fake payment %s marked as %s", txRef, outcome)
+
+	u, err := url.Parse(callbackURL)
+	if err != nil {
+		http.Error(w, "Invalid callback URL", http.StatusInternalServerError)
+		return
+	}
+	q := u.Query()
+	q.Set("trx_ref", txRef)
+	q.Set("status", outcome)
+	u.RawQuery = q.Encode()
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
@@ -0,0 +1,254 @@
+package payment
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// completeFakePayment submits the fake checkout form and returns the
+// callback URL the buyer is redirected to.
+func completeFakePayment(t *testing.T, p *FakeProvider, txRef string, outcome string) *url.URL {
+	t.Helper()
+	form := url.Values{"tx_ref": {txRef}, "outcome": {outcome}}
+	req := httptest.NewRequest(http.MethodPost, "/fake-pay/complete", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	p.CompleteHandler(rec, req)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("complete %s = %d: %s", outcome, rec.Code, rec.Body)
+	}
+	location, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return location
+}
+
+func TestFakeProviderOutcomes(t *testing.T) {
+	for _, outcome := range []string{PaymentStatusSuccess, PaymentStatusFailed, fakeStatusTimeout} {
+		t.Run(outcome, func(t *testing.T) {
+			p := NewFakeProvider()
+			ctx := context.Background()
+			req := InitiatePaymentRequest{Amount: 12050, Currency: "ETB", TxRef: "c-1", CallbackURL: "http://backend/chapa/callback?source=fake"}
+			if _, err := p.InitiatePayment(ctx, req); err != nil {
+				t.Fatal(err)
+			}
+
+			verification, err := p.VerifyPayment(ctx, "c-1")
+			if err != nil || verification.Status != PaymentStatusPending {
+				t.Fatalf("before checkout: %+v, %v", verification, err)
+			}
+
+			callback := completeFakePayment(t, p, "c-1", outcome)
+			if callback.Host != "backend" || callback.Query().Get("source") != "fake" || callback.Query().Get("status") != outcome {
+				t.Errorf("redirected to %s", callback)
+			}
+			event, err := p.ParseWebhook(httptest.NewRequest(http.MethodGet, callback.String(), nil))
+			if err != nil || event.TxRef != "c-1" {
+				t.Fatalf("ParseWebhook = %+v, %v", event, err)
+			}
+
+			ctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+			defer cancel()
+			verification, err = p.VerifyPayment(ctx, "c-1")
+			if outcome == fakeStatusTimeout {
+				if err == nil {
+					t.Fatalf("timed out payment verified as %+v", verification)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if verification.Status != outcome || verification.Amount != 12050 || verification.TransactionID == "" {
+				t.Errorf("verification = %+v", verification)
+			}
+		})
+	}
+}
+
+func TestFakeProviderRefund(t *testing.T) {
+	p := NewFakeProvider()
+	ctx := context.Background()
+	p.InitiatePayment(ctx, InitiatePaymentRequest{Amount: 10000, Currency: "ETB", TxRef: "c-1", CallbackURL: "http://backend/cb"})
+	if _, err := p.Refund(ctx, "c-1", RefundRequest{Currency: "ETB", Amount: 1000, Reference: "r1"}); err == nil {
+		t.Error("refunded a payment that was never completed")
+	}
+	completeFakePayment(t, p, "c-1", PaymentStatusSuccess)
+
+	if _, err := p.Refund(ctx, "c-1", RefundRequest{Currency: "USD", Amount: 1000, Reference: "r1"}); err == nil {
+		t.Error("refunded a birr payment in dollars")
+	}
+	result, err := p.Refund(ctx, "c-1", RefundRequest{Currency: "ETB", Amount: 6000, Reference: "r1"})
+	if err != nil || result.ProviderReference != "fake-refund-r1" {
+		t.Fatalf("Refund = %+v, %v", result, err)
+	}
+	if _, err := p.Refund(ctx, "c-1", RefundRequest{Currency: "ETB", Amount: 4001, Reference: "r2"}); err == nil {
+		t.Error("refunded more than was paid")
+	}
+	if _, err := p.Refund(ctx, "c-1", RefundRequest{Currency: "ETB", Amount: 4000, Reference: "r3"}); err != nil {
+		t.Errorf("refunding the rest failed: %v", err)
+	}
+	// A retried refund is not paid twice
+	if result, err := p.Refund(ctx, "c-1", RefundRequest{Currency: "ETB", Amount: 4000, Reference: "r3"}); err != nil || result.ProviderReference != "fake-refund-r3" {
+		t.Errorf("retrying r3 = %+v, %v", result, err)
+	}
+	var rejected RefundRejectedError
+	if _, err := p.Refund(ctx, "c-1", RefundRequest{Currency: "ETB", Amount: 1, Reference: "r4"}); !errors.As(err, &rejected) {
+		t.Errorf("refunding a fully refunded payment = %v, want a rejection", err)
+	}
+}
+
+func TestFakeProviderRejectsBadInput(t *testing.T) {
+	p := NewFakeProvider()
+	ctx := context.Background()
+	req := InitiatePaymentRequest{Amount: 100, Currency: "ETB", TxRef: "c-1"}
+	p.InitiatePayment(ctx, req)
+	if _, err := p.InitiatePayment(ctx, req); err == nil {
+		t.Error("reused tx_ref was accepted")
+	}
+	if _, err := p.VerifyPayment(ctx, "c-2"); err == nil {
+		t.Error("unknown tx_ref was verified")
+	}
+
+	form := url.Values{"tx_ref": {"c-1"}, "outcome": {"refunded"}}
+	complete := httptest.NewRequest(http.MethodPost, "/fake-pay/complete", strings.NewReader(form.Encode()))
+	complete.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	p.CompleteHandler(rec, complete)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown outcome = %d, want 400", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	p.CheckoutPageHandler(rec, httptest.NewRequest(http.MethodGet, "/fake-pay/checkout?tx_ref=c-2", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("checkout page for unknown tx_ref = %d, want 404", rec.Code)
+	}
+}
+
+// TestFakeCheckoutFlow runs a checkout through the action handlers, the fake
+// checkout page and the callback, with Hasura replaced by fakeHasura.
+func TestFakeCheckoutFlow(t *testing.T) {
+	hasura, hasuraService := newFakeHasura(t)
+	hasura.on("recipes", `[{"id":"r1","user_id":"a1","price_etb":75.25,"title":"Doro wat","is_published":true,"recipe_images":[]}]`)
+	hasura.on("users_by_pk", `{"first_name":"Abebe","last_name":"Kebede","email":"abebe@example.com","phone_number":"0911000000"}`)
+	hasura.on("exchange_rates_by_pk", `{"currency":"ETB","etb_per_unit":1}`)
+	hasura.on("insert_orders_one", `{"id":"o1","chapa_tx_ref":"","return_url":""}`)
+	hasura.onFunc("orders", func(vars map[string]json.RawMessage) string {
+		if _, ok := vars["where"]; ok {
+			return `[]` // no open order to reuse
+		}
+		return `[{"id":"o1","return_url":"https://shop.example.com/orders","payment_provider":"fake","total_amount":150.50,"currency":"ETB",
+			"order_items":[{"id":"i1","recipe_id":"r1","quantity":2,"price_at_purchase":75.25,"recipe":{"user_id":"a1"}}]}]`
+	})
+	hasura.on("update_orders_by_pk", `{"id":"o1"}`)
+	hasura.on("update_orders", `{"affected_rows":1,"returning":[]}`)
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	t.Setenv("PAYMENT_PROVIDER", "fake")
+	t.Setenv("PAYMENT_PROVIDERS", "")
+	t.Setenv("BASE_URL", server.URL)
+	t.Setenv("FAKE_PAYMENT_BASE_URL", "")
+	t.Setenv("FAKE_CALLBACK_URL", "")
+	providers := NewProviderRegistry()
+	provider, _ := providers.Fake()
+	mux.HandleFunc("/initiatePayment", func(w http.ResponseWriter, r *http.Request) {
+		HandleInitiatePayment(w, r, hasuraService, providers)
+	})
+	mux.HandleFunc("/payments/fake/callback", func(w http.ResponseWriter, r *http.Request) {
+		HandlePaymentCallback(w, r, hasuraService, providers, "fake")
+	})
+	mux.HandleFunc("/fake-pay/checkout", provider.CheckoutPageHandler)
+	mux.HandleFunc("/fake-pay/complete", provider.CompleteHandler)
+
+	client := server.Client()
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if req.URL.Host != strings.TrimPrefix(server.URL, "http://") {
+			return http.ErrUseLastResponse
+		}
+		return nil
+	}
+
+	// 1. Start the checkout
+	action := `{"input":{"input":{"recipeItems":[{"recipeId":"r1","quantity":2}],"returnUrl":"https://shop.example.com/orders","amount":150.5,"currency":"ETB","provider":"fake"}},"session_variables":{"x-hasura-user-id":"u1"}}`
+	resp, err := client.Post(server.URL+"/initiatePayment", "application/json", bytes.NewBufferString(action))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var started map[string]string
+	json.NewDecoder(resp.Body).Decode(&started)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || started["checkoutUrl"] == "" {
+		t.Fatalf("initiate = %d %v", resp.StatusCode, started)
+	}
+
+	var order orders_insert_input
+	hasura.calls("insert_orders_one")[0].variable(t, "object", &order)
+	if order.PaymentProvider != "fake" {
+		t.Errorf("order stored with provider %q, want fake", order.PaymentProvider)
+	}
+	if order.CheckoutType == nil || *order.CheckoutType != checkoutTypeDirect {
+		t.Errorf("order stored with checkout type %v, want direct", order.CheckoutType)
+	}
+	if items := order.OrderItems.Data; len(items) != 1 || items[0].Quantity != 2 || items[0].PriceAtPurchase != 7525 {
+		t.Errorf("order stored with items %+v", items)
+	}
+
+	// 2. The checkout page shows the order
+	resp, err = client.Get(started["checkoutUrl"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(page), "150.50 ETB") {
+		t.Errorf("checkout page does not show the amount:\n%s", page)
+	}
+
+	// 3. Paying redirects through the callback back to the shop
+	resp, err = client.PostForm(server.URL+"/fake-pay/complete", url.Values{"tx_ref": {started["txRef"]}, "outcome": {"success"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	final, _ := url.Parse(resp.Header.Get("Location"))
+	if resp.StatusCode != http.StatusFound || final.Host != "shop.example.com" || final.Query().Get("status") != "completed" || final.Query().Get("order_id") != "o1" {
+		t.Fatalf("callback = %d to %s", resp.StatusCode, final)
+	}
+
+	updates := hasura.calls("update_orders")
+	if len(updates) != 1 {
+		t.Fatalf("order updated %d times, want 1", len(updates))
+	}
+	var set struct {
+		Status             string `json:"status"`
+		ChapaTransactionID string `json:"chapa_transaction_id"`
+	}
+	updates[0].variable(t, "set", &set)
+	if set.Status != "completed" || !strings.HasPrefix(set.ChapaTransactionID, "fake-") {
+		t.Errorf("order updated with %+v", set)
+	}
+
+	// 4. The first completion credits the author
+	sales := hasura.calls("insert_ledger_transactions_one")
+	if len(sales) != 1 {
+		t.Fatalf("%d ledger transactions posted, want 1", len(sales))
+	}
+	var sale ledger_transactions_insert_input
+	sales[0].variable(t, "object", &sale)
+	if sale.IdempotencyKey != "sale:o1" {
+		t.Errorf("sale posted with idempotency key %q", sale.IdempotencyKey)
+	}
+}
@@ -31,6 +31,21 @@ func (s *HasuraService) QueryRecipeDetails(ctx context.Context, recipeIDs []stri
 	return resp, err
 }
 
+// QueryOwnedRecipes fetches which of the given recipes the buyer already owns.
+func (s *HasuraService) QueryOwnedRecipes(ctx context.Context, buyerID string, recipeIDs []string) (ownedRecipesQuery, error) {
+	var resp ownedRecipesQuery
+	ids := make([]uuid, 0, len(recipeIDs))
+	for _, id := range recipeIDs {
+		ids = append(ids, uuid(id))
+	}
+	vars := map[string]interface{}{
+		"buyerId":   uuid(buyerID),
+		"recipeIds": ids,
+	}
+	err := s.client.Query(ctx, &resp, vars)
+	return resp, err
+}
+
 // QueryUserDetails fetches a single user's details from Hasura.
 func (s *HasuraService) QueryUserDetails(ctx context.Context, userID string) (userDetailsQuery, error) {
 	var resp userDetailsQuery
@@ -38,7 +53,8 @@ func (s *HasuraService) QueryUserDetails(ctx context.Context, userID string) (us
 	return resp, err
 }
 
-// InsertOrder inserts a new order into the database.
+// InsertOrder inserts a new order together with its items in one mutation,
+// so an order is never stored without them.
 func (s *HasuraService) InsertOrder(ctx context.Context, order orders_insert_input) (insertOrderMutation, error) {
 	var resp insertOrderMutation
 	vars := map[string]interface{}{"object": order}
@@ -46,41 +62,575 @@ func (s *HasuraService) InsertOrder(ctx context.Context, order orders_insert_inp
 	return resp, err
 }
 
-// InsertOrderItems inserts multiple order items into the database.
-func (s *HasuraService) InsertOrderItems(ctx context.Context, items []order_items_insert_input) (insertOrderItemsMutation, error) {
-	var resp insertOrderItemsMutation
-	vars := map[string]interface{}{"objects": items}
-	err := s.client.Mutate(ctx, &resp, vars)
-	return resp, err
-}
-
 // UpdateOrderStatus updates an existing order's status and Chapa transaction ID.
-
 func (s *HasuraService) UpdateOrderStatus(ctx context.Context, txRef string, status string, chapaTxID string) (updateOrderStatusMutation, error) {
 	var resp updateOrderStatusMutation
+	now := DateTime(time.Now())
 	vars := map[string]interface{}{
 		"txRef": graphql.String(txRef),
-		"set": map[string]interface{}{
-			"status":               graphql.String(status),
-			"chapa_transaction_id": graphql.String(chapaTxID),
-			"updated_at":           DateTime(time.Now()),
+		"set": orders_set_input{
+			Status:             &status,
+			ChapaTransactionID: &chapaTxID,
+			UpdatedAt:          &now,
 		},
 	}
 	err := s.client.Mutate(ctx, &resp, vars)
 	return resp, err
 }
 
-// QueryOrderForCallback fetches a specific order to get the return URL.
-func (s *HasuraService) QueryOrderForCallback(ctx context.Context, txRef string) ([]struct {
-	OrderID   string `graphql:"id"`
-	ReturnURL string `graphql:"return_url"`
-}, error) {
+// QueryOrderForCallback fetches a specific order to get the return URL and provider.
+func (s *HasuraService) QueryOrderForCallback(ctx context.Context, txRef string) ([]callbackOrder, error) {
 	var orderQuery struct {
-		Orders []struct {
-			OrderID   string `graphql:"id"`
-			ReturnURL string `graphql:"return_url"`
-		} `graphql:"orders(where: {chapa_tx_ref: {_eq: $txRef}})"`
+		Orders []callbackOrder `graphql:"orders(where: {chapa_tx_ref: {_eq: $txRef}})"`
 	}
 	err := s.client.Query(ctx, &orderQuery, map[string]interface{}{"txRef": graphql.String(txRef)})
 	return orderQuery.Orders, err
-}
\ No newline at end of file
+}
+
+// QueryOrdersMissingInvoice fetches paid orders with items but no invoice, oldest first.
+func (s *HasuraService) QueryOrdersMissingInvoice(ctx context.Context, limit int) ([]callbackOrder, error) {
+	var resp ordersMissingInvoiceQuery
+	err := s.client.Query(ctx, &resp, map[string]interface{}{"limit": limit})
+	return resp.Orders, err
+}
+
+// QueryOrdersMissingSale fetches paid orders and tips that have no sale or
+// tip ledger transaction, oldest first.
+func (s *HasuraService) QueryOrdersMissingSale(ctx context.Context, limit int) ([]callbackOrder, error) {
+	var resp ordersMissingSaleQuery
+	err := s.client.Query(ctx, &resp, map[string]interface{}{"limit": limit})
+	return resp.Orders, err
+}
+
+// QueryOrderForRefund fetches an order with the items and recipe authors needed to authorize a refund.
+func (s *HasuraService) QueryOrderForRefund(ctx context.Context, orderID string) (refundOrderQuery, error) {
+	var resp refundOrderQuery
+	err := s.client.Query(ctx, &resp, map[string]interface{}{"id": uuid(orderID)})
+	return resp, err
+}
+
+// LockOrderForRefund claims a completed or partially refunded order for one
+// refund and reports whether it did. Until the refund is recorded or failed
+// no other refund of the order can start; a lock taken before staleBefore
+// with no pending refund is taken over.
+func (s *HasuraService) LockOrderForRefund(ctx context.Context, orderID string, now time.Time, staleBefore time.Time) (bool, error) {
+	var resp lockOrderForRefundMutation
+	vars := map[string]interface{}{
+		"orderId":     uuid(orderID),
+		"now":         DateTime(now),
+		"staleBefore": DateTime(staleBefore),
+	}
+	err := s.client.Mutate(ctx, &resp, vars)
+	if err != nil {
+		return false, err
+	}
+	return resp.UpdateOrders != nil && resp.UpdateOrders.AffectedRows == 1, nil
+}
+
+// UnlockOrderForRefund releases an order claimed by LockOrderForRefund when no refund was started.
+func (s *HasuraService) UnlockOrderForRefund(ctx context.Context, orderID string) error {
+	var resp unlockOrderForRefundMutation
+	return s.client.Mutate(ctx, &resp, map[string]interface{}{"orderId": uuid(orderID)})
+}
+
+// QueryStuckRefunds fetches pending refunds whose order was locked before
+// lockedBefore, oldest first.
+func (s *HasuraService) QueryStuckRefunds(ctx context.Context, lockedBefore time.Time, limit int) ([]pendingRefund, error) {
+	var resp stuckRefundsQuery
+	vars := map[string]interface{}{
+		"lockedBefore": DateTime(lockedBefore),
+		"limit":        limit,
+	}
+	err := s.client.Query(ctx, &resp, vars)
+	return resp.Refunds, err
+}
+
+// FailRefund marks a refund the provider rejected as failed and releases its order.
+func (s *HasuraService) FailRefund(ctx context.Context, orderID string, refundID string, set refunds_set_input) (failRefundMutation, error) {
+	var resp failRefundMutation
+	vars := map[string]interface{}{
+		"orderId":   uuid(orderID),
+		"refundId":  uuid(refundID),
+		"refundSet": set,
+	}
+	err := s.client.Mutate(ctx, &resp, vars)
+	return resp, err
+}
+
+// InsertRefund records a refund attempt before Chapa is called.
+func (s *HasuraService) InsertRefund(ctx context.Context, refund refunds_insert_input) (insertRefundMutation, error) {
+	var resp insertRefundMutation
+	vars := map[string]interface{}{"object": refund}
+	err := s.client.Mutate(ctx, &resp, vars)
+	return resp, err
+}
+
+// ApplyRefund updates order items, the order, revoked purchases and the refund record, and posts the
+// refund to the ledger, in one transaction.
+func (s *HasuraService) ApplyRefund(ctx context.Context, orderID string, buyerID string, itemUpdates []order_items_updates, orderSet orders_set_input, revokedRecipeIDs []string, purchaseSet purchases_set_input, refundID string, refundSet refunds_set_input, ledgerTransaction ledger_transactions_insert_input) (applyRefundMutation, error) {
+	var resp applyRefundMutation
+	revoked := make([]uuid, 0, len(revokedRecipeIDs))
+	for _, id := range revokedRecipeIDs {
+		revoked = append(revoked, uuid(id))
+	}
+	vars := map[string]interface{}{
+		"itemUpdates":       itemUpdates,
+		"orderId":           uuid(orderID),
+		"orderSet":          orderSet,
+		"buyerId":           uuid(buyerID),
+		"revokedRecipeIds":  revoked,
+		"purchaseSet":       purchaseSet,
+		"refundId":          uuid(refundID),
+		"refundSet":         refundSet,
+		"ledgerTransaction": ledgerTransaction,
+	}
+	err := s.client.Mutate(ctx, &resp, vars)
+	return resp, err
+}
+
+// QueryCoupon fetches a coupon by code together with how often the user has already redeemed it.
+func (s *HasuraService) QueryCoupon(ctx context.Context, code string, userID string) (couponQuery, error) {
+	var resp couponQuery
+	vars := map[string]interface{}{
+		"code":   graphql.String(code),
+		"userId": uuid(userID),
+	}
+	err := s.client.Query(ctx, &resp, vars)
+	return resp, err
+}
+
+// InsertCouponRedemption counts a coupon use once its order has been paid.
+func (s *HasuraService) InsertCouponRedemption(ctx context.Context, redemption coupon_redemptions_insert_input) (insertCouponRedemptionMutation, error) {
+	var resp insertCouponRedemptionMutation
+	vars := map[string]interface{}{"object": redemption}
+	err := s.client.Mutate(ctx, &resp, vars)
+	return resp, err
+}
+
+// InsertLedgerTransaction posts a balanced ledger transaction together with its entries.
+func (s *HasuraService) InsertLedgerTransaction(ctx context.Context, transaction ledger_transactions_insert_input) (insertLedgerTransactionMutation, error) {
+	var resp insertLedgerTransactionMutation
+	vars := map[string]interface{}{"object": transaction}
+	err := s.client.Mutate(ctx, &resp, vars)
+	return resp, err
+}
+
+// QueryMyEarnings fetches an author's ledger balances, one row per currency.
+func (s *HasuraService) QueryMyEarnings(ctx context.Context, userID string) (myEarningsQuery, error) {
+	var resp myEarningsQuery
+	err := s.client.Query(ctx, &resp, map[string]interface{}{"userId": uuid(userID)})
+	return resp, err
+}
+
+// QueryPayoutHistory fetches a page of an author's payouts, newest first.
+func (s *HasuraService) QueryPayoutHistory(ctx context.Context, userID string, limit int, offset int) (payoutHistoryQuery, error) {
+	var resp payoutHistoryQuery
+	vars := map[string]interface{}{
+		"userId": uuid(userID),
+		"limit":  graphql.Int(limit),
+		"offset": graphql.Int(offset),
+	}
+	err := s.client.Query(ctx, &resp, vars)
+	return resp, err
+}
+
+// QueryPayableAuthors fetches every author whose balance in a currency has reached the minimum payout.
+func (s *HasuraService) QueryPayableAuthors(ctx context.Context, currency string, minimum Money) (payableAuthorsQuery, error) {
+	var resp payableAuthorsQuery
+	vars := map[string]interface{}{
+		"currency": graphql.String(currency),
+		"minimum":  minimum,
+	}
+	err := s.client.Query(ctx, &resp, vars)
+	return resp, err
+}
+
+// QueryPayoutAccounts fetches the bank details authors have registered for payouts.
+func (s *HasuraService) QueryPayoutAccounts(ctx context.Context, userIDs []string) (payoutAccountsQuery, error) {
+	var resp payoutAccountsQuery
+	ids := make([]uuid, 0, len(userIDs))
+	for _, id := range userIDs {
+		ids = append(ids, uuid(id))
+	}
+	err := s.client.Query(ctx, &resp, map[string]interface{}{"userIds": ids})
+	return resp, err
+}
+
+// InsertPayoutBatch records a payout batch with its payouts and their ledger transactions.
+func (s *HasuraService) InsertPayoutBatch(ctx context.Context, batch payout_batches_insert_input) (insertPayoutBatchMutation, error) {
+	var resp insertPayoutBatchMutation
+	vars := map[string]interface{}{"object": batch}
+	err := s.client.Mutate(ctx, &resp, vars)
+	return resp, err
+}
+
+// QueryPayoutBatch fetches a payout batch and its payouts.
+func (s *HasuraService) QueryPayoutBatch(ctx context.Context, batchID string) (payoutBatchQuery, error) {
+	var resp payoutBatchQuery
+	err := s.client.Query(ctx, &resp, map[string]interface{}{"id": uuid(batchID)})
+	return resp, err
+}
+
+// SettlePayoutBatch records the outcome of a batch's bank transfers: the
+// failed payouts are reversed and every other processing payout is paid.
+func (s *HasuraService) SettlePayoutBatch(ctx context.Context, batchID string, failedIDs []string, reversals []ledger_transactions_insert_input, now time.Time) (settlePayoutBatchMutation, error) {
+	var resp settlePayoutBatchMutation
+	failed := make([]uuid, 0, len(failedIDs))
+	for _, id := range failedIDs {
+		failed = append(failed, uuid(id))
+	}
+	if reversals == nil {
+		reversals = []ledger_transactions_insert_input{}
+	}
+	vars := map[string]interface{}{
+		"batchId":   uuid(batchID),
+		"failedIds": failed,
+		"failedSet": payouts_set_input{Status: "failed", SettledAt: DateTime(now)},
+		"paidSet":   payouts_set_input{Status: "paid", SettledAt: DateTime(now)},
+		"reversals": reversals,
+	}
+	err := s.client.Mutate(ctx, &resp, vars)
+	return resp, err
+}
+
+// QueryCart fetches the user's cart with the current details of every recipe in it.
+func (s *HasuraService) QueryCart(ctx context.Context, userID string) (cartQuery, error) {
+	var resp cartQuery
+	err := s.client.Query(ctx, &resp, map[string]interface{}{"userId": uuid(userID)})
+	return resp, err
+}
+
+// UpsertCart returns the ID of the user's cart, creating the cart if needed.
+func (s *HasuraService) UpsertCart(ctx context.Context, userID string) (upsertCartMutation, error) {
+	var resp upsertCartMutation
+	vars := map[string]interface{}{"object": carts_insert_input{UserID: uuid(userID), UpdatedAt: DateTime(time.Now())}}
+	err := s.client.Mutate(ctx, &resp, vars)
+	return resp, err
+}
+
+// UpsertCartItem adds a recipe to a cart or replaces its quantity. An item
+// already in the cart keeps the price it was added at.
+func (s *HasuraService) UpsertCartItem(ctx context.Context, item cart_items_insert_input) (upsertCartItemMutation, error) {
+	var resp upsertCartItemMutation
+	vars := map[string]interface{}{"object": item}
+	err := s.client.Mutate(ctx, &resp, vars)
+	return resp, err
+}
+
+// AcceptCartPrices records the buyer's acceptance of new prices by moving
+// the given cart items' price_at_add to them.
+func (s *HasuraService) AcceptCartPrices(ctx context.Context, cartID string, prices map[string]Money) (acceptCartPricesMutation, error) {
+	var resp acceptCartPricesMutation
+	now := DateTime(time.Now())
+	updates := make([]cart_items_updates, 0, len(prices))
+	for recipeID, price := range prices {
+		updates = append(updates, cart_items_updates{
+			Where: map[string]interface{}{
+				"cart_id":   map[string]interface{}{"_eq": cartID},
+				"recipe_id": map[string]interface{}{"_eq": recipeID},
+			},
+			Set: cart_items_set_input{PriceAtAdd: price, UpdatedAt: now},
+		})
+	}
+	err := s.client.Mutate(ctx, &resp, map[string]interface{}{"updates": updates})
+	return resp, err
+}
+
+// DeleteCartItems removes the given recipes from the user's cart.
+func (s *HasuraService) DeleteCartItems(ctx context.Context, userID string, recipeIDs []string) (deleteCartItemsMutation, error) {
+	var resp deleteCartItemsMutation
+	ids := make([]uuid, 0, len(recipeIDs))
+	for _, id := range recipeIDs {
+		ids = append(ids, uuid(id))
+	}
+	vars := map[string]interface{}{
+		"userId":    uuid(userID),
+		"recipeIds": ids,
+	}
+	err := s.client.Mutate(ctx, &resp, vars)
+	return resp, err
+}
+
+// QueryMyOrders fetches a page of a buyer's orders, newest first, optionally limited to one status.
+func (s *HasuraService) QueryMyOrders(ctx context.Context, userID string, status string, limit int, offset int) (myOrdersQuery, error) {
+	var resp myOrdersQuery
+	where := orders_bool_exp{"user_id": map[string]interface{}{"_eq": userID}}
+	if status != "" {
+		where["status"] = map[string]interface{}{"_eq": status}
+	}
+	vars := map[string]interface{}{
+		"where":  where,
+		"limit":  graphql.Int(limit),
+		"offset": graphql.Int(offset),
+	}
+	err := s.client.Query(ctx, &resp, vars)
+	return resp, err
+}
+
+// QueryOrderDetail fetches one order with its items, only if it belongs to the user.
+func (s *HasuraService) QueryOrderDetail(ctx context.Context, userID string, orderID string) (orderDetailQuery, error) {
+	var resp orderDetailQuery
+	vars := map[string]interface{}{
+		"id":     uuid(orderID),
+		"userId": uuid(userID),
+	}
+	err := s.client.Query(ctx, &resp, vars)
+	return resp, err
+}
+
+// InsertInvoice records an invoice with its lines; the database assigns the invoice number.
+func (s *HasuraService) InsertInvoice(ctx context.Context, invoice invoices_insert_input) (insertInvoiceMutation, error) {
+	var resp insertInvoiceMutation
+	vars := map[string]interface{}{"object": invoice}
+	err := s.client.Mutate(ctx, &resp, vars)
+	return resp, err
+}
+
+// QueryInvoice fetches the invoice of an order, only if the order belongs to the user.
+func (s *HasuraService) QueryInvoice(ctx context.Context, userID string, orderID string) (invoiceQuery, error) {
+	var resp invoiceQuery
+	vars := map[string]interface{}{
+		"orderId": uuid(orderID),
+		"userId":  uuid(userID),
+	}
+	err := s.client.Query(ctx, &resp, vars)
+	return resp, err
+}
+
+// QueryOpenOrder finds the buyer's newest pending order for the same cart
+// that has not expired yet and already has a checkout page.
+func (s *HasuraService) QueryOpenOrder(ctx context.Context, userID string, fingerprint string, now time.Time) (openOrderQuery, error) {
+	var resp openOrderQuery
+	where := orders_bool_exp{
+		"user_id":          map[string]interface{}{"_eq": userID},
+		"cart_fingerprint": map[string]interface{}{"_eq": fingerprint},
+		"status":           map[string]interface{}{"_eq": "pending"},
+		"expires_at":       map[string]interface{}{"_gt": DateTime(now)},
+		"checkout_url":     map[string]interface{}{"_is_null": false},
+	}
+	err := s.client.Query(ctx, &resp, map[string]interface{}{"where": where})
+	return resp, err
+}
+
+// UpdateOrder sets fields on a single order.
+func (s *HasuraService) UpdateOrder(ctx context.Context, orderID string, set orders_set_input) (updateOrderMutation, error) {
+	var resp updateOrderMutation
+	vars := map[string]interface{}{
+		"id":  uuid(orderID),
+		"set": set,
+	}
+	err := s.client.Mutate(ctx, &resp, vars)
+	return resp, err
+}
+
+// ExpirePendingOrders marks up to limit pending orders past their expiry as
+// expired, oldest first, and returns them. Orders created before expires_at
+// existed are expired once they are older than cutoff.
+func (s *HasuraService) ExpirePendingOrders(ctx context.Context, now time.Time, cutoff time.Time, limit int) (expireOrdersMutation, error) {
+	var due expirableOrdersQuery
+	where := orders_bool_exp{
+		"status": map[string]interface{}{"_eq": "pending"},
+		"_or": []map[string]interface{}{
+			{"expires_at": map[string]interface{}{"_lt": DateTime(now)}},
+			{
+				"expires_at": map[string]interface{}{"_is_null": true},
+				"created_at": map[string]interface{}{"_lt": DateTime(cutoff)},
+			},
+		},
+	}
+	if err := s.client.Query(ctx, &due, map[string]interface{}{"where": where, "limit": limit}); err != nil {
+		return expireOrdersMutation{}, err
+	}
+
+	var resp expireOrdersMutation
+	if len(due.Orders) == 0 {
+		resp.UpdateOrders = &expiredOrders{}
+		return resp, nil
+	}
+	ids := make([]uuid, 0, len(due.Orders))
+	for _, order := range due.Orders {
+		ids = append(ids, uuid(order.ID))
+	}
+	status := "expired"
+	updatedAt := DateTime(now)
+	vars := map[string]interface{}{
+		// Still pending, so an order paid since it was found stays paid
+		"where": orders_bool_exp{
+			"id":     map[string]interface{}{"_in": ids},
+			"status": map[string]interface{}{"_eq": "pending"},
+		},
+		"set": orders_set_input{Status: &status, UpdatedAt: &updatedAt},
+	}
+	err := s.client.Mutate(ctx, &resp, vars)
+	return resp, err
+}
+
+// ClaimCheckoutReminders marks the expired orders among orderIDs that are
+// still due a reminder as reminded and returns them.
+func (s *HasuraService) ClaimCheckoutReminders(ctx context.Context, orderIDs []string, now time.Time) ([]expiredOrder, error) {
+	var resp claimRemindersMutation
+	ids := make([]uuid, 0, len(orderIDs))
+	for _, id := range orderIDs {
+		ids = append(ids, uuid(id))
+	}
+	vars := map[string]interface{}{
+		"ids": ids,
+		"now": DateTime(now),
+	}
+	if err := s.client.Mutate(ctx, &resp, vars); err != nil {
+		return nil, err
+	}
+	if resp.UpdateOrders == nil {
+		return nil, nil
+	}
+	return resp.UpdateOrders.Returning, nil
+}
+
+// QueryExchangeRate fetches the stored rate for a currency, if it is supported.
+func (s *HasuraService) QueryExchangeRate(ctx context.Context, currency string) (exchangeRateQuery, error) {
+	var resp exchangeRateQuery
+	err := s.client.Query(ctx, &resp, map[string]interface{}{"currency": graphql.String(currency)})
+	return resp, err
+}
+
+// UpsertExchangeRates adds or updates exchange rates in one mutation.
+func (s *HasuraService) UpsertExchangeRates(ctx context.Context, rates []exchange_rates_insert_input) (upsertExchangeRatesMutation, error) {
+	var resp upsertExchangeRatesMutation
+	err := s.client.Mutate(ctx, &resp, map[string]interface{}{"objects": rates})
+	return resp, err
+}
+
+// QuerySubscribe loads what subscribing to a plan depends on; see subscribeQuery.
+func (s *HasuraService) QuerySubscribe(ctx context.Context, userID string, planID string, provider string, openUntil time.Time) (subscribeQuery, error) {
+	var resp subscribeQuery
+	vars := map[string]interface{}{
+		"userId":    uuid(userID),
+		"planId":    uuid(planID),
+		"provider":  graphql.String(provider),
+		"openUntil": DateTime(openUntil),
+	}
+	err := s.client.Query(ctx, &resp, vars)
+	return resp, err
+}
+
+// InsertSubscription inserts a pending subscription together with its first payment.
+func (s *HasuraService) InsertSubscription(ctx context.Context, subscription subscriptions_insert_input) (insertSubscriptionMutation, error) {
+	var resp insertSubscriptionMutation
+	err := s.client.Mutate(ctx, &resp, map[string]interface{}{"object": subscription})
+	return resp, err
+}
+
+// InsertSubscriptionPayment records a renewal checkout for an existing subscription.
+func (s *HasuraService) InsertSubscriptionPayment(ctx context.Context, payment subscription_payments_insert_input) (insertSubscriptionPaymentMutation, error) {
+	var resp insertSubscriptionPaymentMutation
+	err := s.client.Mutate(ctx, &resp, map[string]interface{}{"object": payment})
+	return resp, err
+}
+
+// UpdateSubscriptionPayment sets fields on a single subscription payment.
+func (s *HasuraService) UpdateSubscriptionPayment(ctx context.Context, paymentID string, set subscription_payments_set_input) (updateSubscriptionPaymentMutation, error) {
+	var resp updateSubscriptionPaymentMutation
+	vars := map[string]interface{}{
+		"id":  uuid(paymentID),
+		"set": set,
+	}
+	err := s.client.Mutate(ctx, &resp, vars)
+	return resp, err
+}
+
+// UpdateSubscriptionPaymentStatus records the verified outcome of a
+// subscription payment that has not completed yet.
+func (s *HasuraService) UpdateSubscriptionPaymentStatus(ctx context.Context, txRef string, set subscription_payments_set_input) (updateSubscriptionPaymentStatusMutation, error) {
+	var resp updateSubscriptionPaymentStatusMutation
+	vars := map[string]interface{}{
+		"txRef": graphql.String(txRef),
+		"set":   set,
+	}
+	err := s.client.Mutate(ctx, &resp, vars)
+	return resp, err
+}
+
+// CompleteSubscriptionPayment marks a paid subscription payment completed
+// and activates its subscription; if either update fails neither is applied.
+func (s *HasuraService) CompleteSubscriptionPayment(ctx context.Context, subscriptionID string, txRef string, payment subscription_payments_set_input, subscription subscriptions_set_input) (completeSubscriptionPaymentMutation, error) {
+	var resp completeSubscriptionPaymentMutation
+	vars := map[string]interface{}{
+		"id":           uuid(subscriptionID),
+		"txRef":        graphql.String(txRef),
+		"payment":      payment,
+		"subscription": subscription,
+	}
+	err := s.client.Mutate(ctx, &resp, vars)
+	return resp, err
+}
+
+// QuerySubscriptionPayment fetches a subscription payment and its subscription by provider reference.
+func (s *HasuraService) QuerySubscriptionPayment(ctx context.Context, txRef string) (subscriptionPaymentQuery, error) {
+	var resp subscriptionPaymentQuery
+	err := s.client.Query(ctx, &resp, map[string]interface{}{"txRef": graphql.String(txRef)})
+	return resp, err
+}
+
+// UpdateSubscription sets fields on a single subscription.
+func (s *HasuraService) UpdateSubscription(ctx context.Context, subscriptionID string, set subscriptions_set_input) (updateSubscriptionMutation, error) {
+	var resp updateSubscriptionMutation
+	vars := map[string]interface{}{
+		"id":  uuid(subscriptionID),
+		"set": set,
+	}
+	err := s.client.Mutate(ctx, &resp, vars)
+	return resp, err
+}
+
+// CancelSubscription stops the user's live subscription from renewing.
+func (s *HasuraService) CancelSubscription(ctx context.Context, userID string) (cancelSubscriptionMutation, error) {
+	var resp cancelSubscriptionMutation
+	err := s.client.Mutate(ctx, &resp, map[string]interface{}{"userId": uuid(userID)})
+	return resp, err
+}
+
+// AdvanceSubscriptions expires stale checkouts and moves subscriptions whose
+// period ended to past_due, expired or canceled.
+func (s *HasuraService) AdvanceSubscriptions(ctx context.Context, now time.Time, abandonedBefore time.Time, graceStart time.Time) (advanceSubscriptionsMutation, error) {
+	var resp advanceSubscriptionsMutation
+	vars := map[string]interface{}{
+		"now":             DateTime(now),
+		"abandonedBefore": DateTime(abandonedBefore),
+		"graceStart":      DateTime(graceStart),
+	}
+	err := s.client.Mutate(ctx, &resp, vars)
+	return resp, err
+}
+
+// QueryRenewalDue lists active subscriptions ending before dueBefore whose
+// renewal has not been started yet.
+func (s *HasuraService) QueryRenewalDue(ctx context.Context, dueBefore time.Time) (renewalDueQuery, error) {
+	var resp renewalDueQuery
+	err := s.client.Query(ctx, &resp, map[string]interface{}{"dueBefore": DateTime(dueBefore)})
+	return resp, err
+}
+
+// QueryCreatorSales fetches the paid order items and daily view counts of an
+// author's recipes for orders placed and views counted in [from, to).
+func (s *HasuraService) QueryCreatorSales(ctx context.Context, authorID string, recipeID string, from time.Time, to time.Time) (creatorSalesQuery, error) {
+	var resp creatorSalesQuery
+	recipe := map[string]interface{}{"user_id": map[string]interface{}{"_eq": authorID}}
+	items := order_items_bool_exp{
+		"recipe": recipe,
+		"order": map[string]interface{}{
+			"status":     map[string]interface{}{"_in": []string{"completed", "partially_refunded", "refunded"}},
+			"created_at": map[string]interface{}{"_gte": DateTime(from), "_lt": DateTime(to)},
+		},
+	}
+	views := recipe_view_daily_bool_exp{
+		"recipe": recipe,
+		"day":    map[string]interface{}{"_gte": from.Format("2006-01-02"), "_lt": to.Format("2006-01-02")},
+	}
+	if recipeID != "" {
+		items["recipe_id"] = map[string]interface{}{"_eq": recipeID}
+		views["recipe_id"] = map[string]interface{}{"_eq": recipeID}
+	}
+	vars := map[string]interface{}{"items": items, "views": views}
+	err := s.client.Query(ctx, &resp, vars)
+	return resp, err
+}
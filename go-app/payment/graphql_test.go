@@ -0,0 +1,97 @@
+package payment
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hasura/go-graphql-client"
+)
+
+// fakeHasura is a GraphQL endpoint for handler tests. It answers each root
+// field a test registers with a canned JSON value, leaves every other field
+// out of the response, and records the operations it receives.
+type fakeHasura struct {
+	mu        sync.Mutex
+	responses map[string]func(vars map[string]json.RawMessage) string
+	requests  []graphqlRequest
+}
+
+type graphqlRequest struct {
+	Query     string                     `json:"query"`
+	Variables map[string]json.RawMessage `json:"variables"`
+}
+
+// newFakeHasura starts a fakeHasura and returns a HasuraService that talks to it.
+func newFakeHasura(t *testing.T) (*fakeHasura, *HasuraService) {
+	h := &fakeHasura{responses: make(map[string]func(map[string]json.RawMessage) string)}
+	server := httptest.NewServer(http.HandlerFunc(h.serve))
+	t.Cleanup(server.Close)
+	return h, &HasuraService{client: graphql.NewClient(server.URL, server.Client())}
+}
+
+// on answers the root field with a fixed JSON value.
+func (h *fakeHasura) on(field string, value string) {
+	h.onFunc(field, func(map[string]json.RawMessage) string { return value })
+}
+
+// onFunc answers the root field with a value built from the operation's variables.
+func (h *fakeHasura) onFunc(field string, value func(vars map[string]json.RawMessage) string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.responses[field] = value
+}
+
+// calls returns the operations that selected the root field, oldest first.
+func (h *fakeHasura) calls(field string) []graphqlRequest {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var matched []graphqlRequest
+	for _, req := range h.requests {
+		if selects(req.Query, field) {
+			matched = append(matched, req)
+		}
+	}
+	return matched
+}
+
+func (h *fakeHasura) serve(w http.ResponseWriter, r *http.Request) {
+	var req graphqlRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.mu.Lock()
+	h.requests = append(h.requests, req)
+	var fields []string
+	for field, value := range h.responses {
+		if selects(req.Query, field) {
+			fields = append(fields, `"`+field+`":`+value(req.Variables))
+		}
+	}
+	h.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"data":{` + strings.Join(fields, ",") + `}}`))
+}
+
+// selects reports whether a GraphQL document selects the root field. An
+// aliased field is matched, and answered, by its alias.
+func selects(query string, field string) bool {
+	return regexp.MustCompile(`(^|[^\w])` + regexp.QuoteMeta(field) + `(: \w+)?[({]`).MatchString(query)
+}
+
+// variable decodes one variable of a recorded operation.
+func (r graphqlRequest) variable(t *testing.T, name string, v interface{}) {
+	t.Helper()
+	raw, ok := r.Variables[name]
+	if !ok {
+		t.Fatalf("operation has no $%s variable: %s", name, r.Query)
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		t.Fatalf("decode $%s: %v", name, err)
+	}
+}
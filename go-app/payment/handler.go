@@ -3,20 +3,22 @@ package payment
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"os"
+	"strings"
 	"time"
 
 	google_uuid "github.com/google/uuid"
 )
 
-// HandleInitiateChapaPayment handles the Hasura Action webhook for payment initiation.
-func HandleInitiateChapaPayment(w http.ResponseWriter, r *http.Request, hasuraService *HasuraService, chapaService *ChapaService) {
-	log.Println("🚀 Received /initiate_chapa_payment request")
-	if hasuraService == nil || chapaService == nil {
+// HandleInitiatePayment handles the Hasura Action webhook for payment initiation.
+// It serves both initiatePayment and the older initiate_chapa_payment action.
+func HandleInitiatePayment(w http.ResponseWriter, r *http.Request, hasuraService *HasuraService, providers *ProviderRegistry) {
+	log.Println("🚀 Received /initiatePayment request")
+	if hasuraService == nil || providers == nil {
 		log.Println("❌ Services not initialized")
 		respondWithError(w, http.StatusInternalServerError, "Internal server error: Services not ready")
 		return
@@ -41,136 +43,82 @@ func HandleInitiateChapaPayment(w http.ResponseWriter, r *http.Request, hasuraSe
 		respondWithError(w, http.StatusBadRequest, "Invalid request payload or missing user ID")
 		return
 	}
-
-	// 1. Prepare recipe IDs and quantities
-	recipeIDs := make([]string, len(input.RecipeItems))
-	recipeQuantities := make(map[string]int)
-	for i, item := range input.RecipeItems {
-		recipeIDs[i] = item.RecipeID
-		recipeQuantities[item.RecipeID] = item.Quantity
+	provider, ok := providers.Get(input.Provider)
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Unsupported payment provider: %s", input.Provider))
+		return
 	}
 
+	// 1. Validate the cart and price it from the stored recipes
 	ctx := context.Background()
-	recipesResp, err := hasuraService.QueryRecipeDetails(ctx, recipeIDs)
-	if err != nil || len(recipesResp.Recipes) == 0 {
-		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve recipe details")
+	lines, err := loadCartLines(ctx, hasuraService, buyerID, input.RecipeItems)
+	var cErr cartError
+	if errors.As(err, &cErr) {
+		respondWithCartError(w, cErr)
 		return
 	}
-
-	var backendCalculatedAmount float64
-	var orderItemsForInsertion []order_items_insert_input
-	for _, dbRecipe := range recipesResp.Recipes {
-		quantity := recipeQuantities[dbRecipe.ID]
-		backendCalculatedAmount += dbRecipe.PriceETB * float64(quantity)
-
-		// Pick featured image, fallback to first image if available
-		var imgURL *string
-		for _, img := range dbRecipe.Images {
-			if img.IsFeatured != nil && *img.IsFeatured {
-				imgURL = &img.ImageURL
-				break
-			}
-		}
-		if imgURL == nil && len(dbRecipe.Images) > 0 {
-			imgURL = &dbRecipe.Images[0].ImageURL
-		}
-
-		orderItemsForInsertion = append(orderItemsForInsertion, order_items_insert_input{
-			ID:             uuid(google_uuid.New().String()),
-			RecipeID:       uuid(dbRecipe.ID),
-			Quantity:       quantity,
-			PriceAtPurchase: dbRecipe.PriceETB,
-			RecipeName:     dbRecipe.Title,
-			RecipeImageURL: imgURL,
-			CreatedAt:      DateTime(time.Now()),
-			UpdatedAt:      DateTime(time.Now()),
-		})
-	}
-
-	if fmt.Sprintf("%.2f", input.Amount) != fmt.Sprintf("%.2f", backendCalculatedAmount) {
-		respondWithError(w, http.StatusBadRequest, "Amount mismatch. Please try again.")
+	if err != nil {
+		log.Printf("Failed to load cart for %s: %v", buyerID, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve recipe details")
 		return
 	}
 
-	// 2. Query user details
-	userResp, err := hasuraService.QueryUserDetails(ctx, buyerID)
-	if err != nil || userResp.UsersByPk == nil {
-		respondWithError(w, http.StatusNotFound, "Buyer details not found")
+	currency, rate, err := priceCartLines(ctx, hasuraService, provider, lines, input.Currency)
+	if err != nil {
+		respondWithCheckoutError(w, err)
 		return
 	}
-	user := userResp.UsersByPk
-
-	// 3. Insert pending order
-	//txRef := fmt.Sprintf("chapa-order-%s-%d", google_uuid.New().String(), time.Now().Unix())
-	txRef := fmt.Sprintf("c-%s-%d", google_uuid.New().String()[:8], time.Now().Unix())
 
-	orderObject := orders_insert_input{
-		ID:        uuid(google_uuid.New().String()),
-		UserID:    uuid(buyerID),
-		TotalAmount: backendCalculatedAmount,
-		Currency:  input.Currency,
-		ReturnURL: input.ReturnURL,
-		Status:    "pending",
-		ChapaTxRef: txRef,
-		CreatedAt: DateTime(time.Now()),
-		UpdatedAt: DateTime(time.Now()),
+	// The client amount is checked against the undiscounted cart total in the
+	// chosen currency; any coupon is applied afterwards so the discount is
+	// always computed here.
+	var backendCalculatedAmount Money
+	for _, line := range lines {
+		backendCalculatedAmount += line.total()
 	}
-
-	orderResp, err := hasuraService.InsertOrder(ctx, orderObject)
-	if err != nil || orderResp.InsertOrdersOne == nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to record pending order")
+	if input.Amount != backendCalculatedAmount {
+		respondWithError(w, http.StatusBadRequest, "Amount mismatch. Please try again.")
 		return
 	}
-	orderID := orderResp.InsertOrdersOne.OrderID
 
-	// Set OrderID in order items
-	for i := range orderItemsForInsertion {
-		orderItemsForInsertion[i].OrderID = uuid(orderID)
-	}
-	hasuraService.InsertOrderItems(ctx, orderItemsForInsertion) // Log error but continue
-
-	// 4. Initiate Chapa payment
-	
-	chapaRequest := ChapaInitiateRequest{
-		Amount:      fmt.Sprintf("%.2f", backendCalculatedAmount),
-		Currency:    input.Currency,
-		TxRef:       txRef,
-		Email:       user.Email,
-		FirstName:   user.FirstName,
-		LastName:    user.LastName,
-		PhoneNumber: user.PhoneNumber,
-		CallbackURL: os.Getenv("CHAPA_CALLBACK_URL"),
-		ReturnURL:   getFrontendRedirectURL(input.ReturnURL, "pending", orderID, txRef, ""),
-		Title:       "Food Recipes Order",
-		Description: fmt.Sprintf("Order ID: %s", orderID),
-	}
-log.Printf("Chapa request payload: %+v", chapaRequest)
-
-	chapaResp, err := chapaService.InitiatePayment(ctx, chapaRequest)
+	output, err := startCheckout(ctx, hasuraService, provider, checkoutRequest{
+		buyerID:      buyerID,
+		lines:        lines,
+		currency:     currency,
+		exchangeRate: rate,
+		returnURL:    input.ReturnURL,
+		couponCode:   input.CouponCode,
+		checkoutType: checkoutTypeDirect,
+	})
 	if err != nil {
-		log.Printf("Chapa API error: %v", err)
-		respondWithError(w, http.StatusInternalServerError, "Payment service failed to initiate")
+		respondWithCheckoutError(w, err)
 		return
 	}
-log.Printf("✅ Chapa response: %+v", chapaResp)
-	respondWithJSON(w, http.StatusOK, map[string]string{
-		"checkoutUrl": chapaResp.Data.CheckoutURL,
-		"message":     "Payment initiated successfully",
-		"orderId":     orderID,
-		"txRef":       txRef,
-	})
+	respondWithJSON(w, http.StatusOK, output)
 }
 
-// HandleChapaCallback handles the webhook callback from Chapa after a payment attempt.
-func HandleChapaCallback(w http.ResponseWriter, r *http.Request, hasuraService *HasuraService, chapaService *ChapaService) {
-	txRef := r.URL.Query().Get("trx_ref")
-log.Printf("🚀 Chapa callback received: tx_ref=%s, full query=%v", txRef, r.URL.Query())
+// HandlePaymentCallback handles the webhook callback from a payment provider after a payment attempt.
+// Browser redirects (GET) are sent on to the frontend; server-to-server
+// notifications (POST) get a JSON acknowledgement instead.
+func HandlePaymentCallback(w http.ResponseWriter, r *http.Request, hasuraService *HasuraService, providers *ProviderRegistry, providerName string) {
+	provider, ok := providers.Get(providerName)
+	if !ok {
+		respondWithError(w, http.StatusNotFound, fmt.Sprintf("Unsupported payment provider: %s", providerName))
+		return
+	}
+	event, err := provider.ParseWebhook(r)
+	txRef := event.TxRef
+log.Printf("🚀 %s callback received: tx_ref=%s, full query=%v", provider.Name(), txRef, r.URL.Query())
 
 	
-	if txRef == "" {
+	if err != nil {
 		respondWithError(w, http.StatusBadRequest, "Missing tx_ref in query")
 		return
 	}
+	if isSubscriptionTxRef(txRef) {
+		handleSubscriptionCallback(w, r, hasuraService, provider, txRef)
+		return
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
 	defer cancel()
@@ -180,39 +128,414 @@ log.Printf("🚀 Chapa callback received: tx_ref=%s, full query=%v", txRef, r.UR
 log.Printf("Queried order for txRef=%s: %+v, err=%v", txRef, orderData, err)
 
 	
-	if err != nil || len(orderData) == 0 {
+	if err != nil || len(orderData) == 0 || orderData[0].PaymentProvider != provider.Name() {
 		log.Printf("Failed to query order for TxRef %s: %v", txRef, err)
 		http.Error(w, "Order not found for verification", http.StatusOK)
 		return
 	}
 	originalReturnURL := orderData[0].ReturnURL
 	orderID := orderData[0].OrderID
+	orderTotal := orderData[0].TotalAmount
+	orderCurrency := orderData[0].Currency
 
-	// Verify with Chapa
-	chapaVerifyResp, err := chapaService.VerifyPayment(ctx, txRef)
-	log.Printf("Chapa verification response: %+v, err=%v", chapaVerifyResp, err)
+	// Verify with the provider
+	verification, err := provider.VerifyPayment(ctx, txRef)
+	log.Printf("%s verification response: %+v, err=%v", provider.Name(), verification, err)
 	if err != nil {
-		log.Printf("Failed to verify transaction %s with Chapa: %v", txRef, err)
+		log.Printf("Failed to verify transaction %s with %s: %v", txRef, provider.Name(), err)
 		finalRedirectURL := getFrontendRedirectURL(originalReturnURL, "failure", orderID, txRef, "Verification failed")
-		http.Redirect(w, r, finalRedirectURL, http.StatusFound)
+		finishCallback(w, r, finalRedirectURL, "failure")
 		return
 	}
 
-	status := chapaVerifyResp.Data.Status
+	status := verification.Status
 	dbStatus := "unknown"
 	message := "Payment status unknown."
-	if status == "success" {
+	if status == PaymentStatusSuccess {
 		dbStatus = "completed"
 		message = "Your payment was successful!"
-	} else if status == "failed" {
+	} else if status == PaymentStatusFailed {
 		dbStatus = "failed"
 		message = "Your payment failed."
 	}
+	if status == PaymentStatusSuccess && (verification.Amount != orderTotal || !strings.EqualFold(verification.Currency, orderCurrency)) {
+		log.Printf("❌ Amount mismatch for %s: paid %s %s, order total %s %s", txRef, verification.Amount, verification.Currency, orderTotal, orderCurrency)
+		dbStatus = "amount_mismatch"
+		message = "Your payment amount did not match the order. Please contact support."
+	}
 
-	// Update order with Chapa transaction ID
-	chapaTxID := chapaVerifyResp.Data.ID
-	hasuraService.UpdateOrderStatus(ctx, txRef, dbStatus, chapaTxID)
+	// Update order with the provider's transaction ID. Only the first callback
+	// to move the order out of pending runs the completion bookkeeping.
+	chapaTxID := verification.TransactionID
+	updateResp, err := hasuraService.UpdateOrderStatus(ctx, txRef, dbStatus, chapaTxID)
+	if err != nil {
+		log.Printf("Failed to update order %s to %s: %v", orderID, dbStatus, err)
+	} else if dbStatus == "completed" && updateResp.UpdateOrders != nil && updateResp.UpdateOrders.AffectedRows > 0 {
+		onOrderCompleted(ctx, hasuraService, orderData[0])
+	}
 
 	finalRedirectURL := getFrontendRedirectURL(originalReturnURL, dbStatus, orderID, txRef, message)
-	http.Redirect(w, r, finalRedirectURL, http.StatusFound)
+	finishCallback(w, r, finalRedirectURL, dbStatus)
+}
+
+// finishCallback redirects browsers back to the frontend and acknowledges
+// server-to-server notifications.
+func finishCallback(w http.ResponseWriter, r *http.Request, redirectURL string, status string) {
+	if r.Method == http.MethodGet {
+		http.Redirect(w, r, redirectURL, http.StatusFound)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, map[string]string{"status": status})
+}
+
+var errRefundForbidden = errors.New("you can only refund items for your own recipes")
+
+// refundLine is a single order item and the quantity being refunded from it.
+type refundLine struct {
+	item     refundableOrderItem
+	quantity int
+	amount   Money
+}
+
+// selectRefundLines resolves the requested items into refund lines. An empty
+// request refunds everything still refundable that the caller may refund.
+func selectRefundLines(items []refundableOrderItem, requested []RefundItemInput, callerID string, isAdmin bool) ([]refundLine, error) {
+	canRefund := func(item refundableOrderItem) bool {
+		return isAdmin || item.Recipe.UserID == callerID
+	}
+
+	var lines []refundLine
+	if len(requested) == 0 {
+		authored := false
+		for _, item := range items {
+			if !canRefund(item) {
+				continue
+			}
+			authored = true
+			if remaining := item.Quantity - item.RefundedQuantity; remaining > 0 {
+				lines = append(lines, newRefundLine(item, remaining))
+			}
+		}
+		if !authored {
+			return nil, errRefundForbidden
+		}
+		if len(lines) == 0 {
+			return nil, errors.New("nothing left to refund on this order")
+		}
+		return lines, nil
+	}
+
+	itemsByID := make(map[string]refundableOrderItem, len(items))
+	for _, item := range items {
+		itemsByID[item.ID] = item
+	}
+	seen := make(map[string]bool, len(requested))
+	for _, req := range requested {
+		item, ok := itemsByID[req.OrderItemID]
+		if !ok {
+			return nil, fmt.Errorf("order item %s does not belong to this order", req.OrderItemID)
+		}
+		if seen[req.OrderItemID] {
+			return nil, fmt.Errorf("order item %s is listed more than once", req.OrderItemID)
+		}
+		seen[req.OrderItemID] = true
+		if !canRefund(item) {
+			return nil, errRefundForbidden
+		}
+		remaining := item.Quantity - item.RefundedQuantity
+		quantity := remaining
+		if req.Quantity != nil {
+			quantity = *req.Quantity
+		}
+		if quantity <= 0 || quantity > remaining {
+			return nil, fmt.Errorf("order item %s has %d refundable units", req.OrderItemID, remaining)
+		}
+		lines = append(lines, newRefundLine(item, quantity))
+	}
+	return lines, nil
+}
+
+// newRefundLine prices a refund line from what was actually paid for the
+// item, after any coupon discount. Refunding the last units returns whatever
+// is left on the item so earlier partial refunds cannot drift.
+func newRefundLine(item refundableOrderItem, quantity int) refundLine {
+	paid := item.PriceAtPurchase.Mul(item.Quantity) - item.DiscountAmount
+	amount := Money(int64(paid) * int64(quantity) / int64(item.Quantity))
+	if item.RefundedQuantity+quantity == item.Quantity {
+		amount = paid - item.RefundedAmount
+	}
+	return refundLine{item: item, quantity: quantity, amount: amount}
+}
+
+// refundOrder is an order resolved for a refund request: the provider it was
+// paid with and the lines and amount the request refunds.
+type refundOrder struct {
+	order    *refundableOrder
+	provider PaymentProvider
+	lines    []refundLine
+	amount   Money
+}
+
+// planRefund loads the order and checks that the caller may refund what was
+// requested. Failures are checkoutErrors carrying the status to report.
+func planRefund(ctx context.Context, hasuraService *HasuraService, providers *ProviderRegistry, input RefundOrderInput, callerID string, isAdmin bool) (refundOrder, error) {
+	orderResp, err := hasuraService.QueryOrderForRefund(ctx, input.OrderID)
+	if err != nil {
+		return refundOrder{}, fmt.Errorf("query order %s for refund: %w", input.OrderID, err)
+	}
+	order := orderResp.OrdersByPk
+	if order == nil {
+		return refundOrder{}, checkoutError{http.StatusNotFound, "Order not found"}
+	}
+	if order.Status != "completed" && order.Status != "partially_refunded" {
+		return refundOrder{}, checkoutError{http.StatusBadRequest, "Only completed orders can be refunded"}
+	}
+	provider, ok := providers.Get(order.PaymentProvider)
+	if !ok {
+		log.Printf("Order %s was paid with %s, which is not enabled", order.ID, order.PaymentProvider)
+		return refundOrder{}, checkoutError{http.StatusInternalServerError, "Payment provider for this order is not available"}
+	}
+
+	lines, err := selectRefundLines(order.OrderItems, input.Items, callerID, isAdmin)
+	if errors.Is(err, errRefundForbidden) {
+		return refundOrder{}, checkoutError{http.StatusForbidden, "You can only refund items for your own recipes"}
+	}
+	if err != nil {
+		return refundOrder{}, checkoutError{http.StatusBadRequest, err.Error()}
+	}
+
+	var amount Money
+	for _, line := range lines {
+		amount += line.amount
+	}
+	if amount <= 0 || order.RefundedAmount+amount > order.TotalAmount {
+		return refundOrder{}, checkoutError{http.StatusBadRequest, "Refund amount exceeds the amount paid"}
+	}
+	return refundOrder{order: order, provider: provider, lines: lines, amount: amount}, nil
+}
+
+// refundReference is the reference a refund is sent to the provider under.
+// It is derived from the refund record, so every retry of the refund uses
+// the same one.
+func refundReference(refundID string) string {
+	return "r-" + refundID
+}
+
+// respondWithRefundError reports a refund that could not be started.
+func respondWithRefundError(w http.ResponseWriter, err error) {
+	var cErr checkoutError
+	if errors.As(err, &cErr) {
+		respondWithError(w, cErr.status, cErr.message)
+		return
+	}
+	log.Printf("Refund failed: %v", err)
+	respondWithError(w, http.StatusInternalServerError, "Failed to retrieve order")
+}
+
+// HandleRefundOrder handles the Hasura Action webhook for full or per-item partial refunds.
+// Admins may refund any order; authors may only refund items for their own recipes.
+// Refunds of one order run one at a time: the order is locked before the
+// refund is priced and stays locked until the refund is recorded or failed.
+func HandleRefundOrder(w http.ResponseWriter, r *http.Request, hasuraService *HasuraService, providers *ProviderRegistry) {
+	log.Println("🚀 Received /refundOrder request")
+	if hasuraService == nil || providers == nil {
+		log.Println("❌ Services not initialized")
+		respondWithError(w, http.StatusInternalServerError, "Internal server error: Services not ready")
+		return
+	}
+
+	var payload RefundOrderActionPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Failed to parse Hasura Action payload")
+		return
+	}
+	defer r.Body.Close()
+
+	input := payload.Input.Input
+	callerID := payload.SessionVariables["x-hasura-user-id"]
+	isAdmin := isAdminSession(r, payload.SessionVariables)
+	if input.OrderID == "" || (callerID == "" && !isAdmin) {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload or missing user ID")
+		return
+	}
+	if _, err := google_uuid.Parse(input.OrderID); err != nil {
+		respondWithError(w, http.StatusNotFound, "Order not found")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// 1. Check the request against the order, then lock the order and check
+	// it again, so the amounts below include every earlier refund. The first
+	// check is not redundant: it turns away requests that can never succeed,
+	// such as an author refunding someone else's recipe, before they take
+	// the lock and make a legitimate refund of the order fail with a 409.
+	if _, err := planRefund(ctx, hasuraService, providers, input, callerID, isAdmin); err != nil {
+		respondWithRefundError(w, err)
+		return
+	}
+	now := time.Now()
+	locked, err := hasuraService.LockOrderForRefund(ctx, input.OrderID, now, now.Add(-refundLockTTL()))
+	if err != nil {
+		log.Printf("Failed to lock order %s for refund: %v", input.OrderID, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to start refund")
+		return
+	}
+	if !locked {
+		respondWithError(w, http.StatusConflict, "Another refund of this order is in progress. Please try again.")
+		return
+	}
+	plan, err := planRefund(ctx, hasuraService, providers, input, callerID, isAdmin)
+	if err != nil {
+		if err := hasuraService.UnlockOrderForRefund(ctx, input.OrderID); err != nil {
+			log.Printf("Failed to unlock order %s: %v", input.OrderID, err)
+		}
+		respondWithRefundError(w, err)
+		return
+	}
+	order, provider, lines, refundAmount := plan.order, plan.provider, plan.lines, plan.amount
+
+	reason := input.Reason
+	if reason == "" {
+		reason = "Order refund"
+	}
+
+	// 2. Record the pending refund. Its reference is sent with every attempt
+	// at the refund, so the provider pays it back at most once.
+	refundID := google_uuid.New().String()
+	refundRef := refundReference(refundID)
+	refundObject := refunds_insert_input{
+		ID:        uuid(refundID),
+		OrderID:   uuid(order.ID),
+		Amount:    refundAmount,
+		Currency:  order.Currency,
+		Reason:    reason,
+		Reference: refundRef,
+		Status:    "pending",
+		Items:     refundLineItems(lines),
+		CreatedAt: DateTime(time.Now()),
+		UpdatedAt: DateTime(time.Now()),
+	}
+	if callerID != "" {
+		requestedBy := uuid(callerID)
+		refundObject.RequestedBy = &requestedBy
+	}
+	if _, err := hasuraService.InsertRefund(ctx, refundObject); err != nil {
+		log.Printf("Failed to record refund for order %s: %v", order.ID, err)
+		if err := hasuraService.UnlockOrderForRefund(ctx, order.ID); err != nil {
+			log.Printf("Failed to unlock order %s: %v", order.ID, err)
+		}
+		respondWithError(w, http.StatusInternalServerError, "Failed to record refund")
+		return
+	}
+
+	// 3. Refund through the payment provider
+	refundResult, err := provider.Refund(ctx, order.ChapaTxRef, RefundRequest{
+		Reason:    reason,
+		Amount:    refundAmount,
+		Currency:  order.Currency,
+		Reference: refundRef,
+	})
+	if err != nil {
+		var rejected RefundRejectedError
+		if !errors.As(err, &rejected) {
+			// The refund may still go through, so it stays pending and the
+			// order locked until reconcile_refunds retries it under the
+			// same reference
+			log.Printf("⚠️ %s refund %s (%s) for order %s has an unknown outcome: %v", provider.Name(), refundID, refundRef, order.ID, err)
+			respondWithError(w, http.StatusGatewayTimeout, fmt.Sprintf("The payment service did not confirm the refund. It will be retried. Reference: %s", refundRef))
+			return
+		}
+		log.Printf("%s refund error for order %s: %v", provider.Name(), order.ID, err)
+		failed := "failed"
+		failedAt := DateTime(time.Now())
+		if _, err := hasuraService.FailRefund(ctx, order.ID, refundID, refunds_set_input{Status: &failed, UpdatedAt: &failedAt}); err != nil {
+			log.Printf("Failed to mark refund %s as failed: %v", refundID, err)
+		}
+		respondWithError(w, http.StatusBadGateway, "Payment service declined the refund")
+		return
+	}
+
+	// 4. Update items, order, purchases and the refund record together
+	orderStatus, err := applyRefund(ctx, hasuraService, plan, refundID, refundRef, refundResult.ProviderReference)
+	if err != nil {
+		// The refund stays pending and the order locked until
+		// reconcile_refunds records it
+		log.Printf("❌ Refund %s (%s) succeeded at the provider but could not be recorded: %v", refundID, refundRef, err)
+		respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Refund processed but not recorded. Reference: %s", refundRef))
+		return
+	}
+
+	log.Printf("✅ Refunded %s %s on order %s (ref %s)", refundAmount, order.Currency, order.ID, refundRef)
+	respondWithJSON(w, http.StatusOK, RefundOrderOutput{
+		Success:         true,
+		Message:         "Refund processed successfully",
+		OrderID:         order.ID,
+		RefundID:        refundID,
+		RefundReference: refundRef,
+		RefundedAmount:  refundAmount,
+		OrderStatus:     orderStatus,
+	})
+}
+
+// applyRefund records a refund the provider has made: the refunded items
+// and their order, the revoked purchases and the refund itself, along with
+// its ledger posting, in one transaction that also releases the order. It
+// returns the order's new status.
+func applyRefund(ctx context.Context, hasuraService *HasuraService, plan refundOrder, refundID string, refundRef string, providerRef string) (string, error) {
+	order, lines, refundAmount := plan.order, plan.lines, plan.amount
+	now := DateTime(time.Now())
+	remainingAfter := make(map[string]int, len(order.OrderItems))
+	for _, item := range order.OrderItems {
+		remainingAfter[item.ID] = item.Quantity - item.RefundedQuantity
+	}
+
+	var itemUpdates []order_items_updates
+	var revokedRecipeIDs []string
+	for _, line := range lines {
+		refundedQuantity := line.item.RefundedQuantity + line.quantity
+		refundedAmount := line.item.RefundedAmount + line.amount
+		itemStatus := "partially_refunded"
+		if refundedQuantity == line.item.Quantity {
+			itemStatus = "refunded"
+			revokedRecipeIDs = append(revokedRecipeIDs, line.item.RecipeID)
+		}
+		remainingAfter[line.item.ID] -= line.quantity
+		itemUpdates = append(itemUpdates, order_items_updates{
+			Where: map[string]interface{}{"id": map[string]interface{}{"_eq": line.item.ID}},
+			Set: order_items_set_input{
+				Status:           &itemStatus,
+				RefundedQuantity: &refundedQuantity,
+				RefundedAmount:   &refundedAmount,
+				RefundReference:  &refundRef,
+				UpdatedAt:        &now,
+			},
+		})
+	}
+
+	orderStatus := "refunded"
+	for _, remaining := range remainingAfter {
+		if remaining > 0 {
+			orderStatus = "partially_refunded"
+			break
+		}
+	}
+	orderRefunded := order.RefundedAmount + refundAmount
+	completed := "completed"
+	unlocked := false
+	chapaReference := providerRef
+
+	_, err := hasuraService.ApplyRefund(ctx, order.ID, order.UserID, itemUpdates,
+		orders_set_input{Status: &orderStatus, RefundedAmount: &orderRefunded, RefundReference: &refundRef, RefundInProgress: &unlocked, UpdatedAt: &now},
+		revokedRecipeIDs,
+		purchases_set_input{RevokedAt: &now, RefundReference: &refundRef},
+		refundID,
+		refunds_set_input{Status: &completed, ChapaReference: &chapaReference, UpdatedAt: &now},
+		refundLedgerTransaction(order.ID, refundID, order.Currency, orderCommissionPercent(order.PlatformCommissionPercent), lines),
+	)
+	if err != nil {
+		return "", err
+	}
+	return orderStatus, nil
 }
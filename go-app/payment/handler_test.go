@@ -0,0 +1,267 @@
+package payment
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func refundItem(id string, authorID string, quantity int, price Money, discount Money) refundableOrderItem {
+	item := refundableOrderItem{ID: id, Quantity: quantity, PriceAtPurchase: price, DiscountAmount: discount}
+	item.Recipe.UserID = authorID
+	return item
+}
+
+func TestNewRefundLine(t *testing.T) {
+	item := refundItem("i1", "a1", 3, 1010, 0)
+	if line := newRefundLine(item, 2); line.amount != 2020 || line.quantity != 2 {
+		t.Errorf("two of three units = %d for %s, want 2 for 20.20", line.quantity, line.amount)
+	}
+
+	// The last unit returns whatever is left, so the total refunded always
+	// matches what was paid.
+	item.RefundedQuantity, item.RefundedAmount = 2, 2019
+	if line := newRefundLine(item, 1); line.amount != 1011 {
+		t.Errorf("last unit = %s, want 10.11", line.amount)
+	}
+}
+
+// A coupon discount is refunded in proportion to the units returned, and
+// refunding an item one unit at a time returns exactly what was paid.
+func TestNewRefundLineAfterCoupon(t *testing.T) {
+	item := refundItem("i1", "a1", 3, 1000, 100)
+	if line := newRefundLine(item, 1); line.amount != 966 {
+		t.Errorf("one of three units = %s, want 9.66", line.amount)
+	}
+	if line := newRefundLine(item, 3); line.amount != 2900 {
+		t.Errorf("whole item = %s, want 29.00", line.amount)
+	}
+
+	for _, item := range []refundableOrderItem{
+		refundItem("i1", "a1", 3, 1000, 100),
+		refundItem("i2", "a1", 7, 333, 1),
+		refundItem("i3", "a1", 1, 5000, 5000),
+	} {
+		paid := item.PriceAtPurchase.Mul(item.Quantity) - item.DiscountAmount
+		for item.RefundedQuantity < item.Quantity {
+			line := newRefundLine(item, 1)
+			item.RefundedQuantity++
+			item.RefundedAmount += line.amount
+			if item.RefundedAmount > paid {
+				t.Fatalf("item %s refunded %s of %s paid", item.ID, item.RefundedAmount, paid)
+			}
+		}
+		if item.RefundedAmount != paid {
+			t.Errorf("item %s refunded %s in total, want %s", item.ID, item.RefundedAmount, paid)
+		}
+	}
+}
+
+func TestSelectRefundLines(t *testing.T) {
+	quantity := func(n int) *int { return &n }
+	partly := refundItem("i2", "a2", 3, 500, 0)
+	partly.RefundedQuantity, partly.RefundedAmount = 1, 500
+	items := []refundableOrderItem{
+		refundItem("i1", "a1", 1, 1000, 0),
+		partly,
+		refundItem("i3", "a1", 2, 700, 0),
+	}
+
+	tests := []struct {
+		name      string
+		requested []RefundItemInput
+		callerID  string
+		isAdmin   bool
+		want      map[string]int
+		wantErr   string
+		forbidden bool
+	}{
+		{name: "admin refunds everything left", isAdmin: true, want: map[string]int{"i1": 1, "i2": 2, "i3": 2}},
+		{name: "author refunds their own items", callerID: "a1", want: map[string]int{"i1": 1, "i3": 2}},
+		{name: "author of nothing on the order", callerID: "a9", forbidden: true},
+		{
+			name:      "requested quantity",
+			requested: []RefundItemInput{{OrderItemID: "i3", Quantity: quantity(1)}},
+			isAdmin:   true,
+			want:      map[string]int{"i3": 1},
+		},
+		{
+			name:      "defaults to the remaining units",
+			requested: []RefundItemInput{{OrderItemID: "i2"}},
+			callerID:  "a2",
+			want:      map[string]int{"i2": 2},
+		},
+		{
+			name:      "other author's item",
+			requested: []RefundItemInput{{OrderItemID: "i2"}},
+			callerID:  "a1",
+			forbidden: true,
+		},
+		{
+			name:      "more than remains",
+			requested: []RefundItemInput{{OrderItemID: "i2", Quantity: quantity(3)}},
+			isAdmin:   true,
+			wantErr:   "order item i2 has 2 refundable units",
+		},
+		{
+			name:      "zero units",
+			requested: []RefundItemInput{{OrderItemID: "i1", Quantity: quantity(0)}},
+			isAdmin:   true,
+			wantErr:   "order item i1 has 1 refundable units",
+		},
+		{
+			name:      "unknown item",
+			requested: []RefundItemInput{{OrderItemID: "nope"}},
+			isAdmin:   true,
+			wantErr:   "order item nope does not belong to this order",
+		},
+		{
+			name:      "listed twice",
+			requested: []RefundItemInput{{OrderItemID: "i1"}, {OrderItemID: "i1"}},
+			isAdmin:   true,
+			wantErr:   "order item i1 is listed more than once",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines, err := selectRefundLines(items, tt.requested, tt.callerID, tt.isAdmin)
+			switch {
+			case tt.forbidden:
+				if !errors.Is(err, errRefundForbidden) {
+					t.Fatalf("error = %v, want errRefundForbidden", err)
+				}
+				return
+			case tt.wantErr != "":
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			case err != nil:
+				t.Fatal(err)
+			}
+			got := map[string]int{}
+			for _, line := range lines {
+				got[line.item.ID] = line.quantity
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("refunds %v, want %v", got, tt.want)
+			}
+			for id, quantity := range tt.want {
+				if got[id] != quantity {
+					t.Errorf("refunds %v, want %v", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestSelectRefundLinesNothingLeft(t *testing.T) {
+	item := refundItem("i1", "a1", 1, 1000, 0)
+	item.RefundedQuantity, item.RefundedAmount = 1, 1000
+	if _, err := selectRefundLines([]refundableOrderItem{item}, nil, "a1", false); err == nil {
+		t.Error("selectRefundLines refunded an item twice")
+	}
+}
+
+// refundProvider stands in for a provider whose refunds end with err, or
+// succeed when err is nil, and records each refund it is asked for.
+type refundProvider struct {
+	*FakeProvider
+	err   error
+	calls []RefundRequest
+}
+
+func (p *refundProvider) Refund(ctx context.Context, txRef string, req RefundRequest) (RefundResult, error) {
+	p.calls = append(p.calls, req)
+	if p.err != nil {
+		return RefundResult{}, p.err
+	}
+	return RefundResult{ProviderReference: "provider-" + req.Reference}, nil
+}
+
+// refundOrderFixture answers the queries of a refund of a completed order
+// with one item, paid with provider.
+func refundOrderFixture(t *testing.T, provider *refundProvider) (*fakeHasura, func() *httptest.ResponseRecorder) {
+	hasura, hasuraService := newFakeHasura(t)
+	hasura.on("orders_by_pk", `{"id":"`+testOrderID+`","user_id":"b1","status":"completed","currency":"ETB","total_amount":100,
+		"chapa_tx_ref":"c-1","payment_provider":"fake","order_items":[{"id":"i1","recipe_id":"r1","quantity":1,"price_at_purchase":100,"recipe":{"user_id":"a1"}}]}`)
+	hasura.on("update_orders", `{"affected_rows":1}`)
+	hasura.on("insert_refunds_one", `{"id":"rf1"}`)
+	hasura.on("update_refunds_by_pk", `{"id":"rf1"}`)
+	hasura.on("update_orders_by_pk", `{"id":"`+testOrderID+`"}`)
+	providers := &ProviderRegistry{providers: map[string]PaymentProvider{"fake": provider}, defaultName: "fake"}
+	refund := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		HandleRefundOrder(rec, adminActionRequest(t, `{"input":{"input":{"orderId":"`+testOrderID+`"}},"session_variables":{"x-hasura-role":"admin"}}`), hasuraService, providers)
+		return rec
+	}
+	return hasura, refund
+}
+
+func TestRefundOrderOutcome(t *testing.T) {
+	t.Run("refunded", func(t *testing.T) {
+		provider := &refundProvider{FakeProvider: NewFakeProvider()}
+		hasura, refund := refundOrderFixture(t, provider)
+		rec := refund()
+		var output RefundOrderOutput
+		json.Unmarshal(rec.Body.Bytes(), &output)
+		if rec.Code != http.StatusOK || output.OrderStatus != "refunded" || output.RefundedAmount != 10000 {
+			t.Fatalf("refund = %d %s", rec.Code, rec.Body)
+		}
+		var recorded refunds_insert_input
+		hasura.calls("insert_refunds_one")[0].variable(t, "object", &recorded)
+		if len(provider.calls) != 1 || provider.calls[0].Reference != recorded.Reference ||
+			recorded.Reference != refundReference(string(recorded.ID)) || output.RefundReference != recorded.Reference {
+			t.Errorf("refund %s recorded as %s, sent as %+v", recorded.ID, recorded.Reference, provider.calls)
+		}
+		if len(recorded.Items) != 1 || recorded.Items[0].OrderItemID != "i1" || recorded.Items[0].Quantity == nil || *recorded.Items[0].Quantity != 1 {
+			t.Errorf("refund recorded items %+v", recorded.Items)
+		}
+
+		// A lock older than the TTL is taken over only when no refund is
+		// pending behind it.
+		lock := hasura.calls("update_orders")[0]
+		var now, staleBefore DateTime
+		lock.variable(t, "now", &now)
+		lock.variable(t, "staleBefore", &staleBefore)
+		if age := time.Time(now).Sub(time.Time(staleBefore)); age != refundLockTTL() {
+			t.Errorf("lock taken at %v treats locks older than %v as stale", time.Time(now), age)
+		}
+		if !strings.Contains(lock.Query, `{refund_locked_at: {_lt: $staleBefore}, _not: {refunds: {status: {_eq: "pending"}}}}`) {
+			t.Errorf("lock mutation:\n%s", lock.Query)
+		}
+	})
+
+	t.Run("declined", func(t *testing.T) {
+		provider := &refundProvider{FakeProvider: NewFakeProvider(), err: RefundRejectedError{Provider: "fake", Reason: "insufficient balance"}}
+		hasura, refund := refundOrderFixture(t, provider)
+		if rec := refund(); rec.Code != http.StatusBadGateway {
+			t.Fatalf("refund = %d %s, want 502", rec.Code, rec.Body)
+		}
+		var set refunds_set_input
+		hasura.calls("update_refunds_by_pk")[0].variable(t, "refundSet", &set)
+		if set.Status == nil || *set.Status != "failed" || len(hasura.calls("update_orders_by_pk")) != 1 {
+			t.Errorf("declined refund marked %+v; order unlocked %d times", set, len(hasura.calls("update_orders_by_pk")))
+		}
+	})
+
+	// A timeout may still have refunded the buyer, so the refund stays
+	// pending and the order locked rather than being marked failed.
+	t.Run("unknown", func(t *testing.T) {
+		provider := &refundProvider{FakeProvider: NewFakeProvider(), err: context.DeadlineExceeded}
+		hasura, refund := refundOrderFixture(t, provider)
+		rec := refund()
+		if rec.Code != http.StatusGatewayTimeout || !strings.Contains(rec.Body.String(), "r-") {
+			t.Fatalf("refund = %d %s, want 504 with the reference", rec.Code, rec.Body)
+		}
+		if n := len(hasura.calls("update_refunds_by_pk")) + len(hasura.calls("update_orders_by_pk")); n != 0 {
+			t.Errorf("refund with an unknown outcome was settled or unlocked (%d updates)", n)
+		}
+	})
+}
@@ -0,0 +1,333 @@
+package payment
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/wubshet-kebede/go-app/pdf"
+)
+
+const defaultVATRatePercent = "15"
+
+// maxInvoiceBackfill caps how many missing invoices one cron run issues.
+const maxInvoiceBackfill = 100
+
+// vatRatePercent reads VAT_RATE_PERCENT, the VAT rate included in recipe
+// prices. It accepts up to two decimals, e.g. "15" or "7.5".
+func vatRatePercent() Money {
+	raw := os.Getenv("VAT_RATE_PERCENT")
+	if raw == "" {
+		raw = defaultVATRatePercent
+	}
+	rate, err := ParseMoney(raw)
+	if err != nil || rate < 0 {
+		log.Printf("Invalid VAT_RATE_PERCENT %q, using %s%%", raw, defaultVATRatePercent)
+		rate, _ = ParseMoney(defaultVATRatePercent)
+	}
+	return rate
+}
+
+// fiscalYear returns the fiscal year t falls in, named after the calendar
+// year it starts in. FISCAL_YEAR_START_MONTH (1-12, default 1) sets the
+// month it starts on.
+func fiscalYear(t time.Time) int {
+	startMonth := 1
+	if raw := os.Getenv("FISCAL_YEAR_START_MONTH"); raw != "" {
+		if month, err := strconv.Atoi(raw); err == nil && month >= 1 && month <= 12 {
+			startMonth = month
+		} else {
+			log.Printf("Invalid FISCAL_YEAR_START_MONTH %q, using January", raw)
+		}
+	}
+	if int(t.Month()) < startMonth {
+		return t.Year() - 1
+	}
+	return t.Year()
+}
+
+// splitVAT separates the VAT included in a gross amount, rounding the net
+// amount half up so net and VAT always add back up to the gross.
+func splitVAT(gross Money, rate Money) (net Money, vat Money) {
+	denominator := 10000 + int64(rate)
+	net = Money((int64(gross)*10000*2 + denominator) / (2 * denominator))
+	return net, gross - net
+}
+
+// buildInvoice prices every order item as paid, after coupon discounts, and
+// splits out the VAT included in it.
+func buildInvoice(order callbackOrder, issuedAt time.Time) invoices_insert_input {
+	rate := vatRatePercent()
+	invoice := invoices_insert_input{
+		OrderID:    uuid(order.OrderID),
+		FiscalYear: fiscalYear(issuedAt),
+		Currency:   order.Currency,
+		VatRate:    rate,
+		IssuedAt:   DateTime(issuedAt),
+	}
+	for i, item := range order.OrderItems {
+		gross := item.PriceAtPurchase.Mul(item.Quantity) - item.DiscountAmount
+		net, vat := splitVAT(gross, rate)
+		invoice.InvoiceItems.Data = append(invoice.InvoiceItems.Data, invoice_items_insert_input{
+			OrderItemID:    uuid(item.ID),
+			LineNumber:     i + 1,
+			Description:    item.RecipeName,
+			Quantity:       item.Quantity,
+			UnitPrice:      item.PriceAtPurchase,
+			DiscountAmount: item.DiscountAmount,
+			NetAmount:      net,
+			VatAmount:      vat,
+			TotalAmount:    gross,
+		})
+		invoice.Subtotal += net
+		invoice.VatAmount += vat
+		invoice.TotalAmount += gross
+	}
+	return invoice
+}
+
+// issueInvoice numbers and records the invoice for a completed order. The
+// invoice is unique per order, so a replayed callback cannot issue a second
+// one, and its failed insert does not use up a number. Orders left without
+// an invoice are picked up again by HandleIssueMissingInvoices.
+func issueInvoice(ctx context.Context, hasuraService *HasuraService, order callbackOrder) error {
+	if len(order.OrderItems) == 0 {
+		return nil
+	}
+	resp, err := hasuraService.InsertInvoice(ctx, buildInvoice(order, time.Now()))
+	if err == nil && resp.InsertInvoicesOne == nil {
+		err = fmt.Errorf("no invoice returned")
+	}
+	if err != nil {
+		log.Printf("❌ Failed to issue invoice for order %s: %v", order.OrderID, err)
+		return err
+	}
+	log.Printf("✅ Issued invoice %s for order %s", resp.InsertInvoicesOne.InvoiceNumber, order.OrderID)
+	return nil
+}
+
+// HandleIssueMissingInvoices handles the Hasura cron trigger that issues the
+// invoices of paid orders whose invoice failed when they completed, so no
+// paid order stays without one.
+func HandleIssueMissingInvoices(w http.ResponseWriter, r *http.Request, hasuraService *HasuraService) {
+	if !validCronRequest(r) {
+		respondWithError(w, http.StatusUnauthorized, "Invalid cron secret")
+		return
+	}
+	defer r.Body.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	orders, err := hasuraService.QueryOrdersMissingInvoice(ctx, maxInvoiceBackfill)
+	if err != nil {
+		log.Printf("Failed to query orders missing an invoice: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve orders")
+		return
+	}
+	output := IssueMissingInvoicesOutput{}
+	for _, order := range orders {
+		if err := issueInvoice(ctx, hasuraService, order); err != nil {
+			output.Failed++
+			continue
+		}
+		output.Issued++
+	}
+	if len(orders) > 0 {
+		log.Printf("🧾 Issued %d missing invoices, %d failed", output.Issued, output.Failed)
+	}
+	respondWithJSON(w, http.StatusOK, output)
+}
+
+// invoiceURL is where the buyer can view the invoice for an order; the PDF
+// is served at the same path with a .pdf extension.
+func invoiceURL(orderID string) string {
+	return fmt.Sprintf("%s/orders/%s/invoice.html", publicBaseURL(), orderID)
+}
+
+// invoiceSeller holds the details printed in every invoice header.
+type invoiceSeller struct {
+	Name    string
+	TIN     string
+	Address string
+}
+
+func invoiceSellerFromEnv() invoiceSeller {
+	seller := invoiceSeller{
+		Name:    os.Getenv("INVOICE_SELLER_NAME"),
+		TIN:     os.Getenv("INVOICE_SELLER_TIN"),
+		Address: os.Getenv("INVOICE_SELLER_ADDRESS"),
+	}
+	if seller.Name == "" {
+		seller.Name = "Food Recipes"
+	}
+	return seller
+}
+
+var invoiceTemplate = template.Must(template.New("invoice").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Invoice {{.Invoice.InvoiceNumber}}</title>
+<style>
+body { font-family: Helvetica, Arial, sans-serif; margin: 40px; color: #222; }
+table { width: 100%; border-collapse: collapse; margin-top: 24px; }
+th, td { padding: 6px 8px; border-bottom: 1px solid #ddd; text-align: left; }
+.num { text-align: right; }
+.totals td { border: none; }
+</style>
+</head>
+<body>
+<h1>Invoice {{.Invoice.InvoiceNumber}}</h1>
+<p><strong>{{.Seller.Name}}</strong>{{if .Seller.TIN}}<br>TIN: {{.Seller.TIN}}{{end}}{{if .Seller.Address}}<br>{{.Seller.Address}}{{end}}</p>
+<p>
+Billed to: {{.Invoice.Order.User.FirstName}} {{.Invoice.Order.User.LastName}} ({{.Invoice.Order.User.Email}})<br>
+Issued: {{.IssuedAt}}<br>
+Fiscal year: {{.Invoice.FiscalYear}}<br>
+Order: {{.Invoice.Order.ID}}<br>
+Transaction ref: {{.Invoice.Order.ChapaTxRef}}
+</p>
+<table>
+<tr><th>#</th><th>Description</th><th class="num">Qty</th><th class="num">Unit price</th><th class="num">Discount</th><th class="num">Net</th><th class="num">VAT</th><th class="num">Total</th></tr>
+{{range .Invoice.InvoiceItems}}<tr><td>{{.LineNumber}}</td><td>{{.Description}}</td><td class="num">{{.Quantity}}</td><td class="num">{{.UnitPrice}}</td><td class="num">{{.DiscountAmount}}</td><td class="num">{{.NetAmount}}</td><td class="num">{{.VatAmount}}</td><td class="num">{{.TotalAmount}}</td></tr>
+{{end}}</table>
+<table class="totals">
+<tr><td class="num">Subtotal (excl. VAT)</td><td class="num">{{.Invoice.Subtotal}} {{.Invoice.Currency}}</td></tr>
+<tr><td class="num">VAT ({{.Invoice.VatRate}}%)</td><td class="num">{{.Invoice.VatAmount}} {{.Invoice.Currency}}</td></tr>
+<tr><td class="num"><strong>Total</strong></td><td class="num"><strong>{{.Invoice.TotalAmount}} {{.Invoice.Currency}}</strong></td></tr>
+</table>
+</body>
+</html>
+`))
+
+// renderInvoiceHTML renders a printable HTML invoice.
+func renderInvoiceHTML(invoice invoiceRecord, seller invoiceSeller) ([]byte, error) {
+	var buf bytes.Buffer
+	err := invoiceTemplate.Execute(&buf, map[string]interface{}{
+		"Invoice":  invoice,
+		"Seller":   seller,
+		"IssuedAt": receiptDate(invoice.IssuedAt),
+	})
+	return buf.Bytes(), err
+}
+
+// renderInvoicePDF renders the same invoice as renderInvoiceHTML as a PDF.
+func renderInvoicePDF(invoice invoiceRecord, seller invoiceSeller) []byte {
+	doc := pdf.New("Invoice " + invoice.InvoiceNumber)
+	left := receiptMargin
+	right := pdf.PageWidth - receiptMargin
+	y := pdf.PageHeight - receiptMargin
+
+	doc.Text(left, y, 20, pdf.Bold, "Invoice "+invoice.InvoiceNumber)
+	y -= 28
+	doc.Text(left, y, 10, pdf.Bold, seller.Name)
+	for _, line := range []string{seller.TIN, seller.Address} {
+		if line == seller.TIN && line != "" {
+			line = "TIN: " + line
+		}
+		if line != "" {
+			y -= 14
+			doc.Text(left, y, 10, pdf.Regular, line)
+		}
+	}
+	y -= 24
+	user := invoice.Order.User
+	for _, field := range [][2]string{
+		{"Billed to", fmt.Sprintf("%s %s (%s)", user.FirstName, user.LastName, user.Email)},
+		{"Issued", receiptDate(invoice.IssuedAt)},
+		{"Fiscal year", strconv.Itoa(invoice.FiscalYear)},
+		{"Order ID", invoice.Order.ID},
+		{"Transaction ref", invoice.Order.ChapaTxRef},
+	} {
+		doc.Text(left, y, 10, pdf.Bold, field[0])
+		doc.Text(left+110, y, 10, pdf.Regular, field[1])
+		y -= receiptRowHeight
+	}
+
+	y -= 10
+	columns := []struct {
+		title string
+		x     float64
+	}{{"Qty", right - 290}, {"Unit price", right - 230}, {"Discount", right - 170}, {"Net", right - 115}, {"VAT", right - 60}, {"Total", right}}
+	doc.Text(left, y, 9, pdf.Bold, "Description")
+	for _, column := range columns {
+		doc.TextRight(column.x, y, 9, pdf.Bold, column.title)
+	}
+	y -= 6
+	doc.Line(left, y, right, y)
+	y -= receiptRowHeight
+
+	for _, item := range invoice.InvoiceItems {
+		if y < receiptMargin+5*receiptRowHeight {
+			doc.AddPage()
+			y = pdf.PageHeight - receiptMargin
+		}
+		doc.Text(left, y, 9, pdf.Regular, truncateToWidth(fmt.Sprintf("%d. %s", item.LineNumber, item.Description), columns[0].x-left-30, 9))
+		values := []string{strconv.Itoa(item.Quantity), item.UnitPrice.String(), item.DiscountAmount.String(), item.NetAmount.String(), item.VatAmount.String(), item.TotalAmount.String()}
+		for i, column := range columns {
+			doc.TextRight(column.x, y, 9, pdf.Regular, values[i])
+		}
+		y -= receiptRowHeight
+	}
+
+	y += receiptRowHeight - 6
+	doc.Line(left, y, right, y)
+	y -= receiptRowHeight
+	for i, total := range [][2]string{
+		{"Subtotal (excl. VAT)", invoice.Subtotal.String()},
+		{fmt.Sprintf("VAT (%s%%)", invoice.VatRate), invoice.VatAmount.String()},
+		{"Total", invoice.TotalAmount.String()},
+	} {
+		font := pdf.Regular
+		if i == 2 {
+			font = pdf.Bold
+		}
+		doc.TextRight(right-90, y, 10, font, total[0])
+		doc.TextRight(right, y, 10, font, total[1]+" "+invoice.Currency)
+		y -= receiptRowHeight
+	}
+	return doc.Bytes()
+}
+
+// HandleOrderInvoice serves the invoice for one of the user's orders as HTML
+// or PDF. Like HandleOrderReceipt it is called directly by the browser, so
+// the caller authenticates the request and passes in the user ID.
+func HandleOrderInvoice(w http.ResponseWriter, r *http.Request, hasuraService *HasuraService, userID string, orderID string, format string) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	resp, err := hasuraService.QueryInvoice(ctx, userID, orderID)
+	if err != nil {
+		log.Printf("Failed to query invoice for order %s: %v", orderID, err)
+		http.Error(w, "Failed to retrieve invoice", http.StatusInternalServerError)
+		return
+	}
+	if len(resp.Invoices) == 0 {
+		http.Error(w, "Invoice not found", http.StatusNotFound)
+		return
+	}
+	invoice := resp.Invoices[0]
+	seller := invoiceSellerFromEnv()
+
+	switch format {
+	case "pdf":
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.pdf\"", invoice.InvoiceNumber))
+		w.Write(renderInvoicePDF(invoice, seller))
+	case "html":
+		body, err := renderInvoiceHTML(invoice, seller)
+		if err != nil {
+			log.Printf("Failed to render invoice %s: %v", invoice.InvoiceNumber, err)
+			http.Error(w, "Failed to render invoice", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(body)
+	default:
+		http.Error(w, "Unsupported invoice format", http.StatusNotFound)
+	}
+}
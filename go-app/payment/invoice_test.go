@@ -0,0 +1,128 @@
+package payment
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplitVAT(t *testing.T) {
+	tests := []struct {
+		gross   Money
+		rate    Money
+		wantNet Money
+		wantVAT Money
+	}{
+		{gross: 11500, rate: 1500, wantNet: 10000, wantVAT: 1500},
+		{gross: 100, rate: 1500, wantNet: 87, wantVAT: 13},
+		{gross: 1, rate: 1500, wantNet: 1, wantVAT: 0},
+		{gross: 0, rate: 1500, wantNet: 0, wantVAT: 0},
+		{gross: 1000, rate: 750, wantNet: 930, wantVAT: 70},
+		{gross: 1000, rate: 0, wantNet: 1000, wantVAT: 0},
+		{gross: 3, rate: 10000, wantNet: 2, wantVAT: 1},
+		{gross: 99999999, rate: 1500, wantNet: 86956521, wantVAT: 13043478},
+	}
+	for _, tt := range tests {
+		net, vat := splitVAT(tt.gross, tt.rate)
+		if net != tt.wantNet || vat != tt.wantVAT {
+			t.Errorf("splitVAT(%s, %s%%) = %s + %s, want %s + %s", tt.gross, tt.rate, net, vat, tt.wantNet, tt.wantVAT)
+		}
+		if net+vat != tt.gross {
+			t.Errorf("splitVAT(%s, %s%%) does not add back up: %s + %s", tt.gross, tt.rate, net, vat)
+		}
+	}
+}
+
+func TestBuildInvoice(t *testing.T) {
+	t.Setenv("VAT_RATE_PERCENT", "15")
+	t.Setenv("FISCAL_YEAR_START_MONTH", "")
+	order := callbackOrder{
+		OrderID:  "o1",
+		Currency: "ETB",
+		OrderItems: []refundableOrderItem{
+			{ID: "i1", RecipeName: "Doro wat", Quantity: 2, PriceAtPurchase: 5750, DiscountAmount: 500},
+			{ID: "i2", RecipeName: "Injera", Quantity: 1, PriceAtPurchase: 100},
+		},
+	}
+	invoice := buildInvoice(order, time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC))
+
+	if invoice.FiscalYear != 2025 || invoice.VatRate != 1500 || invoice.Currency != "ETB" {
+		t.Errorf("invoice header = %d, %s%%, %s", invoice.FiscalYear, invoice.VatRate, invoice.Currency)
+	}
+	lines := invoice.InvoiceItems.Data
+	if len(lines) != 2 || lines[0].LineNumber != 1 || lines[1].LineNumber != 2 {
+		t.Fatalf("invoice lines = %+v", lines)
+	}
+	if lines[0].TotalAmount != 11000 || lines[0].NetAmount != 9565 || lines[0].VatAmount != 1435 {
+		t.Errorf("line 1 = %s net + %s VAT = %s", lines[0].NetAmount, lines[0].VatAmount, lines[0].TotalAmount)
+	}
+	if invoice.TotalAmount != 11100 || invoice.Subtotal+invoice.VatAmount != invoice.TotalAmount {
+		t.Errorf("invoice = %s net + %s VAT = %s", invoice.Subtotal, invoice.VatAmount, invoice.TotalAmount)
+	}
+}
+
+func TestFiscalYear(t *testing.T) {
+	tests := []struct {
+		startMonth string
+		date       time.Time
+		want       int
+	}{
+		{startMonth: "", date: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), want: 2025},
+		{startMonth: "", date: time.Date(2025, 12, 31, 0, 0, 0, 0, time.UTC), want: 2025},
+		{startMonth: "7", date: time.Date(2025, 6, 30, 0, 0, 0, 0, time.UTC), want: 2024},
+		{startMonth: "7", date: time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC), want: 2025},
+		{startMonth: "13", date: time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC), want: 2025},
+	}
+	for _, tt := range tests {
+		t.Setenv("FISCAL_YEAR_START_MONTH", tt.startMonth)
+		if got := fiscalYear(tt.date); got != tt.want {
+			t.Errorf("fiscalYear(%s) starting in month %q = %d, want %d", tt.date.Format("2006-01-02"), tt.startMonth, got, tt.want)
+		}
+	}
+}
+
+func TestIssueMissingInvoices(t *testing.T) {
+	t.Setenv("CRON_SECRET", "s3cret")
+	hasura, hasuraService := newFakeHasura(t)
+	hasura.on("orders", `[
+		{"id":"o1","currency":"ETB","order_items":[{"id":"i1","recipe_name":"Doro wat","quantity":1,"price_at_purchase":57.50}]},
+		{"id":"o2","currency":"ETB","order_items":[{"id":"i2","recipe_name":"Injera","quantity":1,"price_at_purchase":1}]}]`)
+	hasura.onFunc("insert_invoices_one", func(vars map[string]json.RawMessage) string {
+		var invoice invoices_insert_input
+		json.Unmarshal(vars["object"], &invoice)
+		if invoice.OrderID == "o2" {
+			return `null`
+		}
+		return `{"id":"inv1","invoice_number":"INV-2025-000001"}`
+	})
+
+	trigger := func(secret string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/cron/issue-missing-invoices", strings.NewReader(`{}`))
+		req.Header.Set("X-Cron-Secret", secret)
+		rec := httptest.NewRecorder()
+		HandleIssueMissingInvoices(rec, req, hasuraService)
+		return rec
+	}
+
+	if rec := trigger("wrong"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong secret = %d, want 401", rec.Code)
+	}
+	if n := len(hasura.calls("orders")); n != 0 {
+		t.Fatalf("orders queried %d times without the cron secret", n)
+	}
+
+	rec := trigger("s3cret")
+	var output IssueMissingInvoicesOutput
+	json.Unmarshal(rec.Body.Bytes(), &output)
+	if rec.Code != http.StatusOK || output.Issued != 1 || output.Failed != 1 {
+		t.Fatalf("issueMissingInvoices = %d %s", rec.Code, rec.Body)
+	}
+	var limit int
+	hasura.calls("orders")[0].variable(t, "limit", &limit)
+	if limit != maxInvoiceBackfill {
+		t.Errorf("backfill limit = %d, want %d", limit, maxInvoiceBackfill)
+	}
+}
@@ -0,0 +1,259 @@
+package payment
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	google_uuid "github.com/google/uuid"
+)
+
+// Ledger accounts. Every ledger transaction debits (positive amounts) and
+// credits (negative amounts) these accounts so that it sums to zero.
+const (
+	accountProviderClearing   = "provider_clearing"
+	accountPlatformCommission = "platform_commission"
+	accountAuthorPayable      = "author_payable"
+)
+
+const defaultPlatformCommissionPercent = "10"
+
+// maxSaleBackfill caps how many missing sales one cron run posts.
+const maxSaleBackfill = 100
+
+// platformCommissionPercent reads PLATFORM_COMMISSION_PERCENT, the share of
+// each sale the platform keeps. It accepts up to two decimals, e.g. "12.5".
+func platformCommissionPercent() Money {
+	raw := os.Getenv("PLATFORM_COMMISSION_PERCENT")
+	if raw == "" {
+		raw = defaultPlatformCommissionPercent
+	}
+	percent, err := ParseMoney(raw)
+	if err != nil || percent < 0 || percent > 10000 {
+		log.Printf("Invalid PLATFORM_COMMISSION_PERCENT %q, using %s%%", raw, defaultPlatformCommissionPercent)
+		percent, _ = ParseMoney(defaultPlatformCommissionPercent)
+	}
+	return percent
+}
+
+// orderCommissionPercent returns the commission recorded on an order, falling
+// back to the current setting for orders created before it was recorded.
+func orderCommissionPercent(recorded *Money) Money {
+	if recorded != nil {
+		return *recorded
+	}
+	return platformCommissionPercent()
+}
+
+// authorShares accumulates amounts per author, holding back the platform
+// commission from each.
+type authorShares struct {
+	byAuthor   map[string]Money
+	commission Money
+	total      Money
+}
+
+func (s *authorShares) add(authorID string, amount Money, percent Money) {
+	if s.byAuthor == nil {
+		s.byAuthor = make(map[string]Money)
+	}
+	commission := amount.Percentage(percent)
+	s.byAuthor[authorID] += amount - commission
+	s.commission += commission
+	s.total += amount
+}
+
+// entries books the shares with the given sign: -1 credits authors and the
+// platform against a clearing debit (a sale), +1 reverses that (a refund).
+func (s *authorShares) entries(sign Money, currency string, now DateTime) []ledger_entries_insert_input {
+	authorIDs := make([]string, 0, len(s.byAuthor))
+	for id := range s.byAuthor {
+		authorIDs = append(authorIDs, id)
+	}
+	sort.Strings(authorIDs)
+
+	entries := []ledger_entries_insert_input{{
+		Account:   accountProviderClearing,
+		Amount:    -sign * s.total,
+		Currency:  currency,
+		CreatedAt: now,
+	}}
+	for _, id := range authorIDs {
+		userID := uuid(id)
+		entries = append(entries, ledger_entries_insert_input{
+			Account:   accountAuthorPayable,
+			UserID:    &userID,
+			Amount:    sign * s.byAuthor[id],
+			Currency:  currency,
+			CreatedAt: now,
+		})
+	}
+	if s.commission != 0 {
+		entries = append(entries, ledger_entries_insert_input{
+			Account:   accountPlatformCommission,
+			Amount:    sign * s.commission,
+			Currency:  currency,
+			CreatedAt: now,
+		})
+	}
+	return entries
+}
+
+// saleLedgerTransaction credits each author with what the buyer paid for
+// their recipes, after coupon discounts and the platform commission.
+func saleLedgerTransaction(order callbackOrder) ledger_transactions_insert_input {
+	percent := orderCommissionPercent(order.PlatformCommissionPercent)
+	var shares authorShares
+	for _, item := range order.OrderItems {
+		shares.add(item.Recipe.UserID, item.PriceAtPurchase.Mul(item.Quantity)-item.DiscountAmount, percent)
+	}
+	now := DateTime(time.Now())
+	orderID := uuid(order.OrderID)
+	return ledger_transactions_insert_input{
+		ID:             uuid(google_uuid.New().String()),
+		Kind:           "sale",
+		IdempotencyKey: "sale:" + order.OrderID,
+		OrderID:        &orderID,
+		Description:    fmt.Sprintf("Sale of order %s", order.OrderID),
+		CreatedAt:      now,
+		LedgerEntries:  ledger_entries_arr_rel_insert_input{Data: shares.entries(-1, order.Currency, now)},
+	}
+}
+
+// tipLedgerTransaction credits the tipped author with the whole tip, less
+// the tip commission recorded on the order.
+func tipLedgerTransaction(order callbackOrder) ledger_transactions_insert_input {
+	var shares authorShares
+	shares.add(*order.TipAuthorID, order.TotalAmount, orderCommissionPercent(order.PlatformCommissionPercent))
+	now := DateTime(time.Now())
+	orderID := uuid(order.OrderID)
+	return ledger_transactions_insert_input{
+		ID:             uuid(google_uuid.New().String()),
+		Kind:           "tip",
+		IdempotencyKey: "tip:" + order.OrderID,
+		OrderID:        &orderID,
+		Description:    fmt.Sprintf("Tip order %s", order.OrderID),
+		CreatedAt:      now,
+		LedgerEntries:  ledger_entries_arr_rel_insert_input{Data: shares.entries(-1, order.Currency, now)},
+	}
+}
+
+// refundLedgerTransaction takes refunded amounts back from the authors and
+// the platform commission in the same proportions the sale credited them.
+func refundLedgerTransaction(orderID string, refundID string, currency string, percent Money, lines []refundLine) ledger_transactions_insert_input {
+	var shares authorShares
+	for _, line := range lines {
+		shares.add(line.item.Recipe.UserID, line.amount, percent)
+	}
+	now := DateTime(time.Now())
+	order := uuid(orderID)
+	refund := uuid(refundID)
+	return ledger_transactions_insert_input{
+		ID:             uuid(google_uuid.New().String()),
+		Kind:           "refund",
+		IdempotencyKey: "refund:" + refundID,
+		OrderID:        &order,
+		RefundID:       &refund,
+		Description:    fmt.Sprintf("Refund %s of order %s", refundID, orderID),
+		CreatedAt:      now,
+		LedgerEntries:  ledger_entries_arr_rel_insert_input{Data: shares.entries(1, currency, now)},
+	}
+}
+
+// payoutLedgerTransaction settles an author's balance against the money
+// leaving the platform's account.
+func payoutLedgerTransaction(payoutID string, authorID string, amount Money, currency string, now DateTime) ledger_transactions_insert_input {
+	author := uuid(authorID)
+	return ledger_transactions_insert_input{
+		ID:             uuid(google_uuid.New().String()),
+		Kind:           "payout",
+		IdempotencyKey: "payout:" + payoutID,
+		Description:    fmt.Sprintf("Payout %s to author %s", payoutID, authorID),
+		CreatedAt:      now,
+		LedgerEntries: ledger_entries_arr_rel_insert_input{Data: []ledger_entries_insert_input{
+			{Account: accountAuthorPayable, UserID: &author, Amount: amount, Currency: currency, CreatedAt: now},
+			{Account: accountProviderClearing, Amount: -amount, Currency: currency, CreatedAt: now},
+		}},
+	}
+}
+
+// payoutReversalLedgerTransaction gives an author back the balance a failed
+// payout had settled.
+func payoutReversalLedgerTransaction(payoutID string, authorID string, amount Money, currency string, now DateTime) ledger_transactions_insert_input {
+	author := uuid(authorID)
+	payout := uuid(payoutID)
+	return ledger_transactions_insert_input{
+		ID:             uuid(google_uuid.New().String()),
+		Kind:           "payout",
+		IdempotencyKey: "payout-reversal:" + payoutID,
+		PayoutID:       &payout,
+		Description:    fmt.Sprintf("Reversal of failed payout %s to author %s", payoutID, authorID),
+		CreatedAt:      now,
+		LedgerEntries: ledger_entries_arr_rel_insert_input{Data: []ledger_entries_insert_input{
+			{Account: accountAuthorPayable, UserID: &author, Amount: -amount, Currency: currency, CreatedAt: now},
+			{Account: accountProviderClearing, Amount: amount, Currency: currency, CreatedAt: now},
+		}},
+	}
+}
+
+// postSale records a completed order, or tip, in the ledger. The idempotency key makes
+// a second posting fail, so a replayed callback cannot credit authors twice.
+// Failures are logged and returned; the post_missing_sales cron trigger
+// posts the order later.
+func postSale(ctx context.Context, hasuraService *HasuraService, order callbackOrder) error {
+	var transaction ledger_transactions_insert_input
+	if order.OrderType == orderTypeTip {
+		if order.TipAuthorID == nil {
+			log.Printf("❌ Tip order %s has no author to credit", order.OrderID)
+			return fmt.Errorf("tip order %s has no author", order.OrderID)
+		}
+		transaction = tipLedgerTransaction(order)
+	} else {
+		if len(order.OrderItems) == 0 {
+			return nil
+		}
+		transaction = saleLedgerTransaction(order)
+	}
+	if _, err := hasuraService.InsertLedgerTransaction(ctx, transaction); err != nil {
+		log.Printf("❌ Failed to post %s of order %s to the ledger: %v", transaction.Kind, order.OrderID, err)
+		return err
+	}
+	return nil
+}
+
+// HandlePostMissingSales handles the Hasura cron trigger that posts the
+// sales and tips of paid orders whose ledger posting failed when they
+// completed. Each is posted under the same idempotency key as at
+// completion, so an order is never credited twice.
+func HandlePostMissingSales(w http.ResponseWriter, r *http.Request, hasuraService *HasuraService) {
+	if !validCronRequest(r) {
+		respondWithError(w, http.StatusUnauthorized, "Invalid cron secret")
+		return
+	}
+	defer r.Body.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	orders, err := hasuraService.QueryOrdersMissingSale(ctx, maxSaleBackfill)
+	if err != nil {
+		log.Printf("Failed to query orders missing a ledger posting: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve orders")
+		return
+	}
+	output := PostMissingSalesOutput{}
+	for _, order := range orders {
+		if err := postSale(ctx, hasuraService, order); err != nil {
+			output.Failed++
+			continue
+		}
+		output.Posted++
+	}
+	if len(orders) > 0 {
+		log.Printf("📒 Posted %d missing sales, %d failed", output.Posted, output.Failed)
+	}
+	respondWithJSON(w, http.StatusOK, output)
+}
@@ -0,0 +1,201 @@
+package payment
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// balances sums a transaction's entries per account and user, checking on
+// the way that they add up to zero.
+func balances(t *testing.T, tx ledger_transactions_insert_input) map[string]Money {
+	t.Helper()
+	var sum Money
+	got := map[string]Money{}
+	for _, entry := range tx.LedgerEntries.Data {
+		sum += entry.Amount
+		name := entry.Account
+		if entry.UserID != nil {
+			name += ":" + string(*entry.UserID)
+		}
+		got[name] += entry.Amount
+	}
+	if sum != 0 {
+		t.Errorf("%s transaction %q does not balance: entries sum to %s", tx.Kind, tx.IdempotencyKey, sum)
+	}
+	return got
+}
+
+func checkBalances(t *testing.T, got map[string]Money, want map[string]Money) {
+	t.Helper()
+	for name, amount := range want {
+		if got[name] != amount {
+			t.Errorf("%s = %s, want %s", name, got[name], amount)
+		}
+	}
+	for name, amount := range got {
+		if _, ok := want[name]; !ok && amount != 0 {
+			t.Errorf("unexpected %s = %s", name, amount)
+		}
+	}
+}
+
+func TestSaleLedgerTransaction(t *testing.T) {
+	percent := func(p Money) *Money { return &p }
+	tests := []struct {
+		name    string
+		percent *Money
+		items   []refundableOrderItem
+		want    map[string]Money
+	}{
+		{
+			name:    "one author",
+			percent: percent(1000),
+			items:   []refundableOrderItem{refundItem("i1", "a1", 2, 1000, 0)},
+			want: map[string]Money{
+				accountProviderClearing:      2000,
+				accountAuthorPayable + ":a1": -1800,
+				accountPlatformCommission:    -200,
+			},
+		},
+		{
+			name:    "two authors after a coupon",
+			percent: percent(1250),
+			items: []refundableOrderItem{
+				refundItem("i1", "a1", 1, 999, 99),
+				refundItem("i2", "a2", 3, 333, 0),
+				refundItem("i3", "a1", 1, 1, 0),
+			},
+			want: map[string]Money{
+				accountProviderClearing:      1900,
+				accountAuthorPayable + ":a1": -788,
+				accountAuthorPayable + ":a2": -874,
+				accountPlatformCommission:    -238,
+			},
+		},
+		{
+			name:    "no commission",
+			percent: percent(0),
+			items:   []refundableOrderItem{refundItem("i1", "a1", 1, 500, 0)},
+			want: map[string]Money{
+				accountProviderClearing:      500,
+				accountAuthorPayable + ":a1": -500,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order := callbackOrder{OrderID: "o1", Currency: "ETB", PlatformCommissionPercent: tt.percent, OrderItems: tt.items}
+			tx := saleLedgerTransaction(order)
+			if tx.Kind != "sale" || tx.IdempotencyKey != "sale:o1" {
+				t.Errorf("kind %q, idempotency key %q", tx.Kind, tx.IdempotencyKey)
+			}
+			checkBalances(t, balances(t, tx), tt.want)
+		})
+	}
+}
+
+func TestTipLedgerTransaction(t *testing.T) {
+	percent := Money(500)
+	author := "a1"
+	tx := tipLedgerTransaction(callbackOrder{OrderID: "o1", Currency: "USD", TotalAmount: 1001, PlatformCommissionPercent: &percent, TipAuthorID: &author})
+	checkBalances(t, balances(t, tx), map[string]Money{
+		accountProviderClearing:      1001,
+		accountAuthorPayable + ":a1": -951,
+		accountPlatformCommission:    -50,
+	})
+}
+
+// A full refund takes back exactly what the sale credited.
+func TestRefundLedgerTransactionReversesSale(t *testing.T) {
+	percent := Money(1250)
+	items := []refundableOrderItem{
+		refundItem("i1", "a1", 1, 999, 99),
+		refundItem("i2", "a2", 3, 333, 0),
+	}
+	sale := balances(t, saleLedgerTransaction(callbackOrder{OrderID: "o1", Currency: "ETB", PlatformCommissionPercent: &percent, OrderItems: items}))
+
+	var lines []refundLine
+	for _, item := range items {
+		lines = append(lines, newRefundLine(item, item.Quantity))
+	}
+	tx := refundLedgerTransaction("o1", "r1", "ETB", percent, lines)
+	if tx.Kind != "refund" || tx.IdempotencyKey != "refund:r1" || tx.RefundID == nil {
+		t.Errorf("kind %q, idempotency key %q, refund %v", tx.Kind, tx.IdempotencyKey, tx.RefundID)
+	}
+	refund := balances(t, tx)
+	for name, amount := range sale {
+		if refund[name] != -amount {
+			t.Errorf("refund %s = %s, want %s", name, refund[name], -amount)
+		}
+	}
+}
+
+func TestPayoutLedgerTransactions(t *testing.T) {
+	now := DateTime(time.Now())
+	payout := balances(t, payoutLedgerTransaction("p1", "a1", 1800, "ETB", now))
+	checkBalances(t, payout, map[string]Money{
+		accountAuthorPayable + ":a1": 1800,
+		accountProviderClearing:      -1800,
+	})
+}
+
+func TestPostMissingSales(t *testing.T) {
+	t.Setenv("CRON_SECRET", "s3cret")
+	hasura, hasuraService := newFakeHasura(t)
+	hasura.on("orders", `[
+		{"id":"o1","order_type":"purchase","currency":"ETB","platform_commission_percent":10,
+			"order_items":[{"id":"i1","quantity":2,"price_at_purchase":10,"recipe":{"user_id":"a1"}}]},
+		{"id":"o2","order_type":"tip","currency":"ETB","total_amount":5,"tip_author_id":"a2"},
+		{"id":"o3","order_type":"tip","currency":"ETB","total_amount":5}]`)
+	hasura.on("insert_ledger_transactions_one", `{"id":"t1"}`)
+
+	trigger := func(secret string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/postMissingSales", strings.NewReader(`{}`))
+		req.Header.Set("X-Cron-Secret", secret)
+		rec := httptest.NewRecorder()
+		HandlePostMissingSales(rec, req, hasuraService)
+		return rec
+	}
+	if rec := trigger("wrong"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong secret = %d, want 401", rec.Code)
+	}
+	if n := len(hasura.calls("orders")); n != 0 {
+		t.Fatalf("orders queried %d times without the cron secret", n)
+	}
+
+	rec := trigger("s3cret")
+	var output PostMissingSalesOutput
+	json.Unmarshal(rec.Body.Bytes(), &output)
+	if rec.Code != http.StatusOK || output.Posted != 2 || output.Failed != 1 {
+		t.Fatalf("postMissingSales = %d %s", rec.Code, rec.Body)
+	}
+	query := hasura.calls("orders")[0]
+	var limit int
+	query.variable(t, "limit", &limit)
+	if limit != maxSaleBackfill || !strings.Contains(query.Query, `_not: {ledger_transactions: {kind: {_in: ["sale", "tip"]}}}`) {
+		t.Errorf("orders queried with limit %d:\n%s", limit, query.Query)
+	}
+
+	// The sale is posted under the key it would have had at completion, so
+	// a posting that did succeed meanwhile cannot be repeated.
+	var keys []string
+	for _, call := range hasura.calls("insert_ledger_transactions_one") {
+		var tx ledger_transactions_insert_input
+		call.variable(t, "object", &tx)
+		keys = append(keys, tx.IdempotencyKey)
+		if tx.Kind == "sale" {
+			checkBalances(t, balances(t, tx), map[string]Money{
+				accountProviderClearing:      2000,
+				accountAuthorPayable + ":a1": -1800,
+				accountPlatformCommission:    -200,
+			})
+		}
+	}
+	if strings.Join(keys, ",") != "sale:o1,tip:o2" {
+		t.Errorf("posted %v, want sale:o1 and tip:o2", keys)
+	}
+}
@@ -0,0 +1,45 @@
+package payment
+
+import (
+	"errors"
+	"fmt"
+	"net/smtp"
+	"os"
+	"strings"
+)
+
+// errMailNotConfigured is returned when SMTP_HOST is not set.
+var errMailNotConfigured = errors.New("SMTP_HOST is not configured")
+
+// mailConfigured reports whether outgoing email is set up.
+func mailConfigured() bool {
+	return os.Getenv("SMTP_HOST") != ""
+}
+
+// sendMail sends a plain-text email through the server in SMTP_HOST and
+// SMTP_PORT (default 587), authenticating when SMTP_USERNAME is set.
+func sendMail(to string, subject string, body string) error {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return errMailNotConfigured
+	}
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+	from := os.Getenv("SMTP_FROM")
+	if from == "" {
+		from = "no-reply@" + host
+	}
+	var auth smtp.Auth
+	if username := os.Getenv("SMTP_USERNAME"); username != "" {
+		auth = smtp.PlainAuth("", username, os.Getenv("SMTP_PASSWORD"), host)
+	}
+
+	// Header values come from our own data, but strip line breaks so a user's
+	// name can never inject extra headers.
+	clean := strings.NewReplacer("\r", "", "\n", "")
+	message := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nMIME-Version: 1.0\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s",
+		clean.Replace(from), clean.Replace(to), clean.Replace(subject), body)
+	return smtp.SendMail(host+":"+port, auth, from, []string{to}, []byte(message))
+}
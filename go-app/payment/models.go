@@ -29,6 +29,11 @@ func (dt *DateTime) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// GetGraphQLType lets DateTime be passed directly as a timestamptz query variable.
+func (dt DateTime) GetGraphQLType() string {
+	return "timestamptz"
+}
+
 // Hasura Actions
 type RecipeItemInput struct {
 	RecipeID string `json:"recipeId"`
@@ -43,39 +48,114 @@ type HasuraActionPayload struct {
 		Input struct {
 			RecipeItems []RecipeItemInput `json:"recipeItems"`
 			ReturnURL   string            `json:"returnUrl"`
-			Amount      float64           `json:"amount"`
+			Amount      Money             `json:"amount"`
 			Currency    string            `json:"currency"`
+			Provider    string            `json:"provider"`
+			CouponCode  string            `json:"couponCode"`
 		} `json:"input"`
 	} `json:"input"`
 	SessionVariables map[string]string `json:"session_variables"`
 }
 
+type InitiatePaymentOutput struct {
+	CheckoutURL    string `json:"checkoutUrl"`
+	Message        string `json:"message"`
+	OrderID        string `json:"orderId"`
+	TxRef          string `json:"txRef"`
+	Provider       string `json:"provider"`
+	Amount         Money  `json:"amount"`
+	DiscountAmount Money  `json:"discountAmount"`
+}
+
+type RefundItemInput struct {
+	OrderItemID string `json:"orderItemId"`
+	Quantity    *int   `json:"quantity"`
+}
+
+type RefundOrderInput struct {
+	OrderID string            `json:"orderId"`
+	Items   []RefundItemInput `json:"items"`
+	Reason  string            `json:"reason"`
+}
+
+type RefundOrderActionPayload struct {
+	Action struct {
+		Name string `json:"name"`
+	} `json:"action"`
+	Input struct {
+		Input RefundOrderInput `json:"input"`
+	} `json:"input"`
+	SessionVariables map[string]string `json:"session_variables"`
+}
+
+type RefundOrderOutput struct {
+	Success         bool    `json:"success"`
+	Message         string  `json:"message"`
+	OrderID         string  `json:"orderId"`
+	RefundID        string  `json:"refundId"`
+	RefundReference string  `json:"refundReference"`
+	RefundedAmount  Money   `json:"refundedAmount"`
+	OrderStatus     string  `json:"orderStatus"`
+}
+
 // GraphQL Mutations and Queries
 type orders_insert_input struct {
 	ID        uuid    `json:"id" graphql:"id"`
 	UserID    uuid    `json:"user_id" graphql:"user_id"`
-	TotalAmount float64 `json:"total_amount" graphql:"total_amount"`
+	TotalAmount Money   `json:"total_amount" graphql:"total_amount"`
 	Currency    string  `json:"currency" graphql:"currency"`
 	ReturnURL   string  `json:"return_url" graphql:"return_url"`
 	Status      string  `json:"status" graphql:"status"`
 	ChapaTxRef  string  `json:"chapa_tx_ref" graphql:"chapa_tx_ref"`
+	PaymentProvider string `json:"payment_provider" graphql:"payment_provider"`
+	CouponID       *uuid   `json:"coupon_id,omitempty" graphql:"coupon_id"`
+	CouponCode     *string `json:"coupon_code,omitempty" graphql:"coupon_code"`
+	DiscountAmount Money   `json:"discount_amount" graphql:"discount_amount"`
+	PlatformCommissionPercent Money `json:"platform_commission_percent" graphql:"platform_commission_percent"`
+	ExchangeRate ExchangeRate `json:"exchange_rate" graphql:"exchange_rate"`
 	ChapaTransactionID *string `json:"chapa_transaction_id,omitempty" graphql:"chapa_transaction_id"`
+	CartFingerprint string `json:"cart_fingerprint" graphql:"cart_fingerprint"`
+	ExpiresAt   DateTime `json:"expires_at" graphql:"expires_at"`
+	OrderType   string   `json:"order_type" graphql:"order_type"`
+	CheckoutType *string `json:"checkout_type,omitempty" graphql:"checkout_type"`
+	TipRecipeID *uuid    `json:"tip_recipe_id,omitempty" graphql:"tip_recipe_id"`
+	TipAuthorID *uuid    `json:"tip_author_id,omitempty" graphql:"tip_author_id"`
+	TipMessage  *string  `json:"tip_message,omitempty" graphql:"tip_message"`
+	OrderItems  *order_items_arr_rel_insert_input `json:"order_items,omitempty" graphql:"order_items"`
 	CreatedAt   DateTime `json:"created_at" graphql:"created_at"`
 	UpdatedAt   DateTime `json:"updated_at" graphql:"updated_at"`
 }
 
+type recipeDetails struct {
+	ID          string `json:"id" graphql:"id"`
+	UserID      string `json:"user_id" graphql:"user_id"`
+	PriceETB    Money  `json:"price_etb" graphql:"price_etb"`
+	Title       string `json:"title" graphql:"title"`
+	IsPublished bool   `json:"is_published" graphql:"is_published"`
+	Prices      []struct {
+		Currency string `json:"currency" graphql:"currency"`
+		Price    Money  `json:"price" graphql:"price"`
+	} `graphql:"recipe_prices"`
+	Images []struct {
+		ID         string `json:"id" graphql:"id"`
+		ImageURL   string `json:"image_url" graphql:"image_url"`
+		IsFeatured *bool  `json:"is_featured" graphql:"is_featured"`
+	} `graphql:"recipe_images(order_by: {image_order: asc})"` // Fetch all images ordered
+}
+
 type recipesDetailsQuery struct {
-	Recipes []struct {
-		ID       string  `json:"id" graphql:"id"`
-		PriceETB float64 `json:"price_etb" graphql:"price_etb"`
-		Title    string  `json:"title" graphql:"title"`
-		Images   []struct {
-			ID         string  `json:"id" graphql:"id"`
-			ImageURL   string  `json:"image_url" graphql:"image_url"`
-			
-			IsFeatured *bool   `json:"is_featured" graphql:"is_featured"`
-		} `graphql:"recipe_images(order_by: {image_order: asc})"` // Fetch all images ordered
-	} `graphql:"recipes(where: {id: {_in: $recipeIds}})"`
+	Recipes []recipeDetails `graphql:"recipes(where: {id: {_in: $recipeIds}})"`
+}
+
+// ownedRecipesQuery finds which of the given recipes the buyer already has,
+// either as a purchase or as a paid order item that has not been refunded.
+type ownedRecipesQuery struct {
+	Purchases []struct {
+		RecipeID string `graphql:"recipe_id"`
+	} `graphql:"purchases(where: {buyer_id: {_eq: $buyerId}, recipe_id: {_in: $recipeIds}, revoked_at: {_is_null: true}})"`
+	OrderItems []struct {
+		RecipeID string `graphql:"recipe_id"`
+	} `graphql:"order_items(where: {recipe_id: {_in: $recipeIds}, status: {_neq: \"refunded\"}, order: {user_id: {_eq: $buyerId}, status: {_in: [\"completed\", \"partially_refunded\"]}}})"`
 }
 
 
@@ -98,26 +178,60 @@ type insertOrderMutation struct {
 	} `graphql:"insert_orders_one(object: $object)"`
 }
 
-type insertOrderItemsMutation struct {
-	InsertOrderItems *struct {
-		AffectedRows int `graphql:"affected_rows"`
-	} `graphql:"insert_order_items(objects: $objects)"`
-}
-
-
+// order_items_insert_input is inserted nested under its order, which fills
+// in order_id.
 type order_items_insert_input struct {
 	ID             uuid      `json:"id" graphql:"id"`
-	OrderID        uuid      `json:"order_id" graphql:"order_id"`
 	RecipeID       uuid      `json:"recipe_id" graphql:"recipe_id"`
 	Quantity       int       `json:"quantity" graphql:"quantity"`
-	PriceAtPurchase float64  `json:"price_at_purchase" graphql:"price_at_purchase"`
+	PriceAtPurchase Money    `json:"price_at_purchase" graphql:"price_at_purchase"`
+	DiscountAmount Money     `json:"discount_amount" graphql:"discount_amount"`
 	RecipeName     string    `json:"recipe_name" graphql:"recipe_name"`
 	RecipeImageURL *string   `json:"recipe_image_url,omitempty" graphql:"recipe_image_url"`
 	CreatedAt      DateTime  `json:"created_at" graphql:"created_at"`
 	UpdatedAt      DateTime  `json:"updated_at" graphql:"updated_at"`
 }
 
+type order_items_arr_rel_insert_input struct {
+	Data []order_items_insert_input `json:"data"`
+}
 
+type callbackOrder struct {
+	OrderID         string `graphql:"id"`
+	ReturnURL       string `graphql:"return_url"`
+	PaymentProvider string `graphql:"payment_provider"`
+	UserID          string  `graphql:"user_id"`
+	TotalAmount     Money   `graphql:"total_amount"`
+	Currency        string  `graphql:"currency"`
+	CouponID        *string `graphql:"coupon_id"`
+	DiscountAmount  Money   `graphql:"discount_amount"`
+	PlatformCommissionPercent *Money `graphql:"platform_commission_percent"`
+	OrderType       string  `graphql:"order_type"`
+	TipAuthorID     *string `graphql:"tip_author_id"`
+	OrderItems      []refundableOrderItem `graphql:"order_items"`
+}
+
+// ordersMissingInvoiceQuery finds paid purchase orders that have no invoice.
+type ordersMissingInvoiceQuery struct {
+	Orders []callbackOrder `graphql:"orders(where: {status: {_in: [\"completed\", \"partially_refunded\", \"refunded\"]}, order_items: {}, _not: {invoice: {}}}, order_by: {created_at: asc}, limit: $limit)"`
+}
+
+// ordersMissingSaleQuery finds paid purchase orders with items, and paid
+// tips, that were never posted to the ledger.
+type ordersMissingSaleQuery struct {
+	Orders []callbackOrder `graphql:"orders(where: {status: {_in: [\"completed\", \"partially_refunded\", \"refunded\"]}, _or: [{order_type: {_eq: \"tip\"}}, {order_items: {}}], _not: {ledger_transactions: {kind: {_in: [\"sale\", \"tip\"]}}}}, order_by: {created_at: asc}, limit: $limit)"`
+}
+
+type orders_set_input struct {
+	Status             *string   `json:"status,omitempty"`
+	ChapaTransactionID *string   `json:"chapa_transaction_id,omitempty"`
+	RefundedAmount     *Money    `json:"refunded_amount,omitempty"`
+	RefundReference    *string   `json:"refund_reference,omitempty"`
+	CheckoutURL        *string   `json:"checkout_url,omitempty"`
+	ReminderSentAt     *DateTime `json:"reminder_sent_at,omitempty"`
+	RefundInProgress   *bool     `json:"refund_in_progress,omitempty"`
+	UpdatedAt          *DateTime `json:"updated_at,omitempty"`
+}
 
 type updateOrderStatusMutation struct {
     UpdateOrders *struct {
@@ -127,7 +241,749 @@ type updateOrderStatusMutation struct {
             TxRef   string `graphql:"chapa_tx_ref"`
             Status  string `graphql:"status"`
         } `graphql:"returning"`
-    } `graphql:"update_orders(where: {chapa_tx_ref: {_eq: $txRef}}, _set: $set)"`
+    } `graphql:"update_orders(where: {chapa_tx_ref: {_eq: $txRef}, status: {_nin: [\"completed\", \"partially_refunded\", \"refunded\"]}}, _set: $set)"`
+}
+
+type refundableOrderItem struct {
+	ID               string  `graphql:"id"`
+	RecipeID         string  `graphql:"recipe_id"`
+	RecipeName       string  `graphql:"recipe_name"`
+	Quantity         int     `graphql:"quantity"`
+	PriceAtPurchase  Money   `graphql:"price_at_purchase"`
+	RefundedQuantity int     `graphql:"refunded_quantity"`
+	RefundedAmount   Money   `graphql:"refunded_amount"`
+	DiscountAmount   Money   `graphql:"discount_amount"`
+	Recipe           struct {
+		UserID string `graphql:"user_id"`
+	} `graphql:"recipe"`
+}
+
+type refundableOrder struct {
+	ID                        string                `graphql:"id"`
+	UserID                    string                `graphql:"user_id"`
+	Status                    string                `graphql:"status"`
+	Currency                  string                `graphql:"currency"`
+	TotalAmount               Money                 `graphql:"total_amount"`
+	RefundedAmount            Money                 `graphql:"refunded_amount"`
+	ChapaTxRef                string                `graphql:"chapa_tx_ref"`
+	PaymentProvider           string                `graphql:"payment_provider"`
+	PlatformCommissionPercent *Money                `graphql:"platform_commission_percent"`
+	OrderItems                []refundableOrderItem `graphql:"order_items"`
+}
+
+type refundOrderQuery struct {
+	OrdersByPk *refundableOrder `graphql:"orders_by_pk(id: $id)"`
+}
+
+type couponRecord struct {
+	ID              string    `graphql:"id"`
+	Code            string    `graphql:"code"`
+	DiscountType    string    `graphql:"discount_type"`
+	DiscountValue   Money     `graphql:"discount_value"`
+	MaxRedemptions  *int      `graphql:"max_redemptions"`
+	PerUserLimit    *int      `graphql:"per_user_limit"`
+	RedemptionCount int       `graphql:"redemption_count"`
+	StartsAt        *DateTime `graphql:"starts_at"`
+	ExpiresAt       *DateTime `graphql:"expires_at"`
+	AuthorID        *string   `graphql:"author_id"`
+	RecipeID        *string   `graphql:"recipe_id"`
+	IsActive        bool      `graphql:"is_active"`
+}
+
+type couponQuery struct {
+	Coupons                    []couponRecord `graphql:"coupons(where: {code: {_eq: $code}}, limit: 1)"`
+	CouponRedemptionsAggregate struct {
+		Aggregate struct {
+			Count int `graphql:"count"`
+		} `graphql:"aggregate"`
+	} `graphql:"coupon_redemptions_aggregate(where: {user_id: {_eq: $userId}, coupon: {code: {_eq: $code}}})"`
+}
+
+type coupon_redemptions_insert_input struct {
+	CouponID       uuid     `json:"coupon_id" graphql:"coupon_id"`
+	OrderID        uuid     `json:"order_id" graphql:"order_id"`
+	UserID         uuid     `json:"user_id" graphql:"user_id"`
+	DiscountAmount Money    `json:"discount_amount" graphql:"discount_amount"`
+	CreatedAt      DateTime `json:"created_at" graphql:"created_at"`
+}
+
+// insertCouponRedemptionMutation ignores duplicates so a replayed callback
+// cannot count the same order twice.
+type insertCouponRedemptionMutation struct {
+	InsertCouponRedemptionsOne *struct {
+		ID string `graphql:"id"`
+	} `graphql:"insert_coupon_redemptions_one(object: $object, on_conflict: {constraint: coupon_redemptions_order_id_key, update_columns: []})"`
+}
+
+type refunds_insert_input struct {
+	ID          uuid     `json:"id" graphql:"id"`
+	OrderID     uuid     `json:"order_id" graphql:"order_id"`
+	RequestedBy *uuid    `json:"requested_by,omitempty" graphql:"requested_by"`
+	Amount      Money    `json:"amount" graphql:"amount"`
+	Currency    string   `json:"currency" graphql:"currency"`
+	Reason      string   `json:"reason" graphql:"reason"`
+	Reference   string   `json:"reference" graphql:"reference"`
+	Status      string   `json:"status" graphql:"status"`
+	Items       []RefundItemInput `json:"items" graphql:"items"`
+	CreatedAt   DateTime `json:"created_at" graphql:"created_at"`
+	UpdatedAt   DateTime `json:"updated_at" graphql:"updated_at"`
+}
+
+type insertRefundMutation struct {
+	InsertRefundsOne *struct {
+		ID string `graphql:"id"`
+	} `graphql:"insert_refunds_one(object: $object)"`
+}
+
+// lockOrderForRefundMutation marks a refundable order as having a refund in
+// progress. It affects no rows when another refund already holds the order,
+// unless that lock is older than $staleBefore and no refund is pending.
+type lockOrderForRefundMutation struct {
+	UpdateOrders *struct {
+		AffectedRows int `graphql:"affected_rows"`
+	} `graphql:"update_orders(where: {id: {_eq: $orderId}, status: {_in: [\"completed\", \"partially_refunded\"]}, _or: [{refund_in_progress: {_eq: false}}, {refund_locked_at: {_lt: $staleBefore}, _not: {refunds: {status: {_eq: \"pending\"}}}}]}, _set: {refund_in_progress: true, refund_locked_at: $now})"`
+}
+
+type unlockOrderForRefundMutation struct {
+	UpdateOrdersByPk *struct {
+		ID string `graphql:"id"`
+	} `graphql:"update_orders_by_pk(pk_columns: {id: $orderId}, _set: {refund_in_progress: false, refund_locked_at: null})"`
+}
+
+// failRefundMutation marks a refund failed and releases its order.
+type failRefundMutation struct {
+	UpdateRefundsByPk *struct {
+		ID string `graphql:"id"`
+	} `graphql:"update_refunds_by_pk(pk_columns: {id: $refundId}, _set: $refundSet)"`
+	UpdateOrdersByPk *struct {
+		ID string `graphql:"id"`
+	} `graphql:"update_orders_by_pk(pk_columns: {id: $orderId}, _set: {refund_in_progress: false, refund_locked_at: null})"`
+}
+
+// pendingRefund is a refund the provider has not confirmed, with what is
+// needed to send it again and record it.
+type pendingRefund struct {
+	ID        string            `graphql:"id"`
+	OrderID   string            `graphql:"order_id"`
+	Amount    Money             `graphql:"amount"`
+	Reason    string            `graphql:"reason"`
+	Reference string            `graphql:"reference"`
+	Items     []RefundItemInput `graphql:"items" scalar:"true"`
+}
+
+// stuckRefundsQuery finds pending refunds whose order has held the refund
+// lock since before $lockedBefore.
+type stuckRefundsQuery struct {
+	Refunds []pendingRefund `graphql:"refunds(where: {status: {_eq: \"pending\"}, order: {refund_in_progress: {_eq: true}, refund_locked_at: {_lt: $lockedBefore}}}, order_by: {created_at: asc}, limit: $limit)"`
+}
+
+type refunds_set_input struct {
+	Status         *string   `json:"status,omitempty"`
+	ChapaReference *string   `json:"chapa_reference,omitempty"`
+	UpdatedAt      *DateTime `json:"updated_at,omitempty"`
+}
+
+type order_items_set_input struct {
+	Status           *string   `json:"status,omitempty"`
+	RefundedQuantity *int      `json:"refunded_quantity,omitempty"`
+	RefundedAmount   *Money    `json:"refunded_amount,omitempty"`
+	RefundReference  *string   `json:"refund_reference,omitempty"`
+	UpdatedAt        *DateTime `json:"updated_at,omitempty"`
+}
+
+type order_items_updates struct {
+	Where map[string]interface{} `json:"where"`
+	Set   order_items_set_input  `json:"_set"`
+}
+
+type purchases_set_input struct {
+	RevokedAt       *DateTime `json:"revoked_at,omitempty"`
+	RefundReference *string   `json:"refund_reference,omitempty"`
+}
+
+// applyRefundMutation writes every row touched by a refund in a single
+// request, so Hasura applies the item, order, purchase and refund updates
+// in one transaction.
+type applyRefundMutation struct {
+	UpdateOrderItemsMany []struct {
+		AffectedRows int `graphql:"affected_rows"`
+	} `graphql:"update_order_items_many(updates: $itemUpdates)"`
+	UpdateOrdersByPk *struct {
+		ID     string `graphql:"id"`
+		Status string `graphql:"status"`
+	} `graphql:"update_orders_by_pk(pk_columns: {id: $orderId}, _set: $orderSet)"`
+	UpdatePurchases *struct {
+		AffectedRows int `graphql:"affected_rows"`
+	} `graphql:"update_purchases(where: {buyer_id: {_eq: $buyerId}, recipe_id: {_in: $revokedRecipeIds}, revoked_at: {_is_null: true}}, _set: $purchaseSet)"`
+	UpdateRefundsByPk *struct {
+		ID string `graphql:"id"`
+	} `graphql:"update_refunds_by_pk(pk_columns: {id: $refundId}, _set: $refundSet)"`
+	InsertLedgerTransactionsOne *struct {
+		ID string `graphql:"id"`
+	} `graphql:"insert_ledger_transactions_one(object: $ledgerTransaction)"`
+}
+
+type ledger_entries_insert_input struct {
+	Account   string   `json:"account" graphql:"account"`
+	UserID    *uuid    `json:"user_id,omitempty" graphql:"user_id"`
+	Amount    Money    `json:"amount" graphql:"amount"`
+	Currency  string   `json:"currency" graphql:"currency"`
+	CreatedAt DateTime `json:"created_at" graphql:"created_at"`
+}
+
+type ledger_entries_arr_rel_insert_input struct {
+	Data []ledger_entries_insert_input `json:"data"`
+}
+
+type ledger_transactions_insert_input struct {
+	ID             uuid                                `json:"id" graphql:"id"`
+	Kind           string                              `json:"kind" graphql:"kind"`
+	IdempotencyKey string                              `json:"idempotency_key" graphql:"idempotency_key"`
+	OrderID        *uuid                               `json:"order_id,omitempty" graphql:"order_id"`
+	RefundID       *uuid                               `json:"refund_id,omitempty" graphql:"refund_id"`
+	PayoutID       *uuid                               `json:"payout_id,omitempty" graphql:"payout_id"`
+	Description    string                              `json:"description" graphql:"description"`
+	CreatedAt      DateTime                            `json:"created_at" graphql:"created_at"`
+	LedgerEntries  ledger_entries_arr_rel_insert_input `json:"ledger_entries" graphql:"ledger_entries"`
+}
+
+type ledger_transactions_arr_rel_insert_input struct {
+	Data []ledger_transactions_insert_input `json:"data"`
+}
+
+type insertLedgerTransactionMutation struct {
+	InsertLedgerTransactionsOne *struct {
+		ID string `graphql:"id"`
+	} `graphql:"insert_ledger_transactions_one(object: $object)"`
+}
+
+// Order history
+type MyOrdersActionPayload struct {
+	Action struct {
+		Name string `json:"name"`
+	} `json:"action"`
+	Input struct {
+		Input struct {
+			Limit  *int   `json:"limit"`
+			Offset *int   `json:"offset"`
+			Status string `json:"status"`
+		} `json:"input"`
+	} `json:"input"`
+	SessionVariables map[string]string `json:"session_variables"`
+}
+
+type OrderDetailActionPayload struct {
+	Action struct {
+		Name string `json:"name"`
+	} `json:"action"`
+	Input struct {
+		Input struct {
+			OrderID string `json:"orderId"`
+		} `json:"input"`
+	} `json:"input"`
+	SessionVariables map[string]string `json:"session_variables"`
+}
+
+type OrderSummary struct {
+	ID             string `json:"id"`
+	Status         string `json:"status"`
+	TxRef          string `json:"txRef"`
+	Provider       string `json:"provider"`
+	Currency       string `json:"currency"`
+	TotalAmount    Money  `json:"totalAmount"`
+	DiscountAmount Money  `json:"discountAmount"`
+	RefundedAmount Money  `json:"refundedAmount"`
+	ItemCount      int    `json:"itemCount"`
+	CreatedAt      string `json:"createdAt"`
+}
+
+type MyOrdersOutput struct {
+	Orders     []OrderSummary `json:"orders"`
+	TotalCount int            `json:"totalCount"`
+}
+
+type OrderItemDetail struct {
+	ID               string  `json:"id"`
+	RecipeID         string  `json:"recipeId"`
+	RecipeName       string  `json:"recipeName"`
+	RecipeImageURL   *string `json:"recipeImageUrl"`
+	Quantity         int     `json:"quantity"`
+	PriceAtPurchase  Money   `json:"priceAtPurchase"`
+	DiscountAmount   Money   `json:"discountAmount"`
+	LineTotal        Money   `json:"lineTotal"`
+	Status           string  `json:"status"`
+	RefundedQuantity int     `json:"refundedQuantity"`
+	RefundedAmount   Money   `json:"refundedAmount"`
+}
+
+type OrderDetailOutput struct {
+	OrderSummary
+	CouponCode    *string           `json:"couponCode"`
+	Subtotal      Money             `json:"subtotal"`
+	Items         []OrderItemDetail `json:"items"`
+	ReceiptURL    *string           `json:"receiptUrl"`
+	InvoiceNumber *string           `json:"invoiceNumber"`
+	InvoiceURL    *string           `json:"invoiceUrl"`
+}
+
+// orders_bool_exp filters orders; the type name is what Hasura expects for the variable.
+type orders_bool_exp map[string]interface{}
+
+type orderHistoryItem struct {
+	ID               string  `graphql:"id"`
+	RecipeID         string  `graphql:"recipe_id"`
+	RecipeName       string  `graphql:"recipe_name"`
+	RecipeImageURL   *string `graphql:"recipe_image_url"`
+	Quantity         int     `graphql:"quantity"`
+	PriceAtPurchase  Money   `graphql:"price_at_purchase"`
+	DiscountAmount   Money   `graphql:"discount_amount"`
+	Status           string  `graphql:"status"`
+	RefundedQuantity int     `graphql:"refunded_quantity"`
+	RefundedAmount   Money   `graphql:"refunded_amount"`
+}
+
+type orderHistoryRecord struct {
+	ID              string             `graphql:"id"`
+	UserID          string             `graphql:"user_id"`
+	Status          string             `graphql:"status"`
+	ChapaTxRef      string             `graphql:"chapa_tx_ref"`
+	PaymentProvider string             `graphql:"payment_provider"`
+	Currency        string             `graphql:"currency"`
+	TotalAmount     Money              `graphql:"total_amount"`
+	DiscountAmount  Money              `graphql:"discount_amount"`
+	RefundedAmount  Money              `graphql:"refunded_amount"`
+	CouponCode      *string            `graphql:"coupon_code"`
+	CreatedAt       string             `graphql:"created_at"`
+	OrderItems      []orderHistoryItem `graphql:"order_items(order_by: {created_at: asc})"`
+	Invoice         *struct {
+		InvoiceNumber string `graphql:"invoice_number"`
+	} `graphql:"invoice"`
+}
+
+type myOrdersQuery struct {
+	Orders          []orderHistoryRecord `graphql:"orders(where: $where, order_by: {created_at: desc}, limit: $limit, offset: $offset)"`
+	OrdersAggregate struct {
+		Aggregate struct {
+			Count int `graphql:"count"`
+		} `graphql:"aggregate"`
+	} `graphql:"orders_aggregate(where: $where)"`
+}
+
+type orderDetailQuery struct {
+	Orders []orderHistoryRecord `graphql:"orders(where: {id: {_eq: $id}, user_id: {_eq: $userId}})"`
+}
+
+// Invoices
+type invoice_items_insert_input struct {
+	OrderItemID    uuid   `json:"order_item_id" graphql:"order_item_id"`
+	LineNumber     int    `json:"line_number" graphql:"line_number"`
+	Description    string `json:"description" graphql:"description"`
+	Quantity       int    `json:"quantity" graphql:"quantity"`
+	UnitPrice      Money  `json:"unit_price" graphql:"unit_price"`
+	DiscountAmount Money  `json:"discount_amount" graphql:"discount_amount"`
+	NetAmount      Money  `json:"net_amount" graphql:"net_amount"`
+	VatAmount      Money  `json:"vat_amount" graphql:"vat_amount"`
+	TotalAmount    Money  `json:"total_amount" graphql:"total_amount"`
+}
+
+type invoice_items_arr_rel_insert_input struct {
+	Data []invoice_items_insert_input `json:"data"`
+}
+
+// invoices_insert_input leaves out sequence_number and invoice_number; the
+// database assigns both when the row is inserted.
+type invoices_insert_input struct {
+	OrderID      uuid                               `json:"order_id" graphql:"order_id"`
+	FiscalYear   int                                `json:"fiscal_year" graphql:"fiscal_year"`
+	Currency     string                             `json:"currency" graphql:"currency"`
+	Subtotal     Money                              `json:"subtotal" graphql:"subtotal"`
+	VatRate      Money                              `json:"vat_rate" graphql:"vat_rate"`
+	VatAmount    Money                              `json:"vat_amount" graphql:"vat_amount"`
+	TotalAmount  Money                              `json:"total_amount" graphql:"total_amount"`
+	IssuedAt     DateTime                           `json:"issued_at" graphql:"issued_at"`
+	InvoiceItems invoice_items_arr_rel_insert_input `json:"invoice_items" graphql:"invoice_items"`
+}
+
+type insertInvoiceMutation struct {
+	InsertInvoicesOne *struct {
+		ID            string `graphql:"id"`
+		InvoiceNumber string `graphql:"invoice_number"`
+	} `graphql:"insert_invoices_one(object: $object)"`
+}
+
+type invoiceRecord struct {
+	InvoiceNumber string `graphql:"invoice_number"`
+	FiscalYear    int    `graphql:"fiscal_year"`
+	IssuedAt      string `graphql:"issued_at"`
+	Currency      string `graphql:"currency"`
+	Subtotal      Money  `graphql:"subtotal"`
+	VatRate       Money  `graphql:"vat_rate"`
+	VatAmount     Money  `graphql:"vat_amount"`
+	TotalAmount   Money  `graphql:"total_amount"`
+	Order         struct {
+		ID         string `graphql:"id"`
+		ChapaTxRef string `graphql:"chapa_tx_ref"`
+		User       struct {
+			FirstName string `graphql:"first_name"`
+			LastName  string `graphql:"last_name"`
+			Email     string `graphql:"email"`
+		} `graphql:"user"`
+	} `graphql:"order"`
+	InvoiceItems []struct {
+		LineNumber     int    `graphql:"line_number"`
+		Description    string `graphql:"description"`
+		Quantity       int    `graphql:"quantity"`
+		UnitPrice      Money  `graphql:"unit_price"`
+		DiscountAmount Money  `graphql:"discount_amount"`
+		NetAmount      Money  `graphql:"net_amount"`
+		VatAmount      Money  `graphql:"vat_amount"`
+		TotalAmount    Money  `graphql:"total_amount"`
+	} `graphql:"invoice_items(order_by: {line_number: asc})"`
+}
+
+type invoiceQuery struct {
+	Invoices []invoiceRecord `graphql:"invoices(where: {order_id: {_eq: $orderId}, order: {user_id: {_eq: $userId}}})"`
+}
+
+// Carts
+type CartItemActionPayload struct {
+	Action struct {
+		Name string `json:"name"`
+	} `json:"action"`
+	Input struct {
+		Input struct {
+			RecipeID string `json:"recipeId"`
+			Quantity *int   `json:"quantity"`
+		} `json:"input"`
+	} `json:"input"`
+	SessionVariables map[string]string `json:"session_variables"`
+}
+
+type CartSummaryActionPayload struct {
+	Action struct {
+		Name string `json:"name"`
+	} `json:"action"`
+	SessionVariables map[string]string `json:"session_variables"`
+}
+
+type CheckoutCartActionPayload struct {
+	Action struct {
+		Name string `json:"name"`
+	} `json:"action"`
+	Input struct {
+		Input struct {
+			ReturnURL          string `json:"returnUrl"`
+			Provider           string `json:"provider"`
+			Currency           string `json:"currency"`
+			CouponCode         string `json:"couponCode"`
+			AcceptPriceChanges bool   `json:"acceptPriceChanges"`
+		} `json:"input"`
+	} `json:"input"`
+	SessionVariables map[string]string `json:"session_variables"`
+}
+
+type CartItemSummary struct {
+	RecipeID     string  `json:"recipeId"`
+	Title        string  `json:"title"`
+	ImageURL     *string `json:"imageUrl"`
+	Quantity     int     `json:"quantity"`
+	UnitPrice    Money   `json:"unitPrice"`
+	PriceAtAdd   Money   `json:"priceAtAdd"`
+	PriceChanged bool    `json:"priceChanged"`
+	LineTotal    Money   `json:"lineTotal"`
+}
+
+type CartSummaryOutput struct {
+	CartID          *string           `json:"cartId"`
+	Items           []CartItemSummary `json:"items"`
+	ItemCount       int               `json:"itemCount"`
+	Subtotal        Money             `json:"subtotal"`
+	Currency        string            `json:"currency"`
+	HasPriceChanges bool              `json:"hasPriceChanges"`
+	Issues          []CartItemError   `json:"issues"`
+}
+
+type cartItemRecord struct {
+	ID         string        `graphql:"id"`
+	RecipeID   string        `graphql:"recipe_id"`
+	Quantity   int           `graphql:"quantity"`
+	PriceAtAdd Money         `graphql:"price_at_add"`
+	Recipe     recipeDetails `graphql:"recipe"`
+}
+
+type cartQuery struct {
+	Carts []struct {
+		ID        string           `graphql:"id"`
+		CartItems []cartItemRecord `graphql:"cart_items(order_by: {created_at: asc})"`
+	} `graphql:"carts(where: {user_id: {_eq: $userId}})"`
+}
+
+type carts_insert_input struct {
+	UserID    uuid     `json:"user_id" graphql:"user_id"`
+	UpdatedAt DateTime `json:"updated_at" graphql:"updated_at"`
+}
+
+// upsertCartMutation returns the user's cart, creating it on first use.
+type upsertCartMutation struct {
+	InsertCartsOne *struct {
+		ID string `graphql:"id"`
+	} `graphql:"insert_carts_one(object: $object, on_conflict: {constraint: carts_user_id_key, update_columns: [updated_at]})"`
+}
+
+type cart_items_insert_input struct {
+	CartID     uuid     `json:"cart_id" graphql:"cart_id"`
+	RecipeID   uuid     `json:"recipe_id" graphql:"recipe_id"`
+	Quantity   int      `json:"quantity" graphql:"quantity"`
+	PriceAtAdd Money    `json:"price_at_add" graphql:"price_at_add"`
+	UpdatedAt  DateTime `json:"updated_at" graphql:"updated_at"`
+}
+
+type upsertCartItemMutation struct {
+	InsertCartItemsOne *struct {
+		ID string `graphql:"id"`
+	} `graphql:"insert_cart_items_one(object: $object, on_conflict: {constraint: cart_items_cart_id_recipe_id_key, update_columns: [quantity, updated_at]})"`
+}
+
+type cart_items_set_input struct {
+	PriceAtAdd Money    `json:"price_at_add"`
+	UpdatedAt  DateTime `json:"updated_at"`
+}
+
+type cart_items_updates struct {
+	Where map[string]interface{} `json:"where"`
+	Set   cart_items_set_input   `json:"_set"`
+}
+
+type acceptCartPricesMutation struct {
+	UpdateCartItemsMany []struct {
+		AffectedRows int `graphql:"affected_rows"`
+	} `graphql:"update_cart_items_many(updates: $updates)"`
+}
+
+type deleteCartItemsMutation struct {
+	DeleteCartItems *struct {
+		AffectedRows int `graphql:"affected_rows"`
+	} `graphql:"delete_cart_items(where: {cart: {user_id: {_eq: $userId}}, recipe_id: {_in: $recipeIds}})"`
+}
+
+// Payouts
+type MyEarningsActionPayload struct {
+	Action struct {
+		Name string `json:"name"`
+	} `json:"action"`
+	SessionVariables map[string]string `json:"session_variables"`
+}
+
+type MyPayoutHistoryActionPayload struct {
+	Action struct {
+		Name string `json:"name"`
+	} `json:"action"`
+	Input struct {
+		Input struct {
+			Limit  *int `json:"limit"`
+			Offset *int `json:"offset"`
+		} `json:"input"`
+	} `json:"input"`
+	SessionVariables map[string]string `json:"session_variables"`
+}
+
+type CreatePayoutBatchActionPayload struct {
+	Action struct {
+		Name string `json:"name"`
+	} `json:"action"`
+	Input struct {
+		Input struct {
+			Currency      string `json:"currency"`
+			MinimumAmount *Money `json:"minimumAmount"`
+		} `json:"input"`
+	} `json:"input"`
+	SessionVariables map[string]string `json:"session_variables"`
+}
+
+type ExportPayoutBatchActionPayload struct {
+	Action struct {
+		Name string `json:"name"`
+	} `json:"action"`
+	Input struct {
+		Input struct {
+			BatchID string `json:"batchId"`
+		} `json:"input"`
+	} `json:"input"`
+	SessionVariables map[string]string `json:"session_variables"`
+}
+
+type SettlePayoutBatchActionPayload struct {
+	Action struct {
+		Name string `json:"name"`
+	} `json:"action"`
+	Input struct {
+		Input struct {
+			BatchID          string   `json:"batchId"`
+			FailedReferences []string `json:"failedReferences"`
+		} `json:"input"`
+	} `json:"input"`
+	SessionVariables map[string]string `json:"session_variables"`
+}
+
+type EarningsBalance struct {
+	Currency      string `json:"currency"`
+	TotalEarned   Money  `json:"totalEarned"`
+	TotalRefunded Money  `json:"totalRefunded"`
+	TotalPaidOut  Money  `json:"totalPaidOut"`
+	Balance       Money  `json:"balance"`
+}
+
+type MyEarningsOutput struct {
+	Balances []EarningsBalance `json:"balances"`
+}
+
+type PayoutRecord struct {
+	ID        string `json:"id"`
+	BatchID   string `json:"batchId"`
+	Amount    Money  `json:"amount"`
+	Currency  string `json:"currency"`
+	Reference string `json:"reference"`
+	Status    string `json:"status"`
+	CreatedAt string `json:"createdAt"`
+}
+
+type MyPayoutHistoryOutput struct {
+	Payouts    []PayoutRecord `json:"payouts"`
+	TotalCount int            `json:"totalCount"`
+}
+
+type CreatePayoutBatchOutput struct {
+	Success        bool   `json:"success"`
+	Message        string `json:"message"`
+	BatchID        string `json:"batchId"`
+	Currency       string `json:"currency"`
+	PayoutCount    int    `json:"payoutCount"`
+	TotalAmount    Money  `json:"totalAmount"`
+	SkippedAuthors int    `json:"skippedAuthors"`
+	CSV            string `json:"csv"`
+}
+
+type ExportPayoutBatchOutput struct {
+	BatchID string `json:"batchId"`
+	CSV     string `json:"csv"`
+}
+
+type SettlePayoutBatchOutput struct {
+	BatchID     string `json:"batchId"`
+	PaidCount   int    `json:"paidCount"`
+	FailedCount int    `json:"failedCount"`
+}
+
+type authorEarningsRecord struct {
+	UserID        string `graphql:"user_id"`
+	Currency      string `graphql:"currency"`
+	TotalEarned   Money  `graphql:"total_earned"`
+	TotalRefunded Money  `graphql:"total_refunded"`
+	TotalPaidOut  Money  `graphql:"total_paid_out"`
+	Balance       Money  `graphql:"balance"`
+}
+
+type myEarningsQuery struct {
+	AuthorEarnings []authorEarningsRecord `graphql:"author_earnings(where: {user_id: {_eq: $userId}}, order_by: {currency: asc})"`
+}
+
+type payoutRow struct {
+	ID            string `graphql:"id"`
+	BatchID       string `graphql:"batch_id"`
+	AuthorID      string `graphql:"author_id"`
+	Amount        Money  `graphql:"amount"`
+	Currency      string `graphql:"currency"`
+	Reference     string `graphql:"reference"`
+	BankName      string `graphql:"bank_name"`
+	AccountName   string `graphql:"account_name"`
+	AccountNumber string `graphql:"account_number"`
+	Status        string `graphql:"status"`
+	CreatedAt     string `graphql:"created_at"`
+}
+
+type payoutHistoryQuery struct {
+	Payouts          []payoutRow `graphql:"payouts(where: {author_id: {_eq: $userId}}, order_by: {created_at: desc}, limit: $limit, offset: $offset)"`
+	PayoutsAggregate struct {
+		Aggregate struct {
+			Count int `graphql:"count"`
+		} `graphql:"aggregate"`
+	} `graphql:"payouts_aggregate(where: {author_id: {_eq: $userId}})"`
+}
+
+type payableAuthorsQuery struct {
+	AuthorEarnings []authorEarningsRecord `graphql:"author_earnings(where: {currency: {_eq: $currency}, balance: {_gte: $minimum}}, order_by: {user_id: asc})"`
+}
+
+type payoutAccountsQuery struct {
+	PayoutAccounts []struct {
+		UserID        string `graphql:"user_id"`
+		BankName      string `graphql:"bank_name"`
+		AccountName   string `graphql:"account_name"`
+		AccountNumber string `graphql:"account_number"`
+	} `graphql:"payout_accounts(where: {user_id: {_in: $userIds}})"`
+}
+
+type payouts_insert_input struct {
+	ID                 uuid                                     `json:"id" graphql:"id"`
+	AuthorID           uuid                                     `json:"author_id" graphql:"author_id"`
+	Amount             Money                                    `json:"amount" graphql:"amount"`
+	Currency           string                                   `json:"currency" graphql:"currency"`
+	Reference          string                                   `json:"reference" graphql:"reference"`
+	BankName           string                                   `json:"bank_name" graphql:"bank_name"`
+	AccountName        string                                   `json:"account_name" graphql:"account_name"`
+	AccountNumber      string                                   `json:"account_number" graphql:"account_number"`
+	Status             string                                   `json:"status" graphql:"status"`
+	CreatedAt          DateTime                                 `json:"created_at" graphql:"created_at"`
+	LedgerTransactions ledger_transactions_arr_rel_insert_input `json:"ledger_transactions" graphql:"ledger_transactions"`
+}
+
+type payouts_arr_rel_insert_input struct {
+	Data []payouts_insert_input `json:"data"`
+}
+
+type payout_batches_insert_input struct {
+	ID          uuid                         `json:"id" graphql:"id"`
+	CreatedBy   *uuid                        `json:"created_by,omitempty" graphql:"created_by"`
+	Currency    string                       `json:"currency" graphql:"currency"`
+	TotalAmount Money                        `json:"total_amount" graphql:"total_amount"`
+	PayoutCount int                          `json:"payout_count" graphql:"payout_count"`
+	CreatedAt   DateTime                     `json:"created_at" graphql:"created_at"`
+	Payouts     payouts_arr_rel_insert_input `json:"payouts" graphql:"payouts"`
+}
+
+// insertPayoutBatchMutation writes the batch, its payouts and their ledger
+// transactions in one request, so a batch is either fully recorded or not at all.
+type insertPayoutBatchMutation struct {
+	InsertPayoutBatchesOne *struct {
+		ID string `graphql:"id"`
+	} `graphql:"insert_payout_batches_one(object: $object)"`
+}
+
+type payoutBatchQuery struct {
+	PayoutBatchesByPk *struct {
+		ID      string      `graphql:"id"`
+		Payouts []payoutRow `graphql:"payouts(order_by: {author_id: asc})"`
+	} `graphql:"payout_batches_by_pk(id: $id)"`
+}
+
+type payouts_set_input struct {
+	Status    string   `json:"status"`
+	SettledAt DateTime `json:"settled_at"`
+}
+
+// settlePayoutBatchMutation marks the batch's failed payouts, then every
+// other payout still processing as paid, and reverses the failed ones in the
+// ledger, in one transaction.
+type settlePayoutBatchMutation struct {
+	FailPayouts *struct {
+		AffectedRows int `graphql:"affected_rows"`
+	} `graphql:"failPayouts: update_payouts(where: {batch_id: {_eq: $batchId}, status: {_eq: \"processing\"}, id: {_in: $failedIds}}, _set: $failedSet)"`
+	PayPayouts *struct {
+		AffectedRows int `graphql:"affected_rows"`
+	} `graphql:"payPayouts: update_payouts(where: {batch_id: {_eq: $batchId}, status: {_eq: \"processing\"}}, _set: $paidSet)"`
+	InsertLedgerTransactions *struct {
+		AffectedRows int `graphql:"affected_rows"`
+	} `graphql:"insert_ledger_transactions(objects: $reversals)"`
 }
 
 // Chapa API
@@ -162,9 +1018,492 @@ type ChapaVerifyResponse struct {
 	Message string `json:"message"`
 	Data    struct {
 		ID       string  `json:"id"`
-		Amount   float64 `json:"amount"`
+		Amount   Money   `json:"amount"`
 		TxRef    string  `json:"tx_ref"`
 		Currency string  `json:"currency"`
 		Status   string  `json:"status"`
 	} `json:"data"`
-}
\ No newline at end of file
+}
+
+type ChapaRefundRequest struct {
+	Reason    string `json:"reason"`
+	Amount    string `json:"amount"`
+	Reference string `json:"reference"`
+}
+
+type ChapaRefundResponse struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	Data    struct {
+		ChapaReference    string `json:"chapa_reference"`
+		MerchantReference string `json:"merchant_reference"`
+		Currency          string `json:"currency"`
+	} `json:"data"`
+}
+
+// Order expiry
+type openOrderQuery struct {
+	Orders []struct {
+		ID              string  `graphql:"id"`
+		ChapaTxRef      string  `graphql:"chapa_tx_ref"`
+		CheckoutURL     *string `graphql:"checkout_url"`
+		PaymentProvider string  `graphql:"payment_provider"`
+		TotalAmount     Money   `graphql:"total_amount"`
+		DiscountAmount  Money   `graphql:"discount_amount"`
+	} `graphql:"orders(where: $where, order_by: {created_at: desc}, limit: 1)"`
+}
+
+type updateOrderMutation struct {
+	UpdateOrdersByPk *struct {
+		ID string `graphql:"id"`
+	} `graphql:"update_orders_by_pk(pk_columns: {id: $id}, _set: $set)"`
+}
+
+type expiredOrder struct {
+	ID           string    `graphql:"id"`
+	UserID       string    `graphql:"user_id"`
+	OrderType    string    `graphql:"order_type"`
+	ExpiresAt    *DateTime `graphql:"expires_at"`
+	CreatedAt    DateTime  `graphql:"created_at"`
+	CheckoutType *string `graphql:"checkout_type"`
+	ChapaTxRef  string `graphql:"chapa_tx_ref"`
+	ReturnURL   string `graphql:"return_url"`
+	Currency    string `graphql:"currency"`
+	TotalAmount Money  `graphql:"total_amount"`
+	User        struct {
+		Email     string `graphql:"email"`
+		FirstName string `graphql:"first_name"`
+	} `graphql:"user"`
+}
+
+// expirableOrdersQuery finds the oldest pending orders matching $where, so
+// one cleanup run expires a bounded batch of them.
+type expirableOrdersQuery struct {
+	Orders []struct {
+		ID string `graphql:"id"`
+	} `graphql:"orders(where: $where, order_by: {created_at: asc}, limit: $limit)"`
+}
+
+type expiredOrders struct {
+	AffectedRows int            `graphql:"affected_rows"`
+	Returning    []expiredOrder `graphql:"returning"`
+}
+
+type expireOrdersMutation struct {
+	UpdateOrders *expiredOrders `graphql:"update_orders(where: $where, _set: $set)"`
+}
+
+// claimRemindersMutation stamps reminder_sent_at on the given expired
+// purchases that had a checkout window and have not been reminded yet, and
+// returns only those, so an order is reminded at most once even when
+// cleanup runs overlap.
+type claimRemindersMutation struct {
+	UpdateOrders *struct {
+		Returning []expiredOrder `graphql:"returning"`
+	} `graphql:"update_orders(where: {id: {_in: $ids}, status: {_eq: \"expired\"}, order_type: {_eq: \"purchase\"}, expires_at: {_is_null: false}, reminder_sent_at: {_is_null: true}}, _set: {reminder_sent_at: $now})"`
+}
+
+type IssueMissingInvoicesOutput struct {
+	Issued int `json:"issued"`
+	Failed int `json:"failed"`
+}
+
+type ReconcileRefundsOutput struct {
+	Completed int `json:"completed"`
+	Failed    int `json:"failed"`
+	Pending   int `json:"pending"`
+}
+
+type PostMissingSalesOutput struct {
+	Posted int `json:"posted"`
+	Failed int `json:"failed"`
+}
+
+type ExpireOrdersOutput struct {
+	Expired       int `json:"expired"`
+	RemindersSent int `json:"remindersSent"`
+}
+
+// Exchange rates
+type SetExchangeRateActionPayload struct {
+	Action struct {
+		Name string `json:"name"`
+	} `json:"action"`
+	Input struct {
+		Input struct {
+			Currency   string       `json:"currency"`
+			EtbPerUnit ExchangeRate `json:"etbPerUnit"`
+		} `json:"input"`
+	} `json:"input"`
+	SessionVariables map[string]string `json:"session_variables"`
+}
+
+type ImportExchangeRatesActionPayload struct {
+	Action struct {
+		Name string `json:"name"`
+	} `json:"action"`
+	Input struct {
+		Input struct {
+			CSV string `json:"csv"`
+		} `json:"input"`
+	} `json:"input"`
+	SessionVariables map[string]string `json:"session_variables"`
+}
+
+type ExchangeRateOutput struct {
+	Currency   string       `json:"currency"`
+	EtbPerUnit ExchangeRate `json:"etbPerUnit"`
+	Source     string       `json:"source"`
+	UpdatedAt  string       `json:"updatedAt"`
+}
+
+type ImportExchangeRatesOutput struct {
+	Imported int                  `json:"imported"`
+	Rates    []ExchangeRateOutput `json:"rates"`
+}
+
+type exchange_rates_insert_input struct {
+	Currency   string       `json:"currency" graphql:"currency"`
+	EtbPerUnit ExchangeRate `json:"etb_per_unit" graphql:"etb_per_unit"`
+	Source     string       `json:"source" graphql:"source"`
+	UpdatedAt  DateTime     `json:"updated_at" graphql:"updated_at"`
+}
+
+type exchangeRateRecord struct {
+	Currency   string       `graphql:"currency"`
+	EtbPerUnit ExchangeRate `graphql:"etb_per_unit"`
+	Source     string       `graphql:"source"`
+	UpdatedAt  string       `graphql:"updated_at"`
+}
+
+type exchangeRateQuery struct {
+	ExchangeRatesByPk *exchangeRateRecord `graphql:"exchange_rates_by_pk(currency: $currency)"`
+}
+
+type upsertExchangeRatesMutation struct {
+	InsertExchangeRates *struct {
+		Returning []exchangeRateRecord `graphql:"returning"`
+	} `graphql:"insert_exchange_rates(objects: $objects, on_conflict: {constraint: exchange_rates_pkey, update_columns: [etb_per_unit, source, updated_at]})"`
+}
+
+// Subscriptions
+type SubscribeActionPayload struct {
+	Action struct {
+		Name string `json:"name"`
+	} `json:"action"`
+	Input struct {
+		Input struct {
+			PlanID    string `json:"planId"`
+			ReturnURL string `json:"returnUrl"`
+			Provider  string `json:"provider"`
+		} `json:"input"`
+	} `json:"input"`
+	SessionVariables map[string]string `json:"session_variables"`
+}
+
+type SubscribeOutput struct {
+	SubscriptionID string `json:"subscriptionId"`
+	PaymentID      string `json:"paymentId"`
+	CheckoutURL    string `json:"checkoutUrl"`
+	TxRef          string `json:"txRef"`
+	Provider       string `json:"provider"`
+	Amount         Money  `json:"amount"`
+	Currency       string `json:"currency"`
+	Message        string `json:"message"`
+}
+
+type CancelSubscriptionActionPayload struct {
+	Action struct {
+		Name string `json:"name"`
+	} `json:"action"`
+	SessionVariables map[string]string `json:"session_variables"`
+}
+
+type CancelSubscriptionOutput struct {
+	SubscriptionID    string `json:"subscriptionId"`
+	Status            string `json:"status"`
+	CancelAtPeriodEnd bool   `json:"cancelAtPeriodEnd"`
+	CurrentPeriodEnd  string `json:"currentPeriodEnd"`
+}
+
+type RenewSubscriptionsOutput struct {
+	ExpiredPayments int `json:"expiredPayments"`
+	PastDue         int `json:"pastDue"`
+	Expired         int `json:"expired"`
+	Canceled        int `json:"canceled"`
+	RenewalsStarted int `json:"renewalsStarted"`
+	RemindersSent   int `json:"remindersSent"`
+}
+
+type planRecord struct {
+	ID              string `graphql:"id"`
+	Name            string `graphql:"name"`
+	BillingInterval string `graphql:"billing_interval"`
+	Price           Money  `graphql:"price"`
+	Currency        string `graphql:"currency"`
+	IsActive        bool   `graphql:"is_active"`
+}
+
+type subscriptionCheckout struct {
+	ID              string  `graphql:"id"`
+	ChapaTxRef      string  `graphql:"chapa_tx_ref"`
+	CheckoutURL     *string `graphql:"checkout_url"`
+	PaymentProvider string  `graphql:"payment_provider"`
+	Amount          Money   `graphql:"amount"`
+	Currency        string  `graphql:"currency"`
+}
+
+// subscribeQuery loads the plan, any live subscription of the user and a
+// still open checkout for the same plan that can be handed out again.
+type subscribeQuery struct {
+	PlansByPk     *planRecord `graphql:"plans_by_pk(id: $planId)"`
+	Subscriptions []struct {
+		ID     string `graphql:"id"`
+		Status string `graphql:"status"`
+	} `graphql:"live: subscriptions(where: {user_id: {_eq: $userId}, status: {_in: [\"active\", \"past_due\"]}})"`
+	Pending []struct {
+		ID                   string                 `graphql:"id"`
+		SubscriptionPayments []subscriptionCheckout `graphql:"subscription_payments(where: {status: {_eq: \"pending\"}, payment_provider: {_eq: $provider}, expires_at: {_gt: $openUntil}, checkout_url: {_is_null: false}}, order_by: {created_at: desc}, limit: 1)"`
+	} `graphql:"pending: subscriptions(where: {user_id: {_eq: $userId}, plan_id: {_eq: $planId}, status: {_eq: \"pending\"}}, order_by: {created_at: desc}, limit: 1)"`
+}
+
+type subscription_payments_insert_input struct {
+	ID              uuid     `json:"id" graphql:"id"`
+	SubscriptionID  uuid     `json:"subscription_id,omitempty" graphql:"subscription_id"`
+	Amount          Money    `json:"amount" graphql:"amount"`
+	Currency        string   `json:"currency" graphql:"currency"`
+	Status          string   `json:"status" graphql:"status"`
+	PaymentProvider string   `json:"payment_provider" graphql:"payment_provider"`
+	ChapaTxRef      string   `json:"chapa_tx_ref" graphql:"chapa_tx_ref"`
+	ReturnURL       string   `json:"return_url" graphql:"return_url"`
+	ExpiresAt       DateTime `json:"expires_at" graphql:"expires_at"`
+}
+
+type subscription_payments_arr_rel_insert_input struct {
+	Data []subscription_payments_insert_input `json:"data"`
+}
+
+type subscriptions_insert_input struct {
+	ID                   uuid                                       `json:"id" graphql:"id"`
+	UserID               uuid                                       `json:"user_id" graphql:"user_id"`
+	PlanID               uuid                                       `json:"plan_id" graphql:"plan_id"`
+	Status               string                                     `json:"status" graphql:"status"`
+	SubscriptionPayments subscription_payments_arr_rel_insert_input `json:"subscription_payments" graphql:"subscription_payments"`
+}
+
+type insertSubscriptionMutation struct {
+	InsertSubscriptionsOne *struct {
+		ID string `graphql:"id"`
+	} `graphql:"insert_subscriptions_one(object: $object)"`
+}
+
+type insertSubscriptionPaymentMutation struct {
+	InsertSubscriptionPaymentsOne *struct {
+		ID string `graphql:"id"`
+	} `graphql:"insert_subscription_payments_one(object: $object)"`
+}
+
+type subscription_payments_set_input struct {
+	Status             *string   `json:"status,omitempty"`
+	ChapaTransactionID *string   `json:"chapa_transaction_id,omitempty"`
+	CheckoutURL        *string   `json:"checkout_url,omitempty"`
+	PaidAt             *DateTime `json:"paid_at,omitempty"`
+	UpdatedAt          *DateTime `json:"updated_at,omitempty"`
+}
+
+type updateSubscriptionPaymentMutation struct {
+	UpdateSubscriptionPaymentsByPk *struct {
+		ID string `graphql:"id"`
+	} `graphql:"update_subscription_payments_by_pk(pk_columns: {id: $id}, _set: $set)"`
+}
+
+// updateSubscriptionPaymentStatusMutation only touches a payment that has
+// not completed yet, so a replayed callback cannot extend a period twice.
+type updateSubscriptionPaymentStatusMutation struct {
+	UpdateSubscriptionPayments *struct {
+		AffectedRows int `graphql:"affected_rows"`
+	} `graphql:"update_subscription_payments(where: {chapa_tx_ref: {_eq: $txRef}, status: {_neq: \"completed\"}}, _set: $set)"`
+}
+
+// completeSubscriptionPaymentMutation activates the subscription and marks
+// its payment completed in one transaction. The subscription is updated
+// first, while the payment is still open, so a replayed callback matches
+// neither row.
+type completeSubscriptionPaymentMutation struct {
+	UpdateSubscriptions *struct {
+		AffectedRows int `graphql:"affected_rows"`
+	} `graphql:"update_subscriptions(where: {id: {_eq: $id}, subscription_payments: {chapa_tx_ref: {_eq: $txRef}, status: {_neq: \"completed\"}}}, _set: $subscription)"`
+	UpdateSubscriptionPayments *struct {
+		AffectedRows int `graphql:"affected_rows"`
+	} `graphql:"update_subscription_payments(where: {chapa_tx_ref: {_eq: $txRef}, status: {_neq: \"completed\"}}, _set: $payment)"`
+}
+
+type subscriptionPaymentRecord struct {
+	ID              string `graphql:"id"`
+	Amount          Money  `graphql:"amount"`
+	Currency        string `graphql:"currency"`
+	PaymentProvider string `graphql:"payment_provider"`
+	ReturnURL       string `graphql:"return_url"`
+	Subscription    struct {
+		ID               string    `graphql:"id"`
+		UserID           string    `graphql:"user_id"`
+		Status           string    `graphql:"status"`
+		CurrentPeriodEnd *DateTime `graphql:"current_period_end"`
+		Plan             struct {
+			BillingInterval string `graphql:"billing_interval"`
+		} `graphql:"plan"`
+	} `graphql:"subscription"`
+}
+
+type subscriptionPaymentQuery struct {
+	SubscriptionPayments []subscriptionPaymentRecord `graphql:"subscription_payments(where: {chapa_tx_ref: {_eq: $txRef}})"`
+}
+
+// subscriptions_set_input is a map so a column can be set back to null.
+type subscriptions_set_input map[string]interface{}
+
+type updateSubscriptionMutation struct {
+	UpdateSubscriptionsByPk *struct {
+		ID string `graphql:"id"`
+	} `graphql:"update_subscriptions_by_pk(pk_columns: {id: $id}, _set: $set)"`
+}
+
+type cancelSubscriptionMutation struct {
+	UpdateSubscriptions *struct {
+		Returning []struct {
+			ID                string `graphql:"id"`
+			Status            string `graphql:"status"`
+			CancelAtPeriodEnd bool   `graphql:"cancel_at_period_end"`
+			CurrentPeriodEnd  string `graphql:"current_period_end"`
+		} `graphql:"returning"`
+	} `graphql:"update_subscriptions(where: {user_id: {_eq: $userId}, status: {_in: [\"active\", \"past_due\"]}}, _set: {cancel_at_period_end: true})"`
+}
+
+type affectedRows struct {
+	AffectedRows int `graphql:"affected_rows"`
+}
+
+// advanceSubscriptionsMutation moves subscriptions and their checkouts along
+// in one transaction. Hasura runs the fields in order, so a subscription can
+// go from active to past_due to expired in a single run.
+type advanceSubscriptionsMutation struct {
+	ExpiredPayments *affectedRows `graphql:"expiredPayments: update_subscription_payments(where: {status: {_eq: \"pending\"}, expires_at: {_lt: $now}}, _set: {status: \"expired\"})"`
+	Abandoned       *affectedRows `graphql:"abandoned: update_subscriptions(where: {status: {_eq: \"pending\"}, created_at: {_lt: $abandonedBefore}}, _set: {status: \"expired\"})"`
+	Canceled        *affectedRows `graphql:"canceled: update_subscriptions(where: {status: {_in: [\"active\", \"past_due\"]}, cancel_at_period_end: {_eq: true}, current_period_end: {_lt: $now}}, _set: {status: \"canceled\"})"`
+	PastDue         *affectedRows `graphql:"pastDue: update_subscriptions(where: {status: {_eq: \"active\"}, current_period_end: {_lt: $now}}, _set: {status: \"past_due\"})"`
+	Expired         *affectedRows `graphql:"expired: update_subscriptions(where: {status: {_eq: \"past_due\"}, current_period_end: {_lt: $graceStart}}, _set: {status: \"expired\"})"`
+}
+
+type renewalDueSubscription struct {
+	ID               string   `graphql:"id"`
+	UserID           string   `graphql:"user_id"`
+	CurrentPeriodEnd DateTime `graphql:"current_period_end"`
+	Plan             struct {
+		Name     string `graphql:"name"`
+		Price    Money  `graphql:"price"`
+		Currency string `graphql:"currency"`
+		IsActive bool   `graphql:"is_active"`
+	} `graphql:"plan"`
+	SubscriptionPayments []struct {
+		PaymentProvider string `graphql:"payment_provider"`
+		ReturnURL       string `graphql:"return_url"`
+	} `graphql:"subscription_payments(order_by: {created_at: desc}, limit: 1)"`
+}
+
+type renewalDueQuery struct {
+	Subscriptions []renewalDueSubscription `graphql:"subscriptions(where: {status: {_eq: \"active\"}, cancel_at_period_end: {_eq: false}, current_period_end: {_lt: $dueBefore}, renewal_reminder_sent_at: {_is_null: true}})"`
+}
+
+// Tips
+type TipAuthorActionPayload struct {
+	Action struct {
+		Name string `json:"name"`
+	} `json:"action"`
+	Input struct {
+		Input struct {
+			RecipeID  string `json:"recipeId"`
+			Amount    Money  `json:"amount"`
+			Currency  string `json:"currency"`
+			Message   string `json:"message"`
+			ReturnURL string `json:"returnUrl"`
+			Provider  string `json:"provider"`
+		} `json:"input"`
+	} `json:"input"`
+	SessionVariables map[string]string `json:"session_variables"`
+}
+
+// Sales reports
+type CreatorSalesReportActionPayload struct {
+	Action struct {
+		Name string `json:"name"`
+	} `json:"action"`
+	Input struct {
+		Input struct {
+			Period   string `json:"period"`
+			From     string `json:"from"`
+			To       string `json:"to"`
+			RecipeID string `json:"recipeId"`
+			Format   string `json:"format"`
+		} `json:"input"`
+	} `json:"input"`
+	SessionVariables map[string]string `json:"session_variables"`
+}
+
+type SalesReportRow struct {
+	RecipeID       string  `json:"recipeId"`
+	RecipeTitle    string  `json:"recipeTitle"`
+	PeriodStart    string  `json:"periodStart"`
+	Units          int     `json:"units"`
+	RefundedUnits  int     `json:"refundedUnits"`
+	Revenue        Money   `json:"revenue"`
+	NetRevenue     Money   `json:"netRevenue"`
+	RefundRate     float64 `json:"refundRate"`
+	Views          int     `json:"views"`
+	Purchases      int     `json:"purchases"`
+	ConversionRate float64 `json:"conversionRate"`
+}
+
+type CreatorSalesReportOutput struct {
+	Period   string           `json:"period"`
+	From     string           `json:"from"`
+	To       string           `json:"to"`
+	Currency string           `json:"currency"`
+	Rows     []SalesReportRow `json:"rows"`
+	Totals   SalesReportRow   `json:"totals"`
+	CSV      *string          `json:"csv"`
+}
+
+// order_items_bool_exp and recipe_view_daily_bool_exp filter the sales
+// report; the type names are what Hasura expects for the variables.
+type order_items_bool_exp map[string]interface{}
+type recipe_view_daily_bool_exp map[string]interface{}
+
+type soldOrderItem struct {
+	RecipeID         string `graphql:"recipe_id"`
+	Quantity         int    `graphql:"quantity"`
+	PriceAtPurchase  Money  `graphql:"price_at_purchase"`
+	DiscountAmount   Money  `graphql:"discount_amount"`
+	RefundedQuantity int    `graphql:"refunded_quantity"`
+	RefundedAmount   Money  `graphql:"refunded_amount"`
+	Recipe           struct {
+		Title string `graphql:"title"`
+	} `graphql:"recipe"`
+	Order struct {
+		CreatedAt    DateTime     `graphql:"created_at"`
+		ExchangeRate ExchangeRate `graphql:"exchange_rate"`
+	} `graphql:"order"`
+}
+
+type recipeViewDay struct {
+	RecipeID string `graphql:"recipe_id"`
+	Day      string `graphql:"day"`
+	Views    int    `graphql:"views"`
+	Recipe   struct {
+		Title string `graphql:"title"`
+	} `graphql:"recipe"`
+}
+
+type creatorSalesQuery struct {
+	OrderItems []soldOrderItem `graphql:"order_items(where: $items)"`
+	Views      []recipeViewDay `graphql:"recipe_view_daily(where: $views)"`
+}
@@ -0,0 +1,127 @@
+package payment
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Money is an exact amount in minor units (santim for ETB, cents for USD).
+// It marshals to and from JSON as a decimal number with two fraction digits,
+// which is how Hasura represents numeric columns, so it never passes
+// through float64.
+type Money int64
+
+// ParseMoney parses a decimal string such as "12", "12.5" or "-3.07".
+// More than two fraction digits are only accepted when the extra digits are zero.
+func ParseMoney(s string) (Money, error) {
+	return parseMoney(s, false)
+}
+
+// roundMoney parses a decimal string like ParseMoney but rounds more than
+// two fraction digits half up to the nearest minor unit, e.g. "1.005" to 1.01.
+func roundMoney(s string) (Money, error) {
+	return parseMoney(s, true)
+}
+
+func parseMoney(s string, round bool) (Money, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, fmt.Errorf("invalid amount: empty")
+	}
+	negative := false
+	if s[0] == '-' || s[0] == '+' {
+		negative = s[0] == '-'
+		s = s[1:]
+	}
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" && frac == "" {
+		return 0, fmt.Errorf("invalid amount: %q", s)
+	}
+	roundUp := false
+	if len(frac) > 2 {
+		extra := frac[2:]
+		if strings.Trim(extra, "0123456789") != "" {
+			return 0, fmt.Errorf("invalid amount: %q", s)
+		}
+		if !round && strings.Trim(extra, "0") != "" {
+			return 0, fmt.Errorf("invalid amount %q: more than two decimal places", s)
+		}
+		roundUp = extra[0] >= '5'
+		frac = frac[:2]
+	}
+	for len(frac) < 2 {
+		frac += "0"
+	}
+	if whole == "" {
+		whole = "0"
+	}
+	for _, part := range []string{whole, frac} {
+		for _, c := range part {
+			if c < '0' || c > '9' {
+				return 0, fmt.Errorf("invalid amount: %q", s)
+			}
+		}
+	}
+	units, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q: %w", s, err)
+	}
+	if roundUp {
+		units++
+	}
+	if negative {
+		units = -units
+	}
+	return Money(units), nil
+}
+
+// String formats the amount with exactly two decimal places, e.g. "12.50".
+func (m Money) String() string {
+	sign := ""
+	units := int64(m)
+	if units < 0 {
+		sign = "-"
+		units = -units
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, units/100, units%100)
+}
+
+// Mul returns the amount multiplied by a quantity.
+func (m Money) Mul(quantity int) Money {
+	return m * Money(quantity)
+}
+
+// Percentage applies a percentage with up to two decimals, such as 12.5 for
+// 12.5%, rounding half up to the nearest minor unit. Stored as Money, a
+// percentage's minor units are hundredths of a percent (basis points).
+func (m Money) Percentage(percent Money) Money {
+	return Money((int64(m)*int64(percent) + 5000) / 10000)
+}
+
+// GetGraphQLType lets Money be passed directly as a numeric query variable.
+func (m Money) GetGraphQLType() string {
+	return "numeric"
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON accepts a JSON number or a quoted decimal string, rounding
+// more than two decimals half up as clients computing prices in floating
+// point often send them. null leaves the amount at zero.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	s := string(bytes.Trim(data, `"`))
+	parsed, err := roundMoney(s)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
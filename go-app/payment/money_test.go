@@ -0,0 +1,126 @@
+package payment
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Money
+		wantErr bool
+	}{
+		{in: "12", want: 1200},
+		{in: "12.5", want: 1250},
+		{in: "12.50", want: 1250},
+		{in: "0.07", want: 7},
+		{in: ".5", want: 50},
+		{in: "3.", want: 300},
+		{in: "-3.07", want: -307},
+		{in: "+4.20", want: 420},
+		{in: " 1.10 ", want: 110},
+		{in: "1.2300", want: 123},
+		{in: "0", want: 0},
+		{in: "", wantErr: true},
+		{in: "-", wantErr: true},
+		{in: ".", wantErr: true},
+		{in: "1.234", wantErr: true},
+		{in: "1,50", wantErr: true},
+		{in: "12a", wantErr: true},
+		{in: "1.-5", wantErr: true},
+		{in: "1e3", wantErr: true},
+		{in: "99999999999999999999", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseMoney(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseMoney(%q) = %s, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseMoney(%q) = %d, %v; want %d", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	tests := []struct {
+		in   Money
+		want string
+	}{
+		{0, "0.00"},
+		{7, "0.07"},
+		{1250, "12.50"},
+		{-307, "-3.07"},
+		{-5, "-0.05"},
+		{123456789, "1234567.89"},
+	}
+	for _, tt := range tests {
+		if got := tt.in.String(); got != tt.want {
+			t.Errorf("Money(%d).String() = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMoneyPercentage(t *testing.T) {
+	tests := []struct {
+		amount  Money
+		percent Money
+		want    Money
+	}{
+		{amount: 10000, percent: 1500, want: 1500},
+		{amount: 999, percent: 1000, want: 100},
+		{amount: 995, percent: 1000, want: 100},
+		{amount: 994, percent: 1000, want: 99},
+		{amount: 1000, percent: 1250, want: 125},
+		{amount: 1000, percent: 0, want: 0},
+		{amount: 1000, percent: 10000, want: 1000},
+	}
+	for _, tt := range tests {
+		if got := tt.amount.Percentage(tt.percent); got != tt.want {
+			t.Errorf("%s.Percentage(%s) = %s, want %s", tt.amount, tt.percent, got, tt.want)
+		}
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Money
+		wantErr bool
+	}{
+		{in: `12.5`, want: 1250},
+		{in: `"12.50"`, want: 1250},
+		{in: `0`, want: 0},
+		{in: `null`, want: 0},
+		{in: `"abc"`, wantErr: true},
+		{in: `1.005`, want: 101},
+		{in: `1.0049`, want: 100},
+		{in: `"9.995"`, want: 1000},
+		{in: `-2.125`, want: -213},
+		{in: `"1.00x"`, wantErr: true},
+	}
+	for _, tt := range tests {
+		var got Money
+		err := json.Unmarshal([]byte(tt.in), &got)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("unmarshal %s = %s, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("unmarshal %s = %s, %v; want %s", tt.in, got, err, tt.want)
+		}
+	}
+
+	out, err := json.Marshal(struct {
+		Amount Money `json:"amount"`
+	}{Amount: 1250})
+	if err != nil || string(out) != `{"amount":12.50}` {
+		t.Errorf("marshal = %s, %v; want {\"amount\":12.50}", out, err)
+	}
+}
@@ -0,0 +1,212 @@
+package payment
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	google_uuid "github.com/google/uuid"
+)
+
+const (
+	defaultOrderHistoryLimit = 20
+	maxOrderHistoryLimit     = 100
+)
+
+// orderStatuses lists every status an order can be filtered by.
+var orderStatuses = map[string]bool{
+	"pending":            true,
+	"completed":          true,
+	"failed":             true,
+	"amount_mismatch":    true,
+	"partially_refunded": true,
+	"refunded":           true,
+	"expired":            true,
+	"unknown":            true,
+}
+
+func newOrderSummary(order orderHistoryRecord) OrderSummary {
+	summary := OrderSummary{
+		ID:             order.ID,
+		Status:         order.Status,
+		TxRef:          order.ChapaTxRef,
+		Provider:       order.PaymentProvider,
+		Currency:       order.Currency,
+		TotalAmount:    order.TotalAmount,
+		DiscountAmount: order.DiscountAmount,
+		RefundedAmount: order.RefundedAmount,
+		CreatedAt:      order.CreatedAt,
+	}
+	for _, item := range order.OrderItems {
+		summary.ItemCount += item.Quantity
+	}
+	return summary
+}
+
+// receiptStatuses lists the statuses of orders that were paid for and so
+// have a receipt.
+var receiptStatuses = map[string]bool{
+	"completed":          true,
+	"partially_refunded": true,
+	"refunded":           true,
+}
+
+// receiptURL is where the buyer can download the PDF receipt for an order.
+func receiptURL(orderID string) string {
+	return fmt.Sprintf("%s/orders/%s/receipt.pdf", publicBaseURL(), orderID)
+}
+
+// HandleMyOrders handles the Hasura Action webhook listing the caller's orders, newest first.
+func HandleMyOrders(w http.ResponseWriter, r *http.Request, hasuraService *HasuraService) {
+	var payload MyOrdersActionPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Failed to parse Hasura Action payload")
+		return
+	}
+	defer r.Body.Close()
+
+	userID := payload.SessionVariables["x-hasura-user-id"]
+	if userID == "" {
+		respondWithError(w, http.StatusBadRequest, "Missing user ID")
+		return
+	}
+	input := payload.Input.Input
+	limit := defaultOrderHistoryLimit
+	if input.Limit != nil {
+		limit = *input.Limit
+	}
+	offset := 0
+	if input.Offset != nil {
+		offset = *input.Offset
+	}
+	if limit <= 0 || limit > maxOrderHistoryLimit || offset < 0 {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d and offset cannot be negative", maxOrderHistoryLimit))
+		return
+	}
+	if input.Status != "" && !orderStatuses[input.Status] {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Unknown order status: %s", input.Status))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	resp, err := hasuraService.QueryMyOrders(ctx, userID, input.Status, limit, offset)
+	if err != nil {
+		log.Printf("Failed to query orders for %s: %v", userID, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve orders")
+		return
+	}
+
+	output := MyOrdersOutput{
+		Orders:     []OrderSummary{},
+		TotalCount: resp.OrdersAggregate.Aggregate.Count,
+	}
+	for _, order := range resp.Orders {
+		output.Orders = append(output.Orders, newOrderSummary(order))
+	}
+	respondWithJSON(w, http.StatusOK, output)
+}
+
+// HandleOrderDetail handles the Hasura Action webhook returning one of the
+// caller's orders with its item snapshots. Orders of other users are
+// reported as not found.
+func HandleOrderDetail(w http.ResponseWriter, r *http.Request, hasuraService *HasuraService) {
+	var payload OrderDetailActionPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Failed to parse Hasura Action payload")
+		return
+	}
+	defer r.Body.Close()
+
+	userID := payload.SessionVariables["x-hasura-user-id"]
+	orderID := payload.Input.Input.OrderID
+	if userID == "" || orderID == "" {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload or missing user ID")
+		return
+	}
+	if _, err := google_uuid.Parse(orderID); err != nil {
+		respondWithError(w, http.StatusNotFound, "Order not found")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	resp, err := hasuraService.QueryOrderDetail(ctx, userID, orderID)
+	if err != nil {
+		log.Printf("Failed to query order %s for %s: %v", orderID, userID, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve order")
+		return
+	}
+	if len(resp.Orders) == 0 {
+		respondWithError(w, http.StatusNotFound, "Order not found")
+		return
+	}
+	order := resp.Orders[0]
+
+	output := OrderDetailOutput{
+		OrderSummary: newOrderSummary(order),
+		CouponCode:   order.CouponCode,
+		Items:        []OrderItemDetail{},
+	}
+	if receiptStatuses[order.Status] {
+		url := receiptURL(order.ID)
+		output.ReceiptURL = &url
+	}
+	if order.Invoice != nil {
+		url := invoiceURL(order.ID)
+		output.InvoiceNumber = &order.Invoice.InvoiceNumber
+		output.InvoiceURL = &url
+	}
+	for _, item := range order.OrderItems {
+		lineTotal := item.PriceAtPurchase.Mul(item.Quantity)
+		output.Subtotal += lineTotal
+		output.Items = append(output.Items, OrderItemDetail{
+			ID:               item.ID,
+			RecipeID:         item.RecipeID,
+			RecipeName:       item.RecipeName,
+			RecipeImageURL:   item.RecipeImageURL,
+			Quantity:         item.Quantity,
+			PriceAtPurchase:  item.PriceAtPurchase,
+			DiscountAmount:   item.DiscountAmount,
+			LineTotal:        lineTotal - item.DiscountAmount,
+			Status:           item.Status,
+			RefundedQuantity: item.RefundedQuantity,
+			RefundedAmount:   item.RefundedAmount,
+		})
+	}
+	respondWithJSON(w, http.StatusOK, output)
+}
+
+// HandleOrderReceipt serves the PDF receipt for one of the user's orders.
+// It is called directly by the browser, so the caller authenticates the
+// request and passes in the user ID. Only orders that were paid for have a
+// receipt.
+func HandleOrderReceipt(w http.ResponseWriter, r *http.Request, hasuraService *HasuraService, userID string, orderID string) {
+	if _, err := google_uuid.Parse(orderID); err != nil {
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	resp, err := hasuraService.QueryOrderDetail(ctx, userID, orderID)
+	if err != nil {
+		log.Printf("Failed to query order %s for receipt: %v", orderID, err)
+		http.Error(w, "Failed to retrieve order", http.StatusInternalServerError)
+		return
+	}
+	if len(resp.Orders) == 0 {
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+	if !receiptStatuses[resp.Orders[0].Status] {
+		http.Error(w, "This order has not been paid, so it has no receipt", http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"receipt-%s.pdf\"", orderID))
+	w.Write(renderReceiptPDF(resp.Orders[0]))
+}
@@ -0,0 +1,113 @@
+package payment
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMyOrdersFiltersByBuyerAndStatus(t *testing.T) {
+	hasura, hasuraService := newFakeHasura(t)
+	hasura.on("orders", `[{"id":"o2","status":"completed","total_amount":30,"order_items":[{"quantity":2},{"quantity":1}]}]`)
+	hasura.on("orders_aggregate", `{"aggregate":{"count":7}}`)
+
+	action := `{"input":{"input":{"status":"completed","limit":1,"offset":1}},"session_variables":{"x-hasura-user-id":"u1"}}`
+	rec := httptest.NewRecorder()
+	HandleMyOrders(rec, httptest.NewRequest(http.MethodPost, "/myOrders", bytes.NewBufferString(action)), hasuraService)
+
+	var output MyOrdersOutput
+	json.Unmarshal(rec.Body.Bytes(), &output)
+	if rec.Code != http.StatusOK || output.TotalCount != 7 || len(output.Orders) != 1 || output.Orders[0].ItemCount != 3 {
+		t.Fatalf("myOrders = %d %s", rec.Code, rec.Body)
+	}
+	var where map[string]map[string]string
+	hasura.calls("orders")[0].variable(t, "where", &where)
+	if where["user_id"]["_eq"] != "u1" || where["status"]["_eq"] != "completed" {
+		t.Errorf("orders queried with %v", where)
+	}
+
+	for _, input := range []string{`{"limit":0}`, `{"limit":101}`, `{"offset":-1}`, `{"status":"shipped"}`} {
+		action := `{"input":{"input":` + input + `},"session_variables":{"x-hasura-user-id":"u1"}}`
+		rec := httptest.NewRecorder()
+		HandleMyOrders(rec, httptest.NewRequest(http.MethodPost, "/myOrders", bytes.NewBufferString(action)), hasuraService)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("myOrders with %s = %d, want 400", input, rec.Code)
+		}
+	}
+}
+
+const testOrderID = "5f2a91b0-3c4d-4e5f-8a6b-7c8d9e0f1a2b"
+
+func TestOrderDetailTotals(t *testing.T) {
+	hasura, hasuraService := newFakeHasura(t)
+	hasura.on("orders", `[{"id":"`+testOrderID+`","status":"completed","total_amount":140.50,"discount_amount":10,"order_items":[
+		{"id":"i1","quantity":2,"price_at_purchase":75.25,"discount_amount":10}]}]`)
+	t.Setenv("BASE_URL", "https://api.example.com/")
+
+	output := orderDetail(t, hasuraService, testOrderID)
+	if output.Subtotal != 15050 || output.Items[0].LineTotal != 14050 {
+		t.Errorf("subtotal %s and line total %s, want 150.50 and 140.50", output.Subtotal, output.Items[0].LineTotal)
+	}
+	if output.ReceiptURL == nil || *output.ReceiptURL != "https://api.example.com/orders/"+testOrderID+"/receipt.pdf" {
+		t.Errorf("receipt URL = %v", output.ReceiptURL)
+	}
+
+	// An order that was never paid has no receipt.
+	hasura.on("orders", `[{"id":"`+testOrderID+`","status":"pending","total_amount":150.50,"order_items":[]}]`)
+	if output := orderDetail(t, hasuraService, testOrderID); output.ReceiptURL != nil {
+		t.Errorf("pending order has receipt URL %s", *output.ReceiptURL)
+	}
+}
+
+func orderDetail(t *testing.T, hasuraService *HasuraService, orderID string) OrderDetailOutput {
+	t.Helper()
+	action := `{"input":{"input":{"orderId":"` + orderID + `"}},"session_variables":{"x-hasura-user-id":"u1"}}`
+	rec := httptest.NewRecorder()
+	HandleOrderDetail(rec, httptest.NewRequest(http.MethodPost, "/orderDetail", bytes.NewBufferString(action)), hasuraService)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("orderDetail = %d %s", rec.Code, rec.Body)
+	}
+	var output OrderDetailOutput
+	json.Unmarshal(rec.Body.Bytes(), &output)
+	return output
+}
+
+// Another buyer's order is filtered out by the query and looks the same as
+// an order that does not exist.
+func TestOrderReceiptOfAnotherBuyer(t *testing.T) {
+	hasura, hasuraService := newFakeHasura(t)
+	hasura.on("orders", `[]`)
+
+	rec := httptest.NewRecorder()
+	HandleOrderReceipt(rec, httptest.NewRequest(http.MethodGet, "/orders/"+testOrderID+"/receipt.pdf", nil), hasuraService, "u2", testOrderID)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("receipt of another buyer's order = %d, want 404", rec.Code)
+	}
+	var userID string
+	hasura.calls("orders")[0].variable(t, "userId", &userID)
+	if userID != "u2" {
+		t.Errorf("order looked up for %q, want the caller", userID)
+	}
+}
+
+func TestOrderReceiptRejects(t *testing.T) {
+	hasura, hasuraService := newFakeHasura(t)
+	hasura.on("orders", `[{"id":"`+testOrderID+`","status":"pending","total_amount":150.50,"order_items":[]}]`)
+
+	rec := httptest.NewRecorder()
+	HandleOrderReceipt(rec, httptest.NewRequest(http.MethodGet, "/orders/"+testOrderID+"/receipt.pdf", nil), hasuraService, "u1", testOrderID)
+	if rec.Code != http.StatusConflict {
+		t.Errorf("receipt of a pending order = %d, want 409", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	HandleOrderReceipt(rec, httptest.NewRequest(http.MethodGet, "/orders/o1/receipt.pdf", nil), hasuraService, "u1", "o1")
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("receipt for a malformed order ID = %d, want 404", rec.Code)
+	}
+	if n := len(hasura.calls("orders")); n != 1 {
+		t.Errorf("orders queried %d times, want only for the well-formed ID", n)
+	}
+}
@@ -0,0 +1,402 @@
+package payment
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	google_uuid "github.com/google/uuid"
+)
+
+const (
+	defaultPayoutHistoryLimit = 20
+	maxPayoutHistoryLimit     = 100
+)
+
+// HandleMyEarnings handles the Hasura Action webhook returning the caller's
+// earned, refunded, paid out and outstanding amounts per currency.
+func HandleMyEarnings(w http.ResponseWriter, r *http.Request, hasuraService *HasuraService) {
+	var payload MyEarningsActionPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Failed to parse Hasura Action payload")
+		return
+	}
+	defer r.Body.Close()
+
+	userID := payload.SessionVariables["x-hasura-user-id"]
+	if userID == "" {
+		respondWithError(w, http.StatusBadRequest, "Missing user ID")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	resp, err := hasuraService.QueryMyEarnings(ctx, userID)
+	if err != nil {
+		log.Printf("Failed to query earnings for %s: %v", userID, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve earnings")
+		return
+	}
+
+	output := MyEarningsOutput{Balances: []EarningsBalance{}}
+	for _, row := range resp.AuthorEarnings {
+		output.Balances = append(output.Balances, EarningsBalance{
+			Currency:      row.Currency,
+			TotalEarned:   row.TotalEarned,
+			TotalRefunded: row.TotalRefunded,
+			TotalPaidOut:  row.TotalPaidOut,
+			Balance:       row.Balance,
+		})
+	}
+	respondWithJSON(w, http.StatusOK, output)
+}
+
+// HandleMyPayoutHistory handles the Hasura Action webhook listing the caller's payouts, newest first.
+func HandleMyPayoutHistory(w http.ResponseWriter, r *http.Request, hasuraService *HasuraService) {
+	var payload MyPayoutHistoryActionPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Failed to parse Hasura Action payload")
+		return
+	}
+	defer r.Body.Close()
+
+	userID := payload.SessionVariables["x-hasura-user-id"]
+	if userID == "" {
+		respondWithError(w, http.StatusBadRequest, "Missing user ID")
+		return
+	}
+	input := payload.Input.Input
+	limit := defaultPayoutHistoryLimit
+	if input.Limit != nil {
+		limit = *input.Limit
+	}
+	offset := 0
+	if input.Offset != nil {
+		offset = *input.Offset
+	}
+	if limit <= 0 || limit > maxPayoutHistoryLimit || offset < 0 {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d and offset cannot be negative", maxPayoutHistoryLimit))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	resp, err := hasuraService.QueryPayoutHistory(ctx, userID, limit, offset)
+	if err != nil {
+		log.Printf("Failed to query payout history for %s: %v", userID, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve payout history")
+		return
+	}
+
+	output := MyPayoutHistoryOutput{
+		Payouts:    []PayoutRecord{},
+		TotalCount: resp.PayoutsAggregate.Aggregate.Count,
+	}
+	for _, payout := range resp.Payouts {
+		output.Payouts = append(output.Payouts, PayoutRecord{
+			ID:        payout.ID,
+			BatchID:   payout.BatchID,
+			Amount:    payout.Amount,
+			Currency:  payout.Currency,
+			Reference: payout.Reference,
+			Status:    payout.Status,
+			CreatedAt: payout.CreatedAt,
+		})
+	}
+	respondWithJSON(w, http.StatusOK, output)
+}
+
+// HandleCreatePayoutBatch handles the admin-only Hasura Action webhook that
+// pays out every author whose balance has reached the minimum. The payouts
+// are recorded as processing, holding the authors' balances, and the CSV
+// handed back is what gets sent to the bank; settlePayoutBatch records the
+// outcome. Authors without a payout account are skipped and keep their balance.
+func HandleCreatePayoutBatch(w http.ResponseWriter, r *http.Request, hasuraService *HasuraService) {
+	log.Println("🚀 Received /createPayoutBatch request")
+	var payload CreatePayoutBatchActionPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Failed to parse Hasura Action payload")
+		return
+	}
+	defer r.Body.Close()
+
+	if !isAdminSession(r, payload.SessionVariables) {
+		respondWithError(w, http.StatusForbidden, "Only admins can create payout batches")
+		return
+	}
+	input := payload.Input.Input
+	currency := strings.ToUpper(strings.TrimSpace(input.Currency))
+	if currency == "" {
+		currency = "ETB"
+	}
+	minimum := Money(1)
+	if input.MinimumAmount != nil {
+		if *input.MinimumAmount <= 0 {
+			respondWithError(w, http.StatusBadRequest, "minimumAmount must be greater than zero")
+			return
+		}
+		minimum = *input.MinimumAmount
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	earningsResp, err := hasuraService.QueryPayableAuthors(ctx, currency, minimum)
+	if err != nil {
+		log.Printf("Failed to query payable authors: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve author balances")
+		return
+	}
+	if len(earningsResp.AuthorEarnings) == 0 {
+		respondWithJSON(w, http.StatusOK, CreatePayoutBatchOutput{Success: true, Message: "No authors are due a payout", Currency: currency})
+		return
+	}
+
+	authorIDs := make([]string, 0, len(earningsResp.AuthorEarnings))
+	for _, earnings := range earningsResp.AuthorEarnings {
+		authorIDs = append(authorIDs, earnings.UserID)
+	}
+	accountsResp, err := hasuraService.QueryPayoutAccounts(ctx, authorIDs)
+	if err != nil {
+		log.Printf("Failed to query payout accounts: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve payout accounts")
+		return
+	}
+	accounts := make(map[string]int, len(accountsResp.PayoutAccounts))
+	for i, account := range accountsResp.PayoutAccounts {
+		accounts[account.UserID] = i
+	}
+
+	now := DateTime(time.Now())
+	batch := payout_batches_insert_input{
+		ID:        uuid(google_uuid.New().String()),
+		Currency:  currency,
+		CreatedAt: now,
+	}
+	if callerID := payload.SessionVariables["x-hasura-user-id"]; callerID != "" {
+		createdBy := uuid(callerID)
+		batch.CreatedBy = &createdBy
+	}
+	var rows []payoutRow
+	skipped := 0
+	for _, earnings := range earningsResp.AuthorEarnings {
+		i, ok := accounts[earnings.UserID]
+		if !ok {
+			skipped++
+			continue
+		}
+		account := accountsResp.PayoutAccounts[i]
+		payoutID := google_uuid.New().String()
+		reference := fmt.Sprintf("p-%s-%d", google_uuid.New().String()[:8], time.Now().Unix())
+		batch.Payouts.Data = append(batch.Payouts.Data, payouts_insert_input{
+			ID:            uuid(payoutID),
+			AuthorID:      uuid(earnings.UserID),
+			Amount:        earnings.Balance,
+			Currency:      currency,
+			Reference:     reference,
+			BankName:      account.BankName,
+			AccountName:   account.AccountName,
+			AccountNumber: account.AccountNumber,
+			Status:        "processing",
+			CreatedAt:     now,
+			LedgerTransactions: ledger_transactions_arr_rel_insert_input{Data: []ledger_transactions_insert_input{
+				payoutLedgerTransaction(payoutID, earnings.UserID, earnings.Balance, currency, now),
+			}},
+		})
+		batch.TotalAmount += earnings.Balance
+		rows = append(rows, payoutRow{
+			ID:            payoutID,
+			BatchID:       string(batch.ID),
+			AuthorID:      earnings.UserID,
+			Amount:        earnings.Balance,
+			Currency:      currency,
+			Reference:     reference,
+			BankName:      account.BankName,
+			AccountName:   account.AccountName,
+			AccountNumber: account.AccountNumber,
+			Status:        "processing",
+		})
+	}
+	batch.PayoutCount = len(rows)
+	if batch.PayoutCount == 0 {
+		respondWithJSON(w, http.StatusOK, CreatePayoutBatchOutput{
+			Success:        true,
+			Message:        "No author due a payout has a payout account",
+			Currency:       currency,
+			SkippedAuthors: skipped,
+		})
+		return
+	}
+
+	if _, err := hasuraService.InsertPayoutBatch(ctx, batch); err != nil {
+		log.Printf("❌ Failed to record payout batch %s: %v", batch.ID, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to record payout batch")
+		return
+	}
+
+	csvData, err := payoutsCSV(rows)
+	if err != nil {
+		log.Printf("Failed to build CSV for payout batch %s: %v", batch.ID, err)
+	}
+	log.Printf("✅ Recorded payout batch %s: %d payouts, %s %s", batch.ID, batch.PayoutCount, batch.TotalAmount, currency)
+	respondWithJSON(w, http.StatusOK, CreatePayoutBatchOutput{
+		Success:        true,
+		Message:        "Payout batch recorded; settle it once the transfers are made",
+		BatchID:        string(batch.ID),
+		Currency:       currency,
+		PayoutCount:    batch.PayoutCount,
+		TotalAmount:    batch.TotalAmount,
+		SkippedAuthors: skipped,
+		CSV:            csvData,
+	})
+}
+
+// HandleExportPayoutBatch handles the admin-only Hasura Action webhook that
+// regenerates the bank CSV for an earlier payout batch.
+func HandleExportPayoutBatch(w http.ResponseWriter, r *http.Request, hasuraService *HasuraService) {
+	var payload ExportPayoutBatchActionPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Failed to parse Hasura Action payload")
+		return
+	}
+	defer r.Body.Close()
+
+	if !isAdminSession(r, payload.SessionVariables) {
+		respondWithError(w, http.StatusForbidden, "Only admins can export payout batches")
+		return
+	}
+	batchID := payload.Input.Input.BatchID
+	if batchID == "" {
+		respondWithError(w, http.StatusBadRequest, "Missing batch ID")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	resp, err := hasuraService.QueryPayoutBatch(ctx, batchID)
+	if err != nil {
+		log.Printf("Failed to query payout batch %s: %v", batchID, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve payout batch")
+		return
+	}
+	if resp.PayoutBatchesByPk == nil {
+		respondWithError(w, http.StatusNotFound, "Payout batch not found")
+		return
+	}
+
+	csvData, err := payoutsCSV(resp.PayoutBatchesByPk.Payouts)
+	if err != nil {
+		log.Printf("Failed to build CSV for payout batch %s: %v", batchID, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to export payout batch")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, ExportPayoutBatchOutput{BatchID: batchID, CSV: csvData})
+}
+
+// HandleSettlePayoutBatch handles the admin-only Hasura Action webhook that
+// records the outcome of a batch's bank transfers. Payouts listed in
+// failedReferences are marked failed and their amounts returned to the
+// authors' balances; every other payout still processing is marked paid.
+func HandleSettlePayoutBatch(w http.ResponseWriter, r *http.Request, hasuraService *HasuraService) {
+	var payload SettlePayoutBatchActionPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Failed to parse Hasura Action payload")
+		return
+	}
+	defer r.Body.Close()
+
+	if !isAdminSession(r, payload.SessionVariables) {
+		respondWithError(w, http.StatusForbidden, "Only admins can settle payout batches")
+		return
+	}
+	input := payload.Input.Input
+	if _, err := google_uuid.Parse(input.BatchID); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Missing batch ID")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	resp, err := hasuraService.QueryPayoutBatch(ctx, input.BatchID)
+	if err != nil {
+		log.Printf("Failed to query payout batch %s: %v", input.BatchID, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve payout batch")
+		return
+	}
+	if resp.PayoutBatchesByPk == nil {
+		respondWithError(w, http.StatusNotFound, "Payout batch not found")
+		return
+	}
+
+	byReference := make(map[string]payoutRow, len(resp.PayoutBatchesByPk.Payouts))
+	processing := 0
+	for _, payout := range resp.PayoutBatchesByPk.Payouts {
+		byReference[payout.Reference] = payout
+		if payout.Status == "processing" {
+			processing++
+		}
+	}
+	if processing == 0 {
+		respondWithError(w, http.StatusConflict, "Payout batch is already settled")
+		return
+	}
+
+	now := time.Now()
+	var failedIDs []string
+	var reversals []ledger_transactions_insert_input
+	for _, reference := range input.FailedReferences {
+		payout, ok := byReference[strings.TrimSpace(reference)]
+		if !ok {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Payout %s is not in this batch", reference))
+			return
+		}
+		if payout.Status != "processing" {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Payout %s is already %s", reference, payout.Status))
+			return
+		}
+		failedIDs = append(failedIDs, payout.ID)
+		reversals = append(reversals, payoutReversalLedgerTransaction(payout.ID, payout.AuthorID, payout.Amount, payout.Currency, DateTime(now)))
+	}
+
+	settled, err := hasuraService.SettlePayoutBatch(ctx, input.BatchID, failedIDs, reversals, now)
+	if err != nil {
+		log.Printf("❌ Failed to settle payout batch %s: %v", input.BatchID, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to settle payout batch")
+		return
+	}
+	output := SettlePayoutBatchOutput{BatchID: input.BatchID}
+	if settled.FailPayouts != nil {
+		output.FailedCount = settled.FailPayouts.AffectedRows
+	}
+	if settled.PayPayouts != nil {
+		output.PaidCount = settled.PayPayouts.AffectedRows
+	}
+	log.Printf("✅ Settled payout batch %s: %d paid, %d failed", input.BatchID, output.PaidCount, output.FailedCount)
+	respondWithJSON(w, http.StatusOK, output)
+}
+
+// payoutsCSV renders payouts in the layout used for bank transfer uploads.
+func payoutsCSV(payouts []payoutRow) (string, error) {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	writer.Write([]string{"reference", "author_id", "account_name", "bank_name", "account_number", "amount", "currency"})
+	for _, payout := range payouts {
+		writer.Write([]string{
+			payout.Reference,
+			payout.AuthorID,
+			payout.AccountName,
+			payout.BankName,
+			payout.AccountNumber,
+			payout.Amount.String(),
+			payout.Currency,
+		})
+	}
+	writer.Flush()
+	return buf.String(), writer.Error()
+}
@@ -0,0 +1,162 @@
+package payment
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// adminActionRequest is an admin action call as Hasura sends it, with the
+// action secret the handlers require before trusting the session role.
+func adminActionRequest(t *testing.T, payload string) *http.Request {
+	t.Helper()
+	t.Setenv("ACTION_SECRET", "act-s3cret")
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(payload))
+	req.Header.Set("X-Action-Secret", "act-s3cret")
+	return req
+}
+
+func createPayoutBatch(t *testing.T, hasuraService *HasuraService, payload string) (*httptest.ResponseRecorder, CreatePayoutBatchOutput) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	HandleCreatePayoutBatch(rec, adminActionRequest(t, payload), hasuraService)
+	var output CreatePayoutBatchOutput
+	json.Unmarshal(rec.Body.Bytes(), &output)
+	return rec, output
+}
+
+func TestCreatePayoutBatch(t *testing.T) {
+	hasura, hasuraService := newFakeHasura(t)
+	hasura.on("author_earnings", `[{"user_id":"a1","currency":"ETB","balance":1800},{"user_id":"a2","currency":"ETB","balance":250.5}]`)
+	hasura.on("payout_accounts", `[{"user_id":"a1","bank_name":"CBE","account_name":"Almaz Bekele","account_number":"1000123"}]`)
+	hasura.on("insert_payout_batches_one", `{"id":"b1"}`)
+
+	rec, output := createPayoutBatch(t, hasuraService, `{"input":{"input":{"currency":"etb"}},"session_variables":{"x-hasura-role":"admin","x-hasura-user-id":"admin1"}}`)
+	if rec.Code != http.StatusOK || output.PayoutCount != 1 || output.SkippedAuthors != 1 || output.TotalAmount != 180000 {
+		t.Fatalf("createPayoutBatch = %d %+v", rec.Code, output)
+	}
+
+	// Only the author with a payout account is paid, and their payout holds
+	// their balance in the same insert until the batch is settled.
+	var batch payout_batches_insert_input
+	hasura.calls("insert_payout_batches_one")[0].variable(t, "object", &batch)
+	if len(batch.Payouts.Data) != 1 {
+		t.Fatalf("batch has %d payouts, want 1", len(batch.Payouts.Data))
+	}
+	payout := batch.Payouts.Data[0]
+	if payout.AuthorID != "a1" || payout.Amount != 180000 || payout.Status != "processing" || payout.AccountNumber != "1000123" {
+		t.Errorf("payout = %+v", payout)
+	}
+	if txs := payout.LedgerTransactions.Data; len(txs) != 1 || txs[0].Kind != "payout" {
+		t.Errorf("payout ledger transactions = %+v", txs)
+	} else {
+		checkBalances(t, balances(t, txs[0]), map[string]Money{
+			accountAuthorPayable + ":a1": 180000,
+			accountProviderClearing:      -180000,
+		})
+	}
+
+	lines := strings.Split(strings.TrimSpace(output.CSV), "\n")
+	if len(lines) != 2 || lines[0] != "reference,author_id,account_name,bank_name,account_number,amount,currency" ||
+		!strings.HasSuffix(lines[1], ",a1,Almaz Bekele,CBE,1000123,1800.00,ETB") {
+		t.Errorf("CSV = %q", output.CSV)
+	}
+}
+
+func TestCreatePayoutBatchRequiresAdmin(t *testing.T) {
+	hasura, hasuraService := newFakeHasura(t)
+	rec, _ := createPayoutBatch(t, hasuraService, `{"input":{"input":{}},"session_variables":{"x-hasura-role":"user","x-hasura-user-id":"a1"}}`)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("non-admin = %d, want 403", rec.Code)
+	}
+
+	// The role is only trusted on requests that carry the action secret.
+	admin := `{"input":{"input":{}},"session_variables":{"x-hasura-role":"admin","x-hasura-user-id":"a1"}}`
+	for _, secret := range []string{"", "wrong"} {
+		req := adminActionRequest(t, admin)
+		req.Header.Set("X-Action-Secret", secret)
+		rec := httptest.NewRecorder()
+		HandleCreatePayoutBatch(rec, req, hasuraService)
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("admin role with secret %q = %d, want 403", secret, rec.Code)
+		}
+	}
+	if len(hasura.calls("author_earnings")) != 0 {
+		t.Error("balances were read for a non-admin")
+	}
+}
+
+func settlePayoutBatch(t *testing.T, hasuraService *HasuraService, failed ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	input, _ := json.Marshal(map[string]interface{}{"batchId": "7d4c2f9e-1b3a-4c5d-8e6f-9a0b1c2d3e4f", "failedReferences": failed})
+	rec := httptest.NewRecorder()
+	HandleSettlePayoutBatch(rec, adminActionRequest(t, `{"input":{"input":`+string(input)+`},"session_variables":{"x-hasura-role":"admin"}}`), hasuraService)
+	return rec
+}
+
+func TestSettlePayoutBatch(t *testing.T) {
+	hasura, hasuraService := newFakeHasura(t)
+	hasura.on("payout_batches_by_pk", `{"id":"7d4c2f9e-1b3a-4c5d-8e6f-9a0b1c2d3e4f","payouts":[`+
+		`{"id":"p1","author_id":"a1","amount":1800,"currency":"ETB","reference":"PAY-1","status":"processing"},`+
+		`{"id":"p2","author_id":"a2","amount":250.5,"currency":"ETB","reference":"PAY-2","status":"processing"}]}`)
+	hasura.on("failPayouts", `{"affected_rows":1}`)
+	hasura.on("payPayouts", `{"affected_rows":1}`)
+	hasura.on("insert_ledger_transactions", `{"affected_rows":1}`)
+
+	rec := settlePayoutBatch(t, hasuraService, " PAY-2 ")
+	var output SettlePayoutBatchOutput
+	json.Unmarshal(rec.Body.Bytes(), &output)
+	if rec.Code != http.StatusOK || output.PaidCount != 1 || output.FailedCount != 1 {
+		t.Fatalf("settlePayoutBatch = %d %s", rec.Code, rec.Body)
+	}
+
+	// The failed payout goes back to its author's balance.
+	settle := hasura.calls("payPayouts")[0]
+	var failedIDs []string
+	settle.variable(t, "failedIds", &failedIDs)
+	var reversals []ledger_transactions_insert_input
+	settle.variable(t, "reversals", &reversals)
+	if len(failedIDs) != 1 || failedIDs[0] != "p2" || len(reversals) != 1 || reversals[0].IdempotencyKey != "payout-reversal:p2" {
+		t.Fatalf("failed %v, reversals %+v", failedIDs, reversals)
+	}
+	checkBalances(t, balances(t, reversals[0]), map[string]Money{
+		accountAuthorPayable + ":a2": -25050,
+		accountProviderClearing:      25050,
+	})
+}
+
+func TestSettlePayoutBatchRejects(t *testing.T) {
+	hasura, hasuraService := newFakeHasura(t)
+	hasura.on("payout_batches_by_pk", `{"id":"7d4c2f9e-1b3a-4c5d-8e6f-9a0b1c2d3e4f","payouts":[`+
+		`{"id":"p1","author_id":"a1","amount":1800,"currency":"ETB","reference":"PAY-1","status":"processing"},`+
+		`{"id":"p2","author_id":"a2","amount":250.5,"currency":"ETB","reference":"PAY-2","status":"failed"}]}`)
+
+	if rec := settlePayoutBatch(t, hasuraService, "PAY-9"); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown reference = %d, want 400", rec.Code)
+	}
+	if rec := settlePayoutBatch(t, hasuraService, "PAY-2"); rec.Code != http.StatusBadRequest {
+		t.Errorf("payout already failed = %d, want 400", rec.Code)
+	}
+	if len(hasura.calls("payPayouts")) != 0 {
+		t.Fatal("a rejected settlement was written")
+	}
+
+	hasura.on("payout_batches_by_pk", `{"id":"7d4c2f9e-1b3a-4c5d-8e6f-9a0b1c2d3e4f","payouts":[`+
+		`{"id":"p1","author_id":"a1","amount":1800,"currency":"ETB","reference":"PAY-1","status":"paid"}]}`)
+	if rec := settlePayoutBatch(t, hasuraService); rec.Code != http.StatusConflict {
+		t.Errorf("settled batch = %d, want 409", rec.Code)
+	}
+}
+
+func TestPayoutsCSVQuotesFields(t *testing.T) {
+	csvData, err := payoutsCSV([]payoutRow{{Reference: "p-1", AuthorID: "a1", AccountName: "Kebede, Almaz", BankName: "CBE", AccountNumber: "100", Amount: 5, Currency: "ETB"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "p-1,a1,\"Kebede, Almaz\",CBE,100,0.05,ETB\n"; !strings.HasSuffix(csvData, want) {
+		t.Errorf("CSV = %q, want a row %q", csvData, want)
+	}
+}
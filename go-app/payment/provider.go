@@ -0,0 +1,178 @@
+package payment
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Payment statuses reported by providers after verification.
+const (
+	PaymentStatusSuccess = "success"
+	PaymentStatusFailed  = "failed"
+	PaymentStatusPending = "pending"
+)
+
+// PaymentProvider is implemented by every payment gateway the checkout flow can use.
+type PaymentProvider interface {
+	// Name identifies the provider, e.g. "chapa".
+	Name() string
+	// SupportsCurrency reports whether the provider can take payments in
+	// the given ISO 4217 code.
+	SupportsCurrency(currency string) bool
+	// InitiatePayment starts a transaction and returns where to send the buyer.
+	InitiatePayment(ctx context.Context, req InitiatePaymentRequest) (InitiatePaymentResult, error)
+	// VerifyPayment asks the provider for the final state of a transaction.
+	VerifyPayment(ctx context.Context, txRef string) (PaymentVerification, error)
+	// Refund returns all or part of a completed transaction. Repeating a
+	// refund with the same Reference pays it back at most once. A
+	// RefundRejectedError means the provider declined it; after any other
+	// error the refund may still have gone through.
+	Refund(ctx context.Context, txRef string, req RefundRequest) (RefundResult, error)
+	// ParseWebhook extracts the transaction reference from a provider callback.
+	ParseWebhook(r *http.Request) (WebhookEvent, error)
+}
+
+// InitiatePaymentRequest is the provider-neutral description of a checkout.
+type InitiatePaymentRequest struct {
+	Amount      Money
+	Currency    string
+	TxRef       string
+	Email       string
+	FirstName   string
+	LastName    string
+	PhoneNumber string
+	CallbackURL string
+	ReturnURL   string
+	Title       string
+	Description string
+}
+
+type InitiatePaymentResult struct {
+	CheckoutURL string
+}
+
+type PaymentVerification struct {
+	TransactionID string
+	TxRef         string
+	Amount        Money
+	Currency      string
+	Status        string
+}
+
+// RefundRequest describes a refund in the currency of the original
+// transaction.
+type RefundRequest struct {
+	Reason    string
+	Amount    Money
+	Currency  string
+	Reference string
+}
+
+type RefundResult struct {
+	ProviderReference string
+}
+
+// RefundRejectedError reports a refund the provider definitively declined,
+// so no money was returned.
+type RefundRejectedError struct {
+	Provider string
+	Reason   string
+}
+
+func (e RefundRejectedError) Error() string {
+	return fmt.Sprintf("%s rejected the refund: %s", e.Provider, e.Reason)
+}
+
+type WebhookEvent struct {
+	TxRef string
+}
+
+// ProviderRegistry holds the payment providers enabled for checkout.
+type ProviderRegistry struct {
+	providers   map[string]PaymentProvider
+	defaultName string
+}
+
+// NewProviderRegistry builds the providers listed in PAYMENT_PROVIDERS
+// (comma separated). PAYMENT_PROVIDER picks the default used when an order
+// does not ask for one; it defaults to Chapa. "fake" selects the in-process
+// provider for local development and integration tests.
+func NewProviderRegistry() *ProviderRegistry {
+	defaultName := os.Getenv("PAYMENT_PROVIDER")
+	if defaultName == "" {
+		defaultName = "chapa"
+	}
+	names := []string{defaultName}
+	for _, name := range strings.Split(os.Getenv("PAYMENT_PROVIDERS"), ",") {
+		if name = strings.TrimSpace(name); name != "" && name != defaultName {
+			names = append(names, name)
+		}
+	}
+
+	registry := &ProviderRegistry{
+		providers:   make(map[string]PaymentProvider, len(names)),
+		defaultName: defaultName,
+	}
+	for _, name := range names {
+		registry.providers[name] = newPaymentProvider(name)
+	}
+	log.Printf("Payment providers enabled: %v (default %s)", names, defaultName)
+	return registry
+}
+
+func newPaymentProvider(name string) PaymentProvider {
+	switch name {
+	case "chapa":
+		return NewChapaService()
+	case "telebirr":
+		return NewTelebirrService()
+	case "fake":
+		log.Println("⚠️ Using the fake payment provider. No real payments will be taken.")
+		return NewFakeProvider()
+	default:
+		log.Fatalf("Unknown payment provider %q", name)
+		return nil
+	}
+}
+
+// Get returns the named provider, or the default one when name is empty.
+func (r *ProviderRegistry) Get(name string) (PaymentProvider, bool) {
+	if name == "" {
+		name = r.defaultName
+	}
+	provider, ok := r.providers[name]
+	return provider, ok
+}
+
+// Fake returns the fake provider when it is enabled.
+func (r *ProviderRegistry) Fake() (*FakeProvider, bool) {
+	fake, ok := r.providers["fake"].(*FakeProvider)
+	return fake, ok
+}
+
+// callbackURLFor returns the webhook URL a provider should call for an order.
+// <PROVIDER>_CALLBACK_URL (e.g. CHAPA_CALLBACK_URL) wins over the default
+// BASE_URL/payments/<provider>/callback.
+func callbackURLFor(providerName string) string {
+	if callbackURL := os.Getenv(strings.ToUpper(providerName) + "_CALLBACK_URL"); callbackURL != "" {
+		return callbackURL
+	}
+	return fmt.Sprintf("%s/payments/%s/callback", publicBaseURL(), providerName)
+}
+
+// publicBaseURL is where this backend can be reached from outside, taken
+// from BASE_URL and defaulting to localhost on PORT.
+func publicBaseURL() string {
+	if baseURL := os.Getenv("BASE_URL"); baseURL != "" {
+		return strings.TrimSuffix(baseURL, "/")
+	}
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8082"
+	}
+	return fmt.Sprintf("http://localhost:%s", port)
+}
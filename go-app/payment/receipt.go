@@ -0,0 +1,115 @@
+package payment
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/wubshet-kebede/go-app/pdf"
+)
+
+const (
+	receiptMargin    = 50.0
+	receiptRowHeight = 16.0
+)
+
+// receiptDate formats an order timestamp for display, falling back to the
+// raw value if Hasura returns something unexpected.
+func receiptDate(timestamp string) string {
+	t, err := time.Parse(time.RFC3339Nano, timestamp)
+	if err != nil {
+		return timestamp
+	}
+	return t.UTC().Format("02 Jan 2006 15:04 UTC")
+}
+
+// renderReceiptPDF lays out a receipt with the order reference, the item
+// snapshots taken at purchase time and the totals.
+func renderReceiptPDF(order orderHistoryRecord) []byte {
+	doc := pdf.New("Receipt " + order.ID)
+	right := pdf.PageWidth - receiptMargin
+	y := pdf.PageHeight - receiptMargin
+
+	doc.Text(receiptMargin, y, 20, pdf.Bold, "Food Recipes - Receipt")
+	y -= 30
+	for _, field := range [][2]string{
+		{"Order ID", order.ID},
+		{"Transaction ref", order.ChapaTxRef},
+		{"Date", receiptDate(order.CreatedAt)},
+		{"Payment provider", order.PaymentProvider},
+		{"Status", order.Status},
+	} {
+		doc.Text(receiptMargin, y, 10, pdf.Bold, field[0])
+		doc.Text(receiptMargin+110, y, 10, pdf.Regular, field[1])
+		y -= receiptRowHeight
+	}
+
+	y -= 10
+	qtyX, priceX, discountX := right-220, right-150, right-75
+	doc.Text(receiptMargin, y, 10, pdf.Bold, "Item")
+	doc.TextRight(qtyX, y, 10, pdf.Bold, "Qty")
+	doc.TextRight(priceX, y, 10, pdf.Bold, "Unit price")
+	doc.TextRight(discountX, y, 10, pdf.Bold, "Discount")
+	doc.TextRight(right, y, 10, pdf.Bold, "Total")
+	y -= 6
+	doc.Line(receiptMargin, y, right, y)
+	y -= receiptRowHeight
+
+	var subtotal Money
+	for _, item := range order.OrderItems {
+		if y < receiptMargin+6*receiptRowHeight {
+			doc.AddPage()
+			y = pdf.PageHeight - receiptMargin
+		}
+		lineTotal := item.PriceAtPurchase.Mul(item.Quantity)
+		subtotal += lineTotal
+		name := item.RecipeName
+		if item.RefundedQuantity > 0 {
+			name = fmt.Sprintf("%s (%d refunded)", name, item.RefundedQuantity)
+		}
+		doc.Text(receiptMargin, y, 10, pdf.Regular, truncateToWidth(name, qtyX-receiptMargin-30, 10))
+		doc.TextRight(qtyX, y, 10, pdf.Regular, fmt.Sprintf("%d", item.Quantity))
+		doc.TextRight(priceX, y, 10, pdf.Regular, item.PriceAtPurchase.String())
+		doc.TextRight(discountX, y, 10, pdf.Regular, item.DiscountAmount.String())
+		doc.TextRight(right, y, 10, pdf.Regular, (lineTotal - item.DiscountAmount).String())
+		y -= receiptRowHeight
+	}
+
+	y += receiptRowHeight - 6
+	doc.Line(receiptMargin, y, right, y)
+	y -= receiptRowHeight
+	totals := [][2]string{
+		{"Subtotal", subtotal.String()},
+		{"Discount", order.DiscountAmount.String()},
+		{"Total paid", order.TotalAmount.String()},
+	}
+	if order.CouponCode != nil {
+		totals[1][0] = fmt.Sprintf("Discount (%s)", *order.CouponCode)
+	}
+	if order.RefundedAmount > 0 {
+		totals = append(totals, [2]string{"Refunded", order.RefundedAmount.String()})
+	}
+	for i, total := range totals {
+		font := pdf.Regular
+		if i == 2 {
+			font = pdf.Bold
+		}
+		doc.TextRight(discountX, y, 10, font, total[0])
+		doc.TextRight(right, y, 10, font, total[1]+" "+order.Currency)
+		y -= receiptRowHeight
+	}
+
+	return doc.Bytes()
+}
+
+// truncateToWidth shortens s with an ellipsis so it fits in width points.
+func truncateToWidth(s string, width float64, size float64) string {
+	if pdf.TextWidth(s, size) <= width {
+		return s
+	}
+	runes := []rune(s)
+	for len(runes) > 0 && pdf.TextWidth(string(runes)+"...", size) > width {
+		runes = runes[:len(runes)-1]
+	}
+	return strings.TrimSpace(string(runes)) + "..."
+}
@@ -0,0 +1,118 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+const defaultRefundLockTTLMinutes = 15
+
+// maxRefundReconcile caps how many stuck refunds one cron run settles.
+const maxRefundReconcile = 50
+
+// refundLockTTL reads REFUND_LOCK_TTL_MINUTES, how long an order's refund
+// lock is trusted. An older lock was left by a refund that never finished:
+// it is taken over when no refund is pending, and otherwise the pending
+// refund is settled by reconcile_refunds.
+func refundLockTTL() time.Duration {
+	minutes := defaultRefundLockTTLMinutes
+	if raw := os.Getenv("REFUND_LOCK_TTL_MINUTES"); raw != "" {
+		if parsed, err := strconv.Atoi(raw); err == nil && parsed > 0 {
+			minutes = parsed
+		} else {
+			log.Printf("Invalid REFUND_LOCK_TTL_MINUTES %q, using %d", raw, defaultRefundLockTTLMinutes)
+		}
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// refundLineItems records the items and quantities a refund covers, so it
+// can be completed later without the request that started it.
+func refundLineItems(lines []refundLine) []RefundItemInput {
+	items := make([]RefundItemInput, 0, len(lines))
+	for _, line := range lines {
+		quantity := line.quantity
+		items = append(items, RefundItemInput{OrderItemID: line.item.ID, Quantity: &quantity})
+	}
+	return items
+}
+
+// HandleReconcileRefunds handles the reconcile_refunds cron trigger. Each
+// pending refund whose order has been locked for longer than the refund lock
+// TTL is sent to the provider again under its original reference, which the
+// provider pays at most once, and is then recorded or failed like a new one.
+// Refunds whose outcome is still unknown stay pending for the next run.
+func HandleReconcileRefunds(w http.ResponseWriter, r *http.Request, hasuraService *HasuraService, providers *ProviderRegistry) {
+	if !validCronRequest(r) {
+		respondWithError(w, http.StatusUnauthorized, "Invalid cron secret")
+		return
+	}
+	defer r.Body.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	refunds, err := hasuraService.QueryStuckRefunds(ctx, time.Now().Add(-refundLockTTL()), maxRefundReconcile)
+	if err != nil {
+		log.Printf("Failed to query stuck refunds: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve refunds")
+		return
+	}
+	output := ReconcileRefundsOutput{}
+	for _, refund := range refunds {
+		switch reconcileRefund(ctx, hasuraService, providers, refund) {
+		case "completed":
+			output.Completed++
+		case "failed":
+			output.Failed++
+		default:
+			output.Pending++
+		}
+	}
+	if len(refunds) > 0 {
+		log.Printf("↩️ Reconciled %d stuck refunds: %d completed, %d failed, %d pending", len(refunds), output.Completed, output.Failed, output.Pending)
+	}
+	respondWithJSON(w, http.StatusOK, output)
+}
+
+// reconcileRefund settles one stuck refund and returns its status.
+func reconcileRefund(ctx context.Context, hasuraService *HasuraService, providers *ProviderRegistry, refund pendingRefund) string {
+	plan, err := planRefund(ctx, hasuraService, providers, RefundOrderInput{OrderID: refund.OrderID, Items: refund.Items, Reason: refund.Reason}, "", true)
+	if err != nil || plan.amount != refund.Amount {
+		log.Printf("❌ Refund %s (%s) of order %s no longer matches the order and needs a manual check: amount %s, %v", refund.ID, refund.Reference, refund.OrderID, plan.amount, err)
+		return "pending"
+	}
+
+	result, err := plan.provider.Refund(ctx, plan.order.ChapaTxRef, RefundRequest{
+		Reason:    refund.Reason,
+		Amount:    refund.Amount,
+		Currency:  plan.order.Currency,
+		Reference: refund.Reference,
+	})
+	if err != nil {
+		var rejected RefundRejectedError
+		if !errors.As(err, &rejected) {
+			log.Printf("⚠️ %s refund %s (%s) for order %s still has an unknown outcome: %v", plan.provider.Name(), refund.ID, refund.Reference, refund.OrderID, err)
+			return "pending"
+		}
+		log.Printf("%s declined refund %s for order %s: %v", plan.provider.Name(), refund.ID, refund.OrderID, err)
+		failed := "failed"
+		now := DateTime(time.Now())
+		if _, err := hasuraService.FailRefund(ctx, refund.OrderID, refund.ID, refunds_set_input{Status: &failed, UpdatedAt: &now}); err != nil {
+			log.Printf("Failed to mark refund %s as failed: %v", refund.ID, err)
+			return "pending"
+		}
+		return "failed"
+	}
+
+	if _, err := applyRefund(ctx, hasuraService, plan, refund.ID, refund.Reference, result.ProviderReference); err != nil {
+		log.Printf("❌ Refund %s (%s) succeeded at the provider but could not be recorded: %v", refund.ID, refund.Reference, err)
+		return "pending"
+	}
+	log.Printf("✅ Reconciled refund %s of %s %s on order %s", refund.ID, refund.Amount, plan.order.Currency, refund.OrderID)
+	return "completed"
+}
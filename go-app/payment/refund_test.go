@@ -0,0 +1,104 @@
+package payment
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A refund left pending behind an expired lock is sent again under its
+// original reference, then recorded, failed or left for the next run.
+func TestReconcileRefunds(t *testing.T) {
+	t.Setenv("CRON_SECRET", "s3cret")
+	tests := []struct {
+		name string
+		err  error
+		want ReconcileRefundsOutput
+	}{
+		{name: "refunded", want: ReconcileRefundsOutput{Completed: 1}},
+		{name: "declined", err: RefundRejectedError{Provider: "fake", Reason: "insufficient balance"}, want: ReconcileRefundsOutput{Failed: 1}},
+		{name: "unknown", err: context.DeadlineExceeded, want: ReconcileRefundsOutput{Pending: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hasura, hasuraService := newFakeHasura(t)
+			hasura.on("refunds", `[{"id":"rf1","order_id":"`+testOrderID+`","amount":100,"reason":"Order refund","reference":"r-rf1",
+				"items":[{"orderItemId":"i1","quantity":1}]}]`)
+			hasura.on("orders_by_pk", `{"id":"`+testOrderID+`","user_id":"b1","status":"completed","currency":"ETB","total_amount":100,
+				"chapa_tx_ref":"c-1","payment_provider":"fake","order_items":[{"id":"i1","recipe_id":"r1","quantity":1,"price_at_purchase":100,"recipe":{"user_id":"a1"}}]}`)
+			hasura.on("update_refunds_by_pk", `{"id":"rf1"}`)
+			hasura.on("update_orders_by_pk", `{"id":"`+testOrderID+`"}`)
+			provider := &refundProvider{FakeProvider: NewFakeProvider(), err: tt.err}
+			providers := &ProviderRegistry{providers: map[string]PaymentProvider{"fake": provider}, defaultName: "fake"}
+
+			trigger := func(secret string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest(http.MethodPost, "/reconcileRefunds", strings.NewReader(`{}`))
+				req.Header.Set("X-Cron-Secret", secret)
+				rec := httptest.NewRecorder()
+				HandleReconcileRefunds(rec, req, hasuraService, providers)
+				return rec
+			}
+			if rec := trigger("wrong"); rec.Code != http.StatusUnauthorized || len(hasura.calls("refunds")) != 0 {
+				t.Fatalf("wrong secret = %d, want 401", rec.Code)
+			}
+
+			rec := trigger("s3cret")
+			var output ReconcileRefundsOutput
+			json.Unmarshal(rec.Body.Bytes(), &output)
+			if rec.Code != http.StatusOK || output != tt.want {
+				t.Fatalf("reconcileRefunds = %d %s, want %+v", rec.Code, rec.Body, tt.want)
+			}
+			if len(provider.calls) != 1 || provider.calls[0].Reference != "r-rf1" || provider.calls[0].Amount != 10000 {
+				t.Errorf("provider refunds %+v, want the stored reference and amount", provider.calls)
+			}
+
+			query := hasura.calls("refunds")[0]
+			var lockedBefore DateTime
+			var limit int
+			query.variable(t, "lockedBefore", &lockedBefore)
+			query.variable(t, "limit", &limit)
+			if age := time.Since(time.Time(lockedBefore)); age < refundLockTTL() || age > refundLockTTL()+time.Minute || limit != maxRefundReconcile {
+				t.Errorf("refunds queried for locks older than %v, limit %d", age, limit)
+			}
+
+			var set refunds_set_input
+			updates := hasura.calls("update_refunds_by_pk")
+			if len(updates) > 0 {
+				updates[0].variable(t, "refundSet", &set)
+			}
+			switch {
+			case tt.want.Pending == 1 && len(updates)+len(hasura.calls("update_orders_by_pk")) != 0:
+				t.Errorf("refund with an unknown outcome was settled or unlocked")
+			case tt.want.Failed == 1 && (set.Status == nil || *set.Status != "failed"):
+				t.Errorf("declined refund marked %+v", set)
+			}
+		})
+	}
+}
+
+// A refund whose order no longer covers it is left for someone to look at
+// rather than sent again.
+func TestReconcileRefundsMismatch(t *testing.T) {
+	t.Setenv("CRON_SECRET", "s3cret")
+	hasura, hasuraService := newFakeHasura(t)
+	hasura.on("refunds", `[{"id":"rf1","order_id":"`+testOrderID+`","amount":100,"reason":"Order refund","reference":"r-rf1",
+		"items":[{"orderItemId":"i1","quantity":1}]}]`)
+	hasura.on("orders_by_pk", `{"id":"`+testOrderID+`","user_id":"b1","status":"completed","currency":"ETB","total_amount":100,
+		"chapa_tx_ref":"c-1","payment_provider":"fake","order_items":[{"id":"i1","recipe_id":"r1","quantity":2,"price_at_purchase":30,"recipe":{"user_id":"a1"}}]}`)
+	provider := &refundProvider{FakeProvider: NewFakeProvider()}
+	providers := &ProviderRegistry{providers: map[string]PaymentProvider{"fake": provider}, defaultName: "fake"}
+
+	req := httptest.NewRequest(http.MethodPost, "/reconcileRefunds", strings.NewReader(`{}`))
+	req.Header.Set("X-Cron-Secret", "s3cret")
+	rec := httptest.NewRecorder()
+	HandleReconcileRefunds(rec, req, hasuraService, providers)
+	var output ReconcileRefundsOutput
+	json.Unmarshal(rec.Body.Bytes(), &output)
+	if rec.Code != http.StatusOK || output != (ReconcileRefundsOutput{Pending: 1}) || len(provider.calls) != 0 {
+		t.Errorf("reconcileRefunds = %d %s; provider called %d times", rec.Code, rec.Body, len(provider.calls))
+	}
+}
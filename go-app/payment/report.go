@@ -0,0 +1,234 @@
+package payment
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	reportDateLayout = "2006-01-02"
+	maxReportDays    = 731
+)
+
+// reportPeriods maps each grouping to the default number of periods a
+// report covers when no start date is given.
+var reportPeriods = map[string]int{"day": 30, "week": 12, "month": 12}
+
+// periodStart returns the first day of the day, ISO week or month holding t.
+func periodStart(t time.Time, period string) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	switch period {
+	case "week":
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	case "month":
+		return day.AddDate(0, 0, 1-day.Day())
+	}
+	return day
+}
+
+// reportRange resolves the inclusive from/to dates of a report into a
+// half-open UTC range, defaulting to the last few periods up to today.
+func reportRange(period string, fromInput string, toInput string, now time.Time) (time.Time, time.Time, error) {
+	to := periodStart(now.UTC(), "day")
+	if toInput != "" {
+		parsed, err := time.Parse(reportDateLayout, toInput)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("to must be a date like 2006-01-02")
+		}
+		to = parsed
+	}
+	var from time.Time
+	switch {
+	case fromInput != "":
+		parsed, err := time.Parse(reportDateLayout, fromInput)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("from must be a date like 2006-01-02")
+		}
+		from = parsed
+	case period == "week":
+		from = periodStart(to, period).AddDate(0, 0, -7*(reportPeriods[period]-1))
+	case period == "month":
+		from = periodStart(to, period).AddDate(0, 1-reportPeriods[period], 0)
+	default:
+		from = to.AddDate(0, 0, 1-reportPeriods[period])
+	}
+	end := to.AddDate(0, 0, 1)
+	if !from.Before(end) {
+		return time.Time{}, time.Time{}, fmt.Errorf("from must not be after to")
+	}
+	if end.Sub(from) > maxReportDays*24*time.Hour {
+		return time.Time{}, time.Time{}, fmt.Errorf("reports cover at most %d days", maxReportDays)
+	}
+	return from, end, nil
+}
+
+// ratio is part/whole rounded to four decimals, or zero without a whole.
+func ratio(part int, whole int) float64 {
+	if whole == 0 {
+		return 0
+	}
+	return math.Round(float64(part)/float64(whole)*10000) / 10000
+}
+
+// buildSalesReport groups sold items and views by recipe and period. Amounts
+// are converted to ETB at each order's exchange rate so currencies add up.
+func buildSalesReport(resp creatorSalesQuery, period string) ([]SalesReportRow, SalesReportRow) {
+	rows := make(map[string]*SalesReportRow)
+	row := func(recipeID string, title string, start time.Time) *SalesReportRow {
+		key := recipeID + "|" + start.Format(reportDateLayout)
+		if rows[key] == nil {
+			rows[key] = &SalesReportRow{RecipeID: recipeID, RecipeTitle: title, PeriodStart: start.Format(reportDateLayout)}
+		}
+		return rows[key]
+	}
+
+	for _, item := range resp.OrderItems {
+		r := row(item.RecipeID, item.Recipe.Title, periodStart(time.Time(item.Order.CreatedAt).UTC(), period))
+		revenue := item.Order.ExchangeRate.ToETB(item.PriceAtPurchase.Mul(item.Quantity) - item.DiscountAmount)
+		r.Units += item.Quantity
+		r.RefundedUnits += item.RefundedQuantity
+		r.Revenue += revenue
+		r.NetRevenue += revenue - item.Order.ExchangeRate.ToETB(item.RefundedAmount)
+		r.Purchases++
+	}
+	for _, view := range resp.Views {
+		day, err := time.Parse(reportDateLayout, view.Day)
+		if err != nil {
+			log.Printf("Skipping view count with invalid day %q: %v", view.Day, err)
+			continue
+		}
+		row(view.RecipeID, view.Recipe.Title, periodStart(day, period)).Views += view.Views
+	}
+
+	var totals SalesReportRow
+	output := make([]SalesReportRow, 0, len(rows))
+	for _, r := range rows {
+		r.RefundRate = ratio(r.RefundedUnits, r.Units)
+		r.ConversionRate = ratio(r.Purchases, r.Views)
+		output = append(output, *r)
+
+		totals.Units += r.Units
+		totals.RefundedUnits += r.RefundedUnits
+		totals.Revenue += r.Revenue
+		totals.NetRevenue += r.NetRevenue
+		totals.Views += r.Views
+		totals.Purchases += r.Purchases
+	}
+	totals.RefundRate = ratio(totals.RefundedUnits, totals.Units)
+	totals.ConversionRate = ratio(totals.Purchases, totals.Views)
+
+	sort.Slice(output, func(i, j int) bool {
+		if output[i].PeriodStart != output[j].PeriodStart {
+			return output[i].PeriodStart < output[j].PeriodStart
+		}
+		if output[i].RecipeTitle != output[j].RecipeTitle {
+			return output[i].RecipeTitle < output[j].RecipeTitle
+		}
+		return output[i].RecipeID < output[j].RecipeID
+	})
+	return output, totals
+}
+
+// salesReportCSV renders report rows for spreadsheets, one row per recipe
+// and period.
+func salesReportCSV(rows []SalesReportRow) (string, error) {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	if err := writer.Write([]string{"period_start", "recipe_id", "recipe_title", "units", "refunded_units", "revenue", "net_revenue", "refund_rate", "views", "purchases", "conversion_rate"}); err != nil {
+		return "", err
+	}
+	for _, r := range rows {
+		record := []string{
+			r.PeriodStart,
+			r.RecipeID,
+			r.RecipeTitle,
+			strconv.Itoa(r.Units),
+			strconv.Itoa(r.RefundedUnits),
+			r.Revenue.String(),
+			r.NetRevenue.String(),
+			strconv.FormatFloat(r.RefundRate, 'f', 4, 64),
+			strconv.Itoa(r.Views),
+			strconv.Itoa(r.Purchases),
+			strconv.FormatFloat(r.ConversionRate, 'f', 4, 64),
+		}
+		if err := writer.Write(record); err != nil {
+			return "", err
+		}
+	}
+	writer.Flush()
+	return buf.String(), writer.Error()
+}
+
+// HandleCreatorSalesReport handles the Hasura Action webhook that reports
+// how the caller's recipes sell, grouped by recipe and day, week or month.
+// Only recipes the caller wrote are included.
+func HandleCreatorSalesReport(w http.ResponseWriter, r *http.Request, hasuraService *HasuraService) {
+	var payload CreatorSalesReportActionPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Failed to parse Hasura Action payload")
+		return
+	}
+	defer r.Body.Close()
+
+	userID := payload.SessionVariables["x-hasura-user-id"]
+	if userID == "" {
+		respondWithError(w, http.StatusBadRequest, "Missing user ID")
+		return
+	}
+	input := payload.Input.Input
+	period := input.Period
+	if period == "" {
+		period = "month"
+	}
+	if _, ok := reportPeriods[period]; !ok {
+		respondWithError(w, http.StatusBadRequest, "period must be day, week or month")
+		return
+	}
+	if input.Format != "" && input.Format != "json" && input.Format != "csv" {
+		respondWithError(w, http.StatusBadRequest, "format must be json or csv")
+		return
+	}
+	from, to, err := reportRange(period, input.From, input.To, time.Now())
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	resp, err := hasuraService.QueryCreatorSales(ctx, userID, input.RecipeID, from, to)
+	if err != nil {
+		log.Printf("Failed to query sales for %s: %v", userID, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve sales")
+		return
+	}
+
+	rows, totals := buildSalesReport(resp, period)
+	output := CreatorSalesReportOutput{
+		Period:   period,
+		From:     from.Format(reportDateLayout),
+		To:       to.AddDate(0, 0, -1).Format(reportDateLayout),
+		Currency: baseCurrency,
+		Rows:     rows,
+		Totals:   totals,
+	}
+	if input.Format == "csv" {
+		csvData, err := salesReportCSV(rows)
+		if err != nil {
+			log.Printf("Failed to build sales report CSV for %s: %v", userID, err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to build CSV")
+			return
+		}
+		output.CSV = &csvData
+	}
+	respondWithJSON(w, http.StatusOK, output)
+}
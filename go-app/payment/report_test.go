@@ -0,0 +1,92 @@
+package payment
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPeriodStart(t *testing.T) {
+	// 2025-06-18 is a Wednesday.
+	at := time.Date(2025, 6, 18, 23, 59, 0, 0, time.UTC)
+	tests := []struct {
+		period string
+		t      time.Time
+		want   string
+	}{
+		{"day", at, "2025-06-18"},
+		{"week", at, "2025-06-16"},
+		{"month", at, "2025-06-01"},
+		{"week", time.Date(2025, 6, 16, 0, 0, 0, 0, time.UTC), "2025-06-16"},
+		{"week", time.Date(2025, 6, 22, 12, 0, 0, 0, time.UTC), "2025-06-16"},
+		{"week", time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), "2024-12-30"},
+		{"month", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), "2024-03-01"},
+	}
+	for _, tt := range tests {
+		if got := periodStart(tt.t, tt.period).Format(reportDateLayout); got != tt.want {
+			t.Errorf("periodStart(%s, %s) = %s, want %s", tt.t.Format(time.RFC3339), tt.period, got, tt.want)
+		}
+	}
+}
+
+func TestReportRange(t *testing.T) {
+	now := time.Date(2025, 6, 18, 15, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		period   string
+		from     string
+		to       string
+		wantFrom string
+		wantEnd  string
+		wantErr  string
+	}{
+		{name: "last 30 days", period: "day", wantFrom: "2025-05-20", wantEnd: "2025-06-19"},
+		{name: "last 12 weeks", period: "week", wantFrom: "2025-03-31", wantEnd: "2025-06-19"},
+		{name: "last 12 months", period: "month", wantFrom: "2024-07-01", wantEnd: "2025-06-19"},
+		{name: "explicit range", period: "day", from: "2025-01-01", to: "2025-01-31", wantFrom: "2025-01-01", wantEnd: "2025-02-01"},
+		{name: "single day", period: "day", from: "2025-01-01", to: "2025-01-01", wantFrom: "2025-01-01", wantEnd: "2025-01-02"},
+		{name: "default start before a given end", period: "month", to: "2025-02-10", wantFrom: "2024-03-01", wantEnd: "2025-02-11"},
+		{name: "longest range", period: "day", from: "2023-01-01", to: "2024-12-31", wantFrom: "2023-01-01", wantEnd: "2025-01-01"},
+		{name: "too long", period: "day", from: "2023-01-01", to: "2025-01-01", wantErr: "reports cover at most 731 days"},
+		{name: "reversed", period: "day", from: "2025-02-01", to: "2025-01-31", wantErr: "from must not be after to"},
+		{name: "bad from", period: "day", from: "01/02/2025", wantErr: "from must be a date like 2006-01-02"},
+		{name: "bad to", period: "day", to: "2025-13-01", wantErr: "to must be a date like 2006-01-02"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from, end, err := reportRange(tt.period, tt.from, tt.to, now)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := from.Format(reportDateLayout); got != tt.wantFrom {
+				t.Errorf("from = %s, want %s", got, tt.wantFrom)
+			}
+			if got := end.Format(reportDateLayout); got != tt.wantEnd {
+				t.Errorf("end = %s, want %s", got, tt.wantEnd)
+			}
+		})
+	}
+}
+
+func TestRatio(t *testing.T) {
+	tests := []struct {
+		part, whole int
+		want        float64
+	}{
+		{1, 3, 0.3333},
+		{2, 3, 0.6667},
+		{5, 0, 0},
+		{0, 7, 0},
+		{7, 7, 1},
+	}
+	for _, tt := range tests {
+		if got := ratio(tt.part, tt.whole); got != tt.want {
+			t.Errorf("ratio(%d, %d) = %v, want %v", tt.part, tt.whole, got, tt.want)
+		}
+	}
+}
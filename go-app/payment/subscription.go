@@ -0,0 +1,459 @@
+package payment
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	google_uuid "github.com/google/uuid"
+)
+
+// subscriptionTxRefPrefix marks provider references that belong to
+// subscription payments rather than orders; orders use "c-".
+const subscriptionTxRefPrefix = "s-"
+
+const (
+	defaultSubscriptionGraceDays  = 3
+	defaultSubscriptionNoticeDays = 3
+	subscriptionPaymentTitle      = "Food Recipes Subscription"
+	subscriptionStatusActive      = "active"
+	subscriptionStatusPastDue     = "past_due"
+	subscriptionStatusPending     = "pending"
+	subscriptionPaymentStatusPaid = "completed"
+	subscriptionIntervalYear      = "year"
+)
+
+// subscriptionPaymentStatusActivationFailed marks a payment that was taken
+// but could not activate its subscription; it needs reconciling.
+const subscriptionPaymentStatusActivationFailed = "activation_failed"
+
+// envDays reads a whole number of days from an environment variable.
+func envDays(name string, fallback int) time.Duration {
+	days := fallback
+	if raw := os.Getenv(name); raw != "" {
+		if parsed, err := strconv.Atoi(raw); err == nil && parsed >= 0 {
+			days = parsed
+		} else {
+			log.Printf("Invalid %s %q, using %d", name, raw, fallback)
+		}
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// subscriptionGracePeriod is how long a subscription keeps access after its
+// period ends without a renewal, from SUBSCRIPTION_GRACE_DAYS.
+func subscriptionGracePeriod() time.Duration {
+	return envDays("SUBSCRIPTION_GRACE_DAYS", defaultSubscriptionGraceDays)
+}
+
+// subscriptionRenewalNotice is how long before a period ends the renewal
+// checkout is created and the reminder sent, from
+// SUBSCRIPTION_RENEWAL_NOTICE_DAYS.
+func subscriptionRenewalNotice() time.Duration {
+	return envDays("SUBSCRIPTION_RENEWAL_NOTICE_DAYS", defaultSubscriptionNoticeDays)
+}
+
+// addBillingInterval returns the end of a billing period starting at start.
+func addBillingInterval(start time.Time, interval string) time.Time {
+	if interval == subscriptionIntervalYear {
+		return start.AddDate(1, 0, 0)
+	}
+	return start.AddDate(0, 1, 0)
+}
+
+func isSubscriptionTxRef(txRef string) bool {
+	return strings.HasPrefix(txRef, subscriptionTxRefPrefix)
+}
+
+func newSubscriptionTxRef() string {
+	return fmt.Sprintf("%s%s-%d", subscriptionTxRefPrefix, google_uuid.New().String()[:8], time.Now().Unix())
+}
+
+// startSubscriptionCheckout asks the provider for a checkout page for a
+// recorded subscription payment and stores it on the payment. A payment the
+// provider refused is marked failed so it is never handed out again.
+func startSubscriptionCheckout(ctx context.Context, hasuraService *HasuraService, provider PaymentProvider, userID string, subscriptionID string, payment subscription_payments_insert_input) (string, error) {
+	userResp, err := hasuraService.QueryUserDetails(ctx, userID)
+	if err != nil || userResp.UsersByPk == nil {
+		return "", fmt.Errorf("subscriber details not found: %v", err)
+	}
+	user := userResp.UsersByPk
+
+	result, err := provider.InitiatePayment(ctx, InitiatePaymentRequest{
+		Amount:      payment.Amount,
+		Currency:    payment.Currency,
+		TxRef:       payment.ChapaTxRef,
+		Email:       user.Email,
+		FirstName:   user.FirstName,
+		LastName:    user.LastName,
+		PhoneNumber: user.PhoneNumber,
+		CallbackURL: callbackURLFor(provider.Name()),
+		ReturnURL:   getFrontendRedirectURL(payment.ReturnURL, "pending", subscriptionID, payment.ChapaTxRef, ""),
+		Title:       subscriptionPaymentTitle,
+		Description: fmt.Sprintf("Subscription ID: %s", subscriptionID),
+	})
+	if err != nil {
+		failed := "failed"
+		if _, updateErr := hasuraService.UpdateSubscriptionPayment(ctx, string(payment.ID), subscription_payments_set_input{Status: &failed}); updateErr != nil {
+			log.Printf("Failed to mark subscription payment %s as failed: %v", payment.ID, updateErr)
+		}
+		return "", fmt.Errorf("%s API error: %w", provider.Name(), err)
+	}
+	if _, err := hasuraService.UpdateSubscriptionPayment(ctx, string(payment.ID), subscription_payments_set_input{CheckoutURL: &result.CheckoutURL}); err != nil {
+		log.Printf("Failed to store checkout URL for subscription payment %s: %v", payment.ID, err)
+	}
+	return result.CheckoutURL, nil
+}
+
+// HandleSubscribe handles the Hasura Action webhook that starts an
+// all-access subscription. The subscription stays pending until the first
+// payment completes; retrying while that checkout is open returns it again.
+func HandleSubscribe(w http.ResponseWriter, r *http.Request, hasuraService *HasuraService, providers *ProviderRegistry) {
+	log.Println("🚀 Received /subscribe request")
+	var payload SubscribeActionPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Failed to parse Hasura Action payload")
+		return
+	}
+	defer r.Body.Close()
+
+	userID := payload.SessionVariables["x-hasura-user-id"]
+	input := payload.Input.Input
+	if userID == "" || input.PlanID == "" || input.ReturnURL == "" {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload or missing user ID")
+		return
+	}
+	provider, ok := providers.Get(input.Provider)
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Unsupported payment provider: %s", input.Provider))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	now := time.Now()
+	resp, err := hasuraService.QuerySubscribe(ctx, userID, input.PlanID, provider.Name(), now.Add(time.Minute))
+	if err != nil {
+		log.Printf("Failed to load subscription state for %s: %v", userID, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve subscription details")
+		return
+	}
+	plan := resp.PlansByPk
+	if plan == nil || !plan.IsActive {
+		respondWithError(w, http.StatusNotFound, "Plan not found")
+		return
+	}
+	if !provider.SupportsCurrency(plan.Currency) {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s does not accept payments in %s", provider.Name(), plan.Currency))
+		return
+	}
+	if len(resp.Subscriptions) > 0 {
+		respondWithError(w, http.StatusConflict, "You already have an active subscription")
+		return
+	}
+	if len(resp.Pending) > 0 && len(resp.Pending[0].SubscriptionPayments) > 0 {
+		open := resp.Pending[0].SubscriptionPayments[0]
+		if open.Amount == plan.Price && open.Currency == plan.Currency {
+			respondWithJSON(w, http.StatusOK, SubscribeOutput{
+				SubscriptionID: resp.Pending[0].ID,
+				PaymentID:      open.ID,
+				CheckoutURL:    *open.CheckoutURL,
+				TxRef:          open.ChapaTxRef,
+				Provider:       open.PaymentProvider,
+				Amount:         open.Amount,
+				Currency:       open.Currency,
+				Message:        "Payment already initiated for this subscription",
+			})
+			return
+		}
+	}
+
+	subscriptionID := google_uuid.New().String()
+	payment := subscription_payments_insert_input{
+		ID:              uuid(google_uuid.New().String()),
+		Amount:          plan.Price,
+		Currency:        plan.Currency,
+		Status:          "pending",
+		PaymentProvider: provider.Name(),
+		ChapaTxRef:      newSubscriptionTxRef(),
+		ReturnURL:       input.ReturnURL,
+		ExpiresAt:       DateTime(now.Add(orderExpiry())),
+	}
+	insertResp, err := hasuraService.InsertSubscription(ctx, subscriptions_insert_input{
+		ID:                   uuid(subscriptionID),
+		UserID:               uuid(userID),
+		PlanID:               uuid(plan.ID),
+		Status:               subscriptionStatusPending,
+		SubscriptionPayments: subscription_payments_arr_rel_insert_input{Data: []subscription_payments_insert_input{payment}},
+	})
+	if err != nil || insertResp.InsertSubscriptionsOne == nil {
+		log.Printf("Failed to insert subscription for %s: %v", userID, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to record subscription")
+		return
+	}
+
+	checkoutURL, err := startSubscriptionCheckout(ctx, hasuraService, provider, userID, subscriptionID, payment)
+	if err != nil {
+		log.Printf("Failed to start subscription checkout for %s: %v", subscriptionID, err)
+		respondWithError(w, http.StatusInternalServerError, "Payment service failed to initiate")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, SubscribeOutput{
+		SubscriptionID: subscriptionID,
+		PaymentID:      string(payment.ID),
+		CheckoutURL:    checkoutURL,
+		TxRef:          payment.ChapaTxRef,
+		Provider:       provider.Name(),
+		Amount:         payment.Amount,
+		Currency:       payment.Currency,
+		Message:        "Payment initiated successfully",
+	})
+}
+
+// nextSubscriptionPeriod works out the period a completed payment pays for.
+// A renewal of a live subscription continues from the end of the current
+// period, even during the grace period; anything else starts now.
+func nextSubscriptionPeriod(payment subscriptionPaymentRecord, now time.Time) (time.Time, time.Time) {
+	sub := payment.Subscription
+	start := now
+	live := sub.Status == subscriptionStatusActive || sub.Status == subscriptionStatusPastDue
+	if live && sub.CurrentPeriodEnd != nil {
+		start = time.Time(*sub.CurrentPeriodEnd)
+	}
+	return start, addBillingInterval(start, sub.Plan.BillingInterval)
+}
+
+// handleSubscriptionCallback finishes a subscription payment once the
+// provider has confirmed it, mirroring HandlePaymentCallback for orders. A
+// payment that completes after its checkout expired still counts.
+func handleSubscriptionCallback(w http.ResponseWriter, r *http.Request, hasuraService *HasuraService, provider PaymentProvider, txRef string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	resp, err := hasuraService.QuerySubscriptionPayment(ctx, txRef)
+	if err != nil || len(resp.SubscriptionPayments) == 0 || resp.SubscriptionPayments[0].PaymentProvider != provider.Name() {
+		log.Printf("Failed to query subscription payment for TxRef %s: %v", txRef, err)
+		http.Error(w, "Subscription payment not found for verification", http.StatusOK)
+		return
+	}
+	payment := resp.SubscriptionPayments[0]
+	subscriptionID := payment.Subscription.ID
+
+	verification, err := provider.VerifyPayment(ctx, txRef)
+	if err != nil {
+		log.Printf("Failed to verify subscription transaction %s with %s: %v", txRef, provider.Name(), err)
+		finishCallback(w, r, getFrontendRedirectURL(payment.ReturnURL, "failure", subscriptionID, txRef, "Verification failed"), "failure")
+		return
+	}
+
+	dbStatus := "unknown"
+	message := "Payment status unknown."
+	switch verification.Status {
+	case PaymentStatusSuccess:
+		dbStatus = subscriptionPaymentStatusPaid
+		message = "Your subscription is active!"
+	case PaymentStatusFailed:
+		dbStatus = "failed"
+		message = "Your payment failed."
+	}
+	if verification.Status == PaymentStatusSuccess && (verification.Amount != payment.Amount || !strings.EqualFold(verification.Currency, payment.Currency)) {
+		log.Printf("❌ Amount mismatch for %s: paid %s %s, expected %s %s", txRef, verification.Amount, verification.Currency, payment.Amount, payment.Currency)
+		dbStatus = "amount_mismatch"
+		message = "Your payment amount did not match the subscription price. Please contact support."
+	}
+
+	now := time.Now()
+	set := subscription_payments_set_input{Status: &dbStatus, ChapaTransactionID: &verification.TransactionID}
+	if dbStatus != subscriptionPaymentStatusPaid {
+		if _, err := hasuraService.UpdateSubscriptionPaymentStatus(ctx, txRef, set); err != nil {
+			log.Printf("Failed to update subscription payment %s to %s: %v", payment.ID, dbStatus, err)
+		}
+		finishCallback(w, r, getFrontendRedirectURL(payment.ReturnURL, dbStatus, subscriptionID, txRef, message), dbStatus)
+		return
+	}
+
+	paidAt := DateTime(now)
+	set.PaidAt = &paidAt
+	start, end := nextSubscriptionPeriod(payment, now)
+	_, err = hasuraService.CompleteSubscriptionPayment(ctx, subscriptionID, txRef, set, subscriptions_set_input{
+		"status":                   subscriptionStatusActive,
+		"current_period_start":     DateTime(start),
+		"current_period_end":       DateTime(end),
+		"cancel_at_period_end":     false,
+		"renewal_reminder_sent_at": nil,
+	})
+	if err == nil {
+		log.Printf("✅ Subscription %s paid through %s", subscriptionID, end.Format(time.RFC3339))
+	} else {
+		// Nothing was applied. Keep a record that the money was taken so the
+		// payment can be reconciled or refunded; a replayed callback retries.
+		log.Printf("❌ Failed to activate subscription %s after payment %s: %v", subscriptionID, payment.ID, err)
+		dbStatus = subscriptionPaymentStatusActivationFailed
+		message = "Your payment was received but your subscription could not be activated. Please contact support."
+		set.Status = &dbStatus
+		if _, err := hasuraService.UpdateSubscriptionPaymentStatus(ctx, txRef, set); err != nil {
+			log.Printf("Failed to update subscription payment %s to %s: %v", payment.ID, dbStatus, err)
+		}
+	}
+
+	finishCallback(w, r, getFrontendRedirectURL(payment.ReturnURL, dbStatus, subscriptionID, txRef, message), dbStatus)
+}
+
+// HandleCancelSubscription handles the Hasura Action webhook that stops the
+// caller's subscription from renewing. Access continues until the end of
+// the period already paid for.
+func HandleCancelSubscription(w http.ResponseWriter, r *http.Request, hasuraService *HasuraService) {
+	var payload CancelSubscriptionActionPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Failed to parse Hasura Action payload")
+		return
+	}
+	defer r.Body.Close()
+
+	userID := payload.SessionVariables["x-hasura-user-id"]
+	if userID == "" {
+		respondWithError(w, http.StatusBadRequest, "Missing user ID")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	resp, err := hasuraService.CancelSubscription(ctx, userID)
+	if err != nil || resp.UpdateSubscriptions == nil {
+		log.Printf("Failed to cancel subscription for %s: %v", userID, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to cancel subscription")
+		return
+	}
+	if len(resp.UpdateSubscriptions.Returning) == 0 {
+		respondWithError(w, http.StatusNotFound, "You have no active subscription")
+		return
+	}
+	sub := resp.UpdateSubscriptions.Returning[0]
+	respondWithJSON(w, http.StatusOK, CancelSubscriptionOutput{
+		SubscriptionID:    sub.ID,
+		Status:            sub.Status,
+		CancelAtPeriodEnd: sub.CancelAtPeriodEnd,
+		CurrentPeriodEnd:  sub.CurrentPeriodEnd,
+	})
+}
+
+// startRenewal creates the checkout for a subscription's next period and
+// emails the subscriber a link to it. It reports whether the email went out;
+// a failed email is logged but does not undo the renewal.
+func startRenewal(ctx context.Context, hasuraService *HasuraService, providers *ProviderRegistry, sub renewalDueSubscription) (bool, error) {
+	now := DateTime(time.Now())
+	if !sub.Plan.IsActive {
+		// Nothing to renew into; mark it handled so the subscription lapses.
+		if _, err := hasuraService.UpdateSubscription(ctx, sub.ID, subscriptions_set_input{"renewal_reminder_sent_at": now}); err != nil {
+			return false, fmt.Errorf("failed to record lapsed plan %s: %w", sub.Plan.Name, err)
+		}
+		return false, fmt.Errorf("plan %s is no longer offered", sub.Plan.Name)
+	}
+	providerName, returnURL := "", ""
+	if len(sub.SubscriptionPayments) > 0 {
+		providerName = sub.SubscriptionPayments[0].PaymentProvider
+		returnURL = sub.SubscriptionPayments[0].ReturnURL
+	}
+	provider, ok := providers.Get(providerName)
+	if !ok {
+		provider, _ = providers.Get("")
+	}
+
+	// The renewal checkout stays usable until the grace period runs out.
+	payment := subscription_payments_insert_input{
+		ID:              uuid(google_uuid.New().String()),
+		SubscriptionID:  uuid(sub.ID),
+		Amount:          sub.Plan.Price,
+		Currency:        sub.Plan.Currency,
+		Status:          "pending",
+		PaymentProvider: provider.Name(),
+		ChapaTxRef:      newSubscriptionTxRef(),
+		ReturnURL:       returnURL,
+		ExpiresAt:       DateTime(time.Time(sub.CurrentPeriodEnd).Add(subscriptionGracePeriod())),
+	}
+	if _, err := hasuraService.InsertSubscriptionPayment(ctx, payment); err != nil {
+		return false, fmt.Errorf("failed to record renewal payment: %w", err)
+	}
+	checkoutURL, err := startSubscriptionCheckout(ctx, hasuraService, provider, sub.UserID, sub.ID, payment)
+	if err != nil {
+		return false, err
+	}
+	if _, err := hasuraService.UpdateSubscription(ctx, sub.ID, subscriptions_set_input{"renewal_reminder_sent_at": now}); err != nil {
+		return false, fmt.Errorf("failed to record renewal: %w", err)
+	}
+
+	if !mailConfigured() {
+		return false, nil
+	}
+	userResp, err := hasuraService.QueryUserDetails(ctx, sub.UserID)
+	if err != nil || userResp.UsersByPk == nil || userResp.UsersByPk.Email == "" {
+		log.Printf("No email address for renewal reminder of subscription %s: %v", sub.ID, err)
+		return false, nil
+	}
+	user := userResp.UsersByPk
+	body := fmt.Sprintf("Hi %s,\n\nYour %s subscription ends on %s. Renew it for %s %s to keep access to every recipe:\n\n%s\n\nFood Recipes\n",
+		user.FirstName, sub.Plan.Name, time.Time(sub.CurrentPeriodEnd).UTC().Format("02 Jan 2006"), sub.Plan.Price, sub.Plan.Currency, checkoutURL)
+	if err := sendMail(user.Email, "Renew your Food Recipes subscription", body); err != nil {
+		log.Printf("Failed to send renewal reminder for subscription %s: %v", sub.ID, err)
+		return false, nil
+	}
+	return true, nil
+}
+
+// HandleRenewSubscriptions handles the Hasura cron trigger that moves
+// subscriptions through their lifecycle and starts renewals that are due.
+func HandleRenewSubscriptions(w http.ResponseWriter, r *http.Request, hasuraService *HasuraService, providers *ProviderRegistry) {
+	if !validCronRequest(r) {
+		respondWithError(w, http.StatusUnauthorized, "Invalid cron secret")
+		return
+	}
+	defer r.Body.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	now := time.Now()
+	advanced, err := hasuraService.AdvanceSubscriptions(ctx, now, now.Add(-orderExpiry()), now.Add(-subscriptionGracePeriod()))
+	if err != nil {
+		log.Printf("Failed to advance subscriptions: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to advance subscriptions")
+		return
+	}
+	count := func(rows *affectedRows) int {
+		if rows == nil {
+			return 0
+		}
+		return rows.AffectedRows
+	}
+	output := RenewSubscriptionsOutput{
+		ExpiredPayments: count(advanced.ExpiredPayments),
+		PastDue:         count(advanced.PastDue),
+		Expired:         count(advanced.Expired) + count(advanced.Abandoned),
+		Canceled:        count(advanced.Canceled),
+	}
+
+	due, err := hasuraService.QueryRenewalDue(ctx, now.Add(subscriptionRenewalNotice()))
+	if err != nil {
+		log.Printf("Failed to query subscriptions due for renewal: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to query subscriptions due for renewal")
+		return
+	}
+	for _, sub := range due.Subscriptions {
+		sent, err := startRenewal(ctx, hasuraService, providers, sub)
+		if err != nil {
+			log.Printf("Failed to start renewal for subscription %s: %v", sub.ID, err)
+			continue
+		}
+		output.RenewalsStarted++
+		if sent {
+			output.RemindersSent++
+		}
+	}
+	log.Printf("⏰ Subscriptions: %+v", output)
+	respondWithJSON(w, http.StatusOK, output)
+}
@@ -0,0 +1,157 @@
+package payment
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNextSubscriptionPeriod(t *testing.T) {
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	periodEnd := DateTime(time.Date(2025, 3, 31, 0, 0, 0, 0, time.UTC))
+	payment := func(status string, end *DateTime, interval string) subscriptionPaymentRecord {
+		var p subscriptionPaymentRecord
+		p.Subscription.Status = status
+		p.Subscription.CurrentPeriodEnd = end
+		p.Subscription.Plan.BillingInterval = interval
+		return p
+	}
+
+	tests := []struct {
+		name      string
+		payment   subscriptionPaymentRecord
+		wantStart time.Time
+		wantEnd   time.Time
+	}{
+		{"first payment", payment(subscriptionStatusPending, nil, "month"), now, now.AddDate(0, 1, 0)},
+		{"early renewal", payment(subscriptionStatusActive, &periodEnd, "month"), time.Time(periodEnd), time.Time(periodEnd).AddDate(0, 1, 0)},
+		{"renewal in the grace period", payment(subscriptionStatusPastDue, &periodEnd, subscriptionIntervalYear), time.Time(periodEnd), time.Time(periodEnd).AddDate(1, 0, 0)},
+		{"resubscribing after it lapsed", payment("expired", &periodEnd, "month"), now, now.AddDate(0, 1, 0)},
+	}
+	for _, tt := range tests {
+		start, end := nextSubscriptionPeriod(tt.payment, now)
+		if !start.Equal(tt.wantStart) || !end.Equal(tt.wantEnd) {
+			t.Errorf("%s: period %s to %s, want %s to %s", tt.name, start, end, tt.wantStart, tt.wantEnd)
+		}
+	}
+}
+
+func subscribe(t *testing.T, hasuraService *HasuraService, providers *ProviderRegistry) *httptest.ResponseRecorder {
+	t.Helper()
+	action := `{"input":{"input":{"planId":"p1","returnUrl":"https://shop.example.com/account","provider":"fake"}},"session_variables":{"x-hasura-user-id":"u1"}}`
+	rec := httptest.NewRecorder()
+	HandleSubscribe(rec, httptest.NewRequest(http.MethodPost, "/subscribe", bytes.NewBufferString(action)), hasuraService, providers)
+	return rec
+}
+
+// Retrying while the first checkout is still open hands out the same
+// checkout instead of recording another subscription.
+func TestSubscribeReturnsOpenCheckout(t *testing.T) {
+	hasura, hasuraService := newFakeHasura(t)
+	hasura.on("plans_by_pk", `{"id":"p1","name":"Monthly","billing_interval":"month","price":199,"currency":"ETB","is_active":true}`)
+	hasura.on("live", `[]`)
+	hasura.on("pending", `[{"id":"s1","subscription_payments":[{"id":"sp1","chapa_tx_ref":"s-1","checkout_url":"https://pay.example.com/s-1","payment_provider":"fake","amount":199,"currency":"ETB"}]}]`)
+	t.Setenv("PAYMENT_PROVIDER", "fake")
+	t.Setenv("PAYMENT_PROVIDERS", "")
+
+	rec := subscribe(t, hasuraService, NewProviderRegistry())
+	var output SubscribeOutput
+	json.Unmarshal(rec.Body.Bytes(), &output)
+	if rec.Code != http.StatusOK || output.SubscriptionID != "s1" || output.CheckoutURL != "https://pay.example.com/s-1" {
+		t.Fatalf("subscribe = %d %s", rec.Code, rec.Body)
+	}
+	if n := len(hasura.calls("insert_subscriptions_one")); n != 0 {
+		t.Errorf("%d subscriptions recorded for a retry", n)
+	}
+
+	// A price change since the checkout was opened starts a new one.
+	hasura.on("plans_by_pk", `{"id":"p1","name":"Monthly","billing_interval":"month","price":249,"currency":"ETB","is_active":true}`)
+	subscribe(t, hasuraService, NewProviderRegistry())
+	if n := len(hasura.calls("insert_subscriptions_one")); n != 1 {
+		t.Errorf("%d subscriptions recorded after the price changed, want 1", n)
+	}
+}
+
+func TestSubscribeWhileSubscribed(t *testing.T) {
+	hasura, hasuraService := newFakeHasura(t)
+	hasura.on("plans_by_pk", `{"id":"p1","price":199,"currency":"ETB","is_active":true}`)
+	hasura.on("live", `[{"id":"s0","status":"past_due"}]`)
+	hasura.on("pending", `[]`)
+	t.Setenv("PAYMENT_PROVIDER", "fake")
+	t.Setenv("PAYMENT_PROVIDERS", "")
+
+	if rec := subscribe(t, hasuraService, NewProviderRegistry()); rec.Code != http.StatusConflict {
+		t.Errorf("subscribe while subscribed = %d, want 409", rec.Code)
+	}
+}
+
+// A replayed callback finds the payment already completed and must not
+// extend the subscription a second time.
+func TestSubscriptionCallbackReplay(t *testing.T) {
+	hasura, hasuraService := newFakeHasura(t)
+	hasura.on("subscription_payments", `[{"id":"sp1","amount":199,"currency":"ETB","payment_provider":"fake","return_url":"https://shop.example.com/account",
+		"subscription":{"id":"s1","user_id":"u1","status":"active","current_period_end":"2025-03-15T00:00:00Z","plan":{"billing_interval":"month"}}}]`)
+	provider := NewFakeProvider()
+	provider.InitiatePayment(context.Background(), InitiatePaymentRequest{Amount: 19900, Currency: "ETB", TxRef: "s-1", CallbackURL: "http://backend/cb"})
+	completeFakePayment(t, provider, "s-1", PaymentStatusSuccess)
+
+	callback := func() {
+		rec := httptest.NewRecorder()
+		handleSubscriptionCallback(rec, httptest.NewRequest(http.MethodPost, "/payments/fake/callback", nil), hasuraService, provider, "s-1")
+		if rec.Body.String() != `{"status":"completed"}` {
+			t.Fatalf("callback = %s", rec.Body)
+		}
+	}
+
+	hasura.on("update_subscription_payments", `{"affected_rows":1}`)
+	hasura.on("update_subscriptions", `{"affected_rows":1}`)
+	callback()
+	activations := hasura.calls("update_subscriptions")
+	if len(activations) != 1 {
+		t.Fatalf("subscription updated %d times, want 1", len(activations))
+	}
+	// The subscription and its payment are updated in the one mutation, and
+	// only while the payment is open, so a replay matches neither
+	if !selects(activations[0].Query, "update_subscription_payments") ||
+		!strings.Contains(activations[0].Query, `subscription_payments: {chapa_tx_ref: {_eq: $txRef}, status: {_neq: "completed"}}`) {
+		t.Errorf("activation is not guarded by the open payment:\n%s", activations[0].Query)
+	}
+	var set map[string]interface{}
+	activations[0].variable(t, "subscription", &set)
+	if set["status"] != subscriptionStatusActive || set["current_period_start"] != "2025-03-15T00:00:00Z" || set["current_period_end"] != "2025-04-15T00:00:00Z" {
+		t.Errorf("subscription updated with %v", set)
+	}
+}
+
+// A payment that cannot activate its subscription is kept for reconciling
+// rather than left looking unpaid.
+func TestSubscriptionCallbackActivationFailed(t *testing.T) {
+	hasura, hasuraService := newFakeHasura(t)
+	hasura.on("subscription_payments", `[{"id":"sp1","amount":199,"currency":"ETB","payment_provider":"fake","return_url":"https://shop.example.com/account",
+		"subscription":{"id":"s1","user_id":"u1","status":"pending","plan":{"billing_interval":"month"}}}]`)
+	hasura.on("update_subscription_payments", `{"affected_rows":1}`)
+	hasura.on("update_subscriptions", `{"affected_rows":"not a number"}`)
+	provider := NewFakeProvider()
+	provider.InitiatePayment(context.Background(), InitiatePaymentRequest{Amount: 19900, Currency: "ETB", TxRef: "s-1", CallbackURL: "http://backend/cb"})
+	completeFakePayment(t, provider, "s-1", PaymentStatusSuccess)
+
+	rec := httptest.NewRecorder()
+	handleSubscriptionCallback(rec, httptest.NewRequest(http.MethodPost, "/payments/fake/callback", nil), hasuraService, provider, "s-1")
+	if rec.Body.String() != `{"status":"activation_failed"}` {
+		t.Fatalf("callback = %s", rec.Body)
+	}
+	updates := hasura.calls("update_subscription_payments")
+	if len(updates) != 2 {
+		t.Fatalf("payment updated %d times, want the failed activation and its record", len(updates))
+	}
+	var set subscription_payments_set_input
+	updates[1].variable(t, "set", &set)
+	if set.Status == nil || *set.Status != subscriptionPaymentStatusActivationFailed || set.PaidAt == nil {
+		t.Errorf("payment recorded with %+v, want activation_failed and paid", set)
+	}
+}
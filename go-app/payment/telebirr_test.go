@@ -0,0 +1,243 @@
+package payment
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testTelebirrService signs with a fresh key and trusts notifications signed
+// with the same key, standing in for Telebirr's public key.
+func testTelebirrService(t *testing.T) *TelebirrService {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &TelebirrService{privateKey: key, publicKey: &key.PublicKey}
+}
+
+func TestCanonicalTelebirrString(t *testing.T) {
+	params := map[string]string{
+		"timestamp":  "1700000000",
+		"appid":      "app",
+		"nonce_str":  "n1",
+		"sign":       "c2lnbg==",
+		"sign_type":  telebirrSignType,
+		"prepay_id":  "",
+		"merch_code": "101",
+	}
+	want := "appid=app&merch_code=101&nonce_str=n1&timestamp=1700000000"
+	if got := canonicalTelebirrString(params); got != want {
+		t.Errorf("canonicalTelebirrString = %q, want %q", got, want)
+	}
+}
+
+func TestTelebirrSignVerify(t *testing.T) {
+	s := testTelebirrService(t)
+	params := map[string]string{"merch_order_id": "c_1", "total_amount": "150.50", "trade_status": "Completed"}
+	signature, err := s.sign(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.verify(params, signature); err != nil {
+		t.Errorf("own signature rejected: %v", err)
+	}
+
+	// The signature fields are not part of what is signed.
+	params["sign"], params["sign_type"] = signature, telebirrSignType
+	if err := s.verify(params, signature); err != nil {
+		t.Errorf("signature rejected once attached: %v", err)
+	}
+
+	params["total_amount"] = "1.50"
+	if err := s.verify(params, signature); err == nil {
+		t.Error("signature accepted for a changed amount")
+	}
+	params["total_amount"] = "150.50"
+	if err := testTelebirrService(t).verify(params, signature); err == nil {
+		t.Error("signature accepted with another key")
+	}
+	if err := s.verify(params, "not base64!"); err == nil {
+		t.Error("malformed signature accepted")
+	}
+}
+
+func TestTelebirrParseWebhook(t *testing.T) {
+	s := testTelebirrService(t)
+	notification := func(tamper func(map[string]string)) *http.Request {
+		fields := map[string]string{
+			"merch_order_id":   "c_5f2a91b0_1700000000",
+			"payment_order_id": "p1",
+			"total_amount":     "150.50",
+			"trade_status":     "Completed",
+		}
+		signature, err := s.sign(fields)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fields["sign"], fields["sign_type"] = signature, telebirrSignType
+		if tamper != nil {
+			tamper(fields)
+		}
+		body, _ := json.Marshal(fields)
+		return httptest.NewRequest(http.MethodPost, "/payments/telebirr/callback", bytes.NewReader(body))
+	}
+
+	event, err := s.ParseWebhook(notification(nil))
+	if err != nil || event.TxRef != "c-5f2a91b0-1700000000" {
+		t.Fatalf("ParseWebhook = %+v, %v", event, err)
+	}
+	for name, tamper := range map[string]func(map[string]string){
+		"changed amount": func(f map[string]string) { f["total_amount"] = "1.50" },
+		"other order":    func(f map[string]string) { f["merch_order_id"] = "c_other_1700000000" },
+		"unsigned":       func(f map[string]string) { delete(f, "sign") },
+	} {
+		if event, err := s.ParseWebhook(notification(tamper)); err == nil {
+			t.Errorf("%s: notification accepted for %s", name, event.TxRef)
+		}
+	}
+
+	event, err = s.ParseWebhook(httptest.NewRequest(http.MethodGet, "/payments/telebirr/callback?trx_ref=c-1", nil))
+	if err != nil || event.TxRef != "c-1" {
+		t.Errorf("browser return = %+v, %v", event, err)
+	}
+	if _, err := s.ParseWebhook(httptest.NewRequest(http.MethodGet, "/payments/telebirr/callback", nil)); err == nil {
+		t.Error("browser return without trx_ref accepted")
+	}
+}
+
+func TestDecodeTelebirrNotification(t *testing.T) {
+	body := `{"merch_order_id":"c_5f2a91b0_1700000000","total_amount":150.50,"notify_time":1700000000123,` +
+		`"is_refund":false,"trans_end_time":null,"appid":""}`
+	params, err := decodeTelebirrNotification(strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"merch_order_id": "c_5f2a91b0_1700000000",
+		"total_amount":   "150.50",
+		"notify_time":    "1700000000123",
+		"is_refund":      "false",
+		"appid":          "",
+	}
+	if !reflect.DeepEqual(params, want) {
+		t.Errorf("decodeTelebirrNotification = %v, want %v", params, want)
+	}
+	if _, err := decodeTelebirrNotification(strings.NewReader(`["not", "an", "object"]`)); err == nil {
+		t.Error("a JSON array was accepted")
+	}
+
+	// A notification with numeric fields verifies against the text Telebirr
+	// signed, and only with the signature it was sent with.
+	s := testTelebirrService(t)
+	signature, err := s.sign(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notification := func(sign string) *http.Request {
+		signed := strings.TrimSuffix(body, "}") + `,"sign":"` + sign + `","sign_type":"` + telebirrSignType + `"}`
+		return httptest.NewRequest(http.MethodPost, "/payments/telebirr/callback", strings.NewReader(signed))
+	}
+	if event, err := s.ParseWebhook(notification(signature)); err != nil || event.TxRef != "c-5f2a91b0-1700000000" {
+		t.Errorf("ParseWebhook = %+v, %v", event, err)
+	}
+	tampered, _ := base64.StdEncoding.DecodeString(signature)
+	tampered[0] ^= 1
+	if _, err := s.ParseWebhook(notification(base64.StdEncoding.EncodeToString(tampered))); err == nil {
+		t.Error("notification accepted with a tampered signature")
+	}
+}
+
+// The checkout URL carries the base64 signature escaped, so a "+" in it
+// reaches Telebirr intact and still verifies against the other parameters.
+func TestTelebirrInitiatePayment(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/payment/v1/merchant/preOrder" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"result":"SUCCESS","biz_content":{"prepay_id":"pp+1/2="}}`))
+	}))
+	defer server.Close()
+	s := testTelebirrService(t)
+	s.baseURL, s.webBaseURL, s.client = server.URL, "https://pay.example.com/paygate?", server.Client()
+	s.merchantAppID, s.shortCode = "app", "101"
+	s.token, s.tokenExpiry = "token", time.Now().Add(time.Hour)
+
+	result, err := s.InitiatePayment(context.Background(), InitiatePaymentRequest{Amount: 15050, Currency: "ETB", TxRef: "c-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkout, err := url.Parse(result.CheckoutURL)
+	if err != nil || checkout.Host != "pay.example.com" || checkout.Path != "/paygate" {
+		t.Fatalf("checkout URL %q: %v", result.CheckoutURL, err)
+	}
+	query := checkout.Query()
+	params := map[string]string{}
+	for k := range query {
+		params[k] = query.Get(k)
+	}
+	if params["prepay_id"] != "pp+1/2=" || params["sign_type"] != telebirrSignType || params["trade_type"] != "Checkout" {
+		t.Fatalf("checkout parameters %v", params)
+	}
+	delete(params, "version")
+	delete(params, "trade_type")
+	if err := s.verify(params, params["sign"]); err != nil {
+		t.Fatalf("signature in %q does not verify: %v", result.CheckoutURL, err)
+	}
+}
+
+func TestTelebirrRefundOutcome(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		rejected bool
+	}{
+		{name: "refunded", status: http.StatusOK, body: `{"result":"SUCCESS","biz_content":{"refund_status":"REFUND_SUCCESS","refund_order_id":"tr-1"}}`},
+		{name: "refused", status: http.StatusOK, body: `{"result":"FAIL","code":"60000042","msg":"refund amount exceeds"}`, rejected: true},
+		{name: "refund failed", status: http.StatusOK, body: `{"result":"SUCCESS","biz_content":{"refund_status":"REFUND_FAILED"}}`, rejected: true},
+		{name: "server error", status: http.StatusInternalServerError, body: `busy`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+			s := testTelebirrService(t)
+			s.baseURL, s.client = server.URL, server.Client()
+			s.token, s.tokenExpiry = "token", time.Now().Add(time.Hour)
+
+			result, err := s.Refund(context.Background(), "c-1", RefundRequest{Amount: 1000, Currency: "ETB", Reference: "r-1"})
+			var rejected RefundRejectedError
+			switch {
+			case tt.rejected:
+				if !errors.As(err, &rejected) {
+					t.Errorf("Refund error = %v, want a rejection", err)
+				}
+			case tt.status == http.StatusOK:
+				if err != nil || result.ProviderReference != "tr-1" {
+					t.Errorf("Refund = %+v, %v", result, err)
+				}
+			default:
+				if err == nil || errors.As(err, &rejected) {
+					t.Errorf("Refund error = %v, want an unknown outcome", err)
+				}
+			}
+		})
+	}
+}
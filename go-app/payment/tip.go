@@ -0,0 +1,189 @@
+package payment
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	google_uuid "github.com/google/uuid"
+)
+
+// Order types. A tip is an order with no order_items that pays its whole
+// amount to the author of tip_recipe_id.
+const (
+	orderTypePurchase = "purchase"
+	orderTypeTip      = "tip"
+)
+
+const (
+	defaultTipMinAmount         = "10"
+	defaultTipMaxAmount         = "10000"
+	defaultTipCommissionPercent = "0"
+	maxTipMessageLength         = 280
+)
+
+// envMoney reads a non-negative amount from the environment, falling back to
+// def when it is unset or invalid.
+func envMoney(name string, def string) Money {
+	raw := os.Getenv(name)
+	if raw == "" {
+		raw = def
+	}
+	amount, err := ParseMoney(raw)
+	if err != nil || amount < 0 {
+		log.Printf("Invalid %s %q, using %s", name, raw, def)
+		amount, _ = ParseMoney(def)
+	}
+	return amount
+}
+
+// tipLimits reads TIP_MIN_AMOUNT and TIP_MAX_AMOUNT, the smallest and largest
+// tip in ETB.
+func tipLimits() (Money, Money) {
+	return envMoney("TIP_MIN_AMOUNT", defaultTipMinAmount), envMoney("TIP_MAX_AMOUNT", defaultTipMaxAmount)
+}
+
+// tipCommissionPercent reads TIP_COMMISSION_PERCENT, the share of a tip the
+// platform keeps. Tips go entirely to the author unless it is set.
+func tipCommissionPercent() Money {
+	percent := envMoney("TIP_COMMISSION_PERCENT", defaultTipCommissionPercent)
+	if percent > 10000 {
+		log.Printf("Invalid TIP_COMMISSION_PERCENT %s, using %s%%", percent, defaultTipCommissionPercent)
+		percent, _ = ParseMoney(defaultTipCommissionPercent)
+	}
+	return percent
+}
+
+// tipFingerprint identifies a tip checkout the way cartFingerprint does a
+// cart, so retrying the same tip reuses its open order.
+func tipFingerprint(recipeID string, amount Money, currency string, message string, provider string, returnURL string) string {
+	parts := []string{orderTypeTip, recipeID, amount.String(), currency, message, provider, returnURL}
+	sum := sha256.Sum256([]byte(strings.Join(parts, "|")))
+	return hex.EncodeToString(sum[:])
+}
+
+// HandleTipAuthor handles the Hasura Action webhook that lets a user tip the
+// author of a free recipe. The tip is a pending order without items that
+// completes through the usual payment callback.
+func HandleTipAuthor(w http.ResponseWriter, r *http.Request, hasuraService *HasuraService, providers *ProviderRegistry) {
+	log.Println("🚀 Received /tipAuthor request")
+	var payload TipAuthorActionPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Failed to parse Hasura Action payload")
+		return
+	}
+	defer r.Body.Close()
+
+	userID := payload.SessionVariables["x-hasura-user-id"]
+	input := payload.Input.Input
+	if userID == "" || input.RecipeID == "" || input.ReturnURL == "" {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload or missing user ID")
+		return
+	}
+	message := strings.TrimSpace(input.Message)
+	if len([]rune(message)) > maxTipMessageLength {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Tip messages are limited to %d characters", maxTipMessageLength))
+		return
+	}
+	provider, ok := providers.Get(input.Provider)
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Unsupported payment provider: %s", input.Provider))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	recipeResp, err := hasuraService.QueryRecipeDetails(ctx, []string{input.RecipeID})
+	if err != nil {
+		log.Printf("Failed to query recipe %s for a tip: %v", input.RecipeID, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve recipe details")
+		return
+	}
+	if len(recipeResp.Recipes) == 0 || !recipeResp.Recipes[0].IsPublished {
+		respondWithError(w, http.StatusNotFound, "Recipe not found")
+		return
+	}
+	recipe := recipeResp.Recipes[0]
+	if recipe.PriceETB > 0 {
+		respondWithError(w, http.StatusBadRequest, "Only authors of free recipes can be tipped")
+		return
+	}
+	if recipe.UserID == userID {
+		respondWithError(w, http.StatusBadRequest, "You cannot tip yourself")
+		return
+	}
+
+	// Only currencies the provider accepts and that have an exchange rate can
+	// be used; the limits are set in ETB and converted like recipe prices.
+	currency := normalizeCurrency(input.Currency)
+	if !currencyCodePattern.MatchString(currency) {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Unsupported currency: %s", currency))
+		return
+	}
+	if !provider.SupportsCurrency(currency) {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s does not accept payments in %s", provider.Name(), currency))
+		return
+	}
+	rateResp, err := hasuraService.QueryExchangeRate(ctx, currency)
+	if err != nil {
+		log.Printf("Failed to query exchange rate for %s: %v", currency, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve exchange rate")
+		return
+	}
+	if rateResp.ExchangeRatesByPk == nil {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Unsupported currency: %s", currency))
+		return
+	}
+	rate := rateResp.ExchangeRatesByPk.EtbPerUnit
+	minAmount, maxAmount := tipLimits()
+	minAmount, maxAmount = rate.FromETB(minAmount), rate.FromETB(maxAmount)
+	if input.Amount < minAmount || input.Amount > maxAmount {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Tips must be between %s and %s %s", minAmount, maxAmount, currency))
+		return
+	}
+
+	fingerprint := tipFingerprint(recipe.ID, input.Amount, currency, message, provider.Name(), input.ReturnURL)
+	if output, ok := reuseOpenOrder(ctx, hasuraService, userID, fingerprint); ok {
+		respondWithJSON(w, http.StatusOK, output)
+		return
+	}
+
+	recipeID := uuid(recipe.ID)
+	authorID := uuid(recipe.UserID)
+	order := orders_insert_input{
+		ID:                        uuid(google_uuid.New().String()),
+		UserID:                    uuid(userID),
+		TotalAmount:               input.Amount,
+		Currency:                  currency,
+		ReturnURL:                 input.ReturnURL,
+		Status:                    "pending",
+		ChapaTxRef:                newOrderTxRef(),
+		PaymentProvider:           provider.Name(),
+		PlatformCommissionPercent: tipCommissionPercent(),
+		ExchangeRate:              rate,
+		CartFingerprint:           fingerprint,
+		ExpiresAt:                 DateTime(time.Now().Add(orderExpiry())),
+		OrderType:                 orderTypeTip,
+		TipRecipeID:               &recipeID,
+		TipAuthorID:               &authorID,
+		CreatedAt:                 DateTime(time.Now()),
+		UpdatedAt:                 DateTime(time.Now()),
+	}
+	if message != "" {
+		order.TipMessage = &message
+	}
+	output, err := placeOrder(ctx, hasuraService, provider, order, "Food Recipes Tip")
+	if err != nil {
+		respondWithCheckoutError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, output)
+}
@@ -0,0 +1,104 @@
+package payment
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func tipAuthor(t *testing.T, hasuraService *HasuraService, input map[string]interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	input["recipeId"], input["returnUrl"], input["provider"] = "r1", "https://shop.example.com/recipes/r1", "fake"
+	body, _ := json.Marshal(map[string]interface{}{
+		"input":             map[string]interface{}{"input": input},
+		"session_variables": map[string]string{"x-hasura-user-id": "u1"},
+	})
+	rec := httptest.NewRecorder()
+	HandleTipAuthor(rec, httptest.NewRequest(http.MethodPost, "/tipAuthor", bytes.NewReader(body)), hasuraService, NewProviderRegistry())
+	return rec
+}
+
+// The limits are set in ETB and apply to tips in other currencies at the
+// stored exchange rate.
+func TestTipLimits(t *testing.T) {
+	hasura, hasuraService := newFakeHasura(t)
+	hasura.on("recipes", `[{"id":"r1","user_id":"a1","price_etb":0,"title":"Free shiro","is_published":true}]`)
+	hasura.on("exchange_rates_by_pk", `{"currency":"USD","etb_per_unit":"50"}`)
+	t.Setenv("PAYMENT_PROVIDER", "fake")
+	t.Setenv("PAYMENT_PROVIDERS", "")
+	t.Setenv("TIP_MIN_AMOUNT", "25")
+	t.Setenv("TIP_MAX_AMOUNT", "5000")
+
+	tests := []struct {
+		amount float64
+		ok     bool
+	}{
+		{amount: 0.49, ok: false},
+		{amount: 0.50, ok: true},
+		{amount: 100, ok: true},
+		{amount: 100.01, ok: false},
+	}
+	for _, tt := range tests {
+		rec := tipAuthor(t, hasuraService, map[string]interface{}{"amount": tt.amount, "currency": "USD"})
+		if rejected := rec.Code == http.StatusBadRequest; rejected == tt.ok {
+			t.Errorf("tip of %.2f USD = %d %s", tt.amount, rec.Code, rec.Body)
+		}
+		if !tt.ok && !strings.Contains(rec.Body.String(), "Tips must be between 0.50 and 100.00 USD") {
+			t.Errorf("tip of %.2f USD rejected with %s", tt.amount, rec.Body)
+		}
+	}
+}
+
+func TestTipRecordsOrder(t *testing.T) {
+	hasura, hasuraService := newFakeHasura(t)
+	hasura.on("recipes", `[{"id":"r1","user_id":"a1","price_etb":0,"title":"Free shiro","is_published":true}]`)
+	hasura.on("exchange_rates_by_pk", `{"currency":"ETB","etb_per_unit":1}`)
+	hasura.on("users_by_pk", `{"first_name":"Abebe","email":"abebe@example.com"}`)
+	hasura.on("insert_orders_one", `{"id":"o1","chapa_tx_ref":"","return_url":""}`)
+	t.Setenv("PAYMENT_PROVIDER", "fake")
+	t.Setenv("PAYMENT_PROVIDERS", "")
+	t.Setenv("TIP_COMMISSION_PERCENT", "")
+
+	if rec := tipAuthor(t, hasuraService, map[string]interface{}{"amount": 50, "message": "  Thank you!  "}); rec.Code != http.StatusOK {
+		t.Fatalf("tip = %d %s", rec.Code, rec.Body)
+	}
+	var order orders_insert_input
+	hasura.calls("insert_orders_one")[0].variable(t, "object", &order)
+	if order.OrderType != orderTypeTip || order.TotalAmount != 5000 || *order.TipAuthorID != "a1" || *order.TipMessage != "Thank you!" {
+		t.Errorf("tip order = %+v", order)
+	}
+	if order.PlatformCommissionPercent != 0 || order.OrderItems != nil {
+		t.Errorf("tip order has commission %s and items %+v", order.PlatformCommissionPercent, order.OrderItems)
+	}
+}
+
+func TestTipRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		recipe string
+		input  map[string]interface{}
+		want   int
+	}{
+		{"paid recipe", `{"id":"r1","user_id":"a1","price_etb":20,"is_published":true}`, map[string]interface{}{"amount": 50}, http.StatusBadRequest},
+		{"own recipe", `{"id":"r1","user_id":"u1","price_etb":0,"is_published":true}`, map[string]interface{}{"amount": 50}, http.StatusBadRequest},
+		{"unpublished recipe", `{"id":"r1","user_id":"a1","price_etb":0,"is_published":false}`, map[string]interface{}{"amount": 50}, http.StatusNotFound},
+		{"long message", `{"id":"r1","user_id":"a1","price_etb":0,"is_published":true}`, map[string]interface{}{"amount": 50, "message": strings.Repeat("ሰ", maxTipMessageLength+1)}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		hasura, hasuraService := newFakeHasura(t)
+		hasura.on("recipes", `[`+tt.recipe+`]`)
+		hasura.on("exchange_rates_by_pk", `{"currency":"ETB","etb_per_unit":1}`)
+		t.Setenv("PAYMENT_PROVIDER", "fake")
+		t.Setenv("PAYMENT_PROVIDERS", "")
+
+		if rec := tipAuthor(t, hasuraService, tt.input); rec.Code != tt.want {
+			t.Errorf("%s: tip = %d %s, want %d", tt.name, rec.Code, rec.Body, tt.want)
+		}
+		if len(hasura.calls("insert_orders_one")) != 0 {
+			t.Errorf("%s: tip order recorded", tt.name)
+		}
+	}
+}
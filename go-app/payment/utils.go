@@ -1,9 +1,11 @@
 package payment
 
 import (
+	"crypto/subtle"
 	"encoding/json"
 	"log"
 	"net/http"
+	"os"
 )
 
 // respondWithError sends an error response with a JSON message.
@@ -11,6 +13,12 @@ func respondWithError(w http.ResponseWriter, code int, message string) {
 	respondWithJSON(w, code, map[string]string{"message": message})
 }
 
+// respondWithErrorExtensions sends an error response whose extensions Hasura
+// passes through to the client alongside the message.
+func respondWithErrorExtensions(w http.ResponseWriter, code int, message string, extensions map[string]interface{}) {
+	respondWithJSON(w, code, map[string]interface{}{"message": message, "extensions": extensions})
+}
+
 // respondWithJSON sends a success response with a JSON payload.
 func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	response, err := json.Marshal(payload)
@@ -23,4 +31,23 @@ func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(code)
 	w.Write(response)
-}
\ No newline at end of file
+}
+
+// isAdminSession reports whether an action was called with the admin role,
+// either by an admin user or with the admin secret. Session variables are
+// part of the request body, so they are only trusted on requests carrying the
+// action secret Hasura adds to admin actions.
+func isAdminSession(r *http.Request, sessionVariables map[string]string) bool {
+	return sessionVariables["x-hasura-role"] == "admin" && validActionSecret(r)
+}
+
+// validActionSecret checks the shared secret Hasura sends with admin actions
+// in the X-Action-Secret header.
+func validActionSecret(r *http.Request) bool {
+	secret := os.Getenv("ACTION_SECRET")
+	if secret == "" {
+		log.Println("❌ ACTION_SECRET is not configured; rejecting admin action")
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Action-Secret")), []byte(secret)) == 1
+}
@@ -0,0 +1,145 @@
+// Package pdf writes simple text-and-line PDF documents such as receipts and
+// invoices without any external dependency. It only uses the standard
+// Helvetica fonts, so text is limited to the Windows-1252 character set;
+// anything outside it is replaced with '?'.
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// A4 page size in points.
+const (
+	PageWidth  = 595.28
+	PageHeight = 841.89
+)
+
+// Font selects one of the two fonts every document embeds.
+type Font string
+
+const (
+	Regular Font = "F1"
+	Bold    Font = "F2"
+)
+
+// Document is a PDF under construction. Coordinates are in points from the
+// bottom-left corner of the page.
+type Document struct {
+	title string
+	pages []*bytes.Buffer
+}
+
+// New starts a document with one empty page.
+func New(title string) *Document {
+	d := &Document{title: title}
+	d.AddPage()
+	return d
+}
+
+// AddPage starts a new page; later drawing goes onto it.
+func (d *Document) AddPage() {
+	d.pages = append(d.pages, &bytes.Buffer{})
+}
+
+func (d *Document) page() *bytes.Buffer {
+	return d.pages[len(d.pages)-1]
+}
+
+// Text draws s with its baseline starting at (x, y).
+func (d *Document) Text(x, y float64, size float64, font Font, s string) {
+	fmt.Fprintf(d.page(), "BT /%s %.2f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, y, escape(s))
+}
+
+// TextRight draws s so that it ends at x, for right-aligned columns.
+func (d *Document) TextRight(x, y float64, size float64, font Font, s string) {
+	d.Text(x-TextWidth(s, size), y, size, font, s)
+}
+
+// Line draws a thin line from (x1, y1) to (x2, y2).
+func (d *Document) Line(x1, y1, x2, y2 float64) {
+	fmt.Fprintf(d.page(), "0.5 w %.2f %.2f m %.2f %.2f l S\n", x1, y1, x2, y2)
+}
+
+// Bytes renders the finished document.
+func (d *Document) Bytes() []byte {
+	var out bytes.Buffer
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	// Objects 1-5 are fixed; each page then adds a page and a content object.
+	const firstPage = 6
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", firstPage+2*i)
+	}
+
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	object(fmt.Sprintf("<< /Title (%s) /Producer (go-app) >>", escape(d.title)))
+	for i, content := range d.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			PageWidth, PageHeight, firstPage+2*i+1))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R /Info 5 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return out.Bytes()
+}
+
+// escape encodes s as the body of a PDF literal string in WinAnsiEncoding.
+func escape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r >= 32 && r < 127:
+			b.WriteRune(r)
+		case r >= 160 && r <= 255:
+			// Latin-1 letters share their code points with Windows-1252.
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+// helveticaWidths holds the Helvetica advance widths, in thousandths of the
+// font size, for the printable ASCII characters starting at space.
+var helveticaWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+// TextWidth estimates the width of s in points. It is exact for regular
+// Helvetica ASCII text and close enough for bold text and other characters.
+func TextWidth(s string, size float64) float64 {
+	total := 0
+	for _, r := range s {
+		if r >= 32 && r < 127 {
+			total += helveticaWidths[r-32]
+		} else {
+			total += 556
+		}
+	}
+	return float64(total) * size / 1000
+}
@@ -0,0 +1,54 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+func TestEscape(t *testing.T) {
+	if got, want := escape(`Tibs (spicy) \ Café ✓`), `Tibs \(spicy\) \\ Caf\351 ?`; got != want {
+		t.Errorf("escape = %q, want %q", got, want)
+	}
+}
+
+func TestTextWidth(t *testing.T) {
+	// "ETB" is 667+611+667 thousandths of the font size.
+	if got := TextWidth("ETB", 10); got != 19.45 {
+		t.Errorf("TextWidth = %v, want 19.45", got)
+	}
+}
+
+// Readers locate objects through the cross-reference table, so every
+// offset in it must point at the start of its object.
+func TestBytesCrossReference(t *testing.T) {
+	doc := New("Receipt (test)")
+	doc.Text(50, 800, 12, Bold, "Page one")
+	doc.AddPage()
+	doc.TextRight(500, 800, 10, Regular, "Page two")
+	out := doc.Bytes()
+
+	if !bytes.Contains(out, []byte("/Count 2")) || !bytes.Contains(out, []byte(`/Title (Receipt \(test\))`)) {
+		t.Errorf("document:\n%s", out)
+	}
+	startxref := regexp.MustCompile(`startxref\n(\d+)\n%%EOF\n$`).FindSubmatch(out)
+	if startxref == nil {
+		t.Fatalf("no startxref trailer:\n%s", out)
+	}
+	xref, _ := strconv.Atoi(string(startxref[1]))
+	if !bytes.HasPrefix(out[xref:], []byte("xref\n")) {
+		t.Fatalf("startxref %d does not point at the xref table", xref)
+	}
+	entries := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllSubmatch(out[xref:], -1)
+	if len(entries) != 9 {
+		t.Fatalf("%d objects in the xref table, want 9", len(entries))
+	}
+	for i, entry := range entries {
+		offset, _ := strconv.Atoi(string(entry[1]))
+		if want := fmt.Sprintf("%d 0 obj\n", i+1); !bytes.HasPrefix(out[offset:], []byte(want)) {
+			t.Errorf("object %d is not at offset %d", i+1, offset)
+		}
+	}
+}
@@ -0,0 +1,12 @@
+ALTER TABLE public.purchases DROP COLUMN refund_reference;
+ALTER TABLE public.purchases DROP COLUMN revoked_at;
+
+ALTER TABLE public.order_items DROP COLUMN refund_reference;
+ALTER TABLE public.order_items DROP COLUMN refunded_amount;
+ALTER TABLE public.order_items DROP COLUMN refunded_quantity;
+ALTER TABLE public.order_items DROP COLUMN status;
+
+ALTER TABLE public.orders DROP COLUMN refund_reference;
+ALTER TABLE public.orders DROP COLUMN refunded_amount;
+
+DROP TABLE IF EXISTS public.refunds;
@@ -0,0 +1,37 @@
+CREATE TABLE IF NOT EXISTS public.refunds (
+    id uuid NOT NULL DEFAULT gen_random_uuid(),
+    order_id uuid NOT NULL,
+    requested_by uuid,
+    amount numeric NOT NULL,
+    currency text NOT NULL,
+    reason text NOT NULL,
+    reference text NOT NULL,
+    chapa_reference text,
+    status text NOT NULL DEFAULT 'pending',
+    created_at timestamptz NOT NULL DEFAULT now(),
+    updated_at timestamptz NOT NULL DEFAULT now(),
+
+    CONSTRAINT refunds_pkey PRIMARY KEY (id),
+
+    CONSTRAINT fk_refunds_order_id FOREIGN KEY (order_id) REFERENCES public.orders(id) ON DELETE CASCADE,
+
+    CONSTRAINT fk_refunds_requested_by FOREIGN KEY (requested_by) REFERENCES public.users(id) ON DELETE SET NULL,
+
+    CONSTRAINT refunds_reference_key UNIQUE (reference)
+);
+
+CREATE INDEX IF NOT EXISTS idx_refunds_order_id ON public.refunds USING btree (order_id);
+
+CREATE TRIGGER update_refunds_updated_at BEFORE UPDATE
+    ON public.refunds FOR EACH ROW EXECUTE PROCEDURE update_updated_at_column();
+
+ALTER TABLE public.orders ADD COLUMN refunded_amount numeric NOT NULL DEFAULT 0;
+ALTER TABLE public.orders ADD COLUMN refund_reference text;
+
+ALTER TABLE public.order_items ADD COLUMN status text NOT NULL DEFAULT 'active';
+ALTER TABLE public.order_items ADD COLUMN refunded_quantity integer NOT NULL DEFAULT 0;
+ALTER TABLE public.order_items ADD COLUMN refunded_amount numeric NOT NULL DEFAULT 0;
+ALTER TABLE public.order_items ADD COLUMN refund_reference text;
+
+ALTER TABLE public.purchases ADD COLUMN revoked_at timestamptz;
+ALTER TABLE public.purchases ADD COLUMN refund_reference text;
@@ -0,0 +1 @@
+alter table "public"."orders" drop column "payment_provider";
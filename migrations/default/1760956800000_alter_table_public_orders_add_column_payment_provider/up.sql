@@ -0,0 +1,2 @@
+alter table "public"."orders" add column "payment_provider" text
+ not null default 'chapa';
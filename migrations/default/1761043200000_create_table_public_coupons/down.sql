@@ -0,0 +1,10 @@
+ALTER TABLE public.order_items DROP COLUMN discount_amount;
+
+ALTER TABLE public.orders DROP CONSTRAINT fk_orders_coupon_id;
+ALTER TABLE public.orders DROP COLUMN discount_amount;
+ALTER TABLE public.orders DROP COLUMN coupon_code;
+ALTER TABLE public.orders DROP COLUMN coupon_id;
+
+DROP TABLE IF EXISTS public.coupon_redemptions;
+DROP FUNCTION IF EXISTS increment_coupon_redemption_count();
+DROP TABLE IF EXISTS public.coupons;
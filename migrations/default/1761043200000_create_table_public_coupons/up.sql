@@ -0,0 +1,79 @@
+CREATE TABLE IF NOT EXISTS public.coupons (
+    id uuid NOT NULL DEFAULT gen_random_uuid(),
+    code text NOT NULL,
+    discount_type text NOT NULL,
+    discount_value numeric NOT NULL,
+    max_redemptions integer,
+    per_user_limit integer,
+    redemption_count integer NOT NULL DEFAULT 0,
+    starts_at timestamptz,
+    expires_at timestamptz,
+    author_id uuid,
+    recipe_id uuid,
+    created_by uuid NOT NULL,
+    is_active boolean NOT NULL DEFAULT true,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    updated_at timestamptz NOT NULL DEFAULT now(),
+
+    CONSTRAINT coupons_pkey PRIMARY KEY (id),
+
+    CONSTRAINT coupons_code_key UNIQUE (code),
+
+    -- Codes are matched exactly, so they are stored upper-case.
+    CONSTRAINT coupons_code_upper CHECK (code = upper(code)),
+
+    CONSTRAINT coupons_discount_type_check CHECK (discount_type IN ('percentage', 'fixed')),
+
+    CONSTRAINT coupons_discount_value_check CHECK (
+        discount_value > 0 AND (discount_type <> 'percentage' OR discount_value <= 100)
+    ),
+
+    CONSTRAINT fk_coupons_author_id FOREIGN KEY (author_id) REFERENCES public.users(id) ON DELETE CASCADE,
+
+    CONSTRAINT fk_coupons_recipe_id FOREIGN KEY (recipe_id) REFERENCES public.recipes(id) ON DELETE CASCADE,
+
+    CONSTRAINT fk_coupons_created_by FOREIGN KEY (created_by) REFERENCES public.users(id) ON DELETE CASCADE
+);
+
+CREATE TRIGGER update_coupons_updated_at BEFORE UPDATE
+    ON public.coupons FOR EACH ROW EXECUTE PROCEDURE update_updated_at_column();
+
+CREATE TABLE IF NOT EXISTS public.coupon_redemptions (
+    id uuid NOT NULL DEFAULT gen_random_uuid(),
+    coupon_id uuid NOT NULL,
+    order_id uuid NOT NULL,
+    user_id uuid NOT NULL,
+    discount_amount numeric NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+
+    CONSTRAINT coupon_redemptions_pkey PRIMARY KEY (id),
+
+    -- One redemption per order, so repeated payment callbacks cannot count twice.
+    CONSTRAINT coupon_redemptions_order_id_key UNIQUE (order_id),
+
+    CONSTRAINT fk_coupon_redemptions_coupon_id FOREIGN KEY (coupon_id) REFERENCES public.coupons(id) ON DELETE CASCADE,
+
+    CONSTRAINT fk_coupon_redemptions_order_id FOREIGN KEY (order_id) REFERENCES public.orders(id) ON DELETE CASCADE,
+
+    CONSTRAINT fk_coupon_redemptions_user_id FOREIGN KEY (user_id) REFERENCES public.users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_coupon_redemptions_coupon_user ON public.coupon_redemptions USING btree (coupon_id, user_id);
+
+CREATE OR REPLACE FUNCTION increment_coupon_redemption_count()
+RETURNS TRIGGER AS $$
+BEGIN
+    UPDATE public.coupons SET redemption_count = redemption_count + 1 WHERE id = NEW.coupon_id;
+    RETURN NEW;
+END;
+$$ language 'plpgsql';
+
+CREATE TRIGGER increment_coupon_redemption_count AFTER INSERT
+    ON public.coupon_redemptions FOR EACH ROW EXECUTE PROCEDURE increment_coupon_redemption_count();
+
+ALTER TABLE public.orders ADD COLUMN coupon_id uuid;
+ALTER TABLE public.orders ADD COLUMN coupon_code text;
+ALTER TABLE public.orders ADD COLUMN discount_amount numeric NOT NULL DEFAULT 0;
+ALTER TABLE public.orders ADD CONSTRAINT fk_orders_coupon_id FOREIGN KEY (coupon_id) REFERENCES public.coupons(id) ON DELETE SET NULL;
+
+ALTER TABLE public.order_items ADD COLUMN discount_amount numeric NOT NULL DEFAULT 0;
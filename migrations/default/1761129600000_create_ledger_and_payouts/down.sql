@@ -0,0 +1,9 @@
+ALTER TABLE public.orders DROP COLUMN platform_commission_percent;
+
+DROP VIEW IF EXISTS public.author_earnings;
+DROP TABLE IF EXISTS public.ledger_entries;
+DROP FUNCTION IF EXISTS check_ledger_transaction_balanced();
+DROP TABLE IF EXISTS public.ledger_transactions;
+DROP TABLE IF EXISTS public.payouts;
+DROP TABLE IF EXISTS public.payout_batches;
+DROP TABLE IF EXISTS public.payout_accounts;
@@ -0,0 +1,137 @@
+CREATE TABLE IF NOT EXISTS public.payout_accounts (
+    user_id uuid NOT NULL,
+    bank_name text NOT NULL,
+    account_name text NOT NULL,
+    account_number text NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    updated_at timestamptz NOT NULL DEFAULT now(),
+
+    CONSTRAINT payout_accounts_pkey PRIMARY KEY (user_id),
+
+    CONSTRAINT fk_payout_accounts_user_id FOREIGN KEY (user_id) REFERENCES public.users(id) ON DELETE CASCADE
+);
+
+CREATE TRIGGER update_payout_accounts_updated_at BEFORE UPDATE
+    ON public.payout_accounts FOR EACH ROW EXECUTE PROCEDURE update_updated_at_column();
+
+CREATE TABLE IF NOT EXISTS public.payout_batches (
+    id uuid NOT NULL DEFAULT gen_random_uuid(),
+    created_by uuid,
+    currency text NOT NULL,
+    total_amount numeric NOT NULL,
+    payout_count integer NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+
+    CONSTRAINT payout_batches_pkey PRIMARY KEY (id),
+
+    CONSTRAINT fk_payout_batches_created_by FOREIGN KEY (created_by) REFERENCES public.users(id) ON DELETE SET NULL
+);
+
+CREATE TABLE IF NOT EXISTS public.payouts (
+    id uuid NOT NULL DEFAULT gen_random_uuid(),
+    batch_id uuid NOT NULL,
+    author_id uuid NOT NULL,
+    amount numeric NOT NULL,
+    currency text NOT NULL,
+    reference text NOT NULL,
+    bank_name text NOT NULL,
+    account_name text NOT NULL,
+    account_number text NOT NULL,
+    status text NOT NULL DEFAULT 'paid',
+    created_at timestamptz NOT NULL DEFAULT now(),
+
+    CONSTRAINT payouts_pkey PRIMARY KEY (id),
+
+    CONSTRAINT payouts_reference_key UNIQUE (reference),
+
+    CONSTRAINT fk_payouts_batch_id FOREIGN KEY (batch_id) REFERENCES public.payout_batches(id) ON DELETE CASCADE,
+
+    CONSTRAINT fk_payouts_author_id FOREIGN KEY (author_id) REFERENCES public.users(id) ON DELETE RESTRICT
+);
+
+CREATE INDEX IF NOT EXISTS idx_payouts_author_id ON public.payouts USING btree (author_id);
+
+-- A ledger transaction groups balanced entries. idempotency_key stops the
+-- same sale, refund or payout from being posted twice.
+CREATE TABLE IF NOT EXISTS public.ledger_transactions (
+    id uuid NOT NULL DEFAULT gen_random_uuid(),
+    kind text NOT NULL,
+    idempotency_key text NOT NULL,
+    order_id uuid,
+    refund_id uuid,
+    payout_id uuid,
+    description text NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+
+    CONSTRAINT ledger_transactions_pkey PRIMARY KEY (id),
+
+    CONSTRAINT ledger_transactions_idempotency_key_key UNIQUE (idempotency_key),
+
+    CONSTRAINT ledger_transactions_kind_check CHECK (kind IN ('sale', 'refund', 'payout')),
+
+    CONSTRAINT fk_ledger_transactions_order_id FOREIGN KEY (order_id) REFERENCES public.orders(id) ON DELETE RESTRICT,
+
+    CONSTRAINT fk_ledger_transactions_refund_id FOREIGN KEY (refund_id) REFERENCES public.refunds(id) ON DELETE RESTRICT,
+
+    CONSTRAINT fk_ledger_transactions_payout_id FOREIGN KEY (payout_id) REFERENCES public.payouts(id) ON DELETE RESTRICT
+);
+
+-- Positive amounts are debits and negative amounts are credits.
+CREATE TABLE IF NOT EXISTS public.ledger_entries (
+    id uuid NOT NULL DEFAULT gen_random_uuid(),
+    transaction_id uuid NOT NULL,
+    account text NOT NULL,
+    user_id uuid,
+    amount numeric NOT NULL,
+    currency text NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+
+    CONSTRAINT ledger_entries_pkey PRIMARY KEY (id),
+
+    CONSTRAINT ledger_entries_account_check CHECK (account IN ('provider_clearing', 'platform_commission', 'author_payable')),
+
+    CONSTRAINT ledger_entries_author_user_check CHECK ((account = 'author_payable') = (user_id IS NOT NULL)),
+
+    CONSTRAINT fk_ledger_entries_transaction_id FOREIGN KEY (transaction_id) REFERENCES public.ledger_transactions(id) ON DELETE RESTRICT,
+
+    CONSTRAINT fk_ledger_entries_user_id FOREIGN KEY (user_id) REFERENCES public.users(id) ON DELETE RESTRICT
+);
+
+CREATE INDEX IF NOT EXISTS idx_ledger_entries_transaction_id ON public.ledger_entries USING btree (transaction_id);
+CREATE INDEX IF NOT EXISTS idx_ledger_entries_account_user ON public.ledger_entries USING btree (account, user_id);
+
+-- Checked at commit, once every entry of the transaction has been inserted.
+CREATE OR REPLACE FUNCTION check_ledger_transaction_balanced()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF (SELECT COALESCE(SUM(amount), 0) FROM public.ledger_entries WHERE transaction_id = NEW.transaction_id) <> 0 THEN
+        RAISE EXCEPTION 'ledger transaction % is not balanced', NEW.transaction_id;
+    END IF;
+    -- Refunds may leave an author owing money, but a payout never may.
+    IF NEW.account = 'author_payable' AND
+       (SELECT kind FROM public.ledger_transactions WHERE id = NEW.transaction_id) = 'payout' AND
+       (SELECT COALESCE(SUM(amount), 0) FROM public.ledger_entries
+         WHERE account = 'author_payable' AND user_id = NEW.user_id AND currency = NEW.currency) > 0 THEN
+        RAISE EXCEPTION 'author % would be paid more than they have earned', NEW.user_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ language 'plpgsql';
+
+CREATE CONSTRAINT TRIGGER check_ledger_transaction_balanced AFTER INSERT
+    ON public.ledger_entries DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW EXECUTE PROCEDURE check_ledger_transaction_balanced();
+
+CREATE OR REPLACE VIEW public.author_earnings AS
+SELECT e.user_id,
+       e.currency,
+       -COALESCE(SUM(e.amount) FILTER (WHERE t.kind = 'sale'), 0) AS total_earned,
+       COALESCE(SUM(e.amount) FILTER (WHERE t.kind = 'refund'), 0) AS total_refunded,
+       COALESCE(SUM(e.amount) FILTER (WHERE t.kind = 'payout'), 0) AS total_paid_out,
+       -SUM(e.amount) AS balance
+  FROM public.ledger_entries e
+  JOIN public.ledger_transactions t ON t.id = e.transaction_id
+ WHERE e.account = 'author_payable'
+ GROUP BY e.user_id, e.currency;
+
+ALTER TABLE public.orders ADD COLUMN platform_commission_percent numeric;
@@ -0,0 +1 @@
+alter table "public"."recipes" drop column "is_published";
@@ -0,0 +1,2 @@
+alter table "public"."recipes" add column "is_published" boolean
+ not null default true;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS public.cart_items;
+DROP TABLE IF EXISTS public.carts;
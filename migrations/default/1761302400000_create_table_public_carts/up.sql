@@ -0,0 +1,42 @@
+CREATE TABLE IF NOT EXISTS public.carts (
+    id uuid NOT NULL DEFAULT gen_random_uuid(),
+    user_id uuid NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    updated_at timestamptz NOT NULL DEFAULT now(),
+
+    CONSTRAINT carts_pkey PRIMARY KEY (id),
+
+    CONSTRAINT carts_user_id_key UNIQUE (user_id),
+
+    CONSTRAINT fk_carts_user_id FOREIGN KEY (user_id) REFERENCES public.users(id) ON DELETE CASCADE
+);
+
+CREATE TRIGGER update_carts_updated_at BEFORE UPDATE
+    ON public.carts FOR EACH ROW EXECUTE PROCEDURE update_updated_at_column();
+
+-- price_at_add is the price the buyer last saw, so the cart can flag
+-- recipes whose price has changed since.
+CREATE TABLE IF NOT EXISTS public.cart_items (
+    id uuid NOT NULL DEFAULT gen_random_uuid(),
+    cart_id uuid NOT NULL,
+    recipe_id uuid NOT NULL,
+    quantity integer NOT NULL,
+    price_at_add numeric NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    updated_at timestamptz NOT NULL DEFAULT now(),
+
+    CONSTRAINT cart_items_pkey PRIMARY KEY (id),
+
+    CONSTRAINT cart_items_cart_id_recipe_id_key UNIQUE (cart_id, recipe_id),
+
+    CONSTRAINT cart_items_quantity_check CHECK (quantity > 0),
+
+    CONSTRAINT fk_cart_items_cart_id FOREIGN KEY (cart_id) REFERENCES public.carts(id) ON DELETE CASCADE,
+
+    CONSTRAINT fk_cart_items_recipe_id FOREIGN KEY (recipe_id) REFERENCES public.recipes(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_cart_items_cart_id ON public.cart_items USING btree (cart_id);
+
+CREATE TRIGGER update_cart_items_updated_at BEFORE UPDATE
+    ON public.cart_items FOR EACH ROW EXECUTE PROCEDURE update_updated_at_column();
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS public.invoice_items;
+DROP TABLE IF EXISTS public.invoices;
+DROP FUNCTION IF EXISTS assign_invoice_number();
+DROP TABLE IF EXISTS public.invoice_sequences;
@@ -0,0 +1,70 @@
+CREATE TABLE IF NOT EXISTS public.invoice_sequences (
+    fiscal_year integer NOT NULL,
+    last_number integer NOT NULL DEFAULT 0,
+
+    CONSTRAINT invoice_sequences_pkey PRIMARY KEY (fiscal_year)
+);
+
+CREATE TABLE IF NOT EXISTS public.invoices (
+    id uuid NOT NULL DEFAULT gen_random_uuid(),
+    order_id uuid NOT NULL,
+    fiscal_year integer NOT NULL,
+    sequence_number integer NOT NULL,
+    invoice_number text NOT NULL,
+    currency text NOT NULL,
+    subtotal numeric NOT NULL,
+    vat_rate numeric NOT NULL,
+    vat_amount numeric NOT NULL,
+    total_amount numeric NOT NULL,
+    issued_at timestamptz NOT NULL DEFAULT now(),
+    created_at timestamptz NOT NULL DEFAULT now(),
+
+    CONSTRAINT invoices_pkey PRIMARY KEY (id),
+
+    CONSTRAINT invoices_order_id_key UNIQUE (order_id),
+
+    CONSTRAINT invoices_invoice_number_key UNIQUE (invoice_number),
+
+    CONSTRAINT invoices_fiscal_year_sequence_number_key UNIQUE (fiscal_year, sequence_number),
+
+    CONSTRAINT fk_invoices_order_id FOREIGN KEY (order_id) REFERENCES public.orders(id) ON DELETE RESTRICT
+);
+
+CREATE TABLE IF NOT EXISTS public.invoice_items (
+    id uuid NOT NULL DEFAULT gen_random_uuid(),
+    invoice_id uuid NOT NULL,
+    order_item_id uuid NOT NULL,
+    line_number integer NOT NULL,
+    description text NOT NULL,
+    quantity integer NOT NULL,
+    unit_price numeric NOT NULL,
+    discount_amount numeric NOT NULL DEFAULT 0,
+    net_amount numeric NOT NULL,
+    vat_amount numeric NOT NULL,
+    total_amount numeric NOT NULL,
+
+    CONSTRAINT invoice_items_pkey PRIMARY KEY (id),
+
+    CONSTRAINT fk_invoice_items_invoice_id FOREIGN KEY (invoice_id) REFERENCES public.invoices(id) ON DELETE CASCADE,
+
+    CONSTRAINT fk_invoice_items_order_item_id FOREIGN KEY (order_item_id) REFERENCES public.order_items(id) ON DELETE RESTRICT
+);
+
+CREATE INDEX IF NOT EXISTS idx_invoice_items_invoice_id ON public.invoice_items USING btree (invoice_id);
+
+-- Numbers come from a locked counter row in the same transaction as the
+-- invoice, so a failed insert rolls its number back and leaves no gap.
+CREATE OR REPLACE FUNCTION assign_invoice_number()
+RETURNS TRIGGER AS $$
+BEGIN
+    INSERT INTO public.invoice_sequences (fiscal_year, last_number)
+    VALUES (NEW.fiscal_year, 1)
+    ON CONFLICT (fiscal_year) DO UPDATE SET last_number = public.invoice_sequences.last_number + 1
+    RETURNING last_number INTO NEW.sequence_number;
+    NEW.invoice_number := 'INV-' || NEW.fiscal_year || '-' || lpad(NEW.sequence_number::text, 6, '0');
+    RETURN NEW;
+END;
+$$ language 'plpgsql';
+
+CREATE TRIGGER assign_invoice_number BEFORE INSERT
+    ON public.invoices FOR EACH ROW EXECUTE PROCEDURE assign_invoice_number();
@@ -0,0 +1,6 @@
+drop index if exists "public"."orders_pending_expires_at_idx";
+drop index if exists "public"."orders_open_checkout_idx";
+alter table "public"."orders" drop column "reminder_sent_at";
+alter table "public"."orders" drop column "checkout_url";
+alter table "public"."orders" drop column "cart_fingerprint";
+alter table "public"."orders" drop column "expires_at";
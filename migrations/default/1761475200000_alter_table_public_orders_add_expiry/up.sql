@@ -0,0 +1,9 @@
+alter table "public"."orders" add column "expires_at" timestamptz;
+alter table "public"."orders" add column "cart_fingerprint" text;
+alter table "public"."orders" add column "checkout_url" text;
+alter table "public"."orders" add column "reminder_sent_at" timestamptz;
+
+-- Pending orders older than the expiry window at deploy time are left for
+-- the first cleanup run, which also expires rows without expires_at.
+create index "orders_open_checkout_idx" on "public"."orders" ("user_id", "cart_fingerprint") where "status" = 'pending';
+create index "orders_pending_expires_at_idx" on "public"."orders" ("expires_at") where "status" = 'pending';
@@ -0,0 +1,3 @@
+ALTER TABLE public.orders DROP COLUMN IF EXISTS exchange_rate;
+DROP TABLE IF EXISTS public.recipe_prices;
+DROP TABLE IF EXISTS public.exchange_rates;
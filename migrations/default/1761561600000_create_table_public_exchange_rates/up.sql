@@ -0,0 +1,50 @@
+-- etb_per_unit is how many birr one unit of the currency is worth, e.g.
+-- 57.5 for USD. ETB itself is always supported at 1.
+CREATE TABLE IF NOT EXISTS public.exchange_rates (
+    currency text NOT NULL,
+    etb_per_unit numeric(18,6) NOT NULL,
+    source text NOT NULL DEFAULT 'manual',
+    created_at timestamptz NOT NULL DEFAULT now(),
+    updated_at timestamptz NOT NULL DEFAULT now(),
+
+    CONSTRAINT exchange_rates_pkey PRIMARY KEY (currency),
+
+    CONSTRAINT exchange_rates_currency_check CHECK (currency ~ '^[A-Z]{3}$'),
+
+    CONSTRAINT exchange_rates_etb_per_unit_check CHECK (etb_per_unit > 0),
+
+    CONSTRAINT exchange_rates_etb_check CHECK (currency <> 'ETB' OR etb_per_unit = 1)
+);
+
+CREATE TRIGGER update_exchange_rates_updated_at BEFORE UPDATE
+    ON public.exchange_rates FOR EACH ROW EXECUTE PROCEDURE update_updated_at_column();
+
+INSERT INTO public.exchange_rates (currency, etb_per_unit, source) VALUES ('ETB', 1, 'base')
+    ON CONFLICT (currency) DO NOTHING;
+
+-- An author may set a fixed price in a currency instead of the converted
+-- price_etb.
+CREATE TABLE IF NOT EXISTS public.recipe_prices (
+    id uuid NOT NULL DEFAULT gen_random_uuid(),
+    recipe_id uuid NOT NULL,
+    currency text NOT NULL,
+    price numeric(12,2) NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    updated_at timestamptz NOT NULL DEFAULT now(),
+
+    CONSTRAINT recipe_prices_pkey PRIMARY KEY (id),
+
+    CONSTRAINT recipe_prices_recipe_id_currency_key UNIQUE (recipe_id, currency),
+
+    CONSTRAINT recipe_prices_price_check CHECK (price > 0),
+
+    CONSTRAINT fk_recipe_prices_recipe_id FOREIGN KEY (recipe_id) REFERENCES public.recipes(id) ON DELETE CASCADE,
+
+    CONSTRAINT fk_recipe_prices_currency FOREIGN KEY (currency) REFERENCES public.exchange_rates(currency) ON UPDATE CASCADE ON DELETE CASCADE
+);
+
+CREATE TRIGGER update_recipe_prices_updated_at BEFORE UPDATE
+    ON public.recipe_prices FOR EACH ROW EXECUTE PROCEDURE update_updated_at_column();
+
+-- The rate an order was priced at, in ETB per unit of its currency.
+ALTER TABLE public.orders ADD COLUMN exchange_rate numeric(18,6) NOT NULL DEFAULT 1;
@@ -0,0 +1,4 @@
+DROP FUNCTION IF EXISTS public.recipe_has_access(public.recipes, json);
+DROP TABLE IF EXISTS public.subscription_payments;
+DROP TABLE IF EXISTS public.subscriptions;
+DROP TABLE IF EXISTS public.plans;
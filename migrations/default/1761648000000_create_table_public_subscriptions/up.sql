@@ -0,0 +1,122 @@
+-- All-access plans. price is charged in currency once per billing
+-- interval; renewals are paid manually through a new checkout each period.
+CREATE TABLE IF NOT EXISTS public.plans (
+    id uuid NOT NULL DEFAULT gen_random_uuid(),
+    code text NOT NULL,
+    name text NOT NULL,
+    description text,
+    billing_interval text NOT NULL,
+    price numeric(12,2) NOT NULL,
+    currency text NOT NULL DEFAULT 'ETB',
+    is_active boolean NOT NULL DEFAULT true,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    updated_at timestamptz NOT NULL DEFAULT now(),
+
+    CONSTRAINT plans_pkey PRIMARY KEY (id),
+
+    CONSTRAINT plans_code_key UNIQUE (code),
+
+    CONSTRAINT plans_billing_interval_check CHECK (billing_interval IN ('month', 'year')),
+
+    CONSTRAINT plans_price_check CHECK (price > 0),
+
+    CONSTRAINT fk_plans_currency FOREIGN KEY (currency) REFERENCES public.exchange_rates(currency) ON UPDATE CASCADE
+);
+
+CREATE TRIGGER update_plans_updated_at BEFORE UPDATE
+    ON public.plans FOR EACH ROW EXECUTE PROCEDURE update_updated_at_column();
+
+-- A subscription is pending until its first payment completes. It stays
+-- active while paid up, is past_due during the grace period after a missed
+-- renewal and ends as expired or, if the user cancelled, canceled.
+CREATE TABLE IF NOT EXISTS public.subscriptions (
+    id uuid NOT NULL DEFAULT gen_random_uuid(),
+    user_id uuid NOT NULL,
+    plan_id uuid NOT NULL,
+    status text NOT NULL DEFAULT 'pending',
+    current_period_start timestamptz,
+    current_period_end timestamptz,
+    cancel_at_period_end boolean NOT NULL DEFAULT false,
+    renewal_reminder_sent_at timestamptz,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    updated_at timestamptz NOT NULL DEFAULT now(),
+
+    CONSTRAINT subscriptions_pkey PRIMARY KEY (id),
+
+    CONSTRAINT subscriptions_status_check CHECK (status IN ('pending', 'active', 'past_due', 'expired', 'canceled')),
+
+    CONSTRAINT fk_subscriptions_user_id FOREIGN KEY (user_id) REFERENCES public.users(id) ON DELETE CASCADE,
+
+    CONSTRAINT fk_subscriptions_plan_id FOREIGN KEY (plan_id) REFERENCES public.plans(id)
+);
+
+-- A user holds at most one live subscription at a time.
+CREATE UNIQUE INDEX IF NOT EXISTS subscriptions_one_live_per_user
+    ON public.subscriptions (user_id) WHERE status IN ('active', 'past_due');
+
+CREATE INDEX IF NOT EXISTS idx_subscriptions_user_id ON public.subscriptions USING btree (user_id);
+
+CREATE TRIGGER update_subscriptions_updated_at BEFORE UPDATE
+    ON public.subscriptions FOR EACH ROW EXECUTE PROCEDURE update_updated_at_column();
+
+-- One row per checkout for a billing period, whether the first payment or a
+-- renewal. chapa_tx_ref holds the provider reference like on orders.
+CREATE TABLE IF NOT EXISTS public.subscription_payments (
+    id uuid NOT NULL DEFAULT gen_random_uuid(),
+    subscription_id uuid NOT NULL,
+    amount numeric(12,2) NOT NULL,
+    currency text NOT NULL,
+    status text NOT NULL DEFAULT 'pending',
+    payment_provider text NOT NULL,
+    chapa_tx_ref text NOT NULL,
+    chapa_transaction_id text,
+    checkout_url text,
+    return_url text NOT NULL,
+    expires_at timestamptz NOT NULL,
+    paid_at timestamptz,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    updated_at timestamptz NOT NULL DEFAULT now(),
+
+    CONSTRAINT subscription_payments_pkey PRIMARY KEY (id),
+
+    CONSTRAINT subscription_payments_chapa_tx_ref_key UNIQUE (chapa_tx_ref),
+
+    CONSTRAINT subscription_payments_status_check CHECK (status IN ('pending', 'completed', 'failed', 'amount_mismatch', 'expired', 'unknown')),
+
+    CONSTRAINT fk_subscription_payments_subscription_id FOREIGN KEY (subscription_id) REFERENCES public.subscriptions(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_subscription_payments_subscription_id ON public.subscription_payments USING btree (subscription_id);
+
+CREATE TRIGGER update_subscription_payments_updated_at BEFORE UPDATE
+    ON public.subscription_payments FOR EACH ROW EXECUTE PROCEDURE update_updated_at_column();
+
+-- has_access on recipes: the caller wrote the recipe, bought it, or has a
+-- live subscription, including the grace period while it is past_due.
+-- Permissions and clients use it instead of checking purchases directly.
+CREATE OR REPLACE FUNCTION public.recipe_has_access(recipe_row public.recipes, hasura_session json)
+RETURNS boolean AS $$
+    SELECT COALESCE(
+        recipe_row.user_id = (hasura_session ->> 'x-hasura-user-id')::uuid
+        OR EXISTS (
+            SELECT 1 FROM public.purchases p
+            WHERE p.recipe_id = recipe_row.id
+              AND p.buyer_id = (hasura_session ->> 'x-hasura-user-id')::uuid
+              AND p.revoked_at IS NULL
+        )
+        OR EXISTS (
+            SELECT 1 FROM public.order_items oi
+            JOIN public.orders o ON o.id = oi.order_id
+            WHERE oi.recipe_id = recipe_row.id
+              AND oi.status <> 'refunded'
+              AND o.user_id = (hasura_session ->> 'x-hasura-user-id')::uuid
+              AND o.status IN ('completed', 'partially_refunded')
+        )
+        OR EXISTS (
+            SELECT 1 FROM public.subscriptions s
+            WHERE s.user_id = (hasura_session ->> 'x-hasura-user-id')::uuid
+              AND (s.status = 'past_due' OR (s.status = 'active' AND s.current_period_end > now()))
+        ),
+        false
+    )
+$$ LANGUAGE sql STABLE;
@@ -0,0 +1,12 @@
+DROP FUNCTION IF EXISTS public.recipe_tip_count(public.recipes);
+drop index if exists "public"."orders_tip_recipe_id_idx";
+alter table "public"."ledger_transactions" drop constraint "ledger_transactions_kind_check";
+alter table "public"."ledger_transactions" add constraint "ledger_transactions_kind_check"
+    check (kind in ('sale', 'refund', 'payout'));
+alter table "public"."orders" drop constraint "orders_tip_author_id_fkey";
+alter table "public"."orders" drop constraint "orders_tip_recipe_id_fkey";
+alter table "public"."orders" drop column "tip_message";
+alter table "public"."orders" drop column "tip_author_id";
+alter table "public"."orders" drop column "tip_recipe_id";
+alter table "public"."orders" drop constraint "orders_order_type_check";
+alter table "public"."orders" drop column "order_type";
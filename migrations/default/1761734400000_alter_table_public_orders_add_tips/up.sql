@@ -0,0 +1,32 @@
+-- Tips are orders without order_items: the buyer pays an amount of their
+-- choosing to the author of a recipe, and the whole tip less any tip
+-- commission is credited to that author.
+alter table "public"."orders" add column "order_type" text not null default 'purchase';
+alter table "public"."orders" add constraint "orders_order_type_check" check (order_type in ('purchase', 'tip'));
+alter table "public"."orders" add column "tip_recipe_id" uuid null;
+alter table "public"."orders" add column "tip_author_id" uuid null;
+alter table "public"."orders" add column "tip_message" text null;
+
+alter table "public"."orders"
+    add constraint "orders_tip_recipe_id_fkey" foreign key ("tip_recipe_id")
+    references "public"."recipes" ("id") on update restrict on delete set null;
+alter table "public"."orders"
+    add constraint "orders_tip_author_id_fkey" foreign key ("tip_author_id")
+    references "public"."users" ("id") on update restrict on delete set null;
+
+alter table "public"."ledger_transactions" drop constraint "ledger_transactions_kind_check";
+alter table "public"."ledger_transactions" add constraint "ledger_transactions_kind_check"
+    check (kind in ('sale', 'refund', 'payout', 'tip'));
+
+CREATE INDEX IF NOT EXISTS orders_tip_recipe_id_idx ON public.orders USING btree (tip_recipe_id)
+    WHERE order_type = 'tip';
+
+-- tip_count on recipes: how many paid tips a recipe has received. Amounts
+-- stay private to the author's earnings.
+CREATE OR REPLACE FUNCTION public.recipe_tip_count(recipe_row public.recipes)
+RETURNS integer AS $$
+    SELECT count(*)::integer FROM public.orders o
+    WHERE o.order_type = 'tip'
+      AND o.tip_recipe_id = recipe_row.id
+      AND o.status IN ('completed', 'partially_refunded')
+$$ LANGUAGE sql STABLE;
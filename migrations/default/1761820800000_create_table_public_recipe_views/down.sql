@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS public.idx_orders_created_at;
+DROP INDEX IF EXISTS public.idx_order_items_recipe_id;
+DROP TRIGGER IF EXISTS count_recipe_view ON public.recipe_views;
+DROP FUNCTION IF EXISTS public.count_recipe_view();
+DROP TABLE IF EXISTS public.recipe_view_daily;
+DROP TABLE IF EXISTS public.recipe_views;
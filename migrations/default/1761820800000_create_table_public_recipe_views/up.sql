@@ -0,0 +1,40 @@
+CREATE TABLE IF NOT EXISTS public.recipe_views (
+    id uuid NOT NULL DEFAULT gen_random_uuid(),
+    recipe_id uuid NOT NULL,
+    user_id uuid,
+    viewed_at timestamptz NOT NULL DEFAULT now(),
+
+    CONSTRAINT recipe_views_pkey PRIMARY KEY (id),
+
+    CONSTRAINT fk_recipe_views_recipe_id FOREIGN KEY (recipe_id) REFERENCES public.recipes(id) ON DELETE CASCADE,
+
+    CONSTRAINT fk_recipe_views_user_id FOREIGN KEY (user_id) REFERENCES public.users(id) ON DELETE SET NULL
+);
+
+-- Daily totals the sales report reads, so it never has to scan raw views.
+CREATE TABLE IF NOT EXISTS public.recipe_view_daily (
+    recipe_id uuid NOT NULL,
+    day date NOT NULL,
+    views integer NOT NULL DEFAULT 0,
+
+    CONSTRAINT recipe_view_daily_pkey PRIMARY KEY (recipe_id, day),
+
+    CONSTRAINT fk_recipe_view_daily_recipe_id FOREIGN KEY (recipe_id) REFERENCES public.recipes(id) ON DELETE CASCADE
+);
+
+CREATE OR REPLACE FUNCTION public.count_recipe_view()
+RETURNS trigger AS $$
+BEGIN
+    INSERT INTO public.recipe_view_daily (recipe_id, day, views)
+    VALUES (NEW.recipe_id, (NEW.viewed_at AT TIME ZONE 'UTC')::date, 1)
+    ON CONFLICT (recipe_id, day) DO UPDATE SET views = recipe_view_daily.views + 1;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER count_recipe_view AFTER INSERT
+    ON public.recipe_views FOR EACH ROW EXECUTE PROCEDURE public.count_recipe_view();
+
+-- The report filters sold items by the order's creation time.
+CREATE INDEX IF NOT EXISTS idx_order_items_recipe_id ON public.order_items USING btree (recipe_id);
+CREATE INDEX IF NOT EXISTS idx_orders_created_at ON public.orders USING btree (created_at);
@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS update_profile_images_updated_at ON public.profile_images;
+alter table "public"."profile_images" drop constraint "profile_images_user_id_key";
@@ -0,0 +1,11 @@
+-- Keep only the newest picture of each user so every user has one current
+-- profile image.
+DELETE FROM public.profile_images p
+USING public.profile_images newer
+WHERE newer.user_id = p.user_id
+  AND (newer.created_at, newer.id) > (p.created_at, p.id);
+
+alter table "public"."profile_images" add constraint "profile_images_user_id_key" unique ("user_id");
+
+CREATE TRIGGER update_profile_images_updated_at BEFORE UPDATE
+    ON public.profile_images FOR EACH ROW EXECUTE PROCEDURE update_updated_at_column();
@@ -0,0 +1,4 @@
+alter table "public"."recipe_images" drop column "height";
+alter table "public"."recipe_images" drop column "width";
+alter table "public"."recipe_images" drop column "card_url";
+alter table "public"."recipe_images" drop column "thumbnail_url";
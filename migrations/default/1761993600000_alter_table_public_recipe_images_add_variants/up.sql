@@ -0,0 +1,5 @@
+-- Resized variants generated on upload; image_url stays the full-size one.
+alter table "public"."recipe_images" add column "thumbnail_url" text null;
+alter table "public"."recipe_images" add column "card_url" text null;
+alter table "public"."recipe_images" add column "width" integer null;
+alter table "public"."recipe_images" add column "height" integer null;
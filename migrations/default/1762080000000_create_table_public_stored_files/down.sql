@@ -0,0 +1,6 @@
+DROP TRIGGER IF EXISTS count_profile_image_files ON public.profile_images;
+DROP FUNCTION IF EXISTS public.count_profile_image_files();
+DROP TRIGGER IF EXISTS count_recipe_image_files ON public.recipe_images;
+DROP FUNCTION IF EXISTS public.count_recipe_image_files();
+DROP FUNCTION IF EXISTS public.add_stored_file_refs(text[], integer);
+DROP TABLE IF EXISTS public.stored_files;
@@ -0,0 +1,62 @@
+-- One row per stored upload, named by the SHA-256 of its content so a
+-- re-uploaded file is stored once. ref_count is kept by the triggers below
+-- and counts the image rows pointing at the file's URL.
+CREATE TABLE IF NOT EXISTS public.stored_files (
+    key text NOT NULL,
+    sha256 text NOT NULL,
+    url text NOT NULL,
+    size bigint NOT NULL,
+    content_type text NOT NULL,
+    ref_count integer NOT NULL DEFAULT 0,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    updated_at timestamptz NOT NULL DEFAULT now(),
+
+    CONSTRAINT stored_files_pkey PRIMARY KEY (key),
+
+    CONSTRAINT stored_files_url_key UNIQUE (url),
+
+    CONSTRAINT stored_files_ref_count_check CHECK (ref_count >= 0)
+);
+
+CREATE INDEX IF NOT EXISTS idx_stored_files_sha256 ON public.stored_files USING btree (sha256);
+CREATE INDEX IF NOT EXISTS idx_stored_files_unreferenced ON public.stored_files USING btree (updated_at) WHERE ref_count = 0;
+
+CREATE OR REPLACE FUNCTION public.add_stored_file_refs(urls text[], delta integer)
+RETURNS void AS $$
+    UPDATE public.stored_files f
+    SET ref_count = greatest(f.ref_count + delta * refs.n, 0), updated_at = now()
+    FROM (SELECT u, count(*) AS n FROM unnest(urls) AS u WHERE u IS NOT NULL GROUP BY u) refs
+    WHERE f.url = refs.u;
+$$ LANGUAGE sql;
+
+CREATE OR REPLACE FUNCTION public.count_recipe_image_files()
+RETURNS trigger AS $$
+BEGIN
+    IF TG_OP IN ('UPDATE', 'DELETE') THEN
+        PERFORM public.add_stored_file_refs(ARRAY[OLD.image_url, OLD.thumbnail_url, OLD.card_url], -1);
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        PERFORM public.add_stored_file_refs(ARRAY[NEW.image_url, NEW.thumbnail_url, NEW.card_url], 1);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER count_recipe_image_files AFTER INSERT OR DELETE OR UPDATE OF image_url, thumbnail_url, card_url
+    ON public.recipe_images FOR EACH ROW EXECUTE PROCEDURE public.count_recipe_image_files();
+
+CREATE OR REPLACE FUNCTION public.count_profile_image_files()
+RETURNS trigger AS $$
+BEGIN
+    IF TG_OP IN ('UPDATE', 'DELETE') THEN
+        PERFORM public.add_stored_file_refs(ARRAY[OLD.image_url], -1);
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        PERFORM public.add_stored_file_refs(ARRAY[NEW.image_url], 1);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER count_profile_image_files AFTER INSERT OR DELETE OR UPDATE OF image_url
+    ON public.profile_images FOR EACH ROW EXECUTE PROCEDURE public.count_profile_image_files();
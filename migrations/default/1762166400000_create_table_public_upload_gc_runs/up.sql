@@ -0,0 +1,20 @@
+-- One row per run of the orphaned upload collector, so reclaimed space can
+-- be tracked over time. Dry runs record what would have been deleted.
+CREATE TABLE IF NOT EXISTS public.upload_gc_runs (
+    id uuid NOT NULL DEFAULT gen_random_uuid(),
+    started_at timestamptz NOT NULL,
+    finished_at timestamptz NOT NULL DEFAULT now(),
+    dry_run boolean NOT NULL,
+    grace_hours integer NOT NULL,
+    scanned_files integer NOT NULL DEFAULT 0,
+    scanned_bytes bigint NOT NULL DEFAULT 0,
+    orphaned_files integer NOT NULL DEFAULT 0,
+    orphaned_bytes bigint NOT NULL DEFAULT 0,
+    deleted_files integer NOT NULL DEFAULT 0,
+    reclaimed_bytes bigint NOT NULL DEFAULT 0,
+    failed_files integer NOT NULL DEFAULT 0,
+
+    CONSTRAINT upload_gc_runs_pkey PRIMARY KEY (id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_upload_gc_runs_started_at ON public.upload_gc_runs USING btree (started_at);
@@ -0,0 +1,4 @@
+alter table "public"."order_items" drop constraint "order_items_refunded_amount_check";
+alter table "public"."order_items" drop constraint "order_items_refunded_quantity_check";
+alter table "public"."orders" drop constraint "orders_refunded_amount_check";
+alter table "public"."orders" drop column "refund_in_progress";
@@ -0,0 +1,13 @@
+-- Refunds are serialized per order: refundOrder sets refund_in_progress with
+-- a conditional update before it loads the order and calls the provider, and
+-- clears it once the refund is recorded or has failed. A refund that went
+-- through at the provider but could not be recorded keeps the order locked
+-- until it is reconciled.
+alter table "public"."orders" add column "refund_in_progress" boolean not null default false;
+
+alter table "public"."orders" add constraint "orders_refunded_amount_check"
+    check (refunded_amount >= 0 and refunded_amount <= total_amount);
+alter table "public"."order_items" add constraint "order_items_refunded_quantity_check"
+    check (refunded_quantity >= 0 and refunded_quantity <= quantity);
+alter table "public"."order_items" add constraint "order_items_refunded_amount_check"
+    check (refunded_amount >= 0);
@@ -0,0 +1,3 @@
+alter table "public"."payouts" drop column "settled_at";
+alter table "public"."payouts" drop constraint "payouts_status_check";
+alter table "public"."payouts" alter column "status" set default 'paid';
@@ -0,0 +1,9 @@
+-- Payouts start as processing when their batch is created and are settled
+-- as paid, or failed, once the bank transfers have been made. The payout's
+-- ledger transaction holds the author's balance meanwhile; a failed payout
+-- is reversed with a second one.
+alter table "public"."payouts" alter column "status" set default 'processing';
+alter table "public"."payouts" add constraint "payouts_status_check"
+    check (status in ('processing', 'paid', 'failed'));
+alter table "public"."payouts" add column "settled_at" timestamptz null;
+
@@ -0,0 +1,2 @@
+alter table "public"."orders" drop constraint "orders_checkout_type_check";
+alter table "public"."orders" drop column "checkout_type";
@@ -0,0 +1,6 @@
+-- Whether a purchase was checked out from the cart or bought directly, so
+-- checkout reminders only point buyers back to their cart when the recipes
+-- are there. Tips and orders from before this column have none.
+alter table "public"."orders" add column "checkout_type" text null;
+alter table "public"."orders" add constraint "orders_checkout_type_check"
+    check (checkout_type in ('direct', 'cart'));
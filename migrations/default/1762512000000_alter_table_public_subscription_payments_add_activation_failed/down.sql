@@ -0,0 +1,3 @@
+alter table "public"."subscription_payments" drop constraint "subscription_payments_status_check";
+alter table "public"."subscription_payments" add constraint "subscription_payments_status_check"
+    check (status in ('pending', 'completed', 'failed', 'amount_mismatch', 'expired', 'unknown'));
@@ -0,0 +1,5 @@
+-- A payment the provider confirmed but whose subscription could not be
+-- activated is kept as activation_failed so it can be reconciled or refunded.
+alter table "public"."subscription_payments" drop constraint "subscription_payments_status_check";
+alter table "public"."subscription_payments" add constraint "subscription_payments_status_check"
+    check (status in ('pending', 'completed', 'failed', 'amount_mismatch', 'expired', 'unknown', 'activation_failed'));
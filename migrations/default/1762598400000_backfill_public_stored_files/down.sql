@@ -0,0 +1,2 @@
+delete from "public"."stored_files" where "sha256" is null;
+alter table "public"."stored_files" alter column "sha256" set not null;
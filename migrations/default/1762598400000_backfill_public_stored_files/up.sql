@@ -0,0 +1,35 @@
+-- Files uploaded before stored_files existed have no row, so nothing stops
+-- them from being deleted. Record every file an image points at, with its
+-- key taken from the URL the way the server does: the path after /uploads/
+-- for local files, or the bucket/user/name tail of an object storage URL.
+-- Their content was never hashed, so sha256 stays null and size unknown.
+alter table "public"."stored_files" alter column "sha256" drop not null;
+
+WITH refs AS (
+    SELECT url FROM public.recipe_images, unnest(ARRAY[image_url, thumbnail_url, card_url]) AS url
+    WHERE url IS NOT NULL
+    UNION ALL
+    SELECT image_url FROM public.profile_images
+    WHERE image_url IS NOT NULL
+), keyed AS (
+    SELECT url, coalesce(
+        substring(url FROM '/uploads/+(.+)$'),
+        substring(url FROM '((?:recipe_images|step_images|avatars)/[0-9a-f-]{36}/[^/]+)$')
+    ) AS key
+    FROM refs
+)
+INSERT INTO public.stored_files (key, sha256, url, size, content_type, ref_count)
+SELECT DISTINCT ON (key) key, NULL, url, 0,
+    CASE lower(substring(key FROM '\.([^./]+)$'))
+        WHEN 'jpg' THEN 'image/jpeg'
+        WHEN 'jpeg' THEN 'image/jpeg'
+        WHEN 'png' THEN 'image/png'
+        WHEN 'gif' THEN 'image/gif'
+        WHEN 'webp' THEN 'image/webp'
+        ELSE 'application/octet-stream'
+    END,
+    count(*) OVER (PARTITION BY url)
+FROM keyed
+WHERE key IS NOT NULL
+ORDER BY key, url
+ON CONFLICT DO NOTHING;
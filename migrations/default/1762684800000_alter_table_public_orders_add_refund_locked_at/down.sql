@@ -0,0 +1,2 @@
+alter table "public"."refunds" drop column "items";
+alter table "public"."orders" drop column "refund_locked_at";
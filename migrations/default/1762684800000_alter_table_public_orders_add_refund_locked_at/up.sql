@@ -0,0 +1,10 @@
+-- When refundOrder took the order's refund lock. A lock older than the
+-- refund lock TTL with no pending refund behind it was left by a crash and
+-- can be taken again; one with a pending refund is settled by the
+-- reconcile_refunds cron trigger. Locks held now count from this migration.
+alter table "public"."orders" add column "refund_locked_at" timestamptz null;
+update "public"."orders" set "refund_locked_at" = now() where "refund_in_progress";
+
+-- The order items and quantities a refund covers, so a pending refund can
+-- be completed without the request that started it.
+alter table "public"."refunds" add column "items" jsonb not null default '[]'::jsonb;